@@ -432,6 +432,12 @@ optionLoop:
 		case QueryExecMode:
 			mode = arg
 			arguments = arguments[1:]
+		case Fragment, *Fragment:
+			// A Fragment is the $1 argument of the query. The arguments that follow it are its arguments even if they
+			// are QueryRewriters such as other fragments.
+			queryRewriter = arg.(QueryRewriter)
+			arguments = arguments[1:]
+			break optionLoop
 		case QueryRewriter:
 			queryRewriter = arg
 			arguments = arguments[1:]
@@ -443,7 +449,7 @@ optionLoop:
 	if queryRewriter != nil {
 		sql, arguments, err = queryRewriter.RewriteQuery(ctx, c, sql, arguments)
 		if err != nil {
-			return pgconn.CommandTag{}, fmt.Errorf("rewrite query failed: %v", err)
+			return pgconn.CommandTag{}, fmt.Errorf("rewrite query failed: %w", err)
 		}
 	}
 
@@ -687,6 +693,10 @@ optionLoop:
 		case QueryExecMode:
 			mode = arg
 			args = args[1:]
+		case Fragment, *Fragment:
+			queryRewriter = arg.(QueryRewriter)
+			args = args[1:]
+			break optionLoop
 		case QueryRewriter:
 			queryRewriter = arg
 			args = args[1:]
@@ -702,7 +712,7 @@ optionLoop:
 		sql, args, err = queryRewriter.RewriteQuery(ctx, c, sql, args)
 		if err != nil {
 			rows := c.getRows(ctx, originalSQL, originalArgs)
-			err = fmt.Errorf("rewrite query failed: %v", err)
+			err = fmt.Errorf("rewrite query failed: %w", err)
 			rows.fatal(err)
 			return rows, err
 		}
@@ -872,6 +882,10 @@ func (c *Conn) SendBatch(ctx context.Context, b *Batch) (br BatchResults) {
 	optionLoop:
 		for len(arguments) > 0 {
 			switch arg := arguments[0].(type) {
+			case Fragment, *Fragment:
+				queryRewriter = arg.(QueryRewriter)
+				arguments = arguments[1:]
+				break optionLoop
 			case QueryRewriter:
 				queryRewriter = arg
 				arguments = arguments[1:]
@@ -884,7 +898,7 @@ func (c *Conn) SendBatch(ctx context.Context, b *Batch) (br BatchResults) {
 			var err error
 			sql, arguments, err = queryRewriter.RewriteQuery(ctx, c, sql, arguments)
 			if err != nil {
-				return &batchResults{ctx: ctx, conn: c, err: fmt.Errorf("rewrite query failed: %v", err)}
+				return &batchResults{ctx: ctx, conn: c, err: fmt.Errorf("rewrite query failed: %w", err)}
 			}
 		}
 
@@ -0,0 +1,157 @@
+package pgx
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fragment is a piece of SQL with its arguments. Placeholders in SQL are '$' ordinals that refer to Args of the same
+// fragment. When fragments are combined, their placeholders are renumbered so dynamic queries can be built without
+// tracking ordinals by hand.
+//
+// An argument that is a Fragment is inserted in place of its placeholder with its own arguments. An argument that is an
+// Identifier is inserted as a quoted identifier. All other arguments are sent as query parameters.
+//
+// A Fragment can be used as the first argument to a query method. It is the $1 argument of the query and any
+// remaining arguments follow it. For example:
+//
+//	where := pgx.JoinFragments(" and ",
+//		pgx.NewFragment("status = $1", "active"),
+//		pgx.NewFragment("id in ($1)", pgx.InList([]int64{1, 2, 3})),
+//	)
+//	conn.Query(ctx, "select * from $2 where $1 limit $3", where, pgx.Identifier{"widgets"}, 10)
+//
+// is equivalent to:
+//
+//	conn.Query(ctx, `select * from "widgets" where status = $1 and id in ($2, $3, $4) limit $5`, "active", 1, 2, 3, 10)
+type Fragment struct {
+	SQL  string
+	Args []any
+}
+
+// NewFragment returns a Fragment of sql and args.
+func NewFragment(sql string, args ...any) Fragment {
+	return Fragment{SQL: sql, Args: args}
+}
+
+// JoinFragments returns a Fragment of fragments separated by sep. sep must be plain SQL text such as ", " or " and "
+// without placeholders. If fragments is empty the result is an empty Fragment.
+func JoinFragments(sep string, fragments ...Fragment) Fragment {
+	var sb strings.Builder
+	args := make([]any, len(fragments))
+	for i, f := range fragments {
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteRune('$')
+		sb.WriteString(strconv.Itoa(i + 1))
+		args[i] = f
+	}
+
+	return Fragment{SQL: sb.String(), Args: args}
+}
+
+// Append returns a Fragment of f followed by others separated by spaces.
+func (f Fragment) Append(others ...Fragment) Fragment {
+	return JoinFragments(" ", append([]Fragment{f}, others...)...)
+}
+
+// InList returns a Fragment of a comma separated placeholder for each value for use in an in expression. e.g.
+//
+//	pgx.NewFragment("id in ($1)", pgx.InList(ids))
+//
+// If values is empty the Fragment is NULL, which matches no rows.
+func InList[T any](values []T) Fragment {
+	if len(values) == 0 {
+		return Fragment{SQL: "NULL"}
+	}
+
+	var sb strings.Builder
+	args := make([]any, len(values))
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteRune('$')
+		sb.WriteString(strconv.Itoa(i + 1))
+		args[i] = v
+	}
+
+	return Fragment{SQL: sb.String(), Args: args}
+}
+
+// Build returns the SQL and arguments of f with all nested fragments and identifiers inserted and placeholders
+// renumbered.
+func (f Fragment) Build() (sql string, args []any, err error) {
+	var sb strings.Builder
+	err = f.build(&sb, &args)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return sb.String(), args, nil
+}
+
+// RewriteQuery implements the QueryRewriter interface. f is the $1 argument of sql and args are the following
+// arguments.
+func (f Fragment) RewriteQuery(ctx context.Context, conn *Conn, sql string, args []any) (newSQL string, newArgs []any, err error) {
+	return Fragment{SQL: sql, Args: append([]any{f}, args...)}.Build()
+}
+
+func (f Fragment) build(sb *strings.Builder, args *[]any) error {
+	l := &sqlLexer{
+		src:      f.SQL,
+		stateFn:  rawState,
+		ordinals: true,
+	}
+
+	for l.stateFn != nil {
+		l.stateFn = l.stateFn(l)
+	}
+
+	// Parameters referenced more than once in f keep a single ordinal.
+	var newOrdinals map[ordinalArg]int
+
+	for _, p := range l.parts {
+		switch p := p.(type) {
+		case string:
+			sb.WriteString(p)
+		case ordinalArg:
+			if p < 1 || int(p) > len(f.Args) {
+				return fmt.Errorf("fragment %q: placeholder $%d has no argument", f.SQL, p)
+			}
+
+			switch arg := f.Args[p-1].(type) {
+			case Fragment:
+				if err := arg.build(sb, args); err != nil {
+					return err
+				}
+			case *Fragment:
+				if arg == nil {
+					return fmt.Errorf("fragment %q: placeholder $%d is a nil *Fragment", f.SQL, p)
+				}
+				if err := arg.build(sb, args); err != nil {
+					return err
+				}
+			case Identifier:
+				sb.WriteString(arg.Sanitize())
+			default:
+				n, ok := newOrdinals[p]
+				if !ok {
+					*args = append(*args, arg)
+					n = len(*args)
+					if newOrdinals == nil {
+						newOrdinals = make(map[ordinalArg]int)
+					}
+					newOrdinals[p] = n
+				}
+				sb.WriteRune('$')
+				sb.WriteString(strconv.Itoa(n))
+			}
+		}
+	}
+
+	return nil
+}
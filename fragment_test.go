@@ -0,0 +1,184 @@
+package pgx_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFragmentBuild(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		fragment     pgx.Fragment
+		expectedSQL  string
+		expectedArgs []any
+	}{
+		{
+			fragment:     pgx.NewFragment("select * from users where id = $1", 42),
+			expectedSQL:  "select * from users where id = $1",
+			expectedArgs: []any{42},
+		},
+		{
+			fragment: pgx.JoinFragments(" and ",
+				pgx.NewFragment("a = $1", 1),
+				pgx.NewFragment("b = $2 or c = $1", 2, 3),
+				pgx.NewFragment("d is null"),
+				pgx.NewFragment("e = $1", 4),
+			),
+			expectedSQL:  "a = $1 and b = $2 or c = $3 and d is null and e = $4",
+			expectedArgs: []any{1, 3, 2, 4},
+		},
+		{
+			fragment:     pgx.NewFragment("select * from $1 where id in ($2)", pgx.Identifier{"public", "user"}, pgx.InList([]int{1, 2, 3})),
+			expectedSQL:  `select * from "public"."user" where id in ($1, $2, $3)`,
+			expectedArgs: []any{1, 2, 3},
+		},
+		{
+			fragment:     pgx.NewFragment("select $1 where id in ($2)", "a", pgx.InList([]int{})),
+			expectedSQL:  "select $1 where id in (NULL)",
+			expectedArgs: []any{"a"},
+		},
+		{
+			fragment:     pgx.NewFragment("select $1", 1).Append(pgx.NewFragment("from t where a = $1", 2)),
+			expectedSQL:  "select $1 from t where a = $2",
+			expectedArgs: []any{1, 2},
+		},
+		{
+			fragment:     pgx.NewFragment("select $1::int, '$2', $$ $1 $$, \"$2\" -- $2\n /* $2 */ $2", 1, 2),
+			expectedSQL:  "select $1::int, '$2', $$ $1 $$, \"$2\" -- $2\n /* $2 */ $2",
+			expectedArgs: []any{1, 2},
+		},
+		{
+			// Unreferenced args are dropped.
+			fragment:     pgx.NewFragment("select $2", 1, 2),
+			expectedSQL:  "select $1",
+			expectedArgs: []any{2},
+		},
+		{
+			fragment:     pgx.NewFragment("where $1", &pgx.Fragment{SQL: "a = $1", Args: []any{1}}),
+			expectedSQL:  "where a = $1",
+			expectedArgs: []any{1},
+		},
+		{
+			fragment:     pgx.JoinFragments(", "),
+			expectedSQL:  "",
+			expectedArgs: nil,
+		},
+	} {
+		sql, args, err := tt.fragment.Build()
+		require.NoError(t, err, i)
+		assert.Equalf(t, tt.expectedSQL, sql, "%d", i)
+		assert.Equalf(t, tt.expectedArgs, args, "%d", i)
+	}
+
+	_, _, err := pgx.NewFragment("a = $1 and b = $2", 1).Build()
+	require.EqualError(t, err, `fragment "a = $1 and b = $2": placeholder $2 has no argument`)
+
+	_, _, err = pgx.JoinFragments(" and ", pgx.NewFragment("a = $0")).Build()
+	require.Error(t, err)
+
+	_, _, err = pgx.NewFragment("select $1", (*pgx.Fragment)(nil)).Build()
+	require.EqualError(t, err, `fragment "select $1": placeholder $1 is a nil *Fragment`)
+}
+
+func TestFragmentRewriteQuery(t *testing.T) {
+	t.Parallel()
+
+	where := pgx.JoinFragments(" and ", pgx.NewFragment("a = $1", 1), pgx.NewFragment("b in ($1)", pgx.InList([]string{"x", "y"})))
+	sql, args, err := where.RewriteQuery(context.Background(), nil, "select * from $2 where $1 limit $3", []any{pgx.Identifier{"t"}, 10})
+	require.NoError(t, err)
+	assert.Equal(t, `select * from "t" where a = $1 and b in ($2, $3) limit $4`, sql)
+	assert.Equal(t, []any{1, "x", "y", 10}, args)
+}
+
+func TestFragmentQuery(t *testing.T) {
+	t.Parallel()
+
+	// The simple protocol requires these parameters. Send them before the final ReadyForQuery of the startup.
+	steps := pgmock.AcceptUnauthenticatedConnRequestSteps()
+	script := &pgmock.Script{Steps: append([]pgmock.Step{}, steps[:len(steps)-1]...)}
+	script.Steps = append(script.Steps,
+		pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"}),
+		pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"}),
+		steps[len(steps)-1],
+		pgmock.ExpectMessage(&pgproto3.Query{String: `delete from "widgets" where id in ('1', '2') and name = 'foo'`}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("DELETE 2")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, server.ConnString())
+	require.NoError(t, err)
+
+	// The second fragment is an argument of the query rather than a second QueryRewriter.
+	commandTag, err := conn.Exec(ctx, "delete from $1 where $2",
+		pgx.QueryExecModeSimpleProtocol,
+		pgx.NewFragment("$1", pgx.Identifier{"widgets"}),
+		pgx.JoinFragments(" and ", pgx.NewFragment("id in ($1)", pgx.InList([]int64{1, 2})), pgx.NewFragment("name = $1", "foo")),
+	)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, commandTag.RowsAffected())
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
+
+func TestFragmentQueryAfterQueryRewriters(t *testing.T) {
+	t.Parallel()
+
+	steps := pgmock.AcceptUnauthenticatedConnRequestSteps()
+	script := &pgmock.Script{Steps: append([]pgmock.Step{}, steps[:len(steps)-1]...)}
+	script.Steps = append(script.Steps,
+		pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"}),
+		pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"}),
+		steps[len(steps)-1],
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 2"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: `select * from "widgets"`}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, server.ConnString())
+	require.NoError(t, err)
+
+	// All leading QueryRewriters are consumed and the last one rewrites the query.
+	_, err = conn.Exec(ctx, "select 0", pgx.QueryExecModeSimpleProtocol,
+		&testQueryRewriter{sql: "select 1"},
+		&testQueryRewriter{sql: "select 2"},
+	)
+	require.NoError(t, err)
+
+	// A Fragment is the last QueryRewriter consumed.
+	_, err = conn.Exec(ctx, "$1", pgx.QueryExecModeSimpleProtocol,
+		&testQueryRewriter{sql: "select 1"},
+		pgx.NewFragment("select * from $1", pgx.Identifier{"widgets"}),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
@@ -463,6 +463,9 @@ func (tc *TLSConn) Flush() error                      { return tc.nbConn.Flush()
 func (tc *TLSConn) LocalAddr() net.Addr               { return tc.tlsConn.LocalAddr() }
 func (tc *TLSConn) RemoteAddr() net.Addr              { return tc.tlsConn.RemoteAddr() }
 
+// ConnectionState returns basic TLS details about the connection.
+func (tc *TLSConn) ConnectionState() tls.ConnectionState { return tc.tlsConn.ConnectionState() }
+
 func (tc *TLSConn) Close() error {
 	// tls.Conn.closeNotify() sets a 5 second deadline to avoid blocking, sends a TLS alert close notification, and then
 	// sets the deadline to now. This causes NetConn's Close not to be able to flush the write buffer. Instead we set our
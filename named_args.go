@@ -2,6 +2,9 @@ package pgx
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -18,10 +21,351 @@ type NamedArgs map[string]any
 
 // RewriteQuery implements the QueryRewriter interface.
 func (na NamedArgs) RewriteQuery(ctx context.Context, conn *Conn, sql string, args []any) (newSQL string, newArgs []any, err error) {
+	newSQL, names := rewriteNamedArgs(sql, NamedArgSyntaxAt, false)
+
+	newArgs = make([]any, len(names))
+	for i, name := range names {
+		newArgs[i] = na[name]
+	}
+
+	return newSQL, newArgs, nil
+}
+
+// StrictNamedArgs can be used in the same way as NamedArgs, but the query must use every name in the map and every
+// named placeholder must have a value in the map. Otherwise, RewriteQuery returns a *NamedArgsError.
+type StrictNamedArgs map[string]any
+
+// RewriteQuery implements the QueryRewriter interface.
+func (sna StrictNamedArgs) RewriteQuery(ctx context.Context, conn *Conn, sql string, args []any) (newSQL string, newArgs []any, err error) {
+	newSQL, names := rewriteNamedArgs(sql, NamedArgSyntaxAt, false)
+
+	keys := make([]string, 0, len(sna))
+	for k := range sna {
+		keys = append(keys, k)
+	}
+
+	newArgs, err = strictNamedArgValues(names, keys, func(name string) (any, bool) {
+		v, ok := sna[name]
+		return v, ok
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return newSQL, newArgs, nil
+}
+
+// NamedArgsError is returned by a strict named args rewriter when named placeholders have no value or provided values
+// are not used.
+type NamedArgsError struct {
+	Missing []string // names of placeholders without a value in the order they appear in the query
+	Unused  []string // sorted names of values that are not used by the query
+}
+
+func (e *NamedArgsError) Error() string {
+	var sb strings.Builder
+	if len(e.Missing) > 0 {
+		sb.WriteString("missing named args: ")
+		writeQuotedNames(&sb, e.Missing)
+	}
+	if len(e.Unused) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString("unused named args: ")
+		writeQuotedNames(&sb, e.Unused)
+	}
+	return sb.String()
+}
+
+func writeQuotedNames(sb *strings.Builder, names []string) {
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(strconv.Quote(name))
+	}
+}
+
+// strictNamedArgValues returns the values of names from lookup. It returns a *NamedArgsError if any name has no value
+// or any of keys is not in names.
+func strictNamedArgValues(names, keys []string, lookup func(name string) (any, bool)) ([]any, error) {
+	values := make([]any, len(names))
+	var missing []string
+	used := make(map[string]struct{}, len(names))
+	for i, name := range names {
+		v, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		values[i] = v
+		used[name] = struct{}{}
+	}
+
+	var unused []string
+	for _, k := range keys {
+		if _, ok := used[k]; !ok {
+			unused = append(unused, k)
+		}
+	}
+
+	if len(missing) > 0 || len(unused) > 0 {
+		sort.Strings(unused)
+		return nil, &NamedArgsError{Missing: missing, Unused: unused}
+	}
+
+	return values, nil
+}
+
+// NamedArgSyntax is a set of named placeholder syntaxes.
+type NamedArgSyntax uint8
+
+const (
+	// NamedArgSyntaxAt is @name as used by NamedArgs.
+	NamedArgSyntaxAt NamedArgSyntax = 1 << iota
+
+	// NamedArgSyntaxColon is :name as used by sqlx. :: casts are not placeholders.
+	NamedArgSyntaxColon
+
+	// NamedArgSyntaxDollar is $name. $1 ordinal placeholders and dollar-quoted strings are not placeholders.
+	NamedArgSyntaxDollar
+)
+
+// NamedArgsRewriter can be used as the first argument to a query method like NamedArgs. It supports the :name and
+// $name placeholder syntaxes in addition to @name, and it can take the values from the fields of a struct.
+//
+// For example, the following two queries are equivalent:
+//
+//	conn.Query(ctx, "insert into widgets (name, size) values (:name, :size)", &pgx.NamedArgsRewriter{Syntax: pgx.NamedArgSyntaxColon, Args: widget})
+//	conn.Query(ctx, "insert into widgets (name, size) values ($1, $2)", widget.Name, widget.Size)
+//
+// Struct fields are matched to placeholder names by their db tag or case-insensitively by their name. Fields with the
+// tag db:"-" and unexported fields are ignored. The fields of embedded structs are promoted as with Go field selectors:
+// the shallowest field wins and a name matching several fields at the same depth has no value unless exactly one of
+// them has a db tag. The fields of other nested structs and pointers to structs are selected with a dotted name (e.g. :address.city). A
+// nil pointer to a struct makes all its fields NULL.
+//
+// Like NamedArgs, a placeholder without a value is replaced with NULL unless Strict is set.
+type NamedArgsRewriter struct {
+	// Syntax is the set of placeholder syntaxes to replace. If zero, only NamedArgSyntaxAt is replaced.
+	Syntax NamedArgSyntax
+
+	// Args is a map with string keys (e.g. NamedArgs), a struct, or a pointer to either.
+	Args any
+
+	// Strict makes RewriteQuery return a *NamedArgsError if a placeholder has no value in Args or if Args is a map with
+	// keys the query does not use. Unused struct fields are allowed.
+	Strict bool
+}
+
+// RewriteQuery implements the QueryRewriter interface.
+func (nar *NamedArgsRewriter) RewriteQuery(ctx context.Context, conn *Conn, sql string, args []any) (newSQL string, newArgs []any, err error) {
+	syntax := nar.Syntax
+	if syntax == 0 {
+		syntax = NamedArgSyntaxAt
+	}
+
+	argsValue := reflect.ValueOf(nar.Args)
+	for argsValue.Kind() == reflect.Pointer && !argsValue.IsNil() {
+		argsValue = argsValue.Elem()
+	}
+
+	var lookup func(name string) (any, bool)
+	var keys []string
+	switch argsValue.Kind() {
+	case reflect.Map:
+		if argsValue.Type().Key().Kind() != reflect.String {
+			return "", nil, fmt.Errorf("named args map must have string keys, got %v", argsValue.Type())
+		}
+		iter := argsValue.MapRange()
+		for iter.Next() {
+			keys = append(keys, iter.Key().String())
+		}
+		lookup = func(name string) (any, bool) {
+			v := argsValue.MapIndex(reflect.ValueOf(name).Convert(argsValue.Type().Key()))
+			if !v.IsValid() {
+				return nil, false
+			}
+			return v.Interface(), true
+		}
+	case reflect.Struct:
+		lookup = func(name string) (any, bool) {
+			return namedStructArg(argsValue, name)
+		}
+	case reflect.Invalid, reflect.Pointer:
+		lookup = func(name string) (any, bool) { return nil, false }
+	default:
+		return "", nil, fmt.Errorf("named args must be a map or struct, got %v", argsValue.Type())
+	}
+
+	newSQL, names := rewriteNamedArgs(sql, syntax, argsValue.Kind() == reflect.Struct)
+
+	if nar.Strict {
+		newArgs, err = strictNamedArgValues(names, keys, lookup)
+		if err != nil {
+			return "", nil, err
+		}
+		return newSQL, newArgs, nil
+	}
+
+	newArgs = make([]any, len(names))
+	for i, name := range names {
+		newArgs[i], _ = lookup(name)
+	}
+
+	return newSQL, newArgs, nil
+}
+
+// namedStructArg returns the value selected by name from the struct v. name may be a dotted path through nested
+// structs.
+func namedStructArg(v reflect.Value, name string) (any, bool) {
+	for {
+		fieldName, rest, nested := strings.Cut(name, ".")
+		field, ok := namedStructField(v, fieldName)
+		if !ok {
+			return nil, false
+		}
+
+		if !nested {
+			return field.Interface(), true
+		}
+
+		for field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				// Everything below a nil pointer is NULL.
+				return nil, namedStructPathExists(field.Type().Elem(), rest)
+			}
+			field = field.Elem()
+		}
+		if field.Kind() != reflect.Struct {
+			return nil, false
+		}
+
+		v = field
+		name = rest
+	}
+}
+
+// namedStructField returns the field of the struct v matching name. As with Go's promoted fields, embedded structs are
+// searched breadth-first so the shallowest match wins. If there are several matches at the same depth a field with a
+// db tag is preferred, otherwise the name is ambiguous and no field is returned. A struct type is not searched again
+// below itself so structs that embed each other do not recurse forever.
+func namedStructField(v reflect.Value, name string) (reflect.Value, bool) {
+	type embeddedStruct struct {
+		v     reflect.Value
+		isNil bool           // v is the zero value of a struct reached through a nil pointer
+		path  []reflect.Type // the struct types from the root to v
+	}
+
+	type match struct {
+		v      reflect.Value
+		tagged bool
+	}
+
+	current := []embeddedStruct{{v: v, path: []reflect.Type{v.Type()}}}
+	for len(current) > 0 {
+		var next []embeddedStruct
+		var matches []match
+
+		for _, es := range current {
+			t := es.v.Type()
+			for i := 0; i < t.NumField(); i++ {
+				sf := t.Field(i)
+				tag, hasTag := sf.Tag.Lookup("db")
+				tag, _, _ = strings.Cut(tag, ",")
+				if tag == "-" {
+					continue
+				}
+
+				if sf.Anonymous && !hasTag {
+					embedded := es.v.Field(i)
+					isNil := es.isNil
+					if embedded.Kind() == reflect.Pointer {
+						if embedded.IsNil() {
+							// Search the zero value of the struct type to find out if the field exists.
+							embedded = reflect.New(embedded.Type().Elem()).Elem()
+							isNil = true
+						} else {
+							embedded = embedded.Elem()
+						}
+					}
+					if embedded.Kind() == reflect.Struct {
+						if !containsType(es.path, embedded.Type()) {
+							path := append(es.path[:len(es.path):len(es.path)], embedded.Type())
+							next = append(next, embeddedStruct{v: embedded, isNil: isNil, path: path})
+						}
+						continue
+					}
+				}
+
+				if !sf.IsExported() {
+					continue
+				}
+
+				if tag != "" && tag != name || tag == "" && !strings.EqualFold(sf.Name, name) {
+					continue
+				}
+
+				field := es.v.Field(i)
+				if !field.CanInterface() {
+					continue
+				}
+				if es.isNil {
+					// Everything below a nil pointer is NULL.
+					field = reflect.Zero(reflect.TypeOf((*any)(nil)).Elem())
+				}
+				matches = append(matches, match{v: field, tagged: tag != ""})
+			}
+		}
+
+		if len(matches) == 1 {
+			return matches[0].v, true
+		}
+		if len(matches) > 1 {
+			// A field with a db tag takes precedence over untagged fields at the same depth.
+			var tagged []match
+			for _, m := range matches {
+				if m.tagged {
+					tagged = append(tagged, m)
+				}
+			}
+			if len(tagged) == 1 {
+				return tagged[0].v, true
+			}
+			return reflect.Value{}, false
+		}
+
+		current = next
+	}
+
+	return reflect.Value{}, false
+}
+
+func containsType(types []reflect.Type, t reflect.Type) bool {
+	for _, tt := range types {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// namedStructPathExists returns true if name selects a field of the struct type t.
+func namedStructPathExists(t reflect.Type, name string) bool {
+	_, ok := namedStructArg(reflect.New(t).Elem(), name)
+	return ok
+}
+
+// rewriteNamedArgs replaces the named placeholders in sql recognized by syntax with '$' ordinal placeholders. It
+// returns the new SQL and the placeholder names in ordinal order. If dottedNames is true, names may be dotted paths
+// (e.g. @address.city).
+func rewriteNamedArgs(sql string, syntax NamedArgSyntax, dottedNames bool) (newSQL string, names []string) {
 	l := &sqlLexer{
 		src:           sql,
 		stateFn:       rawState,
-		nameToOrdinal: make(map[namedArg]int, len(na)),
+		syntax:        syntax,
+		dottedNames:   dottedNames,
+		nameToOrdinal: make(map[namedArg]int),
 	}
 
 	for l.stateFn != nil {
@@ -39,16 +383,19 @@ func (na NamedArgs) RewriteQuery(ctx context.Context, conn *Conn, sql string, ar
 		}
 	}
 
-	newArgs = make([]any, len(l.nameToOrdinal))
+	names = make([]string, len(l.nameToOrdinal))
 	for name, ordinal := range l.nameToOrdinal {
-		newArgs[ordinal-1] = na[string(name)]
+		names[ordinal-1] = string(name)
 	}
 
-	return sb.String(), newArgs, nil
+	return sb.String(), names
 }
 
 type namedArg string
 
+// ordinalArg is a '$' ordinal placeholder. It is only lexed if sqlLexer.ordinals is set.
+type ordinalArg int
+
 type sqlLexer struct {
 	src     string
 	start   int
@@ -57,6 +404,12 @@ type sqlLexer struct {
 	stateFn stateFn
 	parts   []any
 
+	syntax      NamedArgSyntax // placeholder syntaxes that are lexed as named args.
+	dottedNames bool           // allow '.' between the parts of a named arg.
+	ordinals    bool           // lex '$' ordinal placeholders as ordinalArg.
+	dollarTag   string         // tag of the dollar-quoted string being lexed including both '$'.
+	splitter    *statementSplitter
+
 	nameToOrdinal map[namedArg]int
 }
 
@@ -67,6 +420,10 @@ func rawState(l *sqlLexer) stateFn {
 		r, width := utf8.DecodeRuneInString(l.src[l.pos:])
 		l.pos += width
 
+		if l.splitter != nil && l.splitter.raw(l, r, width) {
+			continue
+		}
+
 		switch r {
 		case 'e', 'E':
 			nextRune, width := utf8.DecodeRuneInString(l.src[l.pos:])
@@ -80,12 +437,48 @@ func rawState(l *sqlLexer) stateFn {
 			return doubleQuoteState
 		case '@':
 			nextRune, _ := utf8.DecodeRuneInString(l.src[l.pos:])
-			if isLetter(nextRune) {
-				if l.pos-l.start > 0 {
+			if l.syntax&NamedArgSyntaxAt != 0 && isLetter(nextRune) {
+				return l.beginNamedArg(width)
+			}
+		case ':':
+			nextRune, nextWidth := utf8.DecodeRuneInString(l.src[l.pos:])
+			if nextRune == ':' {
+				// A :: cast.
+				l.pos += nextWidth
+			} else if l.syntax&NamedArgSyntaxColon != 0 && isLetter(nextRune) && !l.followsIdentifier(width) {
+				return l.beginNamedArg(width)
+			}
+		case '$':
+			if l.followsIdentifier(width) {
+				// '$' is allowed in identifiers after the first character.
+				continue
+			}
+
+			// A dollar-quoted string starts with $$ or $tag$ where tag is an identifier without '$'.
+			tagLen := 0
+			for {
+				r, w := utf8.DecodeRuneInString(l.src[l.pos+tagLen:])
+				if !(isLetter(r) || r == '_' || (tagLen > 0 && r >= '0' && r <= '9')) {
+					break
+				}
+				tagLen += w
+			}
+			if strings.HasPrefix(l.src[l.pos+tagLen:], "$") {
+				l.dollarTag = l.src[l.pos-width : l.pos+tagLen+1]
+				l.pos += tagLen + 1
+				return dollarQuoteState
+			}
+
+			nextRune, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+			if l.syntax&NamedArgSyntaxDollar != 0 && isLetter(nextRune) {
+				return l.beginNamedArg(width)
+			}
+			if l.ordinals && nextRune >= '0' && nextRune <= '9' {
+				if l.pos-width-l.start > 0 {
 					l.parts = append(l.parts, l.src[l.start:l.pos-width])
 				}
 				l.start = l.pos
-				return namedArgState
+				return ordinalArgState
 			}
 		case '-':
 			nextRune, width := utf8.DecodeRuneInString(l.src[l.pos:])
@@ -113,11 +506,33 @@ func isLetter(r rune) bool {
 	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
 }
 
+// followsIdentifier returns true if the rune of width ending at l.pos immediately follows a letter, digit, or '_'.
+func (l *sqlLexer) followsIdentifier(width int) bool {
+	prevRune, _ := utf8.DecodeLastRuneInString(l.src[:l.pos-width])
+	return isLetter(prevRune) || (prevRune >= '0' && prevRune <= '9') || prevRune == '_'
+}
+
+// beginNamedArg ends the current raw part before the placeholder prefix of width and starts lexing a named arg.
+func (l *sqlLexer) beginNamedArg(width int) stateFn {
+	if l.pos-width-l.start > 0 {
+		l.parts = append(l.parts, l.src[l.start:l.pos-width])
+	}
+	l.start = l.pos
+	return namedArgState
+}
+
 func namedArgState(l *sqlLexer) stateFn {
 	for {
 		r, width := utf8.DecodeRuneInString(l.src[l.pos:])
 		l.pos += width
 
+		if r == '.' && l.dottedNames {
+			nextRune, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+			if isLetter(nextRune) {
+				continue
+			}
+		}
+
 		if r == utf8.RuneError {
 			if l.pos-l.start > 0 {
 				na := namedArg(l.src[l.start:l.pos])
@@ -141,6 +556,38 @@ func namedArgState(l *sqlLexer) stateFn {
 	}
 }
 
+func ordinalArgState(l *sqlLexer) stateFn {
+	for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+		l.pos++
+	}
+
+	n, err := strconv.Atoi(l.src[l.start:l.pos])
+	if err != nil {
+		// Too large to be a valid ordinal. Keep it as text so the server reports the error.
+		l.parts = append(l.parts, l.src[l.start-1:l.pos])
+	} else {
+		l.parts = append(l.parts, ordinalArg(n))
+	}
+	l.start = l.pos
+
+	return rawState
+}
+
+func dollarQuoteState(l *sqlLexer) stateFn {
+	end := strings.Index(l.src[l.pos:], l.dollarTag)
+	if end == -1 {
+		l.pos = len(l.src)
+		if l.pos-l.start > 0 {
+			l.parts = append(l.parts, l.src[l.start:l.pos])
+			l.start = l.pos
+		}
+		return nil
+	}
+
+	l.pos += end + len(l.dollarTag)
+	return rawState
+}
+
 func singleQuoteState(l *sqlLexer) stateFn {
 	for {
 		r, width := utf8.DecodeRuneInString(l.src[l.pos:])
@@ -3,8 +3,11 @@ package pgx_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -93,6 +96,12 @@ func TestNamedArgsRewriteQuery(t *testing.T) {
 			where id = $1;`,
 			expectedArgs: []any{int32(42)},
 		},
+		{
+			sql:          `select $$@foo$$, $tag$ @bar $tag$, $1, @id`,
+			namedArgs:    pgx.NamedArgs{"id": int32(42)},
+			expectedSQL:  `select $$@foo$$, $tag$ @bar $tag$, $1, $1`,
+			expectedArgs: []any{int32(42)},
+		},
 
 		// test comments and quotes
 	} {
@@ -102,3 +111,259 @@ func TestNamedArgsRewriteQuery(t *testing.T) {
 		assert.Equalf(t, tt.expectedArgs, args, "%d", i)
 	}
 }
+
+func TestNamedArgsRewriterSyntax(t *testing.T) {
+	t.Parallel()
+
+	args := pgx.NamedArgs{"id": int32(42), "name": "foo"}
+
+	for i, tt := range []struct {
+		syntax       pgx.NamedArgSyntax
+		sql          string
+		expectedSQL  string
+		expectedArgs []any
+	}{
+		{
+			syntax:       0,
+			sql:          "select * from users where id = @id and name = :name",
+			expectedSQL:  "select * from users where id = $1 and name = :name",
+			expectedArgs: []any{int32(42)},
+		},
+		{
+			syntax:       pgx.NamedArgSyntaxColon,
+			sql:          "select :id::int, :name::text, '{1,2}'::int[], :'name', x[1:2] from users where id = :id and name = @name",
+			expectedSQL:  "select $1::int, $2::text, '{1,2}'::int[], :'name', x[1:2] from users where id = $1 and name = @name",
+			expectedArgs: []any{int32(42), "foo"},
+		},
+		{
+			syntax:       pgx.NamedArgSyntaxDollar,
+			sql:          "select $id::int, $1, $$ $name $$, $fn$ select $name $fn$, foo$name from users where name = $name",
+			expectedSQL:  "select $1::int, $1, $$ $name $$, $fn$ select $name $fn$, foo$name from users where name = $2",
+			expectedArgs: []any{int32(42), "foo"},
+		},
+		{
+			syntax:       pgx.NamedArgSyntaxAt | pgx.NamedArgSyntaxColon | pgx.NamedArgSyntaxDollar,
+			sql:          "select @id, :id, $id, :name -- :ignored",
+			expectedSQL:  "select $1, $1, $1, $2 -- :ignored",
+			expectedArgs: []any{int32(42), "foo"},
+		},
+		{
+			syntax:       pgx.NamedArgSyntaxDollar,
+			sql:          "select $unterminated$ $id",
+			expectedSQL:  "select $unterminated$ $id",
+			expectedArgs: []any{},
+		},
+	} {
+		nar := &pgx.NamedArgsRewriter{Syntax: tt.syntax, Args: args}
+		sql, args, err := nar.RewriteQuery(context.Background(), nil, tt.sql, nil)
+		require.NoError(t, err)
+		assert.Equalf(t, tt.expectedSQL, sql, "%d", i)
+		assert.Equalf(t, tt.expectedArgs, args, "%d", i)
+	}
+}
+
+func TestNamedArgsRewriterStruct(t *testing.T) {
+	t.Parallel()
+
+	type Address struct {
+		City string
+		Zip  string `db:"postal_code"`
+	}
+
+	type Timestamps struct {
+		CreatedAt string `db:"created_at"`
+	}
+
+	type user struct {
+		ID       int32 `db:"id"`
+		Name     string
+		Nickname *string
+		Secret   string `db:"-"`
+		password string
+		Address  Address
+		Previous *Address
+		Timestamps
+	}
+
+	nickname := "bob"
+	u := &user{
+		ID:         42,
+		Name:       "Robert",
+		Nickname:   &nickname,
+		Secret:     "hidden",
+		password:   "hidden",
+		Address:    Address{City: "Dallas", Zip: "75001"},
+		Timestamps: Timestamps{CreatedAt: "2022-01-01"},
+	}
+
+	nar := &pgx.NamedArgsRewriter{Syntax: pgx.NamedArgSyntaxColon, Args: u}
+	sql, args, err := nar.RewriteQuery(
+		context.Background(),
+		nil,
+		"insert into users values (:id, :name, :nickname, :address.city, :address.postal_code, :previous.city, :created_at, :secret, :password, :missing)",
+		nil,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "insert into users values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", sql)
+	assert.Equal(t, []any{int32(42), "Robert", &nickname, "Dallas", "75001", nil, "2022-01-01", nil, nil, nil}, args)
+
+	// A map of another type can be used.
+	nar = &pgx.NamedArgsRewriter{Args: map[string]string{"name": "Robert"}}
+	_, args, err = nar.RewriteQuery(context.Background(), nil, "select @name", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []any{"Robert"}, args)
+
+	nar = &pgx.NamedArgsRewriter{Args: 42}
+	_, _, err = nar.RewriteQuery(context.Background(), nil, "select @name", nil)
+	require.Error(t, err)
+}
+
+type namedArgsCycleA struct {
+	*namedArgsCycleB
+	Name string
+}
+
+type namedArgsCycleB struct {
+	*namedArgsCycleA
+	Age int32
+}
+
+func TestNamedArgsRewriterStructEmbeddedCycle(t *testing.T) {
+	t.Parallel()
+
+	a := &namedArgsCycleA{Name: "foo"}
+	for _, args := range []any{namedArgsCycleA{}, a, &namedArgsCycleA{namedArgsCycleB: &namedArgsCycleB{namedArgsCycleA: a, Age: 7}}} {
+		nar := &pgx.NamedArgsRewriter{Args: args}
+		_, _, err := nar.RewriteQuery(context.Background(), nil, "select @missing, @name, @age", nil)
+		require.NoError(t, err)
+	}
+
+	nar := &pgx.NamedArgsRewriter{Args: &namedArgsCycleA{Name: "bar", namedArgsCycleB: &namedArgsCycleB{namedArgsCycleA: a, Age: 7}}, Strict: true}
+	_, args, err := nar.RewriteQuery(context.Background(), nil, "select @name, @age", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []any{"bar", int32(7)}, args)
+
+	nar = &pgx.NamedArgsRewriter{Args: namedArgsCycleA{}, Strict: true}
+	_, args, err = nar.RewriteQuery(context.Background(), nil, "select @age", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []any{nil}, args)
+	_, _, err = nar.RewriteQuery(context.Background(), nil, "select @missing", nil)
+	var namedArgsErr *pgx.NamedArgsError
+	require.ErrorAs(t, err, &namedArgsErr)
+	assert.Equal(t, []string{"missing"}, namedArgsErr.Missing)
+}
+
+type namedArgsInner struct {
+	ID   int32
+	Name string
+}
+
+type namedArgsOuter struct {
+	namedArgsInner
+}
+
+type namedArgsShallow struct {
+	ID    int32
+	Name  string
+	Email string `db:"email"`
+}
+
+type namedArgsOther struct {
+	Name  string
+	Email string
+}
+
+func TestNamedArgsRewriterStructEmbeddedShadowing(t *testing.T) {
+	t.Parallel()
+
+	// As with Go's promoted fields the shallowest field wins regardless of the order of the embedded structs. Fields at
+	// the same depth are ambiguous unless exactly one of them has a db tag.
+	args := struct {
+		namedArgsOuter
+		namedArgsShallow
+		namedArgsOther
+	}{
+		namedArgsOuter:   namedArgsOuter{namedArgsInner{ID: 1, Name: "deep"}},
+		namedArgsShallow: namedArgsShallow{ID: 2, Name: "shallow", Email: "tagged"},
+		namedArgsOther:   namedArgsOther{Name: "other", Email: "untagged"},
+	}
+
+	nar := &pgx.NamedArgsRewriter{Args: args}
+	_, newArgs, err := nar.RewriteQuery(context.Background(), nil, "select @id, @email, @name", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []any{int32(2), "tagged", nil}, newArgs)
+
+	nar.Strict = true
+	_, _, err = nar.RewriteQuery(context.Background(), nil, "select @name", nil)
+	var namedArgsErr *pgx.NamedArgsError
+	require.ErrorAs(t, err, &namedArgsErr)
+	assert.Equal(t, []string{"name"}, namedArgsErr.Missing)
+}
+
+func TestStrictNamedArgsRewriteQuery(t *testing.T) {
+	t.Parallel()
+
+	sql, args, err := pgx.StrictNamedArgs{"id": int32(42), "name": "foo"}.RewriteQuery(context.Background(), nil, "select @name, @id, @name", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "select $1, $2, $1", sql)
+	assert.Equal(t, []any{"foo", int32(42)}, args)
+
+	_, _, err = pgx.StrictNamedArgs{"id": int32(42), "nmae": "foo", "extra": 1}.RewriteQuery(context.Background(), nil, "select @name, @id, @other", nil)
+	var namedArgsErr *pgx.NamedArgsError
+	require.ErrorAs(t, err, &namedArgsErr)
+	assert.Equal(t, []string{"name", "other"}, namedArgsErr.Missing)
+	assert.Equal(t, []string{"extra", "nmae"}, namedArgsErr.Unused)
+	assert.Equal(t, `missing named args: "name", "other"; unused named args: "extra", "nmae"`, err.Error())
+
+	_, _, err = pgx.StrictNamedArgs{"id": int32(42), "extra": 1}.RewriteQuery(context.Background(), nil, "select @id", nil)
+	assert.EqualError(t, err, `unused named args: "extra"`)
+}
+
+func TestNamedArgsRewriterStrict(t *testing.T) {
+	t.Parallel()
+
+	type user struct {
+		ID   int32
+		Name string
+	}
+
+	nar := &pgx.NamedArgsRewriter{Syntax: pgx.NamedArgSyntaxColon, Args: user{ID: 42}, Strict: true}
+	_, args, err := nar.RewriteQuery(context.Background(), nil, "select :id", nil)
+	require.NoError(t, err, "unused struct fields are allowed")
+	assert.Equal(t, []any{int32(42)}, args)
+
+	_, _, err = nar.RewriteQuery(context.Background(), nil, "select :id, :nmae", nil)
+	assert.EqualError(t, err, `missing named args: "nmae"`)
+
+	nar = &pgx.NamedArgsRewriter{Args: map[string]any{"id": 1, "name": "foo"}, Strict: true}
+	_, _, err = nar.RewriteQuery(context.Background(), nil, "select @id", nil)
+	assert.EqualError(t, err, `unused named args: "name"`)
+}
+
+func TestStrictNamedArgsBatch(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, server.ConnString())
+	require.NoError(t, err)
+
+	batch := &pgx.Batch{}
+	batch.Queue("insert into users (id, name) values (@id, @name)", pgx.StrictNamedArgs{"id": 1, "nmae": "foo"})
+	err = conn.SendBatch(ctx, batch).Close()
+	var namedArgsErr *pgx.NamedArgsError
+	require.ErrorAs(t, err, &namedArgsErr)
+	assert.Equal(t, []string{"name"}, namedArgsErr.Missing)
+	assert.Equal(t, []string{"nmae"}, namedArgsErr.Unused)
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
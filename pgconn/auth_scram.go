@@ -3,6 +3,7 @@
 // Resources:
 //   https://tools.ietf.org/html/rfc5802
 //   https://tools.ietf.org/html/rfc8265
+//   https://tools.ietf.org/html/rfc5929#section-4 (tls-server-end-point channel binding)
 //   https://www.postgresql.org/docs/current/sasl-authentication.html
 //
 // Inspiration drawn from other implementations:
@@ -17,11 +18,16 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash"
 	"strconv"
 
+	"github.com/jackc/pgx/v5/internal/nbconn"
 	"github.com/jackc/pgx/v5/pgproto3"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/text/secure/precis"
@@ -29,32 +35,43 @@ import (
 
 const clientNonceLen = 18
 
-// Perform SCRAM authentication.
-func (c *PgConn) scramAuth(serverAuthMechanisms []string) error {
-	sc, err := newScramClient(serverAuthMechanisms, c.config.Password)
+const (
+	scramSHA256Name     = "SCRAM-SHA-256"
+	scramSHA256PlusName = "SCRAM-SHA-256-PLUS"
+)
+
+// Perform SCRAM authentication. channelBound reports whether SCRAM-SHA-256-PLUS channel binding was used.
+func (c *PgConn) scramAuth(serverAuthMechanisms []string) (channelBound bool, err error) {
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := c.conn.(*nbconn.TLSConn); ok {
+		cs := tlsConn.ConnectionState()
+		tlsState = &cs
+	}
+
+	sc, err := newScramClient(serverAuthMechanisms, c.config.Password, c.config.ChannelBinding, tlsState)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Send client-first-message in a SASLInitialResponse
 	saslInitialResponse := &pgproto3.SASLInitialResponse{
-		AuthMechanism: "SCRAM-SHA-256",
+		AuthMechanism: sc.authMechanism,
 		Data:          sc.clientFirstMessage(),
 	}
 	c.frontend.Send(saslInitialResponse)
 	err = c.frontend.Flush()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Receive server-first-message payload in a AuthenticationSASLContinue.
 	saslContinue, err := c.rxSASLContinue()
 	if err != nil {
-		return err
+		return false, err
 	}
 	err = sc.recvServerFirstMessage(saslContinue.Data)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Send client-final-message in a SASLResponse
@@ -64,15 +81,20 @@ func (c *PgConn) scramAuth(serverAuthMechanisms []string) error {
 	c.frontend.Send(saslResponse)
 	err = c.frontend.Flush()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Receive server-final-message payload in a AuthenticationSASLFinal.
 	saslFinal, err := c.rxSASLFinal()
 	if err != nil {
-		return err
+		return false, err
+	}
+	err = sc.recvServerFinalMessage(saslFinal.Data)
+	if err != nil {
+		return false, err
 	}
-	return sc.recvServerFinalMessage(saslFinal.Data)
+
+	return sc.authMechanism == scramSHA256PlusName, nil
 }
 
 func (c *PgConn) rxSASLContinue() (*pgproto3.AuthenticationSASLContinue, error) {
@@ -110,6 +132,10 @@ type scramClient struct {
 	password             []byte
 	clientNonce          []byte
 
+	authMechanism string
+	gs2Header     []byte // GS2 header of the client-first-message (e.g. "n,,")
+	cbindData     []byte // channel binding data sent after gs2Header in the client-final-message
+
 	clientFirstMessageBare []byte
 
 	serverFirstMessage   []byte
@@ -121,21 +147,63 @@ type scramClient struct {
 	authMessage    []byte
 }
 
-func newScramClient(serverAuthMechanisms []string, password string) (*scramClient, error) {
+func newScramClient(serverAuthMechanisms []string, password string, channelBinding string, tlsState *tls.ConnectionState) (*scramClient, error) {
 	sc := &scramClient{
 		serverAuthMechanisms: serverAuthMechanisms,
 	}
 
-	// Ensure server supports SCRAM-SHA-256
 	hasScramSHA256 := false
+	hasScramSHA256Plus := false
 	for _, mech := range sc.serverAuthMechanisms {
-		if mech == "SCRAM-SHA-256" {
+		switch mech {
+		case scramSHA256Name:
 			hasScramSHA256 = true
-			break
+		case scramSHA256PlusName:
+			hasScramSHA256Plus = true
 		}
 	}
-	if !hasScramSHA256 {
-		return nil, errors.New("server does not support SCRAM-SHA-256")
+
+	useChannelBinding := false
+	switch channelBinding {
+	case "require":
+		if tlsState == nil {
+			return nil, errors.New("channel binding required but connection is not using TLS")
+		}
+		if !hasScramSHA256Plus {
+			return nil, errors.New("channel binding required but server does not support SCRAM-SHA-256-PLUS")
+		}
+		useChannelBinding = true
+	case "disable":
+	default:
+		useChannelBinding = tlsState != nil && hasScramSHA256Plus
+	}
+
+	if useChannelBinding {
+		if len(tlsState.PeerCertificates) == 0 {
+			return nil, errors.New("channel binding requires a server certificate")
+		}
+		certHash, err := tlsServerEndPointHash(tlsState.PeerCertificates[0])
+		if err != nil {
+			return nil, err
+		}
+
+		sc.authMechanism = scramSHA256PlusName
+		sc.gs2Header = []byte("p=tls-server-end-point,,")
+		sc.cbindData = certHash
+	} else {
+		// Ensure server supports SCRAM-SHA-256
+		if !hasScramSHA256 {
+			return nil, errors.New("server does not support SCRAM-SHA-256")
+		}
+
+		sc.authMechanism = scramSHA256Name
+		if tlsState != nil && channelBinding != "disable" {
+			// The client supports channel binding but the server did not offer it. Tell the server so it can detect a
+			// downgrade attack.
+			sc.gs2Header = []byte("y,,")
+		} else {
+			sc.gs2Header = []byte("n,,")
+		}
 	}
 
 	// precis.OpaqueString is equivalent to SASLprep for password.
@@ -159,7 +227,7 @@ func newScramClient(serverAuthMechanisms []string, password string) (*scramClien
 
 func (sc *scramClient) clientFirstMessage() []byte {
 	sc.clientFirstMessageBare = []byte(fmt.Sprintf("n=,r=%s", sc.clientNonce))
-	return []byte(fmt.Sprintf("n,,%s", sc.clientFirstMessageBare))
+	return []byte(fmt.Sprintf("%s%s", sc.gs2Header, sc.clientFirstMessageBare))
 }
 
 func (sc *scramClient) recvServerFirstMessage(serverFirstMessage []byte) error {
@@ -218,7 +286,8 @@ func (sc *scramClient) recvServerFirstMessage(serverFirstMessage []byte) error {
 }
 
 func (sc *scramClient) clientFinalMessage() string {
-	clientFinalMessageWithoutProof := []byte(fmt.Sprintf("c=biws,r=%s", sc.clientAndServerNonce))
+	cbindInput := append(append([]byte{}, sc.gs2Header...), sc.cbindData...)
+	clientFinalMessageWithoutProof := []byte(fmt.Sprintf("c=%s,r=%s", base64.StdEncoding.EncodeToString(cbindInput), sc.clientAndServerNonce))
 
 	sc.saltedPassword = pbkdf2.Key([]byte(sc.password), sc.salt, sc.iterations, 32, sha256.New)
 	sc.authMessage = bytes.Join([][]byte{sc.clientFirstMessageBare, sc.serverFirstMessage, clientFinalMessageWithoutProof}, []byte(","))
@@ -242,6 +311,27 @@ func (sc *scramClient) recvServerFinalMessage(serverFinalMessage []byte) error {
 	return nil
 }
 
+// tlsServerEndPointHash returns the tls-server-end-point channel binding data for cert. It is the hash of the DER
+// encoded certificate using the hash function of the certificate's signature algorithm. MD5 and SHA-1 are replaced with
+// SHA-256.
+func tlsServerEndPointHash(cert *x509.Certificate) ([]byte, error) {
+	var h hash.Hash
+	switch cert.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.ECDSAWithSHA256, x509.DSAWithSHA256:
+		h = sha256.New()
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		h = sha512.New384()
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("channel binding is not supported for server certificate signature algorithm %v", cert.SignatureAlgorithm)
+	}
+
+	h.Write(cert.Raw)
+	return h.Sum(nil), nil
+}
+
 func computeHMAC(key, msg []byte) []byte {
 	mac := hmac.New(sha256.New, key)
 	mac.Write(msg)
@@ -20,6 +20,7 @@ import (
 
 	"github.com/jackc/pgpassfile"
 	"github.com/jackc/pgservicefile"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
 	"github.com/jackc/pgx/v5/pgproto3"
 )
 
@@ -37,15 +38,72 @@ type Config struct {
 	Password       string
 	TLSConfig      *tls.Config // nil disables TLS
 	ConnectTimeout time.Duration
-	DialFunc       DialFunc   // e.g. net.Dialer.DialContext
-	LookupFunc     LookupFunc // e.g. net.Resolver.LookupHost
+	DialFunc       DialFunc      // e.g. net.Dialer.DialContext
+	LookupFunc     LookupFunc    // e.g. net.Resolver.LookupHost
+	LookupSRVFunc  LookupSRVFunc // e.g. net.Resolver.LookupSRV
 	BuildFrontend  BuildFrontendFunc
 	RuntimeParams  map[string]string // Run-time parameters to set on connection as session default values (e.g. search_path or application_name)
 
+	// ParallelConnectDelay enables connection attempts to race against each other when there are multiple hosts,
+	// addresses, or fallbacks, in the manner of RFC 8305 ("Happy Eyeballs"). If non-zero, the next attempt is started
+	// after ParallelConnectDelay or as soon as all attempts in progress have failed. The first attempt that succeeds,
+	// including ValidateConnect, is used and the others are canceled. RFC 8305 recommends 250ms. If zero (the default),
+	// attempts are made one at a time.
+	ParallelConnectDelay time.Duration
+
 	KerberosSrvName string
 	KerberosSpn     string
 	Fallbacks       []*FallbackConfig
 
+	// ChannelBinding controls the use of SCRAM-SHA-256-PLUS channel binding on TLS connections. It is one of "disable",
+	// "prefer", or "require". An empty value is treated as "prefer". With "require" the connection fails unless the
+	// server authenticates with SCRAM-SHA-256-PLUS.
+	ChannelBinding string
+
+	// RequireAuth restricts the authentication methods the server may request. It has the same format as the libpq
+	// require_auth setting (e.g. "scram-sha-256" or "!password,!md5"). An empty value allows any method.
+	RequireAuth string
+
+	// MinProtocolVersion and MaxProtocolVersion are the range of protocol versions to accept. Each is one of "3.0",
+	// "3.2", or "latest". The connection requests MaxProtocolVersion and accepts a lower version negotiated by the server
+	// if it is not less than MinProtocolVersion. Empty values default to "3.0".
+	MinProtocolVersion string
+	MaxProtocolVersion string
+
+	// SSLNegotiation is how TLS is negotiated with the server. With "postgres" (the default if empty) an SSLRequest is
+	// sent before the TLS handshake. With "direct" the TLS handshake starts immediately and the server must select the
+	// "postgresql" ALPN protocol. Direct negotiation requires PostgreSQL 17 or later.
+	SSLNegotiation string
+
+	// SSLCertMode controls whether a client certificate is sent. It is one of "disable", "allow" (the default if empty),
+	// or "require". With "require" the connection fails if the server does not request a client certificate.
+	SSLCertMode string
+
+	// SRVName is the name of the DNS SRV records that list the hosts to connect to (e.g. "_postgresql._tcp.db.internal").
+	// If set, each fallback config with Host equal to SRVName is replaced by the targets of the SRV records, using the
+	// port of each record. Targets are ordered by priority and then randomly by weight as described in RFC 2782.
+	// ParseConfig sets SRVName from the srvname setting or from the host of a postgres+srv:// URL.
+	SRVName string
+
+	// LoadBalanceHosts controls the order in which hosts are tried. With "disable" (the default if empty) hosts are tried
+	// in the order given. With "random" the hosts, and the addresses each host resolves to, are tried in random order.
+	LoadBalanceHosts string
+
+	// MaxMessageBodyLen is the maximum length in octets of the body of a message received from the server. A connection
+	// that receives a larger message fails with a *pgproto3.ExceededMaxBodyLenErr and is closed. If zero (the default)
+	// there is no limit. ParseConfig sets MaxMessageBodyLen from the max_message_body_len setting.
+	MaxMessageBodyLen int
+
+	// TraceWriter, if not nil, receives a trace of every message sent to and received from the server, including the
+	// startup and authentication messages. The format is selected by TraceOptions. Set TraceOptions.JSON to write one
+	// pgproto3.TraceRecord per line. Each traced message is written with a single Write call. TraceWriter must be safe
+	// for concurrent use if it is shared between connections.
+	TraceWriter  io.Writer
+	TraceOptions pgproto3.TracerOptions
+
+	// ReplicationMode starts the connection as a physical or logical replication connection. See StartReplication.
+	ReplicationMode ReplicationMode
+
 	// ValidateConnect is called during a connection attempt after a successful authentication with the PostgreSQL server.
 	// It can be used to validate that the server is acceptable. If this returns an error the connection is closed and the next
 	// fallback config is tried. This allows implementing high availability behavior such as libpq does with target_session_attrs.
@@ -55,6 +113,12 @@ type Config struct {
 	// or prepare statements). If this returns an error the connection attempt fails.
 	AfterConnect AfterConnectFunc
 
+	// BuildContextWatcherHandler is called to build the handler that determines what happens when the context of an
+	// operation in progress on an established connection is canceled. DeadlineContextWatcherHandler is used while the
+	// connection is being established. ParseConfig sets it to build a DeadlineContextWatcherHandler.
+	// CancelRequestContextWatcherHandler can be used to cancel queries without closing the connection.
+	BuildContextWatcherHandler func(*PgConn) ctxwatch.Handler
+
 	// OnNotice is a callback function called when a notice response is received.
 	OnNotice NoticeHandler
 
@@ -180,9 +244,20 @@ func NetworkAddress(host string, port uint16) (network, address string) {
 //	PGSSLKEY
 //	PGSSLROOTCERT
 //	PGSSLPASSWORD
+//	PGSSLNEGOTIATION
+//	PGSSLCRL
+//	PGSSLCRLDIR
+//	PGSSLCERTMODE
+//	PGSSLMINPROTOCOLVERSION
+//	PGSSLMAXPROTOCOLVERSION
 //	PGAPPNAME
 //	PGCONNECT_TIMEOUT
 //	PGTARGETSESSIONATTRS
+//	PGCHANNELBINDING
+//	PGREQUIREAUTH
+//	PGMINPROTOCOLVERSION
+//	PGMAXPROTOCOLVERSION
+//	PGLOADBALANCEHOSTS
 //
 // See http://www.postgresql.org/docs/11/static/libpq-envars.html for details on the meaning of environment variables.
 //
@@ -209,11 +284,26 @@ func NetworkAddress(host string, port uint16) (network, address string) {
 // When multiple hosts are specified, libpq allows them to have different passwords set via the .pgpass file. pgconn
 // does not.
 //
+// The keepalives, keepalives_idle, keepalives_interval, keepalives_count, and tcp_user_timeout settings configure the
+// default DialFunc. keepalives_interval, keepalives_count, and tcp_user_timeout are only supported on Linux. If
+// keepalives_idle is not set the idle time is 5 minutes rather than the operating system default.
+//
 // In addition, ParseConfig accepts the following options:
 //
 //	servicefile
 //		libpq only reads servicefile from the PGSERVICEFILE environment variable. ParseConfig accepts servicefile as a
 //		part of the connection string.
+//
+//	srvname
+//		The name of DNS SRV records that list the hosts to connect to. See Config.SRVName. A postgres+srv:// URL sets
+//		srvname from its host. For example, postgres+srv://jack@db.example.com/mydb looks up the SRV records for
+//		_postgresql._tcp.db.example.com.
+//
+//	max_message_body_len
+//		The maximum length in octets of the body of a message received from the server. See Config.MaxMessageBodyLen.
+//
+// The replication parameter is parsed into Config.ReplicationMode rather than passed as a run-time parameter. It
+// accepts the same values as libpq: "database" for logical replication and a boolean for physical replication.
 func ParseConfig(connString string) (*Config, error) {
 	var parseConfigOptions ParseConfigOptions
 	return ParseConfigWithOptions(connString, parseConfigOptions)
@@ -230,7 +320,7 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 	if connString != "" {
 		var err error
 		// connString may be a database URL or a DSN
-		if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
+		if isURLConnString(connString) {
 			connStringSettings, err = parseURLSettings(connString)
 			if err != nil {
 				return nil, &parseConfigError{connString: connString, msg: "failed to parse as URL", err: err}
@@ -264,39 +354,67 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 		},
 	}
 
+	keepalive, err := parseKeepaliveSettings(settings)
+	if err != nil {
+		return nil, &parseConfigError{connString: connString, msg: "invalid keepalive settings", err: err}
+	}
+
 	if connectTimeoutSetting, present := settings["connect_timeout"]; present {
 		connectTimeout, err := parseConnectTimeoutSetting(connectTimeoutSetting)
 		if err != nil {
 			return nil, &parseConfigError{connString: connString, msg: "invalid connect_timeout", err: err}
 		}
 		config.ConnectTimeout = connectTimeout
-		config.DialFunc = makeConnectTimeoutDialFunc(connectTimeout)
+		config.DialFunc = makeConnectTimeoutDialFunc(connectTimeout, keepalive)
 	} else {
-		defaultDialer := makeDefaultDialer()
+		defaultDialer := makeDialer(keepalive)
 		config.DialFunc = defaultDialer.DialContext
 	}
 
 	config.LookupFunc = makeDefaultResolver().LookupHost
+	config.BuildContextWatcherHandler = func(pgConn *PgConn) ctxwatch.Handler {
+		return &DeadlineContextWatcherHandler{Conn: pgConn.conn}
+	}
+	config.LookupSRVFunc = makeDefaultResolver().LookupSRV
 
 	notRuntimeParams := map[string]struct{}{
-		"host":                 {},
-		"port":                 {},
-		"database":             {},
-		"user":                 {},
-		"password":             {},
-		"passfile":             {},
-		"connect_timeout":      {},
-		"sslmode":              {},
-		"sslkey":               {},
-		"sslcert":              {},
-		"sslrootcert":          {},
-		"sslpassword":          {},
-		"sslsni":               {},
-		"krbspn":               {},
-		"krbsrvname":           {},
-		"target_session_attrs": {},
-		"service":              {},
-		"servicefile":          {},
+		"host":                     {},
+		"port":                     {},
+		"database":                 {},
+		"user":                     {},
+		"password":                 {},
+		"passfile":                 {},
+		"connect_timeout":          {},
+		"keepalives":               {},
+		"keepalives_idle":          {},
+		"keepalives_interval":      {},
+		"keepalives_count":         {},
+		"tcp_user_timeout":         {},
+		"load_balance_hosts":       {},
+		"srvname":                  {},
+		"max_message_body_len":     {},
+		"sslmode":                  {},
+		"sslkey":                   {},
+		"sslcert":                  {},
+		"sslrootcert":              {},
+		"sslpassword":              {},
+		"sslsni":                   {},
+		"sslnegotiation":           {},
+		"sslcrl":                   {},
+		"sslcrldir":                {},
+		"sslcertmode":              {},
+		"ssl_min_protocol_version": {},
+		"ssl_max_protocol_version": {},
+		"krbspn":                   {},
+		"krbsrvname":               {},
+		"target_session_attrs":     {},
+		"service":                  {},
+		"servicefile":              {},
+		"replication":              {},
+		"channel_binding":          {},
+		"require_auth":             {},
+		"min_protocol_version":     {},
+		"max_protocol_version":     {},
 	}
 
 	// Adding kerberos configuration
@@ -307,6 +425,70 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 		config.KerberosSpn = settings["krbspn"]
 	}
 
+	if replicationSetting, present := settings["replication"]; present {
+		replicationMode, err := parseReplicationMode(replicationSetting)
+		if err != nil {
+			return nil, &parseConfigError{connString: connString, msg: "invalid replication", err: err}
+		}
+		config.ReplicationMode = replicationMode
+	}
+
+	switch channelBinding := settings["channel_binding"]; channelBinding {
+	case "", "disable", "prefer", "require":
+		config.ChannelBinding = channelBinding
+	default:
+		return nil, &parseConfigError{connString: connString, msg: fmt.Sprintf("unknown channel_binding value: %v", channelBinding)}
+	}
+
+	if requireAuth, present := settings["require_auth"]; present {
+		if _, err := parseRequireAuth(requireAuth); err != nil {
+			return nil, &parseConfigError{connString: connString, msg: "invalid require_auth", err: err}
+		}
+		config.RequireAuth = requireAuth
+	}
+
+	if _, _, err := parseProtocolVersionRange(settings["min_protocol_version"], settings["max_protocol_version"]); err != nil {
+		return nil, &parseConfigError{connString: connString, msg: "invalid protocol version", err: err}
+	}
+	config.MinProtocolVersion = settings["min_protocol_version"]
+	config.MaxProtocolVersion = settings["max_protocol_version"]
+
+	switch sslcertmode := settings["sslcertmode"]; sslcertmode {
+	case "", "disable", "allow", "require":
+		config.SSLCertMode = sslcertmode
+	default:
+		return nil, &parseConfigError{connString: connString, msg: fmt.Sprintf("unknown sslcertmode value: %v", sslcertmode)}
+	}
+
+	switch sslnegotiation := settings["sslnegotiation"]; sslnegotiation {
+	case "", "postgres":
+		config.SSLNegotiation = sslnegotiation
+	case "direct":
+		switch effectiveSSLMode(settings) {
+		case "require", "verify-ca", "verify-full":
+		default:
+			return nil, &parseConfigError{connString: connString, msg: "sslnegotiation=direct requires sslmode of require, verify-ca, or verify-full"}
+		}
+		config.SSLNegotiation = sslnegotiation
+	default:
+		return nil, &parseConfigError{connString: connString, msg: fmt.Sprintf("unknown sslnegotiation value: %v", sslnegotiation)}
+	}
+
+	switch loadBalanceHosts := settings["load_balance_hosts"]; loadBalanceHosts {
+	case "", "disable", "random":
+		config.LoadBalanceHosts = loadBalanceHosts
+	default:
+		return nil, &parseConfigError{connString: connString, msg: fmt.Sprintf("unknown load_balance_hosts value: %v", loadBalanceHosts)}
+	}
+
+	if s := settings["max_message_body_len"]; s != "" {
+		maxMessageBodyLen, err := strconv.Atoi(s)
+		if err != nil || maxMessageBodyLen < 0 {
+			return nil, &parseConfigError{connString: connString, msg: "invalid max_message_body_len", err: err}
+		}
+		config.MaxMessageBodyLen = maxMessageBodyLen
+	}
+
 	for k, v := range settings {
 		if _, present := notRuntimeParams[k]; present {
 			continue
@@ -319,6 +501,12 @@ func ParseConfigWithOptions(connString string, options ParseConfigOptions) (*Con
 	hosts := strings.Split(settings["host"], ",")
 	ports := strings.Split(settings["port"], ",")
 
+	// The hosts for an SRV name are found when connecting. Until then the SRV name takes the place of the hosts.
+	if srvName := settings["srvname"]; srvName != "" {
+		config.SRVName = srvName
+		hosts = []string{srvName}
+	}
+
 	for i, host := range hosts {
 		var portStr string
 		if i < len(ports) {
@@ -407,23 +595,34 @@ func parseEnvSettings() map[string]string {
 	settings := make(map[string]string)
 
 	nameMap := map[string]string{
-		"PGHOST":               "host",
-		"PGPORT":               "port",
-		"PGDATABASE":           "database",
-		"PGUSER":               "user",
-		"PGPASSWORD":           "password",
-		"PGPASSFILE":           "passfile",
-		"PGAPPNAME":            "application_name",
-		"PGCONNECT_TIMEOUT":    "connect_timeout",
-		"PGSSLMODE":            "sslmode",
-		"PGSSLKEY":             "sslkey",
-		"PGSSLCERT":            "sslcert",
-		"PGSSLSNI":             "sslsni",
-		"PGSSLROOTCERT":        "sslrootcert",
-		"PGSSLPASSWORD":        "sslpassword",
-		"PGTARGETSESSIONATTRS": "target_session_attrs",
-		"PGSERVICE":            "service",
-		"PGSERVICEFILE":        "servicefile",
+		"PGHOST":                  "host",
+		"PGPORT":                  "port",
+		"PGDATABASE":              "database",
+		"PGUSER":                  "user",
+		"PGPASSWORD":              "password",
+		"PGPASSFILE":              "passfile",
+		"PGAPPNAME":               "application_name",
+		"PGCONNECT_TIMEOUT":       "connect_timeout",
+		"PGSSLMODE":               "sslmode",
+		"PGSSLKEY":                "sslkey",
+		"PGSSLCERT":               "sslcert",
+		"PGSSLSNI":                "sslsni",
+		"PGSSLROOTCERT":           "sslrootcert",
+		"PGSSLPASSWORD":           "sslpassword",
+		"PGSSLNEGOTIATION":        "sslnegotiation",
+		"PGSSLCRL":                "sslcrl",
+		"PGSSLCRLDIR":             "sslcrldir",
+		"PGSSLCERTMODE":           "sslcertmode",
+		"PGSSLMINPROTOCOLVERSION": "ssl_min_protocol_version",
+		"PGSSLMAXPROTOCOLVERSION": "ssl_max_protocol_version",
+		"PGTARGETSESSIONATTRS":    "target_session_attrs",
+		"PGSERVICE":               "service",
+		"PGSERVICEFILE":           "servicefile",
+		"PGCHANNELBINDING":        "channel_binding",
+		"PGREQUIREAUTH":           "require_auth",
+		"PGMINPROTOCOLVERSION":    "min_protocol_version",
+		"PGMAXPROTOCOLVERSION":    "max_protocol_version",
+		"PGLOADBALANCEHOSTS":      "load_balance_hosts",
 	}
 
 	for envname, realname := range nameMap {
@@ -436,6 +635,22 @@ func parseEnvSettings() map[string]string {
 	return settings
 }
 
+// srvURLSchemeSuffix marks a URL connection string whose host is a domain to look up PostgreSQL SRV records for (e.g.
+// postgres+srv://db.example.com/mydb).
+const srvURLSchemeSuffix = "+srv"
+
+// srvNamePrefix is prepended to the domain of a postgres+srv:// URL to form the SRV record name.
+const srvNamePrefix = "_postgresql._tcp."
+
+func isURLConnString(connString string) bool {
+	for _, scheme := range []string{"postgres", "postgresql"} {
+		if strings.HasPrefix(connString, scheme+"://") || strings.HasPrefix(connString, scheme+srvURLSchemeSuffix+"://") {
+			return true
+		}
+	}
+	return false
+}
+
 func parseURLSettings(connString string) (map[string]string, error) {
 	settings := make(map[string]string)
 
@@ -473,6 +688,12 @@ func parseURLSettings(connString string) (map[string]string, error) {
 			ports = append(ports, p)
 		}
 	}
+	if strings.HasSuffix(url.Scheme, srvURLSchemeSuffix) {
+		if len(hosts) != 1 || len(ports) != 0 {
+			return nil, errors.New("SRV URL must have exactly one host and no port")
+		}
+		settings["srvname"] = srvNamePrefix + hosts[0]
+	}
 	if len(hosts) > 0 {
 		settings["host"] = strings.Join(hosts, ",")
 	}
@@ -604,17 +825,38 @@ func parseServiceSettings(servicefilePath, serviceName string) (map[string]strin
 	return settings, nil
 }
 
+// effectiveSSLMode returns the sslmode of settings. As with libpq, sslrootcert=system defaults it to verify-full.
+// Otherwise an empty sslmode is returned and means prefer.
+func effectiveSSLMode(settings map[string]string) string {
+	if sslmode := settings["sslmode"]; sslmode != "" || settings["sslrootcert"] != "system" {
+		return sslmode
+	}
+	return "verify-full"
+}
+
 // configTLS uses libpq's TLS parameters to construct  []*tls.Config. It is
 // necessary to allow returning multiple TLS configs as sslmode "allow" and
 // "prefer" allow fallback.
 func configTLS(settings map[string]string, thisHost string, parseConfigOptions ParseConfigOptions) ([]*tls.Config, error) {
 	host := thisHost
-	sslmode := settings["sslmode"]
+	sslmode := effectiveSSLMode(settings)
 	sslrootcert := settings["sslrootcert"]
 	sslcert := settings["sslcert"]
 	sslkey := settings["sslkey"]
 	sslpassword := settings["sslpassword"]
 	sslsni := settings["sslsni"]
+	sslcrl := settings["sslcrl"]
+	sslcrldir := settings["sslcrldir"]
+	sslcertmode := settings["sslcertmode"]
+
+	// sslrootcert=system uses the system trust store. As with libpq, it defaults sslmode to verify-full and does not
+	// allow weaker modes because any certificate signed by a public CA would otherwise be accepted.
+	useSystemRootCerts := sslrootcert == "system"
+	if useSystemRootCerts {
+		if sslmode != "verify-full" {
+			return nil, fmt.Errorf("weak sslmode %q may not be used with sslrootcert=system (use verify-full)", sslmode)
+		}
+	}
 
 	// Match libpq default behavior
 	if sslmode == "" {
@@ -623,9 +865,39 @@ func configTLS(settings map[string]string, thisHost string, parseConfigOptions P
 	if sslsni == "" {
 		sslsni = "1"
 	}
+	if sslcertmode == "" {
+		sslcertmode = "allow"
+	}
+
+	// Certificate revocation lists are only checked when the server certificate is verified.
+	if sslmode == "require" && sslrootcert == "" && (sslcrl != "" || sslcrldir != "") {
+		return nil, errors.New("sslcrl and sslcrldir require sslrootcert with sslmode=require")
+	}
 
 	tlsConfig := &tls.Config{}
 
+	var err error
+	tlsConfig.MinVersion, err = parseTLSVersion(settings["ssl_min_protocol_version"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssl_min_protocol_version: %w", err)
+	}
+	tlsConfig.MaxVersion, err = parseTLSVersion(settings["ssl_max_protocol_version"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssl_max_protocol_version: %w", err)
+	}
+	if tlsConfig.MinVersion != 0 && tlsConfig.MaxVersion != 0 && tlsConfig.MinVersion > tlsConfig.MaxVersion {
+		return nil, errors.New("ssl_min_protocol_version is greater than ssl_max_protocol_version")
+	}
+
+	crls, err := loadCRLs(sslcrl, sslcrldir)
+	if err != nil {
+		return nil, err
+	}
+
+	if settings["sslnegotiation"] == "direct" {
+		tlsConfig.NextProtos = []string{alpnProtocolPostgreSQL}
+	}
+
 	switch sslmode {
 	case "disable":
 		return []*tls.Config{nil}, nil
@@ -674,16 +946,24 @@ func configTLS(settings map[string]string, thisHost string, parseConfigOptions P
 			for _, cert := range certs[1:] {
 				opts.Intermediates.AddCert(cert)
 			}
-			_, err := certs[0].Verify(opts)
-			return err
+			chains, err := certs[0].Verify(opts)
+			if err != nil {
+				return err
+			}
+			return checkRevocation(chains, crls)
 		}
 	case "verify-full":
 		tlsConfig.ServerName = host
+		if len(crls) > 0 {
+			tlsConfig.VerifyPeerCertificate = func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+				return checkRevocation(verifiedChains, crls)
+			}
+		}
 	default:
 		return nil, errors.New("sslmode is invalid")
 	}
 
-	if sslrootcert != "" {
+	if sslrootcert != "" && !useSystemRootCerts {
 		caCertPool := x509.NewCertPool()
 
 		caPath := sslrootcert
@@ -700,6 +980,19 @@ func configTLS(settings map[string]string, thisHost string, parseConfigOptions P
 		tlsConfig.ClientCAs = caCertPool
 	}
 
+	switch sslcertmode {
+	case "disable":
+		sslcert = ""
+		sslkey = ""
+	case "allow":
+	case "require":
+		if sslcert == "" || sslkey == "" {
+			return nil, errors.New(`sslcertmode=require requires "sslcert" and "sslkey"`)
+		}
+	default:
+		return nil, errors.New("sslcertmode is invalid")
+	}
+
 	if (sslcert != "" && sslkey == "") || (sslcert == "" && sslkey != "") {
 		return nil, errors.New(`both "sslcert" and "sslkey" are required`)
 	}
@@ -774,6 +1067,92 @@ func configTLS(settings map[string]string, thisHost string, parseConfigOptions P
 	}
 }
 
+// parseTLSVersion parses a ssl_min_protocol_version or ssl_max_protocol_version setting. An empty value returns 0
+// which means the crypto/tls default.
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "TLSv1":
+		return tls.VersionTLS10, nil
+	case "TLSv1.1":
+		return tls.VersionTLS11, nil
+	case "TLSv1.2":
+		return tls.VersionTLS12, nil
+	case "TLSv1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version: %q", s)
+	}
+}
+
+// loadCRLs reads the certificate revocation lists in the file sslcrl and the directory sslcrldir. Files in sslcrldir
+// that are not CRLs are ignored so the directory may be shared with certificates as with OpenSSL's c_rehash.
+func loadCRLs(sslcrl, sslcrldir string) ([]*revocationList, error) {
+	var crls []*revocationList
+
+	if sslcrl != "" {
+		buf, err := os.ReadFile(sslcrl)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read sslcrl: %w", err)
+		}
+		crl, err := parseCRL(buf)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse sslcrl: %w", err)
+		}
+		crls = append(crls, crl)
+	}
+
+	if sslcrldir != "" {
+		entries, err := os.ReadDir(sslcrldir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read sslcrldir: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			buf, err := os.ReadFile(filepath.Join(sslcrldir, entry.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("unable to read sslcrldir: %w", err)
+			}
+			crl, err := parseCRL(buf)
+			if err != nil {
+				continue
+			}
+			crls = append(crls, crl)
+		}
+	}
+
+	return crls, nil
+}
+
+// checkRevocation returns an error if any certificate in chains has been revoked by one of crls. A CRL applies to a
+// certificate if it is signed by the certificate's issuer.
+func checkRevocation(chains [][]*x509.Certificate, crls []*revocationList) error {
+	now := time.Now()
+	for _, chain := range chains {
+		for i := 0; i < len(chain)-1; i++ {
+			cert, issuer := chain[i], chain[i+1]
+			for _, crl := range crls {
+				if checkCRLSignature(crl, issuer) != nil {
+					continue
+				}
+				if crlExpired(crl, now) {
+					return fmt.Errorf("certificate revocation list for %q has expired", issuer.Subject)
+				}
+				for _, revoked := range crlRevokedCertificates(crl) {
+					if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+						return fmt.Errorf("certificate %q has been revoked", cert.Subject)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
 func parsePort(s string) (uint16, error) {
 	port, err := strconv.ParseUint(s, 10, 16)
 	if err != nil {
@@ -785,14 +1164,129 @@ func parsePort(s string) (uint16, error) {
 	return uint16(port), nil
 }
 
-func makeDefaultDialer() *net.Dialer {
-	return &net.Dialer{KeepAlive: 5 * time.Minute}
+// defaultKeepAliveIdle is the TCP keepalive idle time used when keepalives_idle is not set.
+const defaultKeepAliveIdle = 5 * time.Minute
+
+// makeDialer returns a dialer that configures TCP keepalive and user timeout according to ks. Only the idle time is
+// supported on platforms other than Linux.
+func makeDialer(ks keepaliveSettings) *net.Dialer {
+	d := &net.Dialer{KeepAlive: defaultKeepAliveIdle}
+	if ks.disabled {
+		d.KeepAlive = -1
+	} else if ks.idle > 0 {
+		d.KeepAlive = ks.idle
+	}
+
+	if control := keepaliveControl(ks); control != nil {
+		// The net package sets both the keepalive idle time and interval from KeepAlive after Control is called. Disable
+		// that so it does not overwrite the socket options set by control.
+		d.KeepAlive = -1
+		d.Control = control
+	}
+
+	return d
 }
 
 func makeDefaultResolver() *net.Resolver {
 	return net.DefaultResolver
 }
 
+// parseProtocolVersion parses a min_protocol_version or max_protocol_version setting. An empty value is protocol version
+// 3.0.
+func parseProtocolVersion(s string) (uint32, error) {
+	switch s {
+	case "", "3.0":
+		return pgproto3.ProtocolVersion30, nil
+	case "3.2", "latest":
+		return pgproto3.ProtocolVersion32, nil
+	default:
+		return 0, fmt.Errorf("unsupported protocol version: %q", s)
+	}
+}
+
+func parseProtocolVersionRange(minSetting, maxSetting string) (min, max uint32, err error) {
+	min, err = parseProtocolVersion(minSetting)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// libpq defaults max_protocol_version to min_protocol_version if it is newer than 3.0.
+	if maxSetting == "" && min > pgproto3.ProtocolVersion30 {
+		return min, min, nil
+	}
+
+	max, err = parseProtocolVersion(maxSetting)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if min > max {
+		return 0, 0, fmt.Errorf("min_protocol_version %s is greater than max_protocol_version %s", formatProtocolVersion(min), formatProtocolVersion(max))
+	}
+
+	return min, max, nil
+}
+
+func formatProtocolVersion(v uint32) string {
+	return fmt.Sprintf("%d.%d", v>>16, v&0xFFFF)
+}
+
+// Authentication methods that can be named in the require_auth setting.
+const (
+	authMethodPassword    = "password"
+	authMethodMD5         = "md5"
+	authMethodGSS         = "gss"
+	authMethodSSPI        = "sspi"
+	authMethodSCRAMSHA256 = "scram-sha-256"
+	authMethodNone        = "none"
+)
+
+// authRequirement is the parsed form of the require_auth setting.
+type authRequirement struct {
+	methods map[string]struct{}
+	negated bool // methods are forbidden rather than required
+}
+
+// parseRequireAuth parses a require_auth setting. It returns nil if s is empty.
+func parseRequireAuth(s string) (*authRequirement, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	ar := &authRequirement{methods: make(map[string]struct{})}
+	for i, method := range strings.Split(s, ",") {
+		negated := strings.HasPrefix(method, "!")
+		if i == 0 {
+			ar.negated = negated
+		} else if negated != ar.negated {
+			return nil, errors.New("negative and positive methods cannot be mixed")
+		}
+		method = strings.TrimPrefix(method, "!")
+
+		switch method {
+		case authMethodPassword, authMethodMD5, authMethodGSS, authMethodSSPI, authMethodSCRAMSHA256, authMethodNone:
+		default:
+			return nil, fmt.Errorf("unknown authentication method: %q", method)
+		}
+
+		if _, present := ar.methods[method]; present {
+			return nil, fmt.Errorf("authentication method %q is specified more than once", method)
+		}
+		ar.methods[method] = struct{}{}
+	}
+
+	return ar, nil
+}
+
+// allows reports whether the server may authenticate the client with method. A nil *authRequirement allows any method.
+func (ar *authRequirement) allows(method string) bool {
+	if ar == nil {
+		return true
+	}
+	_, present := ar.methods[method]
+	return present != ar.negated
+}
+
 func parseConnectTimeoutSetting(s string) (time.Duration, error) {
 	timeout, err := strconv.ParseInt(s, 10, 64)
 	if err != nil {
@@ -804,8 +1298,70 @@ func parseConnectTimeoutSetting(s string) (time.Duration, error) {
 	return time.Duration(timeout) * time.Second, nil
 }
 
-func makeConnectTimeoutDialFunc(timeout time.Duration) DialFunc {
-	d := makeDefaultDialer()
+// keepaliveSettings are the libpq keepalives, keepalives_idle, keepalives_interval, keepalives_count, and
+// tcp_user_timeout settings. Zero values use the default.
+type keepaliveSettings struct {
+	disabled    bool
+	idle        time.Duration
+	interval    time.Duration
+	count       int
+	userTimeout time.Duration
+}
+
+func parseKeepaliveSettings(settings map[string]string) (keepaliveSettings, error) {
+	var ks keepaliveSettings
+
+	switch s := settings["keepalives"]; s {
+	case "", "1":
+	case "0":
+		ks.disabled = true
+	default:
+		return ks, fmt.Errorf("invalid keepalives value: %v", s)
+	}
+
+	parseNonNegative := func(name string) (int, error) {
+		s := settings[name]
+		if s == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if n < 0 {
+			return 0, fmt.Errorf("invalid %s: negative value", name)
+		}
+		return int(n), nil
+	}
+
+	idle, err := parseNonNegative("keepalives_idle")
+	if err != nil {
+		return ks, err
+	}
+	ks.idle = time.Duration(idle) * time.Second
+
+	interval, err := parseNonNegative("keepalives_interval")
+	if err != nil {
+		return ks, err
+	}
+	ks.interval = time.Duration(interval) * time.Second
+
+	ks.count, err = parseNonNegative("keepalives_count")
+	if err != nil {
+		return ks, err
+	}
+
+	userTimeout, err := parseNonNegative("tcp_user_timeout")
+	if err != nil {
+		return ks, err
+	}
+	ks.userTimeout = time.Duration(userTimeout) * time.Millisecond
+
+	return ks, nil
+}
+
+func makeConnectTimeoutDialFunc(timeout time.Duration, ks keepaliveSettings) DialFunc {
+	d := makeDialer(ks)
 	d.Timeout = timeout
 	return d.DialContext
 }
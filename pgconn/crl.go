@@ -0,0 +1,32 @@
+//go:build go1.19
+
+package pgconn
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"time"
+)
+
+type revocationList = x509.RevocationList
+
+// parseCRL parses a PEM or DER encoded certificate revocation list.
+func parseCRL(buf []byte) (*revocationList, error) {
+	if block, _ := pem.Decode(buf); block != nil && block.Type == "X509 CRL" {
+		buf = block.Bytes
+	}
+	return x509.ParseRevocationList(buf)
+}
+
+func checkCRLSignature(crl *revocationList, issuer *x509.Certificate) error {
+	return crl.CheckSignatureFrom(issuer)
+}
+
+func crlExpired(crl *revocationList, now time.Time) bool {
+	return !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate)
+}
+
+func crlRevokedCertificates(crl *revocationList) []pkix.RevokedCertificate {
+	return crl.RevokedCertificates
+}
@@ -0,0 +1,29 @@
+//go:build !go1.19
+
+package pgconn
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+)
+
+// x509.RevocationList cannot be parsed before Go 1.19.
+type revocationList = pkix.CertificateList
+
+// parseCRL parses a PEM or DER encoded certificate revocation list.
+func parseCRL(buf []byte) (*revocationList, error) {
+	return x509.ParseCRL(buf)
+}
+
+func checkCRLSignature(crl *revocationList, issuer *x509.Certificate) error {
+	return issuer.CheckCRLSignature(crl)
+}
+
+func crlExpired(crl *revocationList, now time.Time) bool {
+	return crl.HasExpired(now)
+}
+
+func crlRevokedCertificates(crl *revocationList) []pkix.RevokedCertificate {
+	return crl.TBSCertList.RevokedCertificates
+}
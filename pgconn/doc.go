@@ -23,6 +23,13 @@ Pipeline Mode
 Pipeline mode allows sending queries without having read the results of previously sent queries. It allows
 control of exactly how many and when network round trips occur.
 
+Streaming Replication
+
+A connection started with replication=database or replication=true in the connection string is a walsender replication
+connection. IdentifySystem, CreateReplicationSlot, and DropReplicationSlot run replication commands. StartReplication
+switches the connection to copy both mode and returns a ReplicationStream that receives and sends CopyData messages
+until it is closed.
+
 Context Support
 
 All potentially blocking operations take a context.Context. If a context is canceled while the method is in progress the
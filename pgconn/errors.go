@@ -184,7 +184,7 @@ func newContextAlreadyDoneError(ctx context.Context) (err error) {
 }
 
 func redactPW(connString string) string {
-	if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
+	if isURLConnString(connString) {
 		if u, err := url.Parse(connString); err == nil {
 			return redactURL(u)
 		}
@@ -2,10 +2,24 @@ package pgconn_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -34,3 +48,123 @@ func ensureConnValid(t *testing.T, pgConn *pgconn.PgConn) {
 	assert.Equal(t, "2", string(result.Rows[1][0]))
 	assert.Equal(t, "3", string(result.Rows[2][0]))
 }
+
+// startMockServer starts a server on a random local port that runs script against the first connection it accepts. It
+// returns a connection string for the server and a channel that receives the result of running the script.
+func startMockServer(t *testing.T, script *pgmock.Script) (string, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		defer close(serverErrChan)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		serverErrChan <- script.Run(pgproto3.NewBackend(conn, conn))
+	}()
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	return fmt.Sprintf("sslmode=disable host=%s port=%s", host, port), serverErrChan
+}
+
+// testCA is a certificate authority for tests that need certificates and certificate revocation lists. Its files are
+// written to a temporary directory.
+type testCA struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	dir      string
+	certPath string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pgx test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.certPath = ca.writePEM(t, "ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+// issue creates a certificate for commonName signed by ca. It returns the certificate and the paths of its PEM encoded
+// certificate and key files.
+func (ca *testCA) issue(t *testing.T, serial int64, commonName string) (cert tls.Certificate, certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath = ca.writePEM(t, fmt.Sprintf("%d.crt", serial), "CERTIFICATE", der)
+	keyPath = ca.writePEM(t, fmt.Sprintf("%d.key", serial), "EC PRIVATE KEY", keyDER)
+
+	cert, err = tls.LoadX509KeyPair(certPath, keyPath)
+	require.NoError(t, err)
+
+	return cert, certPath, keyPath
+}
+
+// writeCRL writes a certificate revocation list that revokes serials to a file named name.
+func (ca *testCA) writeCRL(t *testing.T, name string, serials ...int64) string {
+	var revoked []pkix.RevokedCertificate
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now().Add(-time.Hour),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: revoked,
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+
+	return ca.writePEM(t, name, "X509 CRL", der)
+}
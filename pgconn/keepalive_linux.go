@@ -0,0 +1,74 @@
+package pgconn
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT from linux/tcp.h. It is not defined by the syscall package.
+const tcpUserTimeout = 0x12
+
+// keepaliveControl returns a net.Dialer Control function that sets the TCP keepalive and user timeout socket options
+// from ks. It returns nil if ks does not require any socket options beyond what net.Dialer.KeepAlive provides.
+func keepaliveControl(ks keepaliveSettings) func(network, address string, c syscall.RawConn) error {
+	if ks.disabled && ks.userTimeout == 0 {
+		return nil
+	}
+	if ks.interval == 0 && ks.count == 0 && ks.userTimeout == 0 {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+
+		var sockoptErr error
+		err := c.Control(func(fd uintptr) {
+			sockoptErr = setKeepaliveSockopts(int(fd), ks)
+		})
+		if err != nil {
+			return err
+		}
+		return sockoptErr
+	}
+}
+
+func setKeepaliveSockopts(fd int, ks keepaliveSettings) error {
+	type sockopt struct {
+		level int
+		opt   int
+		value int
+	}
+
+	var sockopts []sockopt
+	if !ks.disabled {
+		idle := ks.idle
+		if idle == 0 {
+			idle = defaultKeepAliveIdle
+		}
+		sockopts = append(sockopts,
+			sockopt{syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+			sockopt{syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, int(idle.Seconds())},
+		)
+		if ks.interval > 0 {
+			sockopts = append(sockopts, sockopt{syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, int(ks.interval.Seconds())})
+		}
+		if ks.count > 0 {
+			sockopts = append(sockopts, sockopt{syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, ks.count})
+		}
+	}
+	if ks.userTimeout > 0 {
+		sockopts = append(sockopts, sockopt{syscall.IPPROTO_TCP, tcpUserTimeout, int(ks.userTimeout.Milliseconds())})
+	}
+
+	for _, so := range sockopts {
+		err := syscall.SetsockoptInt(fd, so.level, so.opt, so.value)
+		if err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	return nil
+}
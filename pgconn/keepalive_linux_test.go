@@ -0,0 +1,71 @@
+package pgconn
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialerSetsKeepaliveSockopts(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	ks, err := parseKeepaliveSettings(map[string]string{
+		"keepalives_idle":     "10",
+		"keepalives_interval": "5",
+		"keepalives_count":    "3",
+		"tcp_user_timeout":    "30000",
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := makeDialer(ks).DialContext(ctx, "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+
+	sockopts := map[string]int{}
+	err = rawConn.Control(func(fd uintptr) {
+		for name, opt := range map[string][2]int{
+			"keepalive": {syscall.SOL_SOCKET, syscall.SO_KEEPALIVE},
+			"idle":      {syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE},
+			"interval":  {syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL},
+			"count":     {syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT},
+			"timeout":   {syscall.IPPROTO_TCP, tcpUserTimeout},
+		} {
+			value, err := syscall.GetsockoptInt(int(fd), opt[0], opt[1])
+			require.NoError(t, err)
+			sockopts[name] = value
+		}
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]int{"keepalive": 1, "idle": 10, "interval": 5, "count": 3, "timeout": 30000}, sockopts)
+}
+
+func TestDialerKeepalivesDisabled(t *testing.T) {
+	t.Parallel()
+
+	d := makeDialer(keepaliveSettings{disabled: true})
+	assert.Less(t, d.KeepAlive, time.Duration(0))
+	assert.Nil(t, d.Control)
+}
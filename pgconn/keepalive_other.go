@@ -0,0 +1,10 @@
+//go:build !linux
+
+package pgconn
+
+import "syscall"
+
+// keepaliveControl returns nil. Only the keepalives and keepalives_idle settings are supported on this platform.
+func keepaliveControl(ks keepaliveSettings) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
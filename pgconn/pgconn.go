@@ -10,15 +10,18 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5/internal/iobufpool"
 	"github.com/jackc/pgx/v5/internal/nbconn"
 	"github.com/jackc/pgx/v5/internal/pgio"
-	"github.com/jackc/pgx/v5/pgconn/internal/ctxwatch"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
 	"github.com/jackc/pgx/v5/pgproto3"
 )
 
@@ -48,6 +51,10 @@ type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
 // returned in order to override the connection string's port.
 type LookupFunc func(ctx context.Context, host string) (addrs []string, err error)
 
+// LookupSRVFunc is a function that can be used to lookup SRV records. It has the same semantics as
+// net.Resolver.LookupSRV.
+type LookupSRVFunc func(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+
 // BuildFrontendFunc is a function that can be used to create Frontend implementation for connection.
 type BuildFrontendFunc func(r io.Reader, w io.Writer) *pgproto3.Frontend
 
@@ -68,6 +75,8 @@ type PgConn struct {
 	conn              nbconn.Conn       // the non-blocking wrapper for the underlying TCP or unix domain socket connection
 	pid               uint32            // backend pid
 	secretKey         uint32            // key to use to send a cancel query message to the server
+	longSecretKey     []byte            // protocol version 3.2 key longer than 4 bytes that is used instead of secretKey
+	protocolVersion   uint32            // negotiated protocol version (e.g. pgproto3.ProtocolVersion30)
 	parameterStatuses map[string]string // parameters that have been reported by the server
 	txStatus          byte
 	frontend          *pgproto3.Frontend
@@ -85,6 +94,8 @@ type PgConn struct {
 	contextWatcher    *ctxwatch.ContextWatcher
 	fieldDescriptions [16]FieldDescription
 
+	wireStats *wireStats
+
 	cleanupDone chan struct{}
 }
 
@@ -117,7 +128,8 @@ func ConnectWithOptions(ctx context.Context, connString string, parseConfigOptio
 // If config.Fallbacks are present they will sequentially be tried in case of error establishing network connection. An
 // authentication error will terminate the chain of attempts (like libpq:
 // https://www.postgresql.org/docs/11/libpq-connect.html#LIBPQ-MULTIPLE-HOSTS) and be returned as the error. Otherwise,
-// if all attempts fail the last error is returned.
+// if all attempts fail the last error is returned. If config.ParallelConnectDelay is set the attempts are staggered and
+// run concurrently instead.
 func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err error) {
 	// Default values are set in ParseConfig. Enforce initial creation by ParseConfig rather than setting defaults from
 	// zero values.
@@ -135,7 +147,12 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 	}
 	fallbackConfigs = append(fallbackConfigs, config.Fallbacks...)
 	ctx := octx
-	fallbackConfigs, err = expandWithIPs(ctx, config.LookupFunc, fallbackConfigs)
+	lookupFn := config.LookupFunc
+	if config.LoadBalanceHosts == "random" {
+		fallbackConfigs = shuffleHosts(fallbackConfigs)
+		lookupFn = shuffledLookupFunc(lookupFn)
+	}
+	fallbackConfigs, err = expandWithIPs(ctx, lookupFn, config.LookupSRVFunc, config.SRVName, fallbackConfigs)
 	if err != nil {
 		return nil, &connectError{config: config, msg: "hostname resolving error", err: err}
 	}
@@ -144,41 +161,21 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 		return nil, &connectError{config: config, msg: "hostname resolving error", err: errors.New("ip addr wasn't found")}
 	}
 
-	foundBestServer := false
 	var fallbackConfig *FallbackConfig
-	for _, fc := range fallbackConfigs {
-		// ConnectTimeout restricts the whole connection process.
-		if config.ConnectTimeout != 0 {
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithTimeout(octx, config.ConnectTimeout)
-			defer cancel()
-		} else {
-			ctx = octx
-		}
-		pgConn, err = connect(ctx, config, fc, false)
-		if err == nil {
-			foundBestServer = true
-			break
-		} else if pgerr, ok := err.(*PgError); ok {
-			err = &connectError{config: config, msg: "server error", err: pgerr}
-			const ERRCODE_INVALID_PASSWORD = "28P01"                    // wrong password
-			const ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION = "28000" // wrong password or bad pg_hba.conf settings
-			const ERRCODE_INVALID_CATALOG_NAME = "3D000"                // db does not exist
-			const ERRCODE_INSUFFICIENT_PRIVILEGE = "42501"              // missing connect privilege
-			if pgerr.Code == ERRCODE_INVALID_PASSWORD ||
-				pgerr.Code == ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION ||
-				pgerr.Code == ERRCODE_INVALID_CATALOG_NAME ||
-				pgerr.Code == ERRCODE_INSUFFICIENT_PRIVILEGE {
-				break
-			}
-		} else if cerr, ok := err.(*connectError); ok {
-			if _, ok := cerr.err.(*NotPreferredError); ok {
-				fallbackConfig = fc
-			}
-		}
+	if config.ParallelConnectDelay > 0 && len(fallbackConfigs) > 1 {
+		pgConn, fallbackConfig, err = connectParallel(octx, config, fallbackConfigs)
+	} else {
+		pgConn, fallbackConfig, err = connectSequential(octx, config, fallbackConfigs)
+	}
+
+	// ConnectTimeout restricts the whole connection process.
+	if config.ConnectTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(octx, config.ConnectTimeout)
+		defer cancel()
 	}
 
-	if !foundBestServer && fallbackConfig != nil {
+	if pgConn == nil && fallbackConfig != nil {
 		pgConn, err = connect(ctx, config, fallbackConfig, true)
 		if pgerr, ok := err.(*PgError); ok {
 			err = &connectError{config: config, msg: "server error", err: pgerr}
@@ -200,10 +197,176 @@ func ConnectConfig(octx context.Context, config *Config) (pgConn *PgConn, err er
 	return pgConn, nil
 }
 
-func expandWithIPs(ctx context.Context, lookupFn LookupFunc, fallbacks []*FallbackConfig) ([]*FallbackConfig, error) {
+// connectSequential tries fallbackConfigs in order until a connection succeeds. If no connection succeeds but a server
+// was rejected only because it was not preferred, its fallback config is returned so it can be used as a last resort.
+func connectSequential(octx context.Context, config *Config, fallbackConfigs []*FallbackConfig) (*PgConn, *FallbackConfig, error) {
+	var err error
+	var notPreferredConfig *FallbackConfig
+	for _, fc := range fallbackConfigs {
+		var pgConn *PgConn
+		pgConn, err = connectAttempt(octx, config, fc)
+		if err == nil {
+			return pgConn, nil, nil
+		}
+		if isNotPreferredConnectError(err) {
+			notPreferredConfig = fc
+		}
+		if isFatalConnectError(err) {
+			break
+		}
+	}
+
+	return nil, notPreferredConfig, err
+}
+
+// connectParallel races staggered connection attempts against fallbackConfigs in the manner of RFC 8305 ("Happy
+// Eyeballs"). An attempt is started for each fallback config in order when config.ParallelConnectDelay has elapsed
+// since the previous attempt started or when all attempts in progress have failed. The first successful attempt wins.
+// The remaining attempts are canceled and any that still succeed are closed before connectParallel returns. The
+// result otherwise matches connectSequential: an authentication error ends all attempts and if all attempts fail the
+// error of the last fallback config is returned.
+func connectParallel(octx context.Context, config *Config, fallbackConfigs []*FallbackConfig) (*PgConn, *FallbackConfig, error) {
+	ctx, cancel := context.WithCancel(octx)
+	defer cancel()
+
+	type attemptResult struct {
+		idx    int
+		pgConn *PgConn
+		err    error
+	}
+
+	results := make(chan attemptResult)
+	startAttempt := func(idx int) {
+		go func() {
+			pgConn, err := connectAttempt(ctx, config, fallbackConfigs[idx])
+			results <- attemptResult{idx: idx, pgConn: pgConn, err: err}
+		}()
+	}
+
+	delay := time.NewTimer(config.ParallelConnectDelay)
+	defer delay.Stop()
+
+	errs := make([]error, len(fallbackConfigs))
+	var winner *PgConn
+	var fatalErr error
+	started := 0
+	inProgress := 0
+
+	startAttempt(started)
+	started++
+	inProgress++
+
+	for inProgress > 0 {
+		var delayC <-chan time.Time
+		if winner == nil && fatalErr == nil && started < len(fallbackConfigs) {
+			delayC = delay.C
+		}
+
+		select {
+		case <-delayC:
+			startAttempt(started)
+			started++
+			inProgress++
+			delay.Reset(config.ParallelConnectDelay)
+		case result := <-results:
+			inProgress--
+			errs[result.idx] = result.err
+
+			switch {
+			case result.err == nil && winner == nil && fatalErr == nil:
+				winner = result.pgConn
+				cancel()
+			case result.err == nil:
+				result.pgConn.Close(octx)
+			case isFatalConnectError(result.err):
+				if winner == nil && fatalErr == nil {
+					fatalErr = result.err
+					cancel()
+				}
+			}
+
+			// Start the next attempt immediately when nothing else is in progress instead of waiting for the delay.
+			if inProgress == 0 && winner == nil && fatalErr == nil && started < len(fallbackConfigs) {
+				if !delay.Stop() {
+					<-delay.C
+				}
+				startAttempt(started)
+				started++
+				inProgress++
+				delay.Reset(config.ParallelConnectDelay)
+			}
+		}
+	}
+
+	if winner != nil {
+		return winner, nil, nil
+	}
+
+	var notPreferredConfig *FallbackConfig
+	for i, err := range errs {
+		if isNotPreferredConnectError(err) {
+			notPreferredConfig = fallbackConfigs[i]
+		}
+	}
+
+	if fatalErr != nil {
+		return nil, notPreferredConfig, fatalErr
+	}
+	return nil, notPreferredConfig, errs[started-1]
+}
+
+// connectAttempt makes a single connection attempt to fallbackConfig. ConnectTimeout restricts each attempt. A
+// *PgError is wrapped in a connectError.
+func connectAttempt(ctx context.Context, config *Config, fallbackConfig *FallbackConfig) (*PgConn, error) {
+	if config.ConnectTimeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.ConnectTimeout)
+		defer cancel()
+	}
+
+	pgConn, err := connect(ctx, config, fallbackConfig, false)
+	if pgerr, ok := err.(*PgError); ok {
+		err = &connectError{config: config, msg: "server error", err: pgerr}
+	}
+	return pgConn, err
+}
+
+// isFatalConnectError reports whether err should end all connection attempts rather than trying the next fallback
+// config.
+func isFatalConnectError(err error) bool {
+	cerr, ok := err.(*connectError)
+	if !ok {
+		return false
+	}
+	pgerr, ok := cerr.err.(*PgError)
+	if !ok {
+		return false
+	}
+
+	const ERRCODE_INVALID_PASSWORD = "28P01"                    // wrong password
+	const ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION = "28000" // wrong password or bad pg_hba.conf settings
+	const ERRCODE_INVALID_CATALOG_NAME = "3D000"                // db does not exist
+	const ERRCODE_INSUFFICIENT_PRIVILEGE = "42501"              // missing connect privilege
+	return pgerr.Code == ERRCODE_INVALID_PASSWORD ||
+		pgerr.Code == ERRCODE_INVALID_AUTHORIZATION_SPECIFICATION ||
+		pgerr.Code == ERRCODE_INVALID_CATALOG_NAME ||
+		pgerr.Code == ERRCODE_INSUFFICIENT_PRIVILEGE
+}
+
+func isNotPreferredConnectError(err error) bool {
+	if cerr, ok := err.(*connectError); ok {
+		_, ok := cerr.err.(*NotPreferredError)
+		return ok
+	}
+	return false
+}
+
+func expandWithIPs(ctx context.Context, lookupFn LookupFunc, lookupSRVFn LookupSRVFunc, srvName string, fallbacks []*FallbackConfig) ([]*FallbackConfig, error) {
 	var configs []*FallbackConfig
 
-	for _, fb := range fallbacks {
+	for i := 0; i < len(fallbacks); i++ {
+		fb := fallbacks[i]
+
 		// skip resolve for unix sockets
 		if isAbsolutePath(fb.Host) {
 			configs = append(configs, &FallbackConfig{
@@ -215,29 +378,48 @@ func expandWithIPs(ctx context.Context, lookupFn LookupFunc, fallbacks []*Fallba
 			continue
 		}
 
-		ips, err := lookupFn(ctx, fb.Host)
-		if err != nil {
-			return nil, err
+		hostConfigs := []*FallbackConfig{fb}
+		if srvName != "" && fb.Host == srvName {
+			// The fallbacks for the SRV name (e.g. with and without TLS for sslmode=prefer) share a single lookup so
+			// each target is tried with all of them before the next target.
+			srvFallbacks := []*FallbackConfig{fb}
+			for i+1 < len(fallbacks) && fallbacks[i+1].Host == fb.Host && fallbacks[i+1].Port == fb.Port {
+				i++
+				srvFallbacks = append(srvFallbacks, fallbacks[i])
+			}
+
+			var err error
+			hostConfigs, err = lookupSRVFallbacks(ctx, lookupSRVFn, srvFallbacks)
+			if err != nil {
+				return nil, err
+			}
 		}
 
-		for _, ip := range ips {
-			splitIP, splitPort, err := net.SplitHostPort(ip)
-			if err == nil {
-				port, err := strconv.ParseUint(splitPort, 10, 16)
-				if err != nil {
-					return nil, fmt.Errorf("error parsing port (%s) from lookup: %w", splitPort, err)
+		for _, hc := range hostConfigs {
+			ips, err := lookupFn(ctx, hc.Host)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ip := range ips {
+				splitIP, splitPort, err := net.SplitHostPort(ip)
+				if err == nil {
+					port, err := strconv.ParseUint(splitPort, 10, 16)
+					if err != nil {
+						return nil, fmt.Errorf("error parsing port (%s) from lookup: %w", splitPort, err)
+					}
+					configs = append(configs, &FallbackConfig{
+						Host:      splitIP,
+						Port:      uint16(port),
+						TLSConfig: hc.TLSConfig,
+					})
+				} else {
+					configs = append(configs, &FallbackConfig{
+						Host:      ip,
+						Port:      hc.Port,
+						TLSConfig: hc.TLSConfig,
+					})
 				}
-				configs = append(configs, &FallbackConfig{
-					Host:      splitIP,
-					Port:      uint16(port),
-					TLSConfig: fb.TLSConfig,
-				})
-			} else {
-				configs = append(configs, &FallbackConfig{
-					Host:      ip,
-					Port:      fb.Port,
-					TLSConfig: fb.TLSConfig,
-				})
 			}
 		}
 	}
@@ -245,13 +427,154 @@ func expandWithIPs(ctx context.Context, lookupFn LookupFunc, fallbacks []*Fallba
 	return configs, nil
 }
 
+// lookupSRVFallbacks looks up the SRV records named by the host of fallbacks, which all have the same host, and returns
+// a fallback config for each target and each of fallbacks in the order they should be tried. The TLS server name of
+// each fallback config is set to its target.
+func lookupSRVFallbacks(ctx context.Context, lookupSRVFn LookupSRVFunc, fallbacks []*FallbackConfig) ([]*FallbackConfig, error) {
+	_, records, err := lookupSRVFn(ctx, "", "", fallbacks[0].Host)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []*FallbackConfig
+	for _, srv := range orderSRVRecords(records) {
+		// A target of "." means the service is not available at this domain.
+		target := strings.TrimSuffix(srv.Target, ".")
+		if target == "" {
+			continue
+		}
+
+		for _, fb := range fallbacks {
+			tlsConfig := fb.TLSConfig
+			if tlsConfig != nil && tlsConfig.ServerName != "" {
+				tlsConfig = tlsConfig.Clone()
+				tlsConfig.ServerName = target
+			}
+
+			configs = append(configs, &FallbackConfig{
+				Host:      target,
+				Port:      srv.Port,
+				TLSConfig: tlsConfig,
+			})
+		}
+	}
+
+	return configs, nil
+}
+
+// orderSRVRecords returns records sorted by priority. Records with the same priority are ordered randomly with the
+// probability of being first proportional to their weight as described in RFC 2782.
+func orderSRVRecords(records []*net.SRV) []*net.SRV {
+	sorted := make([]*net.SRV, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	loadBalanceRand.mux.Lock()
+	defer loadBalanceRand.mux.Unlock()
+
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+
+		// Records with weight 0 are placed first so they have a very small chance of being selected.
+		group := sorted[start:end]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Weight == 0 && group[j].Weight != 0 })
+
+		for i := range group {
+			totalWeight := 0
+			for _, srv := range group[i:] {
+				totalWeight += int(srv.Weight)
+			}
+
+			n := loadBalanceRand.rnd.Intn(totalWeight + 1)
+			runningWeight := 0
+			for j := i; j < len(group); j++ {
+				runningWeight += int(group[j].Weight)
+				if runningWeight >= n {
+					group[i], group[j] = group[j], group[i]
+					break
+				}
+			}
+		}
+
+		start = end
+	}
+
+	return sorted
+}
+
+// loadBalanceRand randomizes the order of hosts for load_balance_hosts=random. It is seeded so that separate processes
+// do not all choose the same host.
+var loadBalanceRand = struct {
+	mux sync.Mutex
+	rnd *rand.Rand
+}{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func loadBalanceShuffle(n int, swap func(i, j int)) {
+	loadBalanceRand.mux.Lock()
+	defer loadBalanceRand.mux.Unlock()
+	loadBalanceRand.rnd.Shuffle(n, swap)
+}
+
+// shuffleHosts returns fallbacks with the hosts in random order. Consecutive fallbacks for the same host and port (e.g.
+// with and without TLS for sslmode=prefer) stay together in their original order.
+func shuffleHosts(fallbacks []*FallbackConfig) []*FallbackConfig {
+	var groups [][]*FallbackConfig
+	for i, fb := range fallbacks {
+		if i > 0 && fb.Host == fallbacks[i-1].Host && fb.Port == fallbacks[i-1].Port {
+			groups[len(groups)-1] = append(groups[len(groups)-1], fb)
+		} else {
+			groups = append(groups, []*FallbackConfig{fb})
+		}
+	}
+
+	loadBalanceShuffle(len(groups), func(i, j int) {
+		groups[i], groups[j] = groups[j], groups[i]
+	})
+
+	shuffled := make([]*FallbackConfig, 0, len(fallbacks))
+	for _, group := range groups {
+		shuffled = append(shuffled, group...)
+	}
+	return shuffled
+}
+
+// shuffledLookupFunc returns a LookupFunc that returns the addresses found by lookupFn in random order. The slice
+// returned by lookupFn is not modified as it may be cached by lookupFn.
+func shuffledLookupFunc(lookupFn LookupFunc) LookupFunc {
+	return func(ctx context.Context, host string) ([]string, error) {
+		addrs, err := lookupFn(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		shuffled := make([]string, len(addrs))
+		copy(shuffled, addrs)
+		loadBalanceShuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		return shuffled, nil
+	}
+}
+
 func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig,
 	ignoreNotPreferredErr bool) (*PgConn, error) {
 	pgConn := new(PgConn)
 	pgConn.config = config
 	pgConn.cleanupDone = make(chan struct{})
 
-	var err error
+	requireAuth, err := parseRequireAuth(config.RequireAuth)
+	if err != nil {
+		return nil, &connectError{config: config, msg: "invalid require_auth", err: err}
+	}
+
+	minProtocolVersion, maxProtocolVersion, err := parseProtocolVersionRange(config.MinProtocolVersion, config.MaxProtocolVersion)
+	if err != nil {
+		return nil, &connectError{config: config, msg: "invalid protocol version", err: err}
+	}
+
 	network, address := NetworkAddress(fallbackConfig.Host, fallbackConfig.Port)
 	netConn, err := config.DialFunc(ctx, network, address)
 	if err != nil {
@@ -264,12 +587,27 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 	pgConn.contextWatcher.Watch(ctx)
 
 	if fallbackConfig.TLSConfig != nil {
-		nbTLSConn, err := startTLS(nbNetConn, fallbackConfig.TLSConfig)
+		tlsConfig := fallbackConfig.TLSConfig
+		clientCertRequested := false
+		if config.SSLCertMode == "require" {
+			tlsConfig = trackClientCertificateRequest(tlsConfig, &clientCertRequested)
+		}
+
+		var nbTLSConn *nbconn.TLSConn
+		if config.SSLNegotiation == "direct" {
+			nbTLSConn, err = startDirectTLS(nbNetConn, tlsConfig)
+		} else {
+			nbTLSConn, err = startTLS(nbNetConn, tlsConfig)
+		}
 		pgConn.contextWatcher.Unwatch() // Always unwatch `netConn` after TLS.
 		if err != nil {
 			netConn.Close()
 			return nil, &connectError{config: config, msg: "tls error", err: err}
 		}
+		if config.SSLCertMode == "require" && !clientCertRequested {
+			netConn.Close()
+			return nil, &connectError{config: config, msg: "server did not request a client certificate but sslcertmode is require"}
+		}
 
 		pgConn.conn = nbTLSConn
 		pgConn.contextWatcher = newContextWatcher(nbTLSConn)
@@ -280,12 +618,20 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 
 	pgConn.parameterStatuses = make(map[string]string)
 	pgConn.status = connStatusConnecting
-	pgConn.frontend = config.BuildFrontend(pgConn.conn, pgConn.conn)
+	pgConn.wireStats = newWireStats()
+	pgConn.frontend = config.BuildFrontend(&statsReader{r: pgConn.conn, s: pgConn.wireStats}, &statsWriter{w: pgConn.conn, s: pgConn.wireStats})
+	if config.MaxMessageBodyLen > 0 {
+		pgConn.frontend.SetMaxBodyLen(config.MaxMessageBodyLen)
+	}
+	if config.TraceWriter != nil {
+		pgConn.frontend.Trace(config.TraceWriter, config.TraceOptions)
+	}
 
 	startupMsg := pgproto3.StartupMessage{
-		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		ProtocolVersion: maxProtocolVersion,
 		Parameters:      make(map[string]string),
 	}
+	pgConn.protocolVersion = maxProtocolVersion
 
 	// Copy default run-time params
 	for k, v := range config.RuntimeParams {
@@ -293,6 +639,9 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 	}
 
 	startupMsg.Parameters["user"] = config.User
+	if config.ReplicationMode != ReplicationModeNone {
+		startupMsg.Parameters["replication"] = config.ReplicationMode.String()
+	}
 	if config.Database != "" {
 		startupMsg.Parameters["database"] = config.Database
 	}
@@ -303,6 +652,9 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 		return nil, &connectError{config: config, msg: "failed to write startup message", err: err}
 	}
 
+	authRequested := false
+	channelBound := false
+
 	for {
 		msg, err := pgConn.receiveMessage()
 		if err != nil {
@@ -317,15 +669,55 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 		case *pgproto3.BackendKeyData:
 			pgConn.pid = msg.ProcessID
 			pgConn.secretKey = msg.SecretKey
+			if msg.LongSecretKey != nil {
+				pgConn.longSecretKey = append([]byte(nil), msg.LongSecretKey...)
+			}
+
+		case *pgproto3.NegotiateProtocolVersion:
+			// The server does not support the requested minor version or some "_pq_." options. Unrecognized options are
+			// simply not in effect.
+			serverVersion := maxProtocolVersion&0xFFFF0000 | msg.NewestMinorProtocol
+			if serverVersion > maxProtocolVersion {
+				pgConn.conn.Close()
+				return nil, &connectError{config: config, msg: fmt.Sprintf("server negotiated protocol version %s which is newer than the requested version %s", formatProtocolVersion(serverVersion), formatProtocolVersion(maxProtocolVersion))}
+			}
+			if serverVersion < minProtocolVersion {
+				pgConn.conn.Close()
+				return nil, &connectError{config: config, msg: fmt.Sprintf("server only supports protocol version %s, but min_protocol_version is %s", formatProtocolVersion(serverVersion), formatProtocolVersion(minProtocolVersion))}
+			}
+			pgConn.protocolVersion = serverVersion
 
 		case *pgproto3.AuthenticationOk:
+			if !authRequested {
+				err = checkAuthRequest(config, requireAuth, authMethodNone)
+				if err != nil {
+					pgConn.conn.Close()
+					return nil, err
+				}
+			}
+			if config.ChannelBinding == "require" && !channelBound {
+				pgConn.conn.Close()
+				return nil, &connectError{config: config, msg: "channel binding required, but server authenticated client without channel binding"}
+			}
 		case *pgproto3.AuthenticationCleartextPassword:
+			authRequested = true
+			err = checkAuthRequest(config, requireAuth, authMethodPassword)
+			if err != nil {
+				pgConn.conn.Close()
+				return nil, err
+			}
 			err = pgConn.txPasswordMessage(pgConn.config.Password)
 			if err != nil {
 				pgConn.conn.Close()
 				return nil, &connectError{config: config, msg: "failed to write password message", err: err}
 			}
 		case *pgproto3.AuthenticationMD5Password:
+			authRequested = true
+			err = checkAuthRequest(config, requireAuth, authMethodMD5)
+			if err != nil {
+				pgConn.conn.Close()
+				return nil, err
+			}
 			digestedPassword := "md5" + hexMD5(hexMD5(pgConn.config.Password+pgConn.config.User)+string(msg.Salt[:]))
 			err = pgConn.txPasswordMessage(digestedPassword)
 			if err != nil {
@@ -333,12 +725,24 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 				return nil, &connectError{config: config, msg: "failed to write password message", err: err}
 			}
 		case *pgproto3.AuthenticationSASL:
-			err = pgConn.scramAuth(msg.AuthMechanisms)
+			authRequested = true
+			err = checkAuthRequest(config, requireAuth, authMethodSCRAMSHA256)
+			if err != nil {
+				pgConn.conn.Close()
+				return nil, err
+			}
+			channelBound, err = pgConn.scramAuth(msg.AuthMechanisms)
 			if err != nil {
 				pgConn.conn.Close()
 				return nil, &connectError{config: config, msg: "failed SASL auth", err: err}
 			}
 		case *pgproto3.AuthenticationGSS:
+			authRequested = true
+			err = checkAuthRequest(config, requireAuth, authMethodGSS)
+			if err != nil {
+				pgConn.conn.Close()
+				return nil, err
+			}
 			err = pgConn.gssAuth()
 			if err != nil {
 				pgConn.conn.Close()
@@ -346,14 +750,19 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 			}
 		case *pgproto3.ReadyForQuery:
 			pgConn.status = connStatusIdle
-			if config.ValidateConnect != nil {
-				// ValidateConnect may execute commands that cause the context to be watched again. Unwatch first to avoid
-				// the watch already in progress panic. This is that last thing done by this method so there is no need to
-				// restart the watch after ValidateConnect returns.
-				//
-				// See https://github.com/jackc/pgconn/issues/40.
-				pgConn.contextWatcher.Unwatch()
 
+			// ValidateConnect may execute commands that cause the context to be watched again. Unwatch the connecting
+			// ContextWatcher first so it does not interrupt them. This is that last thing done by this method so there is
+			// no need to restart the watch after ValidateConnect returns.
+			//
+			// See https://github.com/jackc/pgconn/issues/40.
+			pgConn.contextWatcher.Unwatch()
+
+			// A cancel request cannot be sent until BackendKeyData has been received so the deadline handler is used while
+			// connecting. Switch to the configured handler now that connecting is done.
+			pgConn.contextWatcher = buildContextWatcher(config, pgConn)
+
+			if config.ValidateConnect != nil {
 				err := config.ValidateConnect(ctx, pgConn)
 				if err != nil {
 					if _, ok := err.(*NotPreferredError); ignoreNotPreferredErr && ok {
@@ -376,13 +785,151 @@ func connect(ctx context.Context, config *Config, fallbackConfig *FallbackConfig
 	}
 }
 
+// checkAuthRequest returns an error if config does not allow the server to authenticate the client with method.
+// method is authMethodNone if the server accepted the connection without requesting authentication.
+func checkAuthRequest(config *Config, requireAuth *authRequirement, method string) error {
+	var request string
+	switch method {
+	case authMethodPassword:
+		request = "server requested a cleartext password"
+	case authMethodMD5:
+		request = "server requested a hashed password"
+	case authMethodGSS:
+		request = "server requested GSSAPI authentication"
+	case authMethodSCRAMSHA256:
+		request = "server requested SASL authentication"
+	case authMethodNone:
+		request = "server did not request authentication"
+	}
+
+	if !requireAuth.allows(method) {
+		return &connectError{
+			config: config,
+			msg:    fmt.Sprintf("authentication method requirement %q failed", config.RequireAuth),
+			err:    errors.New(request),
+		}
+	}
+
+	if config.ChannelBinding == "require" && method != authMethodSCRAMSHA256 {
+		return &connectError{config: config, msg: "channel binding required", err: errors.New(request)}
+	}
+
+	return nil
+}
+
 func newContextWatcher(conn net.Conn) *ctxwatch.ContextWatcher {
-	return ctxwatch.NewContextWatcher(
-		func() { conn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC)) },
-		func() { conn.SetDeadline(time.Time{}) },
-	)
+	return ctxwatch.NewContextWatcher(&DeadlineContextWatcherHandler{Conn: conn})
 }
 
+// buildContextWatcher returns a ContextWatcher for an established connection that uses the handler built by
+// config.BuildContextWatcherHandler.
+func buildContextWatcher(config *Config, pgConn *PgConn) *ctxwatch.ContextWatcher {
+	if config == nil || config.BuildContextWatcherHandler == nil {
+		return newContextWatcher(pgConn.conn)
+	}
+	return ctxwatch.NewContextWatcher(config.BuildContextWatcherHandler(pgConn))
+}
+
+// DeadlineContextWatcherHandler handles canceled contexts by setting a deadline on a net.Conn. This interrupts any
+// read or write in progress. It usually leaves the connection in an unusable state, so the PgConn is closed. This is
+// the default behavior.
+type DeadlineContextWatcherHandler struct {
+	Conn net.Conn
+
+	// DeadlineDelay is how long after the context is canceled the deadline is set to. If zero the deadline is set to a
+	// time in the past.
+	DeadlineDelay time.Duration
+}
+
+func (h *DeadlineContextWatcherHandler) HandleCancel(ctx context.Context) {
+	if h.DeadlineDelay == 0 {
+		h.Conn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC))
+	} else {
+		h.Conn.SetDeadline(time.Now().Add(h.DeadlineDelay))
+	}
+}
+
+func (h *DeadlineContextWatcherHandler) HandleUnwatchAfterCancel() {
+	h.Conn.SetDeadline(time.Time{})
+}
+
+// CancelRequestContextWatcherHandler handles canceled contexts by sending a cancel request to the server. If the server
+// cancels the query in progress the operation fails with a *PgError (SQLSTATE 57014) and the connection remains
+// usable. If the operation is not interrupted within DeadlineDelay after the cancel request is sent, a deadline is set
+// on the underlying net.Conn as DeadlineContextWatcherHandler does.
+//
+// Use it with Config.BuildContextWatcherHandler:
+//
+//	config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+//		return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: 5 * time.Second}
+//	}
+type CancelRequestContextWatcherHandler struct {
+	Conn *PgConn
+
+	// CancelRequestDelay is how long after the context is canceled the cancel request is sent.
+	CancelRequestDelay time.Duration
+
+	// DeadlineDelay is how long after the cancel request is sent the deadline is set to. It is the grace period for the
+	// server to act on the cancel request and it also limits how long sending the cancel request may take. If zero, 5
+	// seconds is used as an immediate deadline would interrupt the connection before the cancel request could take
+	// effect.
+	DeadlineDelay time.Duration
+
+	// CancelRequestSettleDelay is how long to wait after the server acknowledges the cancel request before the
+	// connection may be used again. The server acknowledges a cancel request before it is delivered to the backend
+	// process, so a cancel request that arrives after the interrupted operation completed could cancel a later query on
+	// the connection. The operation that was interrupted does not return until the delay has passed. If zero there is
+	// no delay.
+	CancelRequestSettleDelay time.Duration
+
+	stopCancelRequest context.CancelFunc
+	cancelRequestDone chan struct{}
+}
+
+func (h *CancelRequestContextWatcherHandler) HandleCancel(context.Context) {
+	var ctx context.Context
+	ctx, h.stopCancelRequest = context.WithCancel(context.Background())
+	h.cancelRequestDone = make(chan struct{})
+
+	go func() {
+		defer close(h.cancelRequestDone)
+
+		timer := time.NewTimer(h.CancelRequestDelay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		deadlineDelay := h.DeadlineDelay
+		if deadlineDelay == 0 {
+			deadlineDelay = defaultCancelRequestDeadlineDelay
+		}
+		deadline := time.Now().Add(deadlineDelay)
+		h.Conn.conn.SetDeadline(deadline)
+
+		cancelRequestCtx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		err := h.Conn.CancelRequest(cancelRequestCtx)
+		if err != nil {
+			return
+		}
+
+		// This must not be cut short by ctx as HandleUnwatchAfterCancel cancels it before waiting for this goroutine.
+		time.Sleep(h.CancelRequestSettleDelay)
+	}()
+}
+
+func (h *CancelRequestContextWatcherHandler) HandleUnwatchAfterCancel() {
+	h.stopCancelRequest()
+	<-h.cancelRequestDone
+	h.Conn.conn.SetDeadline(time.Time{})
+}
+
+// defaultCancelRequestDeadlineDelay is the DeadlineDelay used by CancelRequestContextWatcherHandler if it is zero.
+const defaultCancelRequestDeadlineDelay = 5 * time.Second
+
 func startTLS(conn *nbconn.NetConn, tlsConfig *tls.Config) (*nbconn.TLSConn, error) {
 	err := binary.Write(conn, binary.BigEndian, []int32{8, 80877103})
 	if err != nil {
@@ -406,6 +953,58 @@ func startTLS(conn *nbconn.NetConn, tlsConfig *tls.Config) (*nbconn.TLSConn, err
 	return tlsConn, nil
 }
 
+// trackClientCertificateRequest returns a copy of tlsConfig that sets *requested to true if the server requests a client
+// certificate during the handshake.
+func trackClientCertificateRequest(tlsConfig *tls.Config, requested *bool) *tls.Config {
+	getClientCertificate := tlsConfig.GetClientCertificate
+	certificates := tlsConfig.Certificates
+
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		*requested = true
+		if getClientCertificate != nil {
+			return getClientCertificate(cri)
+		}
+		if len(certificates) > 0 {
+			return &certificates[0], nil
+		}
+		return &tls.Certificate{}, nil
+	}
+
+	return tlsConfig
+}
+
+// alpnProtocolPostgreSQL is the ALPN protocol name for the PostgreSQL protocol. It is required for direct TLS
+// negotiation.
+const alpnProtocolPostgreSQL = "postgresql"
+
+// startDirectTLS starts the TLS handshake immediately instead of first sending an SSLRequest.
+func startDirectTLS(conn *nbconn.NetConn, tlsConfig *tls.Config) (*nbconn.TLSConn, error) {
+	hasALPNProtocol := false
+	for _, proto := range tlsConfig.NextProtos {
+		if proto == alpnProtocolPostgreSQL {
+			hasALPNProtocol = true
+			break
+		}
+	}
+	if !hasALPNProtocol {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, alpnProtocolPostgreSQL)
+	}
+
+	tlsConn, err := nbconn.TLSClient(conn, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// A server that does not select the protocol may not be a PostgreSQL server at all.
+	if tlsConn.ConnectionState().NegotiatedProtocol != alpnProtocolPostgreSQL {
+		return nil, errors.New("server did not select the postgresql ALPN protocol for direct TLS negotiation")
+	}
+
+	return tlsConn, nil
+}
+
 func (pgConn *PgConn) txPasswordMessage(password string) (err error) {
 	pgConn.frontend.Send(&pgproto3.PasswordMessage{Password: password})
 	return pgConn.frontend.Flush()
@@ -533,11 +1132,23 @@ func (pgConn *PgConn) TxStatus() byte {
 	return pgConn.txStatus
 }
 
-// SecretKey returns the backend secret key used to send a cancel query message to the server.
+// SecretKey returns the backend secret key used to send a cancel query message to the server. It is 0 if the server
+// sent a key longer than 4 bytes. See LongSecretKey.
 func (pgConn *PgConn) SecretKey() uint32 {
 	return pgConn.secretKey
 }
 
+// LongSecretKey returns the backend secret key if it is longer than 4 bytes. Protocol version 3.2 allows secret keys of
+// up to 256 bytes. It is nil if the key is returned by SecretKey.
+func (pgConn *PgConn) LongSecretKey() []byte {
+	return pgConn.longSecretKey
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server (e.g. pgproto3.ProtocolVersion30).
+func (pgConn *PgConn) ProtocolVersion() uint32 {
+	return pgConn.protocolVersion
+}
+
 // Frontend returns the underlying *pgproto3.Frontend. This rarely necessary.
 func (pgConn *PgConn) Frontend() *pgproto3.Frontend {
 	return pgConn.frontend
@@ -855,19 +1466,12 @@ func (pgConn *PgConn) CancelRequest(ctx context.Context) error {
 	defer cancelConn.Close()
 
 	if ctx != context.Background() {
-		contextWatcher := ctxwatch.NewContextWatcher(
-			func() { cancelConn.SetDeadline(time.Date(1, 1, 1, 1, 1, 1, 1, time.UTC)) },
-			func() { cancelConn.SetDeadline(time.Time{}) },
-		)
+		contextWatcher := newContextWatcher(cancelConn)
 		contextWatcher.Watch(ctx)
 		defer contextWatcher.Unwatch()
 	}
 
-	buf := make([]byte, 16)
-	binary.BigEndian.PutUint32(buf[0:4], 16)
-	binary.BigEndian.PutUint32(buf[4:8], 80877102)
-	binary.BigEndian.PutUint32(buf[8:12], uint32(pgConn.pid))
-	binary.BigEndian.PutUint32(buf[12:16], uint32(pgConn.secretKey))
+	buf := (&pgproto3.CancelRequest{ProcessID: pgConn.pid, SecretKey: pgConn.secretKey, LongSecretKey: pgConn.longSecretKey}).Encode(nil)
 	_, err = cancelConn.Write(buf)
 	if err != nil {
 		return err
@@ -1636,10 +2240,14 @@ type HijackedConn struct {
 	Conn              nbconn.Conn       // the non-blocking wrapper of the underlying TCP or unix domain socket connection
 	PID               uint32            // backend pid
 	SecretKey         uint32            // key to use to send a cancel query message to the server
+	LongSecretKey     []byte            // protocol version 3.2 key longer than 4 bytes that is used instead of SecretKey
+	ProtocolVersion   uint32            // negotiated protocol version
 	ParameterStatuses map[string]string // parameters that have been reported by the server
 	TxStatus          byte
 	Frontend          *pgproto3.Frontend
 	Config            *Config
+
+	wireStats *wireStats // counters updated by Frontend
 }
 
 // Hijack extracts the internal connection data. pgConn must be in an idle state. pgConn is unusable after hijacking.
@@ -1658,10 +2266,13 @@ func (pgConn *PgConn) Hijack() (*HijackedConn, error) {
 		Conn:              pgConn.conn,
 		PID:               pgConn.pid,
 		SecretKey:         pgConn.secretKey,
+		LongSecretKey:     pgConn.longSecretKey,
+		ProtocolVersion:   pgConn.protocolVersion,
 		ParameterStatuses: pgConn.parameterStatuses,
 		TxStatus:          pgConn.txStatus,
 		Frontend:          pgConn.frontend,
 		Config:            pgConn.config,
+		wireStats:         pgConn.wireStats,
 	}, nil
 }
 
@@ -1675,17 +2286,20 @@ func Construct(hc *HijackedConn) (*PgConn, error) {
 		conn:              hc.Conn,
 		pid:               hc.PID,
 		secretKey:         hc.SecretKey,
+		longSecretKey:     hc.LongSecretKey,
+		protocolVersion:   hc.ProtocolVersion,
 		parameterStatuses: hc.ParameterStatuses,
 		txStatus:          hc.TxStatus,
 		frontend:          hc.Frontend,
 		config:            hc.Config,
+		wireStats:         hc.wireStats,
 
 		status: connStatusIdle,
 
 		cleanupDone: make(chan struct{}),
 	}
 
-	pgConn.contextWatcher = newContextWatcher(pgConn.conn)
+	pgConn.contextWatcher = buildContextWatcher(pgConn.config, pgConn)
 
 	return pgConn, nil
 }
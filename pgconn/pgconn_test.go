@@ -4,7 +4,12 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -20,12 +25,14 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/internal/pgio"
-	"github.com/jackc/pgx/v5/internal/pgmock"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"github.com/jackc/pgx/v5/pgmock"
 	"github.com/jackc/pgx/v5/pgproto3"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func TestConnect(t *testing.T) {
@@ -435,766 +442,997 @@ func TestConnectCustomLookupWithPort(t *testing.T) {
 	closeConn(t, conn)
 }
 
-func TestConnectWithRuntimeParams(t *testing.T) {
+func TestConnectLoadBalanceHostsRandom(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-
-	config.RuntimeParams = map[string]string{
-		"application_name": "pgxtest",
-		"search_path":      "myschema",
-	}
+	dialOrder := func(connString string) []string {
+		config, err := pgconn.ParseConfig(connString)
+		require.NoError(t, err)
 
-	conn, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-	defer closeConn(t, conn)
+		config.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+			return []string{host + "-1", host + "-2"}, nil
+		}
 
-	result := conn.ExecParams(context.Background(), "show application_name", nil, nil, nil, nil).Read()
-	require.Nil(t, result.Err)
-	assert.Equal(t, 1, len(result.Rows))
-	assert.Equal(t, "pgxtest", string(result.Rows[0][0]))
+		var dialed []string
+		config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			return nil, errors.New("dial refused")
+		}
 
-	result = conn.ExecParams(context.Background(), "show search_path", nil, nil, nil, nil).Read()
-	require.Nil(t, result.Err)
-	assert.Equal(t, 1, len(result.Rows))
-	assert.Equal(t, "myschema", string(result.Rows[0][0]))
-}
+		_, err = pgconn.ConnectConfig(context.Background(), config)
+		require.Error(t, err)
+		return dialed
+	}
 
-func TestConnectWithFallback(t *testing.T) {
-	t.Parallel()
+	dialed := dialOrder("host=a,b,c port=5432 sslmode=disable")
+	assert.Equal(t, []string{"a-1:5432", "a-2:5432", "b-1:5432", "b-2:5432", "c-1:5432", "c-2:5432"}, dialed)
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
+	firstAddrs := map[string]struct{}{}
+	for i := 0; i < 100; i++ {
+		dialed := dialOrder("host=a,b,c port=5432 sslmode=prefer load_balance_hosts=random")
+		require.Len(t, dialed, 12)
 
-	// Prepend current primary config to fallbacks
-	config.Fallbacks = append([]*pgconn.FallbackConfig{
-		&pgconn.FallbackConfig{
-			Host:      config.Host,
-			Port:      config.Port,
-			TLSConfig: config.TLSConfig,
-		},
-	}, config.Fallbacks...)
+		// The TLS and non-TLS attempts for each host are kept together.
+		for j := 0; j < len(dialed); j += 4 {
+			assert.Equal(t, dialed[j][:1], dialed[j+3][:1])
+			assert.ElementsMatch(t, dialed[j:j+2], dialed[j+2:j+4])
+		}
 
-	// Make primary config bad
-	config.Host = "localhost"
-	config.Port = 1 // presumably nothing listening here
+		firstAddrs[dialed[0]] = struct{}{}
+	}
 
-	// Prepend bad first fallback
-	config.Fallbacks = append([]*pgconn.FallbackConfig{
-		&pgconn.FallbackConfig{
-			Host:      "localhost",
-			Port:      1,
-			TLSConfig: config.TLSConfig,
-		},
-	}, config.Fallbacks...)
+	assert.Greater(t, len(firstAddrs), 3, "hosts and their addresses are shuffled")
 
-	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	// The addresses returned by LookupFunc may be cached and must not be shuffled in place.
+	config, err := pgconn.ParseConfig("host=a port=5432 sslmode=disable load_balance_hosts=random")
 	require.NoError(t, err)
-	closeConn(t, conn)
+	cachedAddrs := []string{"a-1", "a-2", "a-3", "a-4"}
+	config.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		return cachedAddrs, nil
+	}
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, errors.New("dial refused")
+	}
+	for i := 0; i < 20; i++ {
+		_, err = pgconn.ConnectConfig(context.Background(), config)
+		require.Error(t, err)
+	}
+	assert.Equal(t, []string{"a-1", "a-2", "a-3", "a-4"}, cachedAddrs)
 }
 
-func TestConnectWithValidateConnect(t *testing.T) {
+func TestConnectSRV(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig("postgres+srv://jack@db.internal/mydb?sslmode=disable")
 	require.NoError(t, err)
 
-	dialCount := 0
-	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
-		dialCount++
-		return net.Dial(network, address)
-	}
-
-	acceptConnCount := 0
-	config.ValidateConnect = func(ctx context.Context, conn *pgconn.PgConn) error {
-		acceptConnCount++
-		if acceptConnCount < 2 {
-			return errors.New("reject first conn")
+	config.LookupSRVFunc = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if service != "" || proto != "" || name != "_postgresql._tcp.db.internal" {
+			return "", nil, fmt.Errorf("unexpected lookup %q %q %q", service, proto, name)
 		}
-		return nil
+		return name, []*net.SRV{
+			{Target: "standby.db.internal.", Port: 5434, Priority: 10, Weight: 0},
+			{Target: "a.db.internal.", Port: 5432, Priority: 0, Weight: 90},
+			{Target: ".", Port: 5432, Priority: 5, Weight: 0},
+			{Target: "b.db.internal.", Port: 5433, Priority: 0, Weight: 10},
+		}, nil
+	}
+	config.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		return []string{strings.TrimSuffix(host, ".db.internal") + "-ip"}, nil
 	}
 
-	// Append current primary config to fallbacks
-	config.Fallbacks = append(config.Fallbacks, &pgconn.FallbackConfig{
-		Host:      config.Host,
-		Port:      config.Port,
-		TLSConfig: config.TLSConfig,
-	})
-
-	// Repeat fallbacks
-	config.Fallbacks = append(config.Fallbacks, config.Fallbacks...)
-
-	conn, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-	closeConn(t, conn)
-
-	assert.True(t, dialCount > 1)
-	assert.True(t, acceptConnCount > 1)
-}
-
-func TestConnectWithValidateConnectTargetSessionAttrsReadWrite(t *testing.T) {
-	t.Parallel()
-
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
+	firstCounts := map[string]int{}
+	for i := 0; i < 200; i++ {
+		var dialed []string
+		config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			return nil, errors.New("dial refused")
+		}
 
-	config.ValidateConnect = pgconn.ValidateConnectTargetSessionAttrsReadWrite
-	config.RuntimeParams["default_transaction_read_only"] = "on"
+		_, err = pgconn.ConnectConfig(context.Background(), config)
+		require.Error(t, err)
+		require.Len(t, dialed, 3)
+		assert.ElementsMatch(t, []string{"a-ip:5432", "b-ip:5433"}, dialed[:2])
+		assert.Equal(t, "standby-ip:5434", dialed[2])
+		firstCounts[dialed[0]]++
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	assert.Greater(t, firstCounts["a-ip:5432"], firstCounts["b-ip:5433"], "higher weight is tried first more often")
+	assert.Greater(t, firstCounts["b-ip:5433"], 0, "lower weight is sometimes tried first")
 
-	conn, err := pgconn.ConnectConfig(ctx, config)
-	if !assert.NotNil(t, err) {
-		conn.Close(ctx)
+	config.LookupSRVFunc = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("no such host")
 	}
+	_, err = pgconn.ConnectConfig(context.Background(), config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hostname resolving error")
 }
 
-func TestConnectWithAfterConnect(t *testing.T) {
+func TestConnectSRVSSLModePrefer(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig("postgres+srv://jack@db.internal/mydb?sslmode=prefer")
 	require.NoError(t, err)
 
-	config.AfterConnect = func(ctx context.Context, conn *pgconn.PgConn) error {
-		_, err := conn.Exec(ctx, "set search_path to foobar;").ReadAll()
-		return err
+	srvLookups := 0
+	config.LookupSRVFunc = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		srvLookups++
+		return name, []*net.SRV{
+			{Target: "a.db.internal.", Port: 5432, Priority: 0, Weight: 50},
+			{Target: "b.db.internal.", Port: 5433, Priority: 0, Weight: 50},
+		}, nil
+	}
+	config.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		return []string{strings.TrimSuffix(host, ".db.internal") + "-ip"}, nil
 	}
 
-	conn, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
+	var dialed []string
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return nil, errors.New("dial refused")
+	}
 
-	results, err := conn.Exec(context.Background(), "show search_path;").ReadAll()
-	require.NoError(t, err)
-	defer closeConn(t, conn)
+	_, err = pgconn.ConnectConfig(context.Background(), config)
+	require.Error(t, err)
+	assert.Equal(t, 1, srvLookups)
 
-	assert.Equal(t, []byte("foobar"), results[0].Rows[0][0])
+	// Each target is tried with and without TLS before the next target.
+	require.Len(t, dialed, 4)
+	assert.Equal(t, dialed[0], dialed[1])
+	assert.Equal(t, dialed[2], dialed[3])
+	assert.ElementsMatch(t, []string{"a-ip:5432", "b-ip:5433"}, []string{dialed[0], dialed[2]})
 }
 
-func TestConnectConfigRequiresConfigFromParseConfig(t *testing.T) {
+func TestConnStats(t *testing.T) {
 	t.Parallel()
 
-	config := &pgconn.Config{}
+	serverMessages := []pgproto3.BackendMessage{
+		&pgproto3.AuthenticationOk{},
+		&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+	}
+	var serverBytes int
+	for _, msg := range serverMessages {
+		serverBytes += len(msg.Encode(nil))
+	}
 
-	require.PanicsWithValue(t, "config must be created by ParseConfig", func() { pgconn.ConnectConfig(context.Background(), config) })
-}
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.SendMessage(serverMessages[3]),
+		pgmock.SendMessage(serverMessages[4]),
+		pgmock.WaitForClose(),
+	)
+	connStr, serverErrChan := startMockServer(t, script)
 
-func TestConnPrepareSyntaxError(t *testing.T) {
-	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	conn, err := pgconn.Connect(ctx, connStr)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	psd, err := pgConn.Prepare(context.Background(), "ps1", "SYNTAX ERROR", nil)
-	require.Nil(t, psd)
-	require.NotNil(t, err)
-
-	ensureConnValid(t, pgConn)
-}
-
-func TestConnPrepareContextPrecanceled(t *testing.T) {
-	t.Parallel()
+	stats := conn.Stats()
+	assert.EqualValues(t, 1, stats.MessagesSent)
+	assert.EqualValues(t, 3, stats.MessagesReceived)
+	assert.EqualValues(t, 1, stats.RoundTrips)
+	startupBytes := stats.BytesSent
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	_, err = conn.Exec(ctx, "select 1").ReadAll()
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	psd, err := pgConn.Prepare(ctx, "ps1", "select 1", nil)
-	assert.Nil(t, psd)
-	assert.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
+	stats = conn.Stats()
+	assert.Equal(t, startupBytes+int64(len((&pgproto3.Query{String: "select 1"}).Encode(nil))), stats.BytesSent)
+	assert.EqualValues(t, serverBytes, stats.BytesReceived)
+	assert.EqualValues(t, 2, stats.MessagesSent)
+	assert.EqualValues(t, 5, stats.MessagesReceived)
+	assert.EqualValues(t, 2, stats.RoundTrips)
 
-	ensureConnValid(t, pgConn)
+	closeConn(t, conn)
+	require.NoError(t, <-serverErrChan)
 }
 
-func TestConnExec(t *testing.T) {
+func TestConnMaxMessageBodyLen(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	results, err := pgConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
-	assert.NoError(t, err)
-
-	assert.Len(t, results, 1)
-	assert.Nil(t, results[0].Err)
-	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
-	assert.Len(t, results[0].Rows, 1)
-	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
-
-	ensureConnValid(t, pgConn)
-}
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select big"}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("big"), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{make([]byte, 2048)}}),
+	)
+	connStr, _ := startMockServer(t, script)
 
-func TestConnExecEmpty(t *testing.T) {
-	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	conn, err := pgconn.Connect(ctx, connStr+" max_message_body_len=1024")
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	multiResult := pgConn.Exec(context.Background(), ";")
 
-	resultCount := 0
-	for multiResult.NextResult() {
-		resultCount++
-		multiResult.ResultReader().Close()
-	}
-	assert.Equal(t, 0, resultCount)
-	err = multiResult.Close()
-	assert.NoError(t, err)
+	_, err = conn.Exec(ctx, "select big").ReadAll()
+	var exceededErr *pgproto3.ExceededMaxBodyLenErr
+	require.ErrorAs(t, err, &exceededErr)
+	assert.Equal(t, 1024, exceededErr.MaxExpectedBodyLen)
+	assert.Equal(t, 2054, exceededErr.ActualBodyLen)
 
-	ensureConnValid(t, pgConn)
+	assert.True(t, conn.IsClosed())
 }
 
-func TestConnExecMultipleQueries(t *testing.T) {
+func TestConnTraceWriter(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.SendMessage(&pgproto3.EmptyQueryResponse{}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+	connStr, serverErrChan := startMockServer(t, script)
+
+	config, err := pgconn.ParseConfig(connStr)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	traceOutput := &bytes.Buffer{}
+	config.TraceWriter = traceOutput
+	config.TraceOptions = pgproto3.TracerOptions{JSON: true}
 
-	results, err := pgConn.Exec(context.Background(), "select 'Hello, world'; select 1").ReadAll()
-	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	assert.Len(t, results, 2)
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	_, err = conn.Exec(ctx, "select 1").ReadAll()
+	require.NoError(t, err)
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, <-serverErrChan)
 
-	assert.Nil(t, results[0].Err)
-	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
-	assert.Len(t, results[0].Rows, 1)
-	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+	var types []string
+	decoder := json.NewDecoder(traceOutput)
+	for decoder.More() {
+		var record pgproto3.TraceRecord
+		require.NoError(t, decoder.Decode(&record))
+		require.NotNil(t, record.Elapsed)
+		types = append(types, record.From+" "+record.Type)
+	}
+	assert.Equal(t, []string{
+		"F StartupMessage",
+		"B AuthenticationOk",
+		"B BackendKeyData",
+		"B ReadyForQuery",
+		"F Query",
+		"B EmptyQueryResponse",
+		"B ReadyForQuery",
+		"F Terminate",
+	}, types)
+}
 
-	assert.Nil(t, results[1].Err)
-	assert.Equal(t, "SELECT 1", results[1].CommandTag.String())
-	assert.Len(t, results[1].Rows, 1)
-	assert.Equal(t, "1", string(results[1].Rows[0][0]))
+// pgmockStepFunc adapts a function to a pgmock.Step.
+type pgmockStepFunc func(backend *pgproto3.Backend) error
 
-	ensureConnValid(t, pgConn)
+func (f pgmockStepFunc) Step(backend *pgproto3.Backend) error {
+	return f(backend)
 }
 
-func TestConnExecMultipleQueriesEagerFieldDescriptions(t *testing.T) {
+func TestConnectCancelRequestContextWatcherHandler(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	tests := []struct {
+		name               string
+		cancelRequestDelay time.Duration
+		serverCancels      bool
+		connUsableAfter    bool
+	}{
+		{
+			name:            "server cancels query",
+			serverCancels:   true,
+			connUsableAfter: true,
+		},
+		{
+			// The deadline delay is measured from when the cancel request is sent.
+			name:               "cancel request delay longer than deadline delay",
+			cancelRequestDelay: time.Second,
+			serverCancels:      true,
+			connUsableAfter:    true,
+		},
+		{
+			name:          "server ignores cancel request",
+			serverCancels: false,
+		},
+	}
 
-	mrr := pgConn.Exec(context.Background(), "select 'Hello, world' as msg; select 1 as num")
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
 
-	require.True(t, mrr.NextResult())
-	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
-	assert.Equal(t, "msg", mrr.ResultReader().FieldDescriptions()[0].Name)
-	_, err = mrr.ResultReader().Close()
-	require.NoError(t, err)
+			ln, err := net.Listen("tcp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer ln.Close()
 
-	require.True(t, mrr.NextResult())
-	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
-	assert.Equal(t, "num", mrr.ResultReader().FieldDescriptions()[0].Name)
-	_, err = mrr.ResultReader().Close()
-	require.NoError(t, err)
+			secretKey := uint32(0x01020304)
+			cancelRequestChan := make(chan *pgproto3.CancelRequest, 2)
+			acceptCancelRequest := func() error {
+				conn, err := ln.Accept()
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
 
-	require.False(t, mrr.NextResult())
+				msg, err := pgproto3.NewBackend(conn, conn).ReceiveStartupMessage()
+				if err != nil {
+					return err
+				}
+				cancelRequest, ok := msg.(*pgproto3.CancelRequest)
+				if !ok {
+					return fmt.Errorf("expected CancelRequest, got %T", msg)
+				}
+				cancelRequestChan <- cancelRequest
+				return nil
+			}
 
-	require.NoError(t, mrr.Close())
+			script := &pgmock.Script{Steps: []pgmock.Step{
+				pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+				pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+				pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: secretKey}),
+				pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+				pgmock.ExpectMessage(&pgproto3.Query{String: "select pg_sleep(60)"}),
+				pgmockStepFunc(func(*pgproto3.Backend) error { return acceptCancelRequest() }),
+			}}
+			if tt.serverCancels {
+				script.Steps = append(script.Steps,
+					pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "canceling statement due to user request"}),
+					pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+					pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+					pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+					pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+				)
+			} else {
+				// Closing the connection after the deadline sends another cancel request.
+				script.Steps = append(script.Steps, pgmockStepFunc(func(*pgproto3.Backend) error { return acceptCancelRequest() }))
+			}
+			script.Steps = append(script.Steps, pgmock.WaitForClose())
 
-	ensureConnValid(t, pgConn)
+			serverErrChan := make(chan error, 1)
+			go func() {
+				defer close(serverErrChan)
+
+				conn, err := ln.Accept()
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
+				defer conn.Close()
+
+				err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
+
+				serverErrChan <- script.Run(pgproto3.NewBackend(conn, conn))
+			}()
+
+			config, err := pgconn.ParseConfig(fmt.Sprintf("sslmode=disable host=127.0.0.1 port=%d", ln.Addr().(*net.TCPAddr).Port))
+			require.NoError(t, err)
+			config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+				return &pgconn.CancelRequestContextWatcherHandler{
+					Conn:                     pgConn,
+					CancelRequestDelay:       tt.cancelRequestDelay,
+					DeadlineDelay:            500 * time.Millisecond,
+					CancelRequestSettleDelay: 100 * time.Millisecond,
+				}
+			}
+
+			conn, err := pgconn.ConnectConfig(context.Background(), config)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			startTime := time.Now()
+			_, err = conn.Exec(ctx, "select pg_sleep(60)").ReadAll()
+			require.Error(t, err)
+
+			cancelRequest := <-cancelRequestChan
+			assert.EqualValues(t, 42, cancelRequest.ProcessID)
+			assert.Equal(t, secretKey, cancelRequest.SecretKey)
+
+			if tt.connUsableAfter {
+				var pgErr *pgconn.PgError
+				require.ErrorAs(t, err, &pgErr)
+				assert.Equal(t, "57014", pgErr.Code)
+				assert.False(t, conn.IsClosed())
+				// The connection is not used again until the acknowledged cancel request has had time to settle.
+				assert.GreaterOrEqual(t, time.Since(startTime), tt.cancelRequestDelay+150*time.Millisecond)
+
+				_, err = conn.Exec(context.Background(), "select 1").ReadAll()
+				require.NoError(t, err)
+				closeConn(t, conn)
+			} else {
+				assert.True(t, pgconn.Timeout(err))
+				select {
+				case <-conn.CleanupDone():
+				case <-time.After(5 * time.Second):
+					t.Fatal("connection was not closed")
+				}
+			}
+
+			require.NoError(t, <-serverErrChan)
+		})
+	}
 }
 
-func TestConnExecMultipleQueriesError(t *testing.T) {
+func TestConnectValidateConnectUsesContextWatcherHandler(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	ln, err := net.Listen("tcp", "127.0.0.1:")
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	defer ln.Close()
 
-	results, err := pgConn.Exec(context.Background(), "select 1; select 1/0; select 1").ReadAll()
-	require.NotNil(t, err)
-	if pgErr, ok := err.(*pgconn.PgError); ok {
-		assert.Equal(t, "22012", pgErr.Code)
-	} else {
-		t.Errorf("unexpected error: %v", err)
-	}
+	script := &pgmock.Script{Steps: []pgmock.Step{
+		pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+		pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+		pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: 0x01020304}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select pg_sleep(60)"}),
+		pgmockStepFunc(func(*pgproto3.Backend) error {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			_, err = pgproto3.NewBackend(conn, conn).ReceiveStartupMessage()
+			return err
+		}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "57014", Message: "canceling statement due to user request"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "select 1"}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.WaitForClose(),
+	}}
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		// CockroachDB starts the second query result set and then sends the divide by zero error.
-		require.Len(t, results, 2)
-		assert.Len(t, results[0].Rows, 1)
-		assert.Equal(t, "1", string(results[0].Rows[0][0]))
-		assert.Len(t, results[1].Rows, 0)
-	} else {
-		// PostgreSQL sends the divide by zero and never sends the second query result set.
-		require.Len(t, results, 1)
-		assert.Len(t, results[0].Rows, 1)
-		assert.Equal(t, "1", string(results[0].Rows[0][0]))
-	}
+	serverErrChan := make(chan error, 1)
+	go func() {
+		defer close(serverErrChan)
 
-	ensureConnValid(t, pgConn)
-}
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
 
-func TestConnExecDeferredError(t *testing.T) {
-	t.Parallel()
+		serverErrChan <- script.Run(pgproto3.NewBackend(conn, conn))
+	}()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(fmt.Sprintf("sslmode=disable host=127.0.0.1 port=%d", ln.Addr().(*net.TCPAddr).Port))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: time.Second}
+	}
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	// The connect context is canceled while ValidateConnect runs a query. The configured handler sends a cancel
+	// request instead of the connecting handler setting a deadline that breaks the connection.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config.ValidateConnect = func(_ context.Context, pgConn *pgconn.PgConn) error {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		_, err := pgConn.Exec(ctx, "select pg_sleep(60)").ReadAll()
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "57014" {
+			return fmt.Errorf("expected query canceled error, got %v", err)
+		}
+		return nil
 	}
 
-	setupSQL := `create temporary table t (
-		id text primary key,
-		n int not null,
-		unique (n) deferrable initially deferred
-	);
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	assert.False(t, conn.IsClosed())
 
-	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+	_, err = conn.Exec(context.Background(), "select 1").ReadAll()
+	require.NoError(t, err)
+	closeConn(t, conn)
 
-	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
-	assert.NoError(t, err)
+	require.NoError(t, <-serverErrChan)
+}
 
-	_, err = pgConn.Exec(context.Background(), `update t set n=n+1 where id='b' returning *`).ReadAll()
-	require.NotNil(t, err)
+// startBlackholeServer starts a server that accepts a connection but never responds. The returned channel is closed
+// when the client closes the connection.
+func startBlackholeServer(t *testing.T) (port string, clientClosed <-chan struct{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
 
-	var pgErr *pgconn.PgError
-	require.True(t, errors.As(err, &pgErr))
-	require.Equal(t, "23505", pgErr.Code)
+	closed := make(chan struct{})
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
 
-	ensureConnValid(t, pgConn)
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	_, port, err = net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	return port, closed
 }
 
-func TestConnExecContextCanceled(t *testing.T) {
+func TestConnectParallel(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	blackholePort, blackholeClosed := startBlackholeServer(t)
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, pgmock.WaitForClose())
+	connStr, serverErrChan := startMockServer(t, script)
+	config, err := pgconn.ParseConfig(connStr)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	config, err = pgconn.ParseConfig(fmt.Sprintf("host=127.0.0.1,127.0.0.1 port=%s,%d sslmode=disable connect_timeout=30", blackholePort, config.Port))
+	require.NoError(t, err)
+	config.ParallelConnectDelay = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	multiResult := pgConn.Exec(ctx, "select 'Hello, world', pg_sleep(1)")
 
-	for multiResult.NextResult() {
-	}
-	err = multiResult.Close()
-	assert.True(t, pgconn.Timeout(err))
-	assert.ErrorIs(t, err, context.DeadlineExceeded)
-	assert.True(t, pgConn.IsClosed())
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+	assert.EqualValues(t, config.Fallbacks[0].Port, conn.Conn().RemoteAddr().(*net.TCPAddr).Port, "connected to the second host")
+
 	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+	case <-blackholeClosed:
+	case <-ctx.Done():
+		t.Fatal("losing connection attempt was not canceled")
 	}
+
+	closeConn(t, conn)
+	require.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecContextPrecanceled(t *testing.T) {
+func TestConnectParallelFatalError(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	_, err = pgConn.Exec(ctx, "select 'Hello, world'").ReadAll()
-	assert.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
-
-	ensureConnValid(t, pgConn)
-}
+	blackholePort, blackholeClosed := startBlackholeServer(t)
 
-func TestConnExecParams(t *testing.T) {
-	t.Parallel()
+	script := &pgmock.Script{Steps: []pgmock.Step{
+		pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"}),
+	}}
+	connStr, serverErrChan := startMockServer(t, script)
+	config, err := pgconn.ParseConfig(connStr)
+	require.NoError(t, err)
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	// The authentication error from the second server must end all attempts including the one in progress to the first.
+	config, err = pgconn.ParseConfig(fmt.Sprintf("host=127.0.0.1,127.0.0.1 port=%s,%d sslmode=disable", blackholePort, config.Port))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	config.ParallelConnectDelay = time.Millisecond
 
-	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
-	require.Len(t, result.FieldDescriptions(), 1)
-	assert.Equal(t, "msg", result.FieldDescriptions()[0].Name)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
-		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+	_, err = pgconn.ConnectConfig(ctx, config)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "28P01", pgErr.Code)
+	require.NoError(t, ctx.Err())
+
+	select {
+	case <-blackholeClosed:
+	case <-ctx.Done():
+		t.Fatal("connection attempt in progress was not canceled")
 	}
-	assert.Equal(t, 1, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, "SELECT 1", commandTag.String())
-	assert.NoError(t, err)
 
-	ensureConnValid(t, pgConn)
+	require.NoError(t, <-serverErrChan)
 }
 
-func TestConnExecParamsDeferredError(t *testing.T) {
+func TestConnectWithRuntimeParams(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	config.RuntimeParams = map[string]string{
+		"application_name": "pgxtest",
+		"search_path":      "myschema",
 	}
 
-	setupSQL := `create temporary table t (
-		id text primary key,
-		n int not null,
-		unique (n) deferrable initially deferred
-	);
-
-	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
-
-	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
-	assert.NoError(t, err)
+	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, conn)
 
-	result := pgConn.ExecParams(context.Background(), `update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil).Read()
-	require.NotNil(t, result.Err)
-	var pgErr *pgconn.PgError
-	require.True(t, errors.As(result.Err, &pgErr))
-	require.Equal(t, "23505", pgErr.Code)
+	result := conn.ExecParams(context.Background(), "show application_name", nil, nil, nil, nil).Read()
+	require.Nil(t, result.Err)
+	assert.Equal(t, 1, len(result.Rows))
+	assert.Equal(t, "pgxtest", string(result.Rows[0][0]))
 
-	ensureConnValid(t, pgConn)
+	result = conn.ExecParams(context.Background(), "show search_path", nil, nil, nil, nil).Read()
+	require.Nil(t, result.Err)
+	assert.Equal(t, 1, len(result.Rows))
+	assert.Equal(t, "myschema", string(result.Rows[0][0]))
 }
 
-func TestConnExecParamsMaxNumberOfParams(t *testing.T) {
+func TestConnectWithFallback(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	paramCount := math.MaxUint16
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
-	}
-	sql := "values" + strings.Join(params, ", ")
+	// Prepend current primary config to fallbacks
+	config.Fallbacks = append([]*pgconn.FallbackConfig{
+		&pgconn.FallbackConfig{
+			Host:      config.Host,
+			Port:      config.Port,
+			TLSConfig: config.TLSConfig,
+		},
+	}, config.Fallbacks...)
 
-	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
-	require.Len(t, result.Rows, paramCount)
+	// Make primary config bad
+	config.Host = "localhost"
+	config.Port = 1 // presumably nothing listening here
 
-	ensureConnValid(t, pgConn)
+	// Prepend bad first fallback
+	config.Fallbacks = append([]*pgconn.FallbackConfig{
+		&pgconn.FallbackConfig{
+			Host:      "localhost",
+			Port:      1,
+			TLSConfig: config.TLSConfig,
+		},
+	}, config.Fallbacks...)
+
+	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	closeConn(t, conn)
 }
 
-func TestConnExecParamsTooManyParams(t *testing.T) {
+func TestConnectWithValidateConnect(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	paramCount := math.MaxUint16 + 1
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
+	dialCount := 0
+	config.DialFunc = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialCount++
+		return net.Dial(network, address)
 	}
-	sql := "values" + strings.Join(params, ", ")
 
-	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
-	require.Error(t, result.Err)
-	require.Equal(t, "extended protocol limited to 65535 parameters", result.Err.Error())
+	acceptConnCount := 0
+	config.ValidateConnect = func(ctx context.Context, conn *pgconn.PgConn) error {
+		acceptConnCount++
+		if acceptConnCount < 2 {
+			return errors.New("reject first conn")
+		}
+		return nil
+	}
 
-	ensureConnValid(t, pgConn)
+	// Append current primary config to fallbacks
+	config.Fallbacks = append(config.Fallbacks, &pgconn.FallbackConfig{
+		Host:      config.Host,
+		Port:      config.Port,
+		TLSConfig: config.TLSConfig,
+	})
+
+	// Repeat fallbacks
+	config.Fallbacks = append(config.Fallbacks, config.Fallbacks...)
+
+	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	closeConn(t, conn)
+
+	assert.True(t, dialCount > 1)
+	assert.True(t, acceptConnCount > 1)
 }
 
-func TestConnExecParamsCanceled(t *testing.T) {
+func TestConnectWithValidateConnectTargetSessionAttrsReadWrite(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	config.ValidateConnect = pgconn.ValidateConnectTargetSessionAttrsReadWrite
+	config.RuntimeParams["default_transaction_read_only"] = "on"
+
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	result := pgConn.ExecParams(ctx, "select current_database(), pg_sleep(1)", nil, nil, nil, nil)
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
+
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	if !assert.NotNil(t, err) {
+		conn.Close(ctx)
 	}
-	assert.Equal(t, 0, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, pgconn.CommandTag{}, commandTag)
-	assert.True(t, pgconn.Timeout(err))
-	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
 
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+func TestConnectWithAfterConnect(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+
+	config.AfterConnect = func(ctx context.Context, conn *pgconn.PgConn) error {
+		_, err := conn.Exec(ctx, "set search_path to foobar;").ReadAll()
+		return err
 	}
+
+	conn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+
+	results, err := conn.Exec(context.Background(), "show search_path;").ReadAll()
+	require.NoError(t, err)
+	defer closeConn(t, conn)
+
+	assert.Equal(t, []byte("foobar"), results[0].Rows[0][0])
 }
 
-func TestConnExecParamsPrecanceled(t *testing.T) {
+func TestConnectConfigRequiresConfigFromParseConfig(t *testing.T) {
+	t.Parallel()
+
+	config := &pgconn.Config{}
+
+	require.PanicsWithValue(t, "config must be created by ParseConfig", func() { pgconn.ConnectConfig(context.Background(), config) })
+}
+
+func TestConnPrepareSyntaxError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	result := pgConn.ExecParams(ctx, "select $1::text", [][]byte{[]byte("Hello, world")}, nil, nil, nil).Read()
-	require.Error(t, result.Err)
-	assert.True(t, errors.Is(result.Err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(result.Err))
+	psd, err := pgConn.Prepare(context.Background(), "ps1", "SYNTAX ERROR", nil)
+	require.Nil(t, psd)
+	require.NotNil(t, err)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecParamsEmptySQL(t *testing.T) {
+func TestConnPrepareContextPrecanceled(t *testing.T) {
 	t.Parallel()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_DATABASE"))
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(ctx, "", nil, nil, nil, nil).Read()
-	assert.Equal(t, pgconn.CommandTag{}, result.CommandTag)
-	assert.Len(t, result.Rows, 0)
-	assert.NoError(t, result.Err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	psd, err := pgConn.Prepare(ctx, "ps1", "select 1", nil)
+	assert.Nil(t, psd)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
 
 	ensureConnValid(t, pgConn)
 }
 
-// https://github.com/jackc/pgx/issues/859
-func TestResultReaderValuesHaveSameCapacityAsLength(t *testing.T) {
+func TestConnExec(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
-	require.Len(t, result.FieldDescriptions(), 1)
-	assert.Equal(t, "msg", result.FieldDescriptions()[0].Name)
-
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
-		assert.Equal(t, "Hello, world", string(result.Values()[0]))
-		assert.Equal(t, len(result.Values()[0]), cap(result.Values()[0]))
-	}
-	assert.Equal(t, 1, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, "SELECT 1", commandTag.String())
+	results, err := pgConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
 	assert.NoError(t, err)
 
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
+	assert.Len(t, results[0].Rows, 1)
+	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPrepared(t *testing.T) {
+func TestConnExecEmpty(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	psd, err := pgConn.Prepare(context.Background(), "ps1", "select $1::text as msg", nil)
-	require.NoError(t, err)
-	require.NotNil(t, psd)
-	assert.Len(t, psd.ParamOIDs, 1)
-	assert.Len(t, psd.Fields, 1)
-
-	result := pgConn.ExecPrepared(context.Background(), "ps1", [][]byte{[]byte("Hello, world")}, nil, nil)
-	require.Len(t, result.FieldDescriptions(), 1)
-	assert.Equal(t, "msg", result.FieldDescriptions()[0].Name)
+	multiResult := pgConn.Exec(context.Background(), ";")
 
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
-		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+	resultCount := 0
+	for multiResult.NextResult() {
+		resultCount++
+		multiResult.ResultReader().Close()
 	}
-	assert.Equal(t, 1, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, "SELECT 1", commandTag.String())
+	assert.Equal(t, 0, resultCount)
+	err = multiResult.Close()
 	assert.NoError(t, err)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedMaxNumberOfParams(t *testing.T) {
+func TestConnExecMultipleQueries(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	paramCount := math.MaxUint16
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
-	}
-	sql := "values" + strings.Join(params, ", ")
+	results, err := pgConn.Exec(context.Background(), "select 'Hello, world'; select 1").ReadAll()
+	assert.NoError(t, err)
 
-	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
-	require.NoError(t, err)
-	require.NotNil(t, psd)
-	assert.Len(t, psd.ParamOIDs, paramCount)
-	assert.Len(t, psd.Fields, 1)
+	assert.Len(t, results, 2)
 
-	result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
-	require.NoError(t, result.Err)
-	require.Len(t, result.Rows, paramCount)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
+	assert.Len(t, results[0].Rows, 1)
+	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+
+	assert.Nil(t, results[1].Err)
+	assert.Equal(t, "SELECT 1", results[1].CommandTag.String())
+	assert.Len(t, results[1].Rows, 1)
+	assert.Equal(t, "1", string(results[1].Rows[0][0]))
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedTooManyParams(t *testing.T) {
+func TestConnExecMultipleQueriesEagerFieldDescriptions(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	paramCount := math.MaxUint16 + 1
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
-	}
-	sql := "values" + strings.Join(params, ", ")
+	mrr := pgConn.Exec(context.Background(), "select 'Hello, world' as msg; select 1 as num")
 
-	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		// CockroachDB rejects preparing a statement with more than 65535 parameters.
-		require.EqualError(t, err, "ERROR: more than 65535 arguments to prepared statement: 65536 (SQLSTATE 08P01)")
-	} else {
-		// PostgreSQL accepts preparing a statement with more than 65535 parameters and only fails when executing it through the extended protocol.
-		require.NoError(t, err)
-		require.NotNil(t, psd)
-		assert.Len(t, psd.ParamOIDs, paramCount)
-		assert.Len(t, psd.Fields, 1)
+	require.True(t, mrr.NextResult())
+	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
+	assert.Equal(t, "msg", mrr.ResultReader().FieldDescriptions()[0].Name)
+	_, err = mrr.ResultReader().Close()
+	require.NoError(t, err)
 
-		result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
-		require.EqualError(t, result.Err, "extended protocol limited to 65535 parameters")
-	}
+	require.True(t, mrr.NextResult())
+	require.Len(t, mrr.ResultReader().FieldDescriptions(), 1)
+	assert.Equal(t, "num", mrr.ResultReader().FieldDescriptions()[0].Name)
+	_, err = mrr.ResultReader().Close()
+	require.NoError(t, err)
+
+	require.False(t, mrr.NextResult())
+
+	require.NoError(t, mrr.Close())
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedCanceled(t *testing.T) {
+func TestConnExecMultipleQueriesError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
-	require.NoError(t, err)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil)
-	rowCount := 0
-	for result.NextRow() {
-		rowCount += 1
+	results, err := pgConn.Exec(context.Background(), "select 1; select 1/0; select 1").ReadAll()
+	require.NotNil(t, err)
+	if pgErr, ok := err.(*pgconn.PgError); ok {
+		assert.Equal(t, "22012", pgErr.Code)
+	} else {
+		t.Errorf("unexpected error: %v", err)
 	}
-	assert.Equal(t, 0, rowCount)
-	commandTag, err := result.Close()
-	assert.Equal(t, pgconn.CommandTag{}, commandTag)
-	assert.True(t, pgconn.Timeout(err))
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		// CockroachDB starts the second query result set and then sends the divide by zero error.
+		require.Len(t, results, 2)
+		assert.Len(t, results[0].Rows, 1)
+		assert.Equal(t, "1", string(results[0].Rows[0][0]))
+		assert.Len(t, results[1].Rows, 0)
+	} else {
+		// PostgreSQL sends the divide by zero and never sends the second query result set.
+		require.Len(t, results, 1)
+		assert.Len(t, results[0].Rows, 1)
+		assert.Equal(t, "1", string(results[0].Rows[0][0]))
 	}
+
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedPrecanceled(t *testing.T) {
+func TestConnExecDeferredError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
-	require.NoError(t, err)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
-	require.Error(t, result.Err)
-	assert.True(t, errors.Is(result.Err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(result.Err))
+	setupSQL := `create temporary table t (
+		id text primary key,
+		n int not null,
+		unique (n) deferrable initially deferred
+	);
+
+	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
+
+	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
+	assert.NoError(t, err)
+
+	_, err = pgConn.Exec(context.Background(), `update t set n=n+1 where id='b' returning *`).ReadAll()
+	require.NotNil(t, err)
+
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr))
+	require.Equal(t, "23505", pgErr.Code)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecPreparedEmptySQL(t *testing.T) {
+func TestConnExecContextCanceled(t *testing.T) {
 	t.Parallel()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_DATABASE"))
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Prepare(ctx, "ps1", "", nil)
-	require.NoError(t, err)
-
-	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
-	assert.Equal(t, pgconn.CommandTag{}, result.CommandTag)
-	assert.Len(t, result.Rows, 0)
-	assert.NoError(t, result.Err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	multiResult := pgConn.Exec(ctx, "select 'Hello, world', pg_sleep(1)")
 
-	ensureConnValid(t, pgConn)
+	for multiResult.NextResult() {
+	}
+	err = multiResult.Close()
+	assert.True(t, pgconn.Timeout(err))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
 }
 
-func TestConnExecBatch(t *testing.T) {
+func TestConnExecContextPrecanceled(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
-	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pgConn.Exec(ctx, "select 'Hello, world'").ReadAll()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
 
-	batch := &pgconn.Batch{}
+	ensureConnValid(t, pgConn)
+}
 
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
-	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
-	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
+func TestConnExecParams(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	require.Len(t, results, 3)
+	defer closeConn(t, pgConn)
 
-	require.Len(t, results[0].Rows, 1)
-	require.Equal(t, "ExecParams 1", string(results[0].Rows[0][0]))
-	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
+	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
+	require.Len(t, result.FieldDescriptions(), 1)
+	assert.Equal(t, "msg", result.FieldDescriptions()[0].Name)
 
-	require.Len(t, results[1].Rows, 1)
-	require.Equal(t, "ExecPrepared 1", string(results[1].Rows[0][0]))
-	assert.Equal(t, "SELECT 1", results[1].CommandTag.String())
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+	}
+	assert.Equal(t, 1, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, "SELECT 1", commandTag.String())
+	assert.NoError(t, err)
 
-	require.Len(t, results[2].Rows, 1)
-	require.Equal(t, "ExecParams 2", string(results[2].Rows[0][0]))
-	assert.Equal(t, "SELECT 1", results[2].CommandTag.String())
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecBatchDeferredError(t *testing.T) {
+func TestConnExecParamsDeferredError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
@@ -1214,315 +1452,348 @@ func TestConnExecBatchDeferredError(t *testing.T) {
 	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
 
 	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
-	require.NoError(t, err)
-
-	batch := &pgconn.Batch{}
+	assert.NoError(t, err)
 
-	batch.ExecParams(`update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil)
-	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
-	require.NotNil(t, err)
+	result := pgConn.ExecParams(context.Background(), `update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil).Read()
+	require.NotNil(t, result.Err)
 	var pgErr *pgconn.PgError
-	require.True(t, errors.As(err, &pgErr))
+	require.True(t, errors.As(result.Err, &pgErr))
 	require.Equal(t, "23505", pgErr.Code)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecBatchPrecanceled(t *testing.T) {
+func TestConnExecParamsMaxNumberOfParams(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
-	require.NoError(t, err)
-
-	batch := &pgconn.Batch{}
-
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
-	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
-	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
+	paramCount := math.MaxUint16
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
+	}
+	sql := "values" + strings.Join(params, ", ")
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	_, err = pgConn.ExecBatch(ctx, batch).ReadAll()
-	require.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
+	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.Rows, paramCount)
 
 	ensureConnValid(t, pgConn)
 }
 
-// Without concurrent reading and writing large batches can deadlock.
-//
-// See https://github.com/jackc/pgx/issues/374.
-func TestConnExecBatchHuge(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping test in short mode.")
-	}
-
+func TestConnExecParamsTooManyParams(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	batch := &pgconn.Batch{}
-
-	queryCount := 100000
-	args := make([]string, queryCount)
-
-	for i := range args {
-		args[i] = strconv.Itoa(i)
-		batch.ExecParams("select $1::text", [][]byte{[]byte(args[i])}, nil, nil, nil)
+	paramCount := math.MaxUint16 + 1
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
 	}
+	sql := "values" + strings.Join(params, ", ")
 
-	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
-	require.NoError(t, err)
-	require.Len(t, results, queryCount)
+	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
+	require.Error(t, result.Err)
+	require.Equal(t, "extended protocol limited to 65535 parameters", result.Err.Error())
 
-	for i := range args {
-		require.Len(t, results[i].Rows, 1)
-		require.Equal(t, args[i], string(results[i].Rows[0][0]))
-		assert.Equal(t, "SELECT 1", results[i].CommandTag.String())
-	}
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnExecBatchImplicitTransaction(t *testing.T) {
+func TestConnExecParamsCanceled(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Skipping due to known server issue: (https://github.com/cockroachdb/cockroach/issues/44803)")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result := pgConn.ExecParams(ctx, "select current_database(), pg_sleep(1)", nil, nil, nil, nil)
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
 	}
+	assert.Equal(t, 0, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, pgconn.CommandTag{}, commandTag)
+	assert.True(t, pgconn.Timeout(err))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 
-	_, err = pgConn.Exec(context.Background(), "create temporary table t(id int)").ReadAll()
-	require.NoError(t, err)
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
+}
 
-	batch := &pgconn.Batch{}
+func TestConnExecParamsPrecanceled(t *testing.T) {
+	t.Parallel()
 
-	batch.ExecParams("insert into t(id) values(1)", nil, nil, nil, nil)
-	batch.ExecParams("insert into t(id) values(2)", nil, nil, nil, nil)
-	batch.ExecParams("insert into t(id) values(3)", nil, nil, nil, nil)
-	batch.ExecParams("select 1/0", nil, nil, nil, nil)
-	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
-	require.Error(t, err)
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(context.Background(), "select count(*) from t", nil, nil, nil, nil).Read()
-	require.Equal(t, "0", string(result.Rows[0][0]))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result := pgConn.ExecParams(ctx, "select $1::text", [][]byte{[]byte("Hello, world")}, nil, nil, nil).Read()
+	require.Error(t, result.Err)
+	assert.True(t, errors.Is(result.Err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(result.Err))
+
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnLocking(t *testing.T) {
+func TestConnExecParamsEmptySQL(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	mrr := pgConn.Exec(context.Background(), "select 'Hello, world'")
-	_, err = pgConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
-	assert.Error(t, err)
-	assert.Equal(t, "conn busy", err.Error())
-	assert.True(t, pgconn.SafeToRetry(err))
-
-	results, err := mrr.ReadAll()
-	assert.NoError(t, err)
-	assert.Len(t, results, 1)
-	assert.Nil(t, results[0].Err)
-	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
-	assert.Len(t, results[0].Rows, 1)
-	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+	result := pgConn.ExecParams(ctx, "", nil, nil, nil, nil).Read()
+	assert.Equal(t, pgconn.CommandTag{}, result.CommandTag)
+	assert.Len(t, result.Rows, 0)
+	assert.NoError(t, result.Err)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnOnNotice(t *testing.T) {
+// https://github.com/jackc/pgx/issues/859
+func TestResultReaderValuesHaveSameCapacityAsLength(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-
-	var msg string
-	config.OnNotice = func(c *pgconn.PgConn, notice *pgconn.Notice) {
-		msg = notice.Message
-	}
-	config.RuntimeParams["client_min_messages"] = "notice" // Ensure we only get the message we expect.
-
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support PL/PGSQL (https://github.com/cockroachdb/cockroach/issues/17511)")
-	}
+	result := pgConn.ExecParams(context.Background(), "select $1::text as msg", [][]byte{[]byte("Hello, world")}, nil, nil, nil)
+	require.Len(t, result.FieldDescriptions(), 1)
+	assert.Equal(t, "msg", result.FieldDescriptions()[0].Name)
 
-	multiResult := pgConn.Exec(context.Background(), `do $$
-begin
-  raise notice 'hello, world';
-end$$;`)
-	err = multiResult.Close()
-	require.NoError(t, err)
-	assert.Equal(t, "hello, world", msg)
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+		assert.Equal(t, "Hello, world", string(result.Values()[0]))
+		assert.Equal(t, len(result.Values()[0]), cap(result.Values()[0]))
+	}
+	assert.Equal(t, 1, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, "SELECT 1", commandTag.String())
+	assert.NoError(t, err)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnOnNotification(t *testing.T) {
+func TestConnExecPrepared(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	var msg string
-	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
-		msg = n.Payload
-	}
-
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	psd, err := pgConn.Prepare(context.Background(), "ps1", "select $1::text as msg", nil)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	require.NotNil(t, psd)
+	assert.Len(t, psd.ParamOIDs, 1)
+	assert.Len(t, psd.Fields, 1)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
+	result := pgConn.ExecPrepared(context.Background(), "ps1", [][]byte{[]byte("Hello, world")}, nil, nil)
+	require.Len(t, result.FieldDescriptions(), 1)
+	assert.Equal(t, "msg", result.FieldDescriptions()[0].Name)
+
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+		assert.Equal(t, "Hello, world", string(result.Values()[0]))
 	}
+	assert.Equal(t, 1, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, "SELECT 1", commandTag.String())
+	assert.NoError(t, err)
 
-	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
-	require.NoError(t, err)
+	ensureConnValid(t, pgConn)
+}
 
-	notifier, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-	defer closeConn(t, notifier)
-	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
+func TestConnExecPreparedMaxNumberOfParams(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	paramCount := math.MaxUint16
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
+	}
+	sql := "values" + strings.Join(params, ", ")
+
+	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
 	require.NoError(t, err)
+	require.NotNil(t, psd)
+	assert.Len(t, psd.ParamOIDs, paramCount)
+	assert.Len(t, psd.Fields, 1)
 
-	assert.Equal(t, "bar", msg)
+	result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.Rows, paramCount)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnWaitForNotification(t *testing.T) {
+func TestConnExecPreparedTooManyParams(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	var msg string
-	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
-		msg = n.Payload
+	paramCount := math.MaxUint16 + 1
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
 	}
+	sql := "values" + strings.Join(params, ", ")
 
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
+	psd, err := pgConn.Prepare(context.Background(), "ps1", sql, nil)
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
+		// CockroachDB rejects preparing a statement with more than 65535 parameters.
+		require.EqualError(t, err, "ERROR: more than 65535 arguments to prepared statement: 65536 (SQLSTATE 08P01)")
+	} else {
+		// PostgreSQL accepts preparing a statement with more than 65535 parameters and only fails when executing it through the extended protocol.
+		require.NoError(t, err)
+		require.NotNil(t, psd)
+		assert.Len(t, psd.ParamOIDs, paramCount)
+		assert.Len(t, psd.Fields, 1)
+
+		result := pgConn.ExecPrepared(context.Background(), "ps1", args, nil, nil).Read()
+		require.EqualError(t, result.Err, "extended protocol limited to 65535 parameters")
 	}
 
-	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
-	require.NoError(t, err)
+	ensureConnValid(t, pgConn)
+}
 
-	notifier, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-	defer closeConn(t, notifier)
-	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
-	require.NoError(t, err)
+func TestConnExecPreparedCanceled(t *testing.T) {
+	t.Parallel()
 
-	err = pgConn.WaitForNotification(context.Background())
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	assert.Equal(t, "bar", msg)
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
+	require.NoError(t, err)
 
-	ensureConnValid(t, pgConn)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil)
+	rowCount := 0
+	for result.NextRow() {
+		rowCount += 1
+	}
+	assert.Equal(t, 0, rowCount)
+	commandTag, err := result.Close()
+	assert.Equal(t, pgconn.CommandTag{}, commandTag)
+	assert.True(t, pgconn.Timeout(err))
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
 }
 
-func TestConnWaitForNotificationPrecanceled(t *testing.T) {
+func TestConnExecPreparedPrecanceled(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select current_database(), pg_sleep(1)", nil)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
-	err = pgConn.WaitForNotification(ctx)
-	require.ErrorIs(t, err, context.Canceled)
+	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
+	require.Error(t, result.Err)
+	assert.True(t, errors.Is(result.Err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(result.Err))
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnWaitForNotificationTimeout(t *testing.T) {
+func TestConnExecPreparedEmptySQL(t *testing.T) {
 	t.Parallel()
 
-	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
 
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
-	err = pgConn.WaitForNotification(ctx)
-	cancel()
-	assert.True(t, pgconn.Timeout(err))
-	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = pgConn.Prepare(ctx, "ps1", "", nil)
+	require.NoError(t, err)
+
+	result := pgConn.ExecPrepared(ctx, "ps1", nil, nil, nil).Read()
+	assert.Equal(t, pgconn.CommandTag{}, result.CommandTag)
+	assert.Len(t, result.Rows, 0)
+	assert.NoError(t, result.Err)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyToSmall(t *testing.T) {
+func TestConnExecBatch(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does support COPY TO")
-	}
-
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int2,
-		b int4,
-		c int8,
-		d varchar,
-		e text,
-		f date,
-		g json
-	)`).ReadAll()
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
 	require.NoError(t, err)
 
-	_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}')`).ReadAll()
-	require.NoError(t, err)
+	batch := &pgconn.Batch{}
 
-	_, err = pgConn.Exec(context.Background(), `insert into foo values (null, null, null, null, null, null, null)`).ReadAll()
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
+	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
+	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
 	require.NoError(t, err)
+	require.Len(t, results, 3)
 
-	inputBytes := []byte("0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\n" +
-		"\\N\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N\n")
+	require.Len(t, results[0].Rows, 1)
+	require.Equal(t, "ExecParams 1", string(results[0].Rows[0][0]))
+	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
 
-	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
+	require.Len(t, results[1].Rows, 1)
+	require.Equal(t, "ExecPrepared 1", string(results[1].Rows[0][0]))
+	assert.Equal(t, "SELECT 1", results[1].CommandTag.String())
 
-	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
-	require.NoError(t, err)
+	require.Len(t, results[2].Rows, 1)
+	require.Equal(t, "ExecParams 2", string(results[2].Rows[0][0]))
+	assert.Equal(t, "SELECT 1", results[2].CommandTag.String())
+}
 
-	assert.Equal(t, int64(2), res.RowsAffected())
-	assert.Equal(t, inputBytes, outputWriter.Bytes())
-
-	ensureConnValid(t, pgConn)
-}
-
-func TestConnCopyToLarge(t *testing.T) {
+func TestConnExecBatchDeferredError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
@@ -1530,279 +1801,285 @@ func TestConnCopyToLarge(t *testing.T) {
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does support COPY TO")
+		t.Skip("Server does not support deferred constraint (https://github.com/cockroachdb/cockroach/issues/31632)")
 	}
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int2,
-		b int4,
-		c int8,
-		d varchar,
-		e text,
-		f date,
-		g json,
-		h bytea
-	)`).ReadAll()
-	require.NoError(t, err)
-
-	inputBytes := make([]byte, 0)
-
-	for i := 0; i < 1000; i++ {
-		_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}', 'oooo')`).ReadAll()
-		require.NoError(t, err)
-		inputBytes = append(inputBytes, "0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\t\\\\x6f6f6f6f\n"...)
-	}
+	setupSQL := `create temporary table t (
+		id text primary key,
+		n int not null,
+		unique (n) deferrable initially deferred
+	);
 
-	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
+	insert into t (id, n) values ('a', 1), ('b', 2), ('c', 3);`
 
-	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
+	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
 	require.NoError(t, err)
 
-	assert.Equal(t, int64(1000), res.RowsAffected())
-	assert.Equal(t, inputBytes, outputWriter.Bytes())
+	batch := &pgconn.Batch{}
+
+	batch.ExecParams(`update t set n=n+1 where id='b' returning *`, nil, nil, nil, nil)
+	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
+	require.NotNil(t, err)
+	var pgErr *pgconn.PgError
+	require.True(t, errors.As(err, &pgErr))
+	require.Equal(t, "23505", pgErr.Code)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyToQueryError(t *testing.T) {
+func TestConnExecBatchPrecanceled(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	outputWriter := bytes.NewBuffer(make([]byte, 0))
+	_, err = pgConn.Prepare(context.Background(), "ps1", "select $1::text", nil)
+	require.NoError(t, err)
 
-	res, err := pgConn.CopyTo(context.Background(), outputWriter, "cropy foo to stdout")
+	batch := &pgconn.Batch{}
+
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 1")}, nil, nil, nil)
+	batch.ExecPrepared("ps1", [][]byte{[]byte("ExecPrepared 1")}, nil, nil)
+	batch.ExecParams("select $1::text", [][]byte{[]byte("ExecParams 2")}, nil, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pgConn.ExecBatch(ctx, batch).ReadAll()
 	require.Error(t, err)
-	assert.IsType(t, &pgconn.PgError{}, err)
-	assert.Equal(t, int64(0), res.RowsAffected())
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyToCanceled(t *testing.T) {
+// Without concurrent reading and writing large batches can deadlock.
+//
+// See https://github.com/jackc/pgx/issues/374.
+func TestConnExecBatchHuge(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
-	}
+	batch := &pgconn.Batch{}
 
-	outputWriter := &bytes.Buffer{}
+	queryCount := 100000
+	args := make([]string, queryCount)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select *, pg_sleep(0.01) from generate_series(1,1000)) to stdout")
-	assert.Error(t, err)
-	assert.Equal(t, pgconn.CommandTag{}, res)
+	for i := range args {
+		args[i] = strconv.Itoa(i)
+		batch.ExecParams("select $1::text", [][]byte{[]byte(args[i])}, nil, nil, nil)
+	}
 
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+	results, err := pgConn.ExecBatch(context.Background(), batch).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, queryCount)
+
+	for i := range args {
+		require.Len(t, results[i].Rows, 1)
+		require.Equal(t, args[i], string(results[i].Rows[0][0]))
+		assert.Equal(t, "SELECT 1", results[i].CommandTag.String())
 	}
 }
 
-func TestConnCopyToPrecanceled(t *testing.T) {
+func TestConnExecBatchImplicitTransaction(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	outputWriter := &bytes.Buffer{}
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Skipping due to known server issue: (https://github.com/cockroachdb/cockroach/issues/44803)")
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	cancel()
-	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select * from generate_series(1,1000)) to stdout")
+	_, err = pgConn.Exec(context.Background(), "create temporary table t(id int)").ReadAll()
+	require.NoError(t, err)
+
+	batch := &pgconn.Batch{}
+
+	batch.ExecParams("insert into t(id) values(1)", nil, nil, nil, nil)
+	batch.ExecParams("insert into t(id) values(2)", nil, nil, nil, nil)
+	batch.ExecParams("insert into t(id) values(3)", nil, nil, nil, nil)
+	batch.ExecParams("select 1/0", nil, nil, nil, nil)
+	_, err = pgConn.ExecBatch(context.Background(), batch).ReadAll()
 	require.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
-	assert.Equal(t, pgconn.CommandTag{}, res)
 
-	ensureConnValid(t, pgConn)
+	result := pgConn.ExecParams(context.Background(), "select count(*) from t", nil, nil, nil, nil).Read()
+	require.Equal(t, "0", string(result.Rows[0][0]))
 }
 
-func TestConnCopyFrom(t *testing.T) {
+func TestConnLocking(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
-	require.NoError(t, err)
+	mrr := pgConn.Exec(context.Background(), "select 'Hello, world'")
+	_, err = pgConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
+	assert.Error(t, err)
+	assert.Equal(t, "conn busy", err.Error())
+	assert.True(t, pgconn.SafeToRetry(err))
 
-	srcBuf := &bytes.Buffer{}
+	results, err := mrr.ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
+	assert.Len(t, results[0].Rows, 1)
+	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
 
-	inputRows := [][][]byte{}
-	for i := 0; i < 1000; i++ {
-		a := strconv.Itoa(i)
-		b := "foo " + a + " bar"
-		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
-		_, err = srcBuf.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-		require.NoError(t, err)
-	}
+	ensureConnValid(t, pgConn)
+}
 
-	copySql := "COPY foo FROM STDIN WITH (FORMAT csv)"
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		copySql = "COPY foo FROM STDIN WITH CSV"
+func TestConnOnNotice(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+
+	var msg string
+	config.OnNotice = func(c *pgconn.PgConn, notice *pgconn.Notice) {
+		msg = notice.Message
 	}
-	ct, err := pgConn.CopyFrom(context.Background(), srcBuf, copySql)
+	config.RuntimeParams["client_min_messages"] = "notice" // Ensure we only get the message we expect.
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
 	require.NoError(t, err)
-	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
+	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support PL/PGSQL (https://github.com/cockroachdb/cockroach/issues/17511)")
+	}
 
-	assert.Equal(t, inputRows, result.Rows)
+	multiResult := pgConn.Exec(context.Background(), `do $$
+begin
+  raise notice 'hello, world';
+end$$;`)
+	err = multiResult.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", msg)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyFromBinary(t *testing.T) {
+func TestConnOnNotification(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
-
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 
-	buf := []byte{}
-	buf = append(buf, "PGCOPY\n\377\r\n\000"...)
-	buf = pgio.AppendInt32(buf, 0)
-	buf = pgio.AppendInt32(buf, 0)
-
-	inputRows := [][][]byte{}
-	for i := 0; i < 1000; i++ {
-		// Number of elements in the tuple
-		buf = pgio.AppendInt16(buf, int16(2))
-		a := i
-
-		// Length of element for column `a int4`
-		buf = pgio.AppendInt32(buf, 4)
-		buf, err = pgtype.NewMap().Encode(pgtype.Int4OID, pgx.BinaryFormatCode, a, buf)
-		require.NoError(t, err)
+	var msg string
+	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
+		msg = n.Payload
+	}
 
-		b := "foo " + strconv.Itoa(a) + " bar"
-		lenB := int32(len([]byte(b)))
-		// Length of element for column `b varchar`
-		buf = pgio.AppendInt32(buf, lenB)
-		buf, err = pgtype.NewMap().Encode(pgtype.VarcharOID, pgx.BinaryFormatCode, b, buf)
-		require.NoError(t, err)
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-		inputRows = append(inputRows, [][]byte{[]byte(strconv.Itoa(a)), []byte(b)})
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
 	}
 
-	srcBuf := &bytes.Buffer{}
-	srcBuf.Write(buf)
-	ct, err := pgConn.CopyFrom(context.Background(), srcBuf, "COPY foo (a, b) FROM STDIN BINARY;")
+	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
 	require.NoError(t, err)
-	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
 
-	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
+	notifier, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, notifier)
+	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
+	require.NoError(t, err)
 
-	assert.Equal(t, inputRows, result.Rows)
+	_, err = pgConn.Exec(context.Background(), "select 1").ReadAll()
+	require.NoError(t, err)
+
+	assert.Equal(t, "bar", msg)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyFromCanceled(t *testing.T) {
+func TestConnWaitForNotification(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
-	require.NoError(t, err)
+	var msg string
+	config.OnNotification = func(c *pgconn.PgConn, n *pgconn.Notification) {
+		msg = n.Payload
+	}
 
-	r, w := io.Pipe()
-	go func() {
-		for i := 0; i < 1000000; i++ {
-			a := strconv.Itoa(i)
-			b := "foo " + a + " bar"
-			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-			if err != nil {
-				return
-			}
-			time.Sleep(time.Microsecond)
-		}
-	}()
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	copySql := "COPY foo FROM STDIN WITH (FORMAT csv)"
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		copySql = "COPY foo FROM STDIN WITH CSV"
+		t.Skip("Server does not support LISTEN / NOTIFY (https://github.com/cockroachdb/cockroach/issues/41522)")
 	}
-	ct, err := pgConn.CopyFrom(ctx, r, copySql)
-	cancel()
-	assert.Equal(t, int64(0), ct.RowsAffected())
-	assert.Error(t, err)
 
-	assert.True(t, pgConn.IsClosed())
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
-	}
+	_, err = pgConn.Exec(context.Background(), "listen foo").ReadAll()
+	require.NoError(t, err)
+
+	notifier, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, notifier)
+	_, err = notifier.Exec(context.Background(), "notify foo, 'bar'").ReadAll()
+	require.NoError(t, err)
+
+	err = pgConn.WaitForNotification(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "bar", msg)
+
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyFromPrecanceled(t *testing.T) {
+func TestConnWaitForNotificationPrecanceled(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
-	)`).ReadAll()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pgConn.WaitForNotification(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnWaitForNotificationTimeout(t *testing.T) {
+	t.Parallel()
+
+	config, err := pgconn.ParseConfig(os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 
-	r, w := io.Pipe()
-	go func() {
-		for i := 0; i < 1000000; i++ {
-			a := strconv.Itoa(i)
-			b := "foo " + a + " bar"
-			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-			if err != nil {
-				return
-			}
-			time.Sleep(time.Microsecond)
-		}
-	}()
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	err = pgConn.WaitForNotification(ctx)
 	cancel()
-	ct, err := pgConn.CopyFrom(ctx, r, "COPY foo FROM STDIN WITH (FORMAT csv)")
-	require.Error(t, err)
-	assert.True(t, errors.Is(err, context.Canceled))
-	assert.True(t, pgconn.SafeToRetry(err))
-	assert.Equal(t, pgconn.CommandTag{}, ct)
+	assert.True(t, pgconn.Timeout(err))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyFromGzipReader(t *testing.T) {
+func TestConnCopyToSmall(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
@@ -1810,107 +2087,92 @@ func TestConnCopyFromGzipReader(t *testing.T) {
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not fully support COPY FROM (https://www.cockroachlabs.com/docs/v20.2/copy-from.html)")
+		t.Skip("Server does support COPY TO")
 	}
 
 	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
+		a int2,
+		b int4,
+		c int8,
+		d varchar,
+		e text,
+		f date,
+		g json
 	)`).ReadAll()
 	require.NoError(t, err)
 
-	f, err := ioutil.TempFile("", "*")
-	require.NoError(t, err)
-
-	gw := gzip.NewWriter(f)
-
-	inputRows := [][][]byte{}
-	for i := 0; i < 1000; i++ {
-		a := strconv.Itoa(i)
-		b := "foo " + a + " bar"
-		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
-		_, err = gw.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
-		require.NoError(t, err)
-	}
-
-	err = gw.Close()
-	require.NoError(t, err)
-
-	_, err = f.Seek(0, 0)
-	require.NoError(t, err)
-
-	gr, err := gzip.NewReader(f)
+	_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}')`).ReadAll()
 	require.NoError(t, err)
 
-	copySql := "COPY foo FROM STDIN WITH (FORMAT csv)"
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		copySql = "COPY foo FROM STDIN WITH CSV"
-	}
-	ct, err := pgConn.CopyFrom(context.Background(), gr, copySql)
+	_, err = pgConn.Exec(context.Background(), `insert into foo values (null, null, null, null, null, null, null)`).ReadAll()
 	require.NoError(t, err)
-	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
 
-	err = gr.Close()
-	require.NoError(t, err)
+	inputBytes := []byte("0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\n" +
+		"\\N\t\\N\t\\N\t\\N\t\\N\t\\N\t\\N\n")
 
-	err = f.Close()
-	require.NoError(t, err)
+	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
 
-	err = os.Remove(f.Name())
+	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
 	require.NoError(t, err)
 
-	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
-
-	assert.Equal(t, inputRows, result.Rows)
+	assert.Equal(t, int64(2), res.RowsAffected())
+	assert.Equal(t, inputBytes, outputWriter.Bytes())
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyFromQuerySyntaxError(t *testing.T) {
+func TestConnCopyToLarge(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does support COPY TO")
+	}
+
 	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
-		a int4,
-		b varchar
+		a int2,
+		b int4,
+		c int8,
+		d varchar,
+		e text,
+		f date,
+		g json,
+		h bytea
 	)`).ReadAll()
 	require.NoError(t, err)
 
-	srcBuf := &bytes.Buffer{}
+	inputBytes := make([]byte, 0)
 
-	// Send data even though the COPY FROM command will be rejected with a syntax error. This ensures that this does not
-	// break the connection. See https://github.com/jackc/pgconn/pull/127 for context.
-	inputRows := [][][]byte{}
 	for i := 0; i < 1000; i++ {
-		a := strconv.Itoa(i)
-		b := "foo " + a + " bar"
-		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
-		_, err = srcBuf.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+		_, err = pgConn.Exec(context.Background(), `insert into foo values (0, 1, 2, 'abc', 'efg', '2000-01-01', '{"abc":"def","foo":"bar"}', 'oooo')`).ReadAll()
 		require.NoError(t, err)
+		inputBytes = append(inputBytes, "0\t1\t2\tabc\tefg\t2000-01-01\t{\"abc\":\"def\",\"foo\":\"bar\"}\t\\\\x6f6f6f6f\n"...)
 	}
 
-	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "cropy foo FROM STDIN WITH (FORMAT csv)")
-	require.Error(t, err)
-	assert.IsType(t, &pgconn.PgError{}, err)
-	assert.Equal(t, int64(0), res.RowsAffected())
+	outputWriter := bytes.NewBuffer(make([]byte, 0, len(inputBytes)))
+
+	res, err := pgConn.CopyTo(context.Background(), outputWriter, "copy foo to stdout")
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1000), res.RowsAffected())
+	assert.Equal(t, inputBytes, outputWriter.Bytes())
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCopyFromQueryNoTableError(t *testing.T) {
+func TestConnCopyToQueryError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	srcBuf := &bytes.Buffer{}
+	outputWriter := bytes.NewBuffer(make([]byte, 0))
 
-	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "copy foo to stdout")
+	res, err := pgConn.CopyTo(context.Background(), outputWriter, "cropy foo to stdout")
 	require.Error(t, err)
 	assert.IsType(t, &pgconn.PgError{}, err)
 	assert.Equal(t, int64(0), res.RowsAffected())
@@ -1918,956 +2180,1970 @@ func TestConnCopyFromQueryNoTableError(t *testing.T) {
 	ensureConnValid(t, pgConn)
 }
 
-// https://github.com/jackc/pgconn/issues/21
-func TestConnCopyFromNoticeResponseReceivedMidStream(t *testing.T) {
+func TestConnCopyToCanceled(t *testing.T) {
 	t.Parallel()
 
-	ctx := context.Background()
-	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_DATABASE"))
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
 	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support triggers (https://github.com/cockroachdb/cockroach/issues/28296)")
+		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
 	}
 
-	_, err = pgConn.Exec(ctx, `create temporary table sentences(
-		t text,
-		ts tsvector
-	)`).ReadAll()
-	require.NoError(t, err)
-
-	_, err = pgConn.Exec(ctx, `create function pg_temp.sentences_trigger() returns trigger as $$
-	begin
-	  new.ts := to_tsvector(new.t);
-		return new;
-	end
-	$$ language plpgsql;`).ReadAll()
-	require.NoError(t, err)
+	outputWriter := &bytes.Buffer{}
 
-	_, err = pgConn.Exec(ctx, `create trigger sentences_update before insert on sentences for each row execute procedure pg_temp.sentences_trigger();`).ReadAll()
-	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select *, pg_sleep(0.01) from generate_series(1,1000)) to stdout")
+	assert.Error(t, err)
+	assert.Equal(t, pgconn.CommandTag{}, res)
 
-	longString := make([]byte, 10001)
-	for i := range longString {
-		longString[i] = 'x'
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
 	}
+}
 
-	buf := &bytes.Buffer{}
-	for i := 0; i < 1000; i++ {
-		buf.Write([]byte(fmt.Sprintf("%s\n", string(longString))))
-	}
+func TestConnCopyToPrecanceled(t *testing.T) {
+	t.Parallel()
 
-	_, err = pgConn.CopyFrom(ctx, buf, "COPY sentences(t) FROM STDIN WITH (FORMAT csv)")
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-}
+	defer closeConn(t, pgConn)
 
-type delayedReader struct {
-	r io.Reader
-}
+	outputWriter := &bytes.Buffer{}
 
-func (d delayedReader) Read(p []byte) (int, error) {
-	// W/o sleep test passes, with sleep it fails.
-	time.Sleep(time.Millisecond)
-	return d.r.Read(p)
-}
-
-// https://github.com/jackc/pgconn/issues/128
-func TestConnCopyFromDataWriteAfterErrorAndReturn(t *testing.T) {
-	connString := os.Getenv("PGX_TEST_DATABASE")
-	if connString == "" {
-		t.Skipf("Skipping due to missing environment variable %v", "PGX_TEST_DATABASE")
-	}
-
-	config, err := pgconn.ParseConfig(connString)
-	require.NoError(t, err)
-
-	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
-	require.NoError(t, err)
-
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not fully support COPY FROM")
-	}
-
-	setupSQL := `create temporary table t (
-		id text primary key,
-		n int not null
-	);`
-
-	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
-	assert.NoError(t, err)
-
-	r1 := delayedReader{r: strings.NewReader(`id	0\n`)}
-	// Generate an error with a bogus COPY command
-	_, err = pgConn.CopyFrom(context.Background(), r1, "COPY nosuchtable FROM STDIN ")
-	assert.Error(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := pgConn.CopyTo(ctx, outputWriter, "copy (select * from generate_series(1,1000)) to stdout")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
+	assert.Equal(t, pgconn.CommandTag{}, res)
 
-	r2 := delayedReader{r: strings.NewReader(`id	0\n`)}
-	_, err = pgConn.CopyFrom(context.Background(), r2, "COPY t FROM STDIN")
-	assert.NoError(t, err)
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnEscapeString(t *testing.T) {
+func TestConnCopyFrom(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	tests := []struct {
-		in  string
-		out string
-	}{
-		{in: "", out: ""},
-		{in: "42", out: "42"},
-		{in: "'", out: "''"},
-		{in: "hi'there", out: "hi''there"},
-		{in: "'hi there'", out: "''hi there''"},
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
+	require.NoError(t, err)
+
+	srcBuf := &bytes.Buffer{}
+
+	inputRows := [][][]byte{}
+	for i := 0; i < 1000; i++ {
+		a := strconv.Itoa(i)
+		b := "foo " + a + " bar"
+		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
+		_, err = srcBuf.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+		require.NoError(t, err)
 	}
 
-	for i, tt := range tests {
-		value, err := pgConn.EscapeString(tt.in)
-		if assert.NoErrorf(t, err, "%d.", i) {
-			assert.Equalf(t, tt.out, value, "%d.", i)
-		}
+	copySql := "COPY foo FROM STDIN WITH (FORMAT csv)"
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		copySql = "COPY foo FROM STDIN WITH CSV"
 	}
+	ct, err := pgConn.CopyFrom(context.Background(), srcBuf, copySql)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
+
+	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+
+	assert.Equal(t, inputRows, result.Rows)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCancelRequest(t *testing.T) {
+func TestConnCopyFromBinary(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	if pgConn.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
-	}
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
+	require.NoError(t, err)
 
-	multiResult := pgConn.Exec(context.Background(), "select 'Hello, world', pg_sleep(2)")
+	buf := []byte{}
+	buf = append(buf, "PGCOPY\n\377\r\n\000"...)
+	buf = pgio.AppendInt32(buf, 0)
+	buf = pgio.AppendInt32(buf, 0)
 
-	go func() {
-		// The query is actually sent when multiResult.NextResult() is called. So wait to ensure it is sent.
-		// Once Flush is available this could use that instead.
-		time.Sleep(500 * time.Millisecond)
+	inputRows := [][][]byte{}
+	for i := 0; i < 1000; i++ {
+		// Number of elements in the tuple
+		buf = pgio.AppendInt16(buf, int16(2))
+		a := i
 
-		err := pgConn.CancelRequest(context.Background())
+		// Length of element for column `a int4`
+		buf = pgio.AppendInt32(buf, 4)
+		buf, err = pgtype.NewMap().Encode(pgtype.Int4OID, pgx.BinaryFormatCode, a, buf)
 		require.NoError(t, err)
-	}()
 
-	for multiResult.NextResult() {
+		b := "foo " + strconv.Itoa(a) + " bar"
+		lenB := int32(len([]byte(b)))
+		// Length of element for column `b varchar`
+		buf = pgio.AppendInt32(buf, lenB)
+		buf, err = pgtype.NewMap().Encode(pgtype.VarcharOID, pgx.BinaryFormatCode, b, buf)
+		require.NoError(t, err)
+
+		inputRows = append(inputRows, [][]byte{[]byte(strconv.Itoa(a)), []byte(b)})
 	}
-	err = multiResult.Close()
 
-	require.IsType(t, &pgconn.PgError{}, err)
-	require.Equal(t, "57014", err.(*pgconn.PgError).Code)
+	srcBuf := &bytes.Buffer{}
+	srcBuf.Write(buf)
+	ct, err := pgConn.CopyFrom(context.Background(), srcBuf, "COPY foo (a, b) FROM STDIN BINARY;")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
+
+	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+
+	assert.Equal(t, inputRows, result.Rows)
 
 	ensureConnValid(t, pgConn)
 }
 
-// https://github.com/jackc/pgx/issues/659
-func TestConnContextCanceledCancelsRunningQueryOnServer(t *testing.T) {
+func TestConnCopyFromCanceled(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	pid := pgConn.PID()
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
+	require.NoError(t, err)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-	multiResult := pgConn.Exec(ctx, "select 'Hello, world', pg_sleep(30)")
+	r, w := io.Pipe()
+	go func() {
+		for i := 0; i < 1000000; i++ {
+			a := strconv.Itoa(i)
+			b := "foo " + a + " bar"
+			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Microsecond)
+		}
+	}()
 
-	for multiResult.NextResult() {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	copySql := "COPY foo FROM STDIN WITH (FORMAT csv)"
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		copySql = "COPY foo FROM STDIN WITH CSV"
 	}
-	err = multiResult.Close()
-	assert.True(t, pgconn.Timeout(err))
+	ct, err := pgConn.CopyFrom(ctx, r, copySql)
+	cancel()
+	assert.Equal(t, int64(0), ct.RowsAffected())
+	assert.Error(t, err)
+
 	assert.True(t, pgConn.IsClosed())
 	select {
 	case <-pgConn.CleanupDone():
 	case <-time.After(5 * time.Second):
 		t.Fatal("Connection cleanup exceeded maximum time")
 	}
-
-	otherConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-	defer closeConn(t, otherConn)
-
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
-
-	for {
-		result := otherConn.ExecParams(ctx,
-			`select 1 from pg_stat_activity where pid=$1`,
-			[][]byte{[]byte(strconv.FormatInt(int64(pid), 10))},
-			nil,
-			nil,
-			nil,
-		).Read()
-		require.NoError(t, result.Err)
-
-		if len(result.Rows) == 0 {
-			break
-		}
-	}
 }
 
-func TestHijackAndConstruct(t *testing.T) {
+func TestConnCopyFromPrecanceled(t *testing.T) {
 	t.Parallel()
 
-	origConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-
-	hc, err := origConn.Hijack()
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	_, err = origConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
-	require.Error(t, err)
-
-	newConn, err := pgconn.Construct(hc)
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
 	require.NoError(t, err)
 
-	defer closeConn(t, newConn)
-
-	results, err := newConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
-	assert.NoError(t, err)
+	r, w := io.Pipe()
+	go func() {
+		for i := 0; i < 1000000; i++ {
+			a := strconv.Itoa(i)
+			b := "foo " + a + " bar"
+			_, err := w.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Microsecond)
+		}
+	}()
 
-	assert.Len(t, results, 1)
-	assert.Nil(t, results[0].Err)
-	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
-	assert.Len(t, results[0].Rows, 1)
-	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ct, err := pgConn.CopyFrom(ctx, r, "COPY foo FROM STDIN WITH (FORMAT csv)")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.True(t, pgconn.SafeToRetry(err))
+	assert.Equal(t, pgconn.CommandTag{}, ct)
 
-	ensureConnValid(t, newConn)
+	ensureConnValid(t, pgConn)
 }
 
-func TestConnCloseWhileCancellableQueryInProgress(t *testing.T) {
+func TestConnCopyFromGzipReader(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	ctx, _ := context.WithCancel(context.Background())
-	pgConn.Exec(ctx, "select n from generate_series(1,10) n")
-
-	closeCtx, _ := context.WithCancel(context.Background())
-	pgConn.Close(closeCtx)
-	select {
-	case <-pgConn.CleanupDone():
-	case <-time.After(5 * time.Second):
-		t.Fatal("Connection cleanup exceeded maximum time")
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not fully support COPY FROM (https://www.cockroachlabs.com/docs/v20.2/copy-from.html)")
 	}
-}
 
-// https://github.com/jackc/pgx/issues/800
-func TestFatalErrorReceivedAfterCommandComplete(t *testing.T) {
-	t.Parallel()
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
+	require.NoError(t, err)
 
-	steps := pgmock.AcceptUnauthenticatedConnRequestSteps()
-	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Parse{}))
-	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Bind{}))
-	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Describe{}))
-	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Execute{}))
-	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Sync{}))
-	steps = append(steps, pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
-		{Name: []byte("mock")},
-	}}))
-	steps = append(steps, pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")}))
-	steps = append(steps, pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01"}))
+	f, err := ioutil.TempFile("", "*")
+	require.NoError(t, err)
 
-	script := &pgmock.Script{Steps: steps}
+	gw := gzip.NewWriter(f)
 
-	ln, err := net.Listen("tcp", "127.0.0.1:")
+	inputRows := [][][]byte{}
+	for i := 0; i < 1000; i++ {
+		a := strconv.Itoa(i)
+		b := "foo " + a + " bar"
+		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
+		_, err = gw.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+		require.NoError(t, err)
+	}
+
+	err = gw.Close()
 	require.NoError(t, err)
-	defer ln.Close()
 
-	serverErrChan := make(chan error, 1)
-	go func() {
-		defer close(serverErrChan)
+	_, err = f.Seek(0, 0)
+	require.NoError(t, err)
 
-		conn, err := ln.Accept()
-		if err != nil {
-			serverErrChan <- err
-			return
-		}
-		defer conn.Close()
+	gr, err := gzip.NewReader(f)
+	require.NoError(t, err)
 
-		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
-		if err != nil {
-			serverErrChan <- err
-			return
-		}
+	copySql := "COPY foo FROM STDIN WITH (FORMAT csv)"
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		copySql = "COPY foo FROM STDIN WITH CSV"
+	}
+	ct, err := pgConn.CopyFrom(context.Background(), gr, copySql)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(inputRows)), ct.RowsAffected())
 
-		err = script.Run(pgproto3.NewBackend(conn, conn))
-		if err != nil {
-			serverErrChan <- err
-			return
-		}
-	}()
+	err = gr.Close()
+	require.NoError(t, err)
 
-	parts := strings.Split(ln.Addr().String(), ":")
-	host := parts[0]
-	port := parts[1]
-	connStr := fmt.Sprintf("sslmode=disable host=%s port=%s", host, port)
+	err = f.Close()
+	require.NoError(t, err)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	conn, err := pgconn.Connect(ctx, connStr)
+	err = os.Remove(f.Name())
 	require.NoError(t, err)
 
-	rr := conn.ExecParams(ctx, "mocked...", nil, nil, nil, nil)
+	result := pgConn.ExecParams(context.Background(), "select * from foo", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
 
-	for rr.NextRow() {
-	}
+	assert.Equal(t, inputRows, result.Rows)
 
-	_, err = rr.Close()
-	require.Error(t, err)
+	ensureConnValid(t, pgConn)
 }
 
-// https://github.com/jackc/pgconn/issues/27
-func TestConnLargeResponseWhileWritingDoesNotDeadlock(t *testing.T) {
+func TestConnCopyFromQuerySyntaxError(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	_, err = pgConn.Exec(context.Background(), "set client_min_messages = debug5").ReadAll()
+	_, err = pgConn.Exec(context.Background(), `create temporary table foo(
+		a int4,
+		b varchar
+	)`).ReadAll()
 	require.NoError(t, err)
 
-	// The actual contents of this test aren't important. What's important is a large amount of data to be written and
-	// because of client_min_messages = debug5 the server will return a large amount of data.
+	srcBuf := &bytes.Buffer{}
 
-	paramCount := math.MaxUint16
-	params := make([]string, 0, paramCount)
-	args := make([][]byte, 0, paramCount)
-	for i := 0; i < paramCount; i++ {
-		params = append(params, fmt.Sprintf("($%d::text)", i+1))
-		args = append(args, []byte(strconv.Itoa(i)))
+	// Send data even though the COPY FROM command will be rejected with a syntax error. This ensures that this does not
+	// break the connection. See https://github.com/jackc/pgconn/pull/127 for context.
+	inputRows := [][][]byte{}
+	for i := 0; i < 1000; i++ {
+		a := strconv.Itoa(i)
+		b := "foo " + a + " bar"
+		inputRows = append(inputRows, [][]byte{[]byte(a), []byte(b)})
+		_, err = srcBuf.Write([]byte(fmt.Sprintf("%s,\"%s\"\n", a, b)))
+		require.NoError(t, err)
 	}
-	sql := "values" + strings.Join(params, ", ")
 
-	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
-	require.Len(t, result.Rows, paramCount)
+	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "cropy foo FROM STDIN WITH (FORMAT csv)")
+	require.Error(t, err)
+	assert.IsType(t, &pgconn.PgError{}, err)
+	assert.Equal(t, int64(0), res.RowsAffected())
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestConnCheckConn(t *testing.T) {
+func TestConnCopyFromQueryNoTableError(t *testing.T) {
 	t.Parallel()
 
-	// Intentionally using TCP connection for more predictable close behavior. (Not sure if Unix domain sockets would behave subtlely different.)
-
-	connString := os.Getenv("PGX_TEST_TCP_CONN_STRING")
-	if connString == "" {
-		t.Skipf("Skipping due to missing environment variable %v", "PGX_TEST_TCP_CONN_STRING")
-	}
-
-	c1, err := pgconn.Connect(context.Background(), connString)
-	require.NoError(t, err)
-	defer c1.Close(context.Background())
-
-	if c1.ParameterStatus("crdb_version") != "" {
-		t.Skip("Server does not support pg_terminate_backend() (https://github.com/cockroachdb/cockroach/issues/35897)")
-	}
-
-	err = c1.CheckConn()
-	require.NoError(t, err)
-
-	c2, err := pgconn.Connect(context.Background(), connString)
-	require.NoError(t, err)
-	defer c2.Close(context.Background())
-
-	_, err = c2.Exec(context.Background(), fmt.Sprintf("select pg_terminate_backend(%d)", c1.PID())).ReadAll()
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
 
-	// Give a little time for the signal to actually kill the backend.
-	time.Sleep(500 * time.Millisecond)
+	srcBuf := &bytes.Buffer{}
 
-	err = c1.CheckConn()
+	res, err := pgConn.CopyFrom(context.Background(), srcBuf, "copy foo to stdout")
 	require.Error(t, err)
+	assert.IsType(t, &pgconn.PgError{}, err)
+	assert.Equal(t, int64(0), res.RowsAffected())
+
+	ensureConnValid(t, pgConn)
 }
 
-func TestPipelinePrepare(t *testing.T) {
+// https://github.com/jackc/pgconn/issues/21
+func TestConnCopyFromNoticeResponseReceivedMidStream(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	ctx := context.Background()
+	pgConn, err := pgconn.Connect(ctx, os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	result := pgConn.ExecParams(context.Background(), `create temporary table t (id text primary key)`, nil, nil, nil, nil).Read()
-	require.NoError(t, result.Err)
-
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendPrepare("selectInt", "select $1::bigint as a", nil)
-	pipeline.SendPrepare("selectText", "select $1::text as b", nil)
-	pipeline.SendPrepare("selectNoParams", "select 42 as c", nil)
-	pipeline.SendPrepare("insertNoResults", "insert into t (id) values ($1)", nil)
-	pipeline.SendPrepare("insertNoParamsOrResults", "insert into t (id) values ('foo')", nil)
-	err = pipeline.Sync()
-	require.NoError(t, err)
-
-	results, err := pipeline.GetResults()
-	require.NoError(t, err)
-	sd, ok := results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 1)
-	require.Equal(t, string(sd.Fields[0].Name), "a")
-	require.Equal(t, []uint32{pgtype.Int8OID}, sd.ParamOIDs)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support triggers (https://github.com/cockroachdb/cockroach/issues/28296)")
+	}
 
-	results, err = pipeline.GetResults()
+	_, err = pgConn.Exec(ctx, `create temporary table sentences(
+		t text,
+		ts tsvector
+	)`).ReadAll()
 	require.NoError(t, err)
-	sd, ok = results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 1)
-	require.Equal(t, string(sd.Fields[0].Name), "b")
-	require.Equal(t, []uint32{pgtype.TextOID}, sd.ParamOIDs)
 
-	results, err = pipeline.GetResults()
+	_, err = pgConn.Exec(ctx, `create function pg_temp.sentences_trigger() returns trigger as $$
+	begin
+	  new.ts := to_tsvector(new.t);
+		return new;
+	end
+	$$ language plpgsql;`).ReadAll()
 	require.NoError(t, err)
-	sd, ok = results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 1)
-	require.Equal(t, string(sd.Fields[0].Name), "c")
-	require.Equal(t, []uint32{}, sd.ParamOIDs)
 
-	results, err = pipeline.GetResults()
+	_, err = pgConn.Exec(ctx, `create trigger sentences_update before insert on sentences for each row execute procedure pg_temp.sentences_trigger();`).ReadAll()
 	require.NoError(t, err)
-	sd, ok = results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 0)
-	require.Equal(t, []uint32{pgtype.TextOID}, sd.ParamOIDs)
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	sd, ok = results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 0)
-	require.Len(t, sd.ParamOIDs, 0)
+	longString := make([]byte, 10001)
+	for i := range longString {
+		longString[i] = 'x'
+	}
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+	buf := &bytes.Buffer{}
+	for i := 0; i < 1000; i++ {
+		buf.Write([]byte(fmt.Sprintf("%s\n", string(longString))))
+	}
 
-	results, err = pipeline.GetResults()
+	_, err = pgConn.CopyFrom(ctx, buf, "COPY sentences(t) FROM STDIN WITH (FORMAT csv)")
 	require.NoError(t, err)
-	require.Nil(t, results)
+}
 
-	err = pipeline.Close()
-	require.NoError(t, err)
+type delayedReader struct {
+	r io.Reader
+}
 
-	ensureConnValid(t, pgConn)
+func (d delayedReader) Read(p []byte) (int, error) {
+	// W/o sleep test passes, with sleep it fails.
+	time.Sleep(time.Millisecond)
+	return d.r.Read(p)
 }
 
-func TestPipelinePrepareError(t *testing.T) {
-	t.Parallel()
+// https://github.com/jackc/pgconn/issues/128
+func TestConnCopyFromDataWriteAfterErrorAndReturn(t *testing.T) {
+	connString := os.Getenv("PGX_TEST_DATABASE")
+	if connString == "" {
+		t.Skipf("Skipping due to missing environment variable %v", "PGX_TEST_DATABASE")
+	}
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	config, err := pgconn.ParseConfig(connString)
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendPrepare("selectInt", "select $1::bigint as a", nil)
-	pipeline.SendPrepare("selectError", "bad", nil)
-	pipeline.SendPrepare("selectText", "select $1::text as b", nil)
-	err = pipeline.Sync()
+	pgConn, err := pgconn.ConnectConfig(context.Background(), config)
 	require.NoError(t, err)
 
-	results, err := pipeline.GetResults()
-	require.NoError(t, err)
-	sd, ok := results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 1)
-	require.Equal(t, string(sd.Fields[0].Name), "a")
-	require.Equal(t, []uint32{pgtype.Int8OID}, sd.ParamOIDs)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not fully support COPY FROM")
+	}
 
-	results, err = pipeline.GetResults()
-	var pgErr *pgconn.PgError
-	require.ErrorAs(t, err, &pgErr)
-	require.Nil(t, results)
+	setupSQL := `create temporary table t (
+		id text primary key,
+		n int not null
+	);`
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+	_, err = pgConn.Exec(context.Background(), setupSQL).ReadAll()
+	assert.NoError(t, err)
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	require.Nil(t, results)
+	r1 := delayedReader{r: strings.NewReader(`id	0\n`)}
+	// Generate an error with a bogus COPY command
+	_, err = pgConn.CopyFrom(context.Background(), r1, "COPY nosuchtable FROM STDIN ")
+	assert.Error(t, err)
 
-	err = pipeline.Close()
+	r2 := delayedReader{r: strings.NewReader(`id	0\n`)}
+	_, err = pgConn.CopyFrom(context.Background(), r2, "COPY t FROM STDIN")
+	assert.NoError(t, err)
+}
+
+func TestConnEscapeString(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{in: "", out: ""},
+		{in: "42", out: "42"},
+		{in: "'", out: "''"},
+		{in: "hi'there", out: "hi''there"},
+		{in: "'hi there'", out: "''hi there''"},
+	}
+
+	for i, tt := range tests {
+		value, err := pgConn.EscapeString(tt.in)
+		if assert.NoErrorf(t, err, "%d.", i) {
+			assert.Equalf(t, tt.out, value, "%d.", i)
+		}
+	}
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestPipelinePrepareAndDeallocate(t *testing.T) {
+func TestConnCancelRequest(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendPrepare("selectInt", "select $1::bigint as a", nil)
-	pipeline.SendDeallocate("selectInt")
-	err = pipeline.Sync()
-	require.NoError(t, err)
+	if pgConn.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support query cancellation (https://github.com/cockroachdb/cockroach/issues/41335)")
+	}
 
-	results, err := pipeline.GetResults()
-	require.NoError(t, err)
-	sd, ok := results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 1)
-	require.Equal(t, string(sd.Fields[0].Name), "a")
-	require.Equal(t, []uint32{pgtype.Int8OID}, sd.ParamOIDs)
+	multiResult := pgConn.Exec(context.Background(), "select 'Hello, world', pg_sleep(2)")
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	_, ok = results.(*pgconn.CloseComplete)
-	require.Truef(t, ok, "expected CloseComplete, got: %#v", results)
+	go func() {
+		// The query is actually sent when multiResult.NextResult() is called. So wait to ensure it is sent.
+		// Once Flush is available this could use that instead.
+		time.Sleep(500 * time.Millisecond)
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+		err := pgConn.CancelRequest(context.Background())
+		require.NoError(t, err)
+	}()
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	require.Nil(t, results)
+	for multiResult.NextResult() {
+	}
+	err = multiResult.Close()
 
-	err = pipeline.Close()
-	require.NoError(t, err)
+	require.IsType(t, &pgconn.PgError{}, err)
+	require.Equal(t, "57014", err.(*pgconn.PgError).Code)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestPipelineQuery(t *testing.T) {
+// https://github.com/jackc/pgx/issues/659
+func TestConnContextCanceledCancelsRunningQueryOnServer(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
-	err = pipeline.Sync()
-	require.NoError(t, err)
+	pid := pgConn.PID()
 
-	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
-	err = pipeline.Sync()
-	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	multiResult := pgConn.Exec(ctx, "select 'Hello, world', pg_sleep(30)")
 
-	results, err := pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok := results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult := rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "1", string(readResult.Rows[0][0]))
+	for multiResult.NextResult() {
+	}
+	err = multiResult.Close()
+	assert.True(t, pgconn.Timeout(err))
+	assert.True(t, pgConn.IsClosed())
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
 
-	results, err = pipeline.GetResults()
+	otherConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "2", string(readResult.Rows[0][0]))
+	defer closeConn(t, otherConn)
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "3", string(readResult.Rows[0][0]))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+	for {
+		result := otherConn.ExecParams(ctx,
+			`select 1 from pg_stat_activity where pid=$1`,
+			[][]byte{[]byte(strconv.FormatInt(int64(pid), 10))},
+			nil,
+			nil,
+			nil,
+		).Read()
+		require.NoError(t, result.Err)
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "4", string(readResult.Rows[0][0]))
+		if len(result.Rows) == 0 {
+			break
+		}
+	}
+}
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "5", string(readResult.Rows[0][0]))
+func TestHijackAndConstruct(t *testing.T) {
+	t.Parallel()
 
-	results, err = pipeline.GetResults()
+	origConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
 
-	results, err = pipeline.GetResults()
+	hc, err := origConn.Hijack()
 	require.NoError(t, err)
-	require.Nil(t, results)
 
-	err = pipeline.Close()
+	_, err = origConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
+	require.Error(t, err)
+
+	newConn, err := pgconn.Construct(hc)
 	require.NoError(t, err)
 
-	ensureConnValid(t, pgConn)
+	defer closeConn(t, newConn)
+
+	results, err := newConn.Exec(context.Background(), "select 'Hello, world'").ReadAll()
+	assert.NoError(t, err)
+
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
+	assert.Len(t, results[0].Rows, 1)
+	assert.Equal(t, "Hello, world", string(results[0].Rows[0][0]))
+
+	ensureConnValid(t, newConn)
 }
 
-func TestPipelinePrepareQuery(t *testing.T) {
+func TestConnCloseWhileCancellableQueryInProgress(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	defer closeConn(t, pgConn)
 
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendPrepare("ps", "select $1::text as msg", nil)
-	pipeline.SendQueryPrepared(`ps`, [][]byte{[]byte("hello")}, nil, nil)
-	pipeline.SendQueryPrepared(`ps`, [][]byte{[]byte("goodbye")}, nil, nil)
-	err = pipeline.Sync()
-	require.NoError(t, err)
+	ctx, _ := context.WithCancel(context.Background())
+	pgConn.Exec(ctx, "select n from generate_series(1,10) n")
 
-	results, err := pipeline.GetResults()
-	require.NoError(t, err)
-	sd, ok := results.(*pgconn.StatementDescription)
-	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
-	require.Len(t, sd.Fields, 1)
-	require.Equal(t, string(sd.Fields[0].Name), "msg")
-	require.Equal(t, []uint32{pgtype.TextOID}, sd.ParamOIDs)
+	closeCtx, _ := context.WithCancel(context.Background())
+	pgConn.Close(closeCtx)
+	select {
+	case <-pgConn.CleanupDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection cleanup exceeded maximum time")
+	}
+}
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok := results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult := rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "hello", string(readResult.Rows[0][0]))
+// https://github.com/jackc/pgx/issues/800
+func TestFatalErrorReceivedAfterCommandComplete(t *testing.T) {
+	t.Parallel()
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "goodbye", string(readResult.Rows[0][0]))
+	steps := pgmock.AcceptUnauthenticatedConnRequestSteps()
+	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Parse{}))
+	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Bind{}))
+	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Describe{}))
+	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Execute{}))
+	steps = append(steps, pgmock.ExpectAnyMessage(&pgproto3.Sync{}))
+	steps = append(steps, pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+		{Name: []byte("mock")},
+	}}))
+	steps = append(steps, pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")}))
+	steps = append(steps, pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "57P01"}))
 
-	results, err = pipeline.GetResults()
-	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+	script := &pgmock.Script{Steps: steps}
 
-	results, err = pipeline.GetResults()
+	ln, err := net.Listen("tcp", "127.0.0.1:")
 	require.NoError(t, err)
-	require.Nil(t, results)
+	defer ln.Close()
 
-	err = pipeline.Close()
+	serverErrChan := make(chan error, 1)
+	go func() {
+		defer close(serverErrChan)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		err = script.Run(pgproto3.NewBackend(conn, conn))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+	}()
+
+	parts := strings.Split(ln.Addr().String(), ":")
+	host := parts[0]
+	port := parts[1]
+	connStr := fmt.Sprintf("sslmode=disable host=%s port=%s", host, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := pgconn.Connect(ctx, connStr)
 	require.NoError(t, err)
 
-	ensureConnValid(t, pgConn)
+	rr := conn.ExecParams(ctx, "mocked...", nil, nil, nil, nil)
+
+	for rr.NextRow() {
+	}
+
+	_, err = rr.Close()
+	require.Error(t, err)
 }
 
-func TestPipelineQueryErrorBetweenSyncs(t *testing.T) {
+// https://github.com/jackc/pgconn/issues/27
+func TestConnLargeResponseWhileWritingDoesNotDeadlock(t *testing.T) {
 	t.Parallel()
 
 	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
 	defer closeConn(t, pgConn)
 
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
-	err = pipeline.Sync()
+	_, err = pgConn.Exec(context.Background(), "set client_min_messages = debug5").ReadAll()
 	require.NoError(t, err)
 
-	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 1/(3-n) from generate_series(1,10) n`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
-	err = pipeline.Sync()
+	// The actual contents of this test aren't important. What's important is a large amount of data to be written and
+	// because of client_min_messages = debug5 the server will return a large amount of data.
+
+	paramCount := math.MaxUint16
+	params := make([]string, 0, paramCount)
+	args := make([][]byte, 0, paramCount)
+	for i := 0; i < paramCount; i++ {
+		params = append(params, fmt.Sprintf("($%d::text)", i+1))
+		args = append(args, []byte(strconv.Itoa(i)))
+	}
+	sql := "values" + strings.Join(params, ", ")
+
+	result := pgConn.ExecParams(context.Background(), sql, args, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	require.Len(t, result.Rows, paramCount)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestConnCheckConn(t *testing.T) {
+	t.Parallel()
+
+	// Intentionally using TCP connection for more predictable close behavior. (Not sure if Unix domain sockets would behave subtlely different.)
+
+	connString := os.Getenv("PGX_TEST_TCP_CONN_STRING")
+	if connString == "" {
+		t.Skipf("Skipping due to missing environment variable %v", "PGX_TEST_TCP_CONN_STRING")
+	}
+
+	c1, err := pgconn.Connect(context.Background(), connString)
 	require.NoError(t, err)
+	defer c1.Close(context.Background())
 
-	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 6`, nil, nil, nil, nil)
-	err = pipeline.Sync()
+	if c1.ParameterStatus("crdb_version") != "" {
+		t.Skip("Server does not support pg_terminate_backend() (https://github.com/cockroachdb/cockroach/issues/35897)")
+	}
+
+	err = c1.CheckConn()
 	require.NoError(t, err)
 
-	results, err := pipeline.GetResults()
+	c2, err := pgconn.Connect(context.Background(), connString)
 	require.NoError(t, err)
-	rr, ok := results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult := rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "1", string(readResult.Rows[0][0]))
+	defer c2.Close(context.Background())
 
-	results, err = pipeline.GetResults()
+	_, err = c2.Exec(context.Background(), fmt.Sprintf("select pg_terminate_backend(%d)", c1.PID())).ReadAll()
 	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "2", string(readResult.Rows[0][0]))
 
-	results, err = pipeline.GetResults()
+	// Give a little time for the signal to actually kill the backend.
+	time.Sleep(500 * time.Millisecond)
+
+	err = c1.CheckConn()
+	require.Error(t, err)
+}
+
+func TestPipelinePrepare(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
 	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+	defer closeConn(t, pgConn)
 
-	results, err = pipeline.GetResults()
+	result := pgConn.ExecParams(context.Background(), `create temporary table t (id text primary key)`, nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendPrepare("selectInt", "select $1::bigint as a", nil)
+	pipeline.SendPrepare("selectText", "select $1::text as b", nil)
+	pipeline.SendPrepare("selectNoParams", "select 42 as c", nil)
+	pipeline.SendPrepare("insertNoResults", "insert into t (id) values ($1)", nil)
+	pipeline.SendPrepare("insertNoParamsOrResults", "insert into t (id) values ('foo')", nil)
+	err = pipeline.Sync()
 	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "3", string(readResult.Rows[0][0]))
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	sd, ok := results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 1)
+	require.Equal(t, string(sd.Fields[0].Name), "a")
+	require.Equal(t, []uint32{pgtype.Int8OID}, sd.ParamOIDs)
 
 	results, err = pipeline.GetResults()
 	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	var pgErr *pgconn.PgError
-	require.ErrorAs(t, readResult.Err, &pgErr)
-	require.Equal(t, "22012", pgErr.Code)
+	sd, ok = results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 1)
+	require.Equal(t, string(sd.Fields[0].Name), "b")
+	require.Equal(t, []uint32{pgtype.TextOID}, sd.ParamOIDs)
 
 	results, err = pipeline.GetResults()
 	require.NoError(t, err)
-	_, ok = results.(*pgconn.PipelineSync)
-	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+	sd, ok = results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 1)
+	require.Equal(t, string(sd.Fields[0].Name), "c")
+	require.Equal(t, []uint32{}, sd.ParamOIDs)
 
 	results, err = pipeline.GetResults()
 	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "5", string(readResult.Rows[0][0]))
+	sd, ok = results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 0)
+	require.Equal(t, []uint32{pgtype.TextOID}, sd.ParamOIDs)
 
 	results, err = pipeline.GetResults()
 	require.NoError(t, err)
-	rr, ok = results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult = rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "6", string(readResult.Rows[0][0]))
+	sd, ok = results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 0)
+	require.Len(t, sd.ParamOIDs, 0)
 
 	results, err = pipeline.GetResults()
 	require.NoError(t, err)
 	_, ok = results.(*pgconn.PipelineSync)
 	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
 
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	require.Nil(t, results)
+
 	err = pipeline.Close()
 	require.NoError(t, err)
 
 	ensureConnValid(t, pgConn)
 }
 
-func TestPipelineCloseReadsUnreadResults(t *testing.T) {
+func TestPipelinePrepareError(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendPrepare("selectInt", "select $1::bigint as a", nil)
+	pipeline.SendPrepare("selectError", "bad", nil)
+	pipeline.SendPrepare("selectText", "select $1::text as b", nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	sd, ok := results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 1)
+	require.Equal(t, string(sd.Fields[0].Name), "a")
+	require.Equal(t, []uint32{pgtype.Int8OID}, sd.ParamOIDs)
+
+	results, err = pipeline.GetResults()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	require.Nil(t, results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	require.Nil(t, results)
+
+	err = pipeline.Close()
+	require.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestPipelinePrepareAndDeallocate(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendPrepare("selectInt", "select $1::bigint as a", nil)
+	pipeline.SendDeallocate("selectInt")
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	sd, ok := results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 1)
+	require.Equal(t, string(sd.Fields[0].Name), "a")
+	require.Equal(t, []uint32{pgtype.Int8OID}, sd.ParamOIDs)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.CloseComplete)
+	require.Truef(t, ok, "expected CloseComplete, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	require.Nil(t, results)
+
+	err = pipeline.Close()
+	require.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestPipelineQuery(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok := results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult := rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "1", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "2", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "3", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "4", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "5", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	require.Nil(t, results)
+
+	err = pipeline.Close()
+	require.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestPipelinePrepareQuery(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendPrepare("ps", "select $1::text as msg", nil)
+	pipeline.SendQueryPrepared(`ps`, [][]byte{[]byte("hello")}, nil, nil)
+	pipeline.SendQueryPrepared(`ps`, [][]byte{[]byte("goodbye")}, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	sd, ok := results.(*pgconn.StatementDescription)
+	require.Truef(t, ok, "expected StatementDescription, got: %#v", results)
+	require.Len(t, sd.Fields, 1)
+	require.Equal(t, string(sd.Fields[0].Name), "msg")
+	require.Equal(t, []uint32{pgtype.TextOID}, sd.ParamOIDs)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok := results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult := rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "hello", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "goodbye", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	require.Nil(t, results)
+
+	err = pipeline.Close()
+	require.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestPipelineQueryErrorBetweenSyncs(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 1/(3-n) from generate_series(1,10) n`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 6`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok := results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult := rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "1", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "2", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "3", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, readResult.Err, &pgErr)
+	require.Equal(t, "22012", pgErr.Code)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "5", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok = results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult = rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "6", string(readResult.Rows[0][0]))
+
+	results, err = pipeline.GetResults()
+	require.NoError(t, err)
+	_, ok = results.(*pgconn.PipelineSync)
+	require.Truef(t, ok, "expected PipelineSync, got: %#v", results)
+
+	err = pipeline.Close()
+	require.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestPipelineCloseReadsUnreadResults(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok := results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult := rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "1", string(readResult.Rows[0][0]))
+
+	err = pipeline.Close()
+	require.NoError(t, err)
+
+	ensureConnValid(t, pgConn)
+}
+
+func TestPipelineCloseDetectsUnsyncedRequests(t *testing.T) {
+	t.Parallel()
+
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer closeConn(t, pgConn)
+
+	pipeline := pgConn.StartPipeline(context.Background())
+	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
+	err = pipeline.Sync()
+	require.NoError(t, err)
+
+	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
+	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
+
+	results, err := pipeline.GetResults()
+	require.NoError(t, err)
+	rr, ok := results.(*pgconn.ResultReader)
+	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
+	readResult := rr.Read()
+	require.NoError(t, readResult.Err)
+	require.Len(t, readResult.Rows, 1)
+	require.Len(t, readResult.Rows[0], 1)
+	require.Equal(t, "1", string(readResult.Rows[0][0]))
+
+	err = pipeline.Close()
+	require.EqualError(t, err, "pipeline has unsynced requests")
+}
+
+func Example() {
+	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer pgConn.Close(context.Background())
+
+	result := pgConn.ExecParams(context.Background(), "select generate_series(1,3)", nil, nil, nil, nil).Read()
+	if result.Err != nil {
+		log.Fatalln(result.Err)
+	}
+
+	for _, row := range result.Rows {
+		fmt.Println(string(row[0]))
+	}
+
+	fmt.Println(result.CommandTag)
+	// Output:
+	// 1
+	// 2
+	// 3
+	// SELECT 3
+}
+
+func GetSSLPassword(ctx context.Context) string {
+	connString := os.Getenv("PGX_SSL_PASSWORD")
+	return connString
+}
+
+var rsaCertPEM = `-----BEGIN CERTIFICATE-----
+MIIDCTCCAfGgAwIBAgIUQDlN1g1bzxIJ8KWkayNcQY5gzMEwDQYJKoZIhvcNAQEL
+BQAwFDESMBAGA1UEAwwJbG9jYWxob3N0MB4XDTIyMDgxNTIxNDgyNloXDTIzMDgx
+NTIxNDgyNlowFDESMBAGA1UEAwwJbG9jYWxob3N0MIIBIjANBgkqhkiG9w0BAQEF
+AAOCAQ8AMIIBCgKCAQEA0vOppiT8zE+076acRORzD5JVbRYKMK3XlWLVrHua4+ct
+Rm54WyP+3XsYU4JGGGKgb8E+u2UosGJYcSM+b+U1/5XPTcpuumS+pCiD9WP++A39
+tsukYwR7m65cgpiI4dlLEZI3EWpAW+Bb3230KiYW4sAmQ0Ih4PrN+oPvzcs86F4d
+9Y03CqVUxRKLBLaClZQAg8qz2Pawwj1FKKjDX7u2fRVR0wgOugpCMOBJMcCgz9pp
+0HSa4x3KZDHEZY7Pah5XwWrCfAEfRWsSTGcNaoN8gSxGFM1JOEJa8SAuPGjFcYIv
+MmVWdw0FXCgYlSDL02fzLE0uyvXBDibzSqOk770JhQIDAQABo1MwUTAdBgNVHQ4E
+FgQUiJ8JLENJ+2k1Xl4o6y2Lc/qHTh0wHwYDVR0jBBgwFoAUiJ8JLENJ+2k1Xl4o
+6y2Lc/qHTh0wDwYDVR0TAQH/BAUwAwEB/zANBgkqhkiG9w0BAQsFAAOCAQEAwjn2
+gnNAhFvh58VqLIjU6ftvn6rhz5B9dg2+XyY8sskLhhkO1nL9339BVZsRt+eI3a7I
+81GNIm9qHVM3MUAcQv3SZy+0UPVUT8DNH2LwHT3CHnYTBP8U+8n8TDNGSTMUhIBB
+Rx+6KwODpwLdI79VGT3IkbU9bZwuepB9I9nM5t/tt5kS4gHmJFlO0aLJFCTO4Scf
+hp/WLPv4XQUH+I3cPfaJRxz2j0Kc8iOzMhFmvl1XOGByjX6X33LnOzY/LVeTSGyS
+VgC32BGtnMwuy5XZYgFAeUx9HKy4tG4OH2Ux6uPF/WAhsug6PXSjV7BK6wYT5i27
+MlascjupnaptKX/wMA==
+-----END CERTIFICATE-----
+`
+
+var rsaKeyPEM = testingKey(`-----BEGIN TESTING KEY-----
+MIIEvwIBADANBgkqhkiG9w0BAQEFAASCBKkwggSlAgEAAoIBAQDS86mmJPzMT7Tv
+ppxE5HMPklVtFgowrdeVYtWse5rj5y1GbnhbI/7dexhTgkYYYqBvwT67ZSiwYlhx
+Iz5v5TX/lc9Nym66ZL6kKIP1Y/74Df22y6RjBHubrlyCmIjh2UsRkjcRakBb4Fvf
+bfQqJhbiwCZDQiHg+s36g+/NyzzoXh31jTcKpVTFEosEtoKVlACDyrPY9rDCPUUo
+qMNfu7Z9FVHTCA66CkIw4EkxwKDP2mnQdJrjHcpkMcRljs9qHlfBasJ8AR9FaxJM
+Zw1qg3yBLEYUzUk4QlrxIC48aMVxgi8yZVZ3DQVcKBiVIMvTZ/MsTS7K9cEOJvNK
+o6TvvQmFAgMBAAECggEAKzTK54Ol33bn2TnnwdiElIjlRE2CUswYXrl6iDRc2hbs
+WAOiVRB/T/+5UMla7/2rXJhY7+rdNZs/ABU24ZYxxCJ77jPrD/Q4c8j0lhsgCtBa
+ycjV543wf0dsHTd+ubtWu8eVzdRUUD0YtB+CJevdPh4a+CWgaMMV0xyYzi61T+Yv
+Z7Uc3awIAiT4Kw9JRmJiTnyMJg5vZqW3BBAX4ZIvS/54ipwEU+9sWLcuH7WmCR0B
+QCTqS6hfJDLm//dGC89Iyno57zfYuiT3PYCWH5crr/DH3LqnwlNaOGSBkhkXuIL+
+QvOaUMe2i0pjqxDrkBx05V554vyy9jEvK7i330HL4QKBgQDUJmouEr0+o7EMBApC
+CPPu58K04qY5t9aGciG/pOurN42PF99yNZ1CnynH6DbcnzSl8rjc6Y65tzTlWods
+bjwVfcmcokG7sPcivJvVjrjKpSQhL8xdZwSAjcqjN4yoJ/+ghm9w+SRmZr6oCQZ3
+1jREfJKT+PGiWTEjYcExPWUD2QKBgQD+jdgq4c3tFavU8Hjnlf75xbStr5qu+fp2
+SGLRRbX+msQwVbl2ZM9AJLoX9MTCl7D9zaI3ONhheMmfJ77lDTa3VMFtr3NevGA6
+MxbiCEfRtQpNkJnsqCixLckx3bskj5+IF9BWzw7y7nOzdhoWVFv/+TltTm3RB51G
+McdlmmVjjQKBgQDSFAw2/YV6vtu2O1XxGC591/Bd8MaMBziev+wde3GHhaZfGVPC
+I8dLTpMwCwowpFKdNeLLl1gnHX161I+f1vUWjw4TVjVjaBUBx+VEr2Tb/nXtiwiD
+QV0a883CnGJjreAblKRMKdpasMmBWhaWmn39h6Iad3zHuCzJjaaiXNpn2QKBgQCf
+k1Q8LanmQnuh1c41f7aD5gjKCRezMUpt9BrejhD1NxheJJ9LNQ8nat6uPedLBcUS
+lmJms+AR2qKqf0QQWyQ98YgAtshgTz8TvQtPT1mWgSOgVFHqJdC8obNK63FyDgc4
+TZVxlgQNDqbBjfv0m5XA9f+mIlB9hYR2iKYzb4K30QKBgQC+LEJYZh00zsXttGHr
+5wU1RzbgDIEsNuu+nZ4MxsaCik8ILNRHNXdeQbnADKuo6ATfhdmDIQMVZLG8Mivi
+UwnwLd1GhizvqvLHa3ULnFphRyMGFxaLGV48axTT2ADoMX67ILrIY/yjycLqRZ3T
+z3w+CgS20UrbLIR1YXfqUXge1g==
+-----END TESTING KEY-----
+`)
+
+func testingKey(s string) string { return strings.ReplaceAll(s, "TESTING KEY", "PRIVATE KEY") }
+
+func TestSNISupport(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		sni_param string
+		sni_set   bool
+	}{
+		{
+			name:      "SNI is passed by default",
+			sni_param: "",
+			sni_set:   true,
+		},
+		{
+			name:      "SNI is passed when asked for",
+			sni_param: "sslsni=1",
+			sni_set:   true,
+		},
+		{
+			name:      "SNI is not passed when disabled",
+			sni_param: "sslsni=0",
+			sni_set:   false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ln, err := net.Listen("tcp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer ln.Close()
+
+			serverErrChan := make(chan error, 1)
+			serverSNINameChan := make(chan string, 1)
+			defer close(serverErrChan)
+			defer close(serverSNINameChan)
+
+			go func() {
+				var sniHost string
+
+				conn, err := ln.Accept()
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
+				defer conn.Close()
+
+				err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
+
+				backend := pgproto3.NewBackend(conn, conn)
+				startupMessage, err := backend.ReceiveStartupMessage()
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
+
+				switch startupMessage.(type) {
+				case *pgproto3.SSLRequest:
+					_, err = conn.Write([]byte("S"))
+					if err != nil {
+						serverErrChan <- err
+						return
+					}
+				default:
+					serverErrChan <- fmt.Errorf("unexpected startup message: %#v", startupMessage)
+					return
+				}
+
+				cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
+
+				srv := tls.Server(conn, &tls.Config{
+					Certificates: []tls.Certificate{cert},
+					GetConfigForClient: func(argHello *tls.ClientHelloInfo) (*tls.Config, error) {
+						sniHost = argHello.ServerName
+						return nil, nil
+					},
+				})
+				defer srv.Close()
+
+				if err := srv.Handshake(); err != nil {
+					serverErrChan <- fmt.Errorf("handshake: %v", err)
+					return
+				}
+
+				srv.Write((&pgproto3.AuthenticationOk{}).Encode(nil))
+				srv.Write((&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}).Encode(nil))
+				srv.Write((&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(nil))
+
+				serverSNINameChan <- sniHost
+			}()
+
+			port := strings.Split(ln.Addr().String(), ":")[1]
+			connStr := fmt.Sprintf("sslmode=require host=localhost port=%s %s", port, tt.sni_param)
+			_, err = pgconn.Connect(context.Background(), connStr)
+
+			select {
+			case sniHost := <-serverSNINameChan:
+				if tt.sni_set {
+					require.Equal(t, sniHost, "localhost")
+				} else {
+					require.Equal(t, sniHost, "")
+				}
+			case err = <-serverErrChan:
+				t.Fatalf("server failed with error: %+v", err)
+			case <-time.After(time.Millisecond * 100):
+				t.Fatal("exceeded connection timeout without erroring out")
+			}
+		})
+	}
+}
+
+type scramTestServerResult struct {
+	authMechanism string
+	gs2Header     string
+	err           error
+}
+
+// startSCRAMTestServer starts a TLS server that authenticates the first connection it accepts with SCRAM using the
+// password "secret". The server offers mechanisms. If mechanisms is empty the server authenticates without a password.
+// It verifies the client proof and the tls-server-end-point channel binding data.
+func startSCRAMTestServer(t *testing.T, mechanisms []string) (string, <-chan scramTestServerResult) {
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	require.Equal(t, x509.SHA256WithRSA, leaf.SignatureAlgorithm)
+	certHash := sha256.Sum256(leaf.Raw)
+
+	resultChan := make(chan scramTestServerResult, 1)
+	go func() {
+		var result scramTestServerResult
+		defer func() { resultChan <- result }()
+
+		conn, err := ln.Accept()
+		if err != nil {
+			result.err = err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err != nil {
+			result.err = err
+			return
+		}
+
+		startupMessage, err := pgproto3.NewBackend(conn, conn).ReceiveStartupMessage()
+		if err != nil {
+			result.err = err
+			return
+		}
+		if _, ok := startupMessage.(*pgproto3.SSLRequest); !ok {
+			result.err = fmt.Errorf("unexpected startup message: %#v", startupMessage)
+			return
+		}
+		_, err = conn.Write([]byte("S"))
+		if err != nil {
+			result.err = err
+			return
+		}
+
+		tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		backend := pgproto3.NewBackend(tlsConn, tlsConn)
+		_, err = backend.ReceiveStartupMessage()
+		if err != nil {
+			result.err = err
+			return
+		}
+
+		if len(mechanisms) > 0 {
+			result.authMechanism, result.gs2Header, result.err = scramTestServerAuth(backend, mechanisms, certHash[:])
+			if result.err != nil {
+				return
+			}
+		}
+
+		backend.Send(&pgproto3.AuthenticationOk{})
+		backend.Send(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0})
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		result.err = backend.Flush()
+		if result.err != nil {
+			return
+		}
+
+		// Wait for the client to terminate the connection.
+		_, result.err = backend.Receive()
+	}()
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	return fmt.Sprintf("sslmode=require host=%s port=%s password=secret", host, port), resultChan
+}
+
+func scramTestServerAuth(backend *pgproto3.Backend, mechanisms []string, certHash []byte) (authMechanism, gs2Header string, err error) {
+	backend.Send(&pgproto3.AuthenticationSASL{AuthMechanisms: mechanisms})
+	err = backend.Flush()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = backend.SetAuthType(pgproto3.AuthTypeSASL)
+	if err != nil {
+		return "", "", err
+	}
+	msg, err := backend.Receive()
+	if err != nil {
+		return "", "", err
+	}
+	initialResponse, ok := msg.(*pgproto3.SASLInitialResponse)
+	if !ok {
+		return "", "", fmt.Errorf("unexpected message: %#v", msg)
+	}
+	authMechanism = initialResponse.AuthMechanism
+
+	// client-first-message is gs2-header followed by client-first-message-bare.
+	parts := strings.SplitN(string(initialResponse.Data), ",", 3)
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("invalid client-first-message: %q", initialResponse.Data)
+	}
+	gs2Header = parts[0] + "," + parts[1] + ","
+	clientFirstMessageBare := parts[2]
+	clientNonce := strings.TrimPrefix(clientFirstMessageBare, "n=,r=")
+
+	salt := []byte("0123456789abcdef")
+	iterations := 4096
+	nonce := clientNonce + "server"
+	serverFirstMessage := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(salt), iterations)
+	backend.Send(&pgproto3.AuthenticationSASLContinue{Data: []byte(serverFirstMessage)})
+	err = backend.Flush()
+	if err != nil {
+		return "", "", err
+	}
+
+	err = backend.SetAuthType(pgproto3.AuthTypeSASLContinue)
+	if err != nil {
+		return "", "", err
+	}
+	msg, err = backend.Receive()
+	if err != nil {
+		return "", "", err
+	}
+	response, ok := msg.(*pgproto3.SASLResponse)
+	if !ok {
+		return "", "", fmt.Errorf("unexpected message: %#v", msg)
+	}
+
+	idx := strings.LastIndex(string(response.Data), ",p=")
+	if idx == -1 {
+		return "", "", fmt.Errorf("invalid client-final-message: %q", response.Data)
+	}
+	clientFinalMessageWithoutProof := string(response.Data[:idx])
+	clientProof, err := base64.StdEncoding.DecodeString(string(response.Data[idx+3:]))
+	if err != nil {
+		return "", "", err
+	}
+
+	cbindInput := []byte(gs2Header)
+	if authMechanism == "SCRAM-SHA-256-PLUS" {
+		cbindInput = append(cbindInput, certHash...)
+	}
+	expected := fmt.Sprintf("c=%s,r=%s", base64.StdEncoding.EncodeToString(cbindInput), nonce)
+	if clientFinalMessageWithoutProof != expected {
+		return "", "", fmt.Errorf("client-final-message %q does not match %q", clientFinalMessageWithoutProof, expected)
+	}
+
+	saltedPassword := pbkdf2.Key([]byte("secret"), salt, iterations, 32, sha256.New)
+	authMessage := []byte(clientFirstMessageBare + "," + serverFirstMessage + "," + clientFinalMessageWithoutProof)
+
+	clientKey := testHMAC(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	clientSignature := testHMAC(storedKey[:], authMessage)
+	for i := range clientProof {
+		clientProof[i] ^= clientSignature[i]
+	}
+	if !bytes.Equal(clientProof, clientKey) {
+		return "", "", errors.New("invalid client proof")
+	}
+
+	serverSignature := testHMAC(testHMAC(saltedPassword, []byte("Server Key")), authMessage)
+	backend.Send(&pgproto3.AuthenticationSASLFinal{Data: []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature))})
+	return authMechanism, gs2Header, nil
+}
+
+func testHMAC(key, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+func TestConnectSCRAMChannelBinding(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		mechanisms     []string
+		channelBinding string
+		authMechanism  string
+		gs2Header      string
+	}{
+		{
+			name:           "require",
+			mechanisms:     []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"},
+			channelBinding: "channel_binding=require",
+			authMechanism:  "SCRAM-SHA-256-PLUS",
+			gs2Header:      "p=tls-server-end-point,,",
+		},
+		{
+			name:          "prefer by default",
+			mechanisms:    []string{"SCRAM-SHA-256", "SCRAM-SHA-256-PLUS"},
+			authMechanism: "SCRAM-SHA-256-PLUS",
+			gs2Header:     "p=tls-server-end-point,,",
+		},
+		{
+			name:           "prefer without server support",
+			mechanisms:     []string{"SCRAM-SHA-256"},
+			channelBinding: "channel_binding=prefer",
+			authMechanism:  "SCRAM-SHA-256",
+			gs2Header:      "y,,",
+		},
+		{
+			name:           "disable",
+			mechanisms:     []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"},
+			channelBinding: "channel_binding=disable",
+			authMechanism:  "SCRAM-SHA-256",
+			gs2Header:      "n,,",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			connString, resultChan := startSCRAMTestServer(t, tt.mechanisms)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := pgconn.Connect(ctx, connString+" "+tt.channelBinding)
+			require.NoError(t, err)
+			closeConn(t, conn)
+
+			result := <-resultChan
+			require.NoError(t, result.err)
+			assert.Equal(t, tt.authMechanism, result.authMechanism)
+			assert.Equal(t, tt.gs2Header, result.gs2Header)
+		})
+	}
+}
+
+func TestConnectSCRAMChannelBindingRequiredFails(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		mechanisms []string
+		errMsg     string
+	}{
+		{
+			name:       "server does not support SCRAM-SHA-256-PLUS",
+			mechanisms: []string{"SCRAM-SHA-256"},
+			errMsg:     "server does not support SCRAM-SHA-256-PLUS",
+		},
+		{
+			name:   "server skips authentication",
+			errMsg: "channel binding required (server did not request authentication)",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			connString, resultChan := startSCRAMTestServer(t, tt.mechanisms)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := pgconn.Connect(ctx, connString+" channel_binding=require")
+			require.Error(t, err)
+			require.Nil(t, conn)
+			assert.Contains(t, err.Error(), tt.errMsg)
+
+			<-resultChan
+		})
+	}
+}
+
+func TestConnectRequireAuth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		requireAuth string
+		authRequest pgproto3.BackendMessage
+		errMsg      string
+	}{
+		{
+			name:        "cleartext password allowed",
+			requireAuth: "password",
+			authRequest: &pgproto3.AuthenticationCleartextPassword{},
+		},
+		{
+			name:        "cleartext password not negated",
+			requireAuth: "!md5,!gss",
+			authRequest: &pgproto3.AuthenticationCleartextPassword{},
+		},
+		{
+			name:        "cleartext password forbidden by positive list",
+			requireAuth: "scram-sha-256",
+			authRequest: &pgproto3.AuthenticationCleartextPassword{},
+			errMsg:      `authentication method requirement "scram-sha-256" failed (server requested a cleartext password)`,
+		},
+		{
+			name:        "cleartext password forbidden by negative list",
+			requireAuth: "!password",
+			authRequest: &pgproto3.AuthenticationCleartextPassword{},
+			errMsg:      `authentication method requirement "!password" failed (server requested a cleartext password)`,
+		},
+		{
+			name:        "md5 forbidden",
+			requireAuth: "scram-sha-256,password",
+			authRequest: &pgproto3.AuthenticationMD5Password{Salt: [4]byte{1, 2, 3, 4}},
+			errMsg:      "server requested a hashed password",
+		},
+		{
+			name:        "no authentication forbidden",
+			requireAuth: "password",
+			errMsg:      `authentication method requirement "password" failed (server did not request authentication)`,
+		},
+		{
+			name:        "no authentication allowed",
+			requireAuth: "password,none",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			script := &pgmock.Script{
+				Steps: []pgmock.Step{pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}})},
+			}
+			if tt.authRequest != nil {
+				script.Steps = append(script.Steps, pgmock.SendMessage(tt.authRequest))
+			} else {
+				script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.AuthenticationOk{}))
+			}
+			// On failure the client closes the connection without responding to the authentication request.
+			if tt.errMsg == "" {
+				if tt.authRequest != nil {
+					script.Steps = append(script.Steps,
+						pgmock.ExpectMessage(&pgproto3.PasswordMessage{Password: "secret"}),
+						pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+					)
+				}
+				script.Steps = append(script.Steps,
+					pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+					pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+					pgmock.WaitForClose(),
+				)
+			}
+
+			connString, serverErrChan := startMockServer(t, script)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := pgconn.Connect(ctx, connString+" password=secret require_auth="+tt.requireAuth)
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				closeConn(t, conn)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			}
+
+			require.NoError(t, <-serverErrChan)
+		})
+	}
+}
+
+func TestConnectNegotiateProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	longSecretKey := []byte("a variable-length protocol version 3.2 secret key")
+
+	tests := []struct {
+		name            string
+		connParams      string
+		serverSteps     []pgmock.Step
+		protocolVersion uint32
+		secretKey       uint32
+		longSecretKey   []byte
+		errMsg          string
+	}{
+		{
+			name:       "server supports 3.2",
+			connParams: "max_protocol_version=3.2",
+			serverSteps: []pgmock.Step{
+				pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+				pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, LongSecretKey: longSecretKey}),
+			},
+			protocolVersion: pgproto3.ProtocolVersion32,
+			longSecretKey:   longSecretKey,
+		},
+		{
+			name:       "server downgrades to 3.0",
+			connParams: "max_protocol_version=latest",
+			serverSteps: []pgmock.Step{
+				pgmock.SendMessage(&pgproto3.NegotiateProtocolVersion{NewestMinorProtocol: 0}),
+				pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+				pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0x01020304}),
+			},
+			protocolVersion: pgproto3.ProtocolVersion30,
+			secretKey:       0x01020304,
+		},
+		{
+			name: "server rejects protocol options",
+			serverSteps: []pgmock.Step{
+				pgmock.SendMessage(&pgproto3.NegotiateProtocolVersion{NewestMinorProtocol: 0, UnrecognizedOptions: []string{"_pq_.foo"}}),
+				pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+				pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0x01020304}),
+			},
+			protocolVersion: pgproto3.ProtocolVersion30,
+			secretKey:       0x01020304,
+		},
+		{
+			name:       "server does not support min_protocol_version",
+			connParams: "min_protocol_version=3.2",
+			serverSteps: []pgmock.Step{
+				pgmock.SendMessage(&pgproto3.NegotiateProtocolVersion{NewestMinorProtocol: 0}),
+			},
+			errMsg: "server only supports protocol version 3.0, but min_protocol_version is 3.2",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
 
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
-	err = pipeline.Sync()
-	require.NoError(t, err)
+			script := &pgmock.Script{
+				Steps: []pgmock.Step{pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}})},
+			}
+			script.Steps = append(script.Steps, tt.serverSteps...)
+			if tt.errMsg == "" {
+				script.Steps = append(script.Steps,
+					pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+					pgmock.WaitForClose(),
+				)
+			}
 
-	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
-	err = pipeline.Sync()
-	require.NoError(t, err)
+			connString, serverErrChan := startMockServer(t, script)
 
-	results, err := pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok := results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult := rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "1", string(readResult.Rows[0][0]))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
 
-	err = pipeline.Close()
-	require.NoError(t, err)
+			conn, err := pgconn.Connect(ctx, connString+" "+tt.connParams)
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				assert.Equal(t, tt.protocolVersion, conn.ProtocolVersion())
+				assert.Equal(t, tt.secretKey, conn.SecretKey())
+				assert.Equal(t, tt.longSecretKey, conn.LongSecretKey())
+				closeConn(t, conn)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			}
 
-	ensureConnValid(t, pgConn)
+			require.NoError(t, <-serverErrChan)
+		})
+	}
 }
 
-func TestPipelineCloseDetectsUnsyncedRequests(t *testing.T) {
+func TestConnectDirectTLSNegotiation(t *testing.T) {
 	t.Parallel()
 
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	require.NoError(t, err)
-	defer closeConn(t, pgConn)
+	tests := []struct {
+		name       string
+		serverALPN []string
+		errMsg     string
+	}{
+		{
+			name:       "server selects postgresql ALPN",
+			serverALPN: []string{"postgresql"},
+		},
+		{
+			name:   "server does not select ALPN",
+			errMsg: "server did not select the postgresql ALPN protocol",
+		},
+	}
 
-	pipeline := pgConn.StartPipeline(context.Background())
-	pipeline.SendQueryParams(`select 1`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 2`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 3`, nil, nil, nil, nil)
-	err = pipeline.Sync()
-	require.NoError(t, err)
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
 
-	pipeline.SendQueryParams(`select 4`, nil, nil, nil, nil)
-	pipeline.SendQueryParams(`select 5`, nil, nil, nil, nil)
+			ln, err := net.Listen("tcp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer ln.Close()
 
-	results, err := pipeline.GetResults()
-	require.NoError(t, err)
-	rr, ok := results.(*pgconn.ResultReader)
-	require.Truef(t, ok, "expected ResultReader, got: %#v", results)
-	readResult := rr.Read()
-	require.NoError(t, readResult.Err)
-	require.Len(t, readResult.Rows, 1)
-	require.Len(t, readResult.Rows[0], 1)
-	require.Equal(t, "1", string(readResult.Rows[0][0]))
+			cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+			require.NoError(t, err)
 
-	err = pipeline.Close()
-	require.EqualError(t, err, "pipeline has unsynced requests")
-}
+			serverErrChan := make(chan error, 1)
+			go func() {
+				defer close(serverErrChan)
 
-func Example() {
-	pgConn, err := pgconn.Connect(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
-	if err != nil {
-		log.Fatalln(err)
-	}
-	defer pgConn.Close(context.Background())
+				conn, err := ln.Accept()
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
+				defer conn.Close()
 
-	result := pgConn.ExecParams(context.Background(), "select generate_series(1,3)", nil, nil, nil, nil).Read()
-	if result.Err != nil {
-		log.Fatalln(result.Err)
-	}
+				err = conn.SetDeadline(time.Now().Add(5 * time.Second))
+				if err != nil {
+					serverErrChan <- err
+					return
+				}
 
-	for _, row := range result.Rows {
-		fmt.Println(string(row[0]))
-	}
+				// The TLS handshake must start immediately without an SSLRequest.
+				tlsConn := tls.Server(conn, &tls.Config{
+					Certificates: []tls.Certificate{cert},
+					NextProtos:   tt.serverALPN,
+				})
+				err = tlsConn.Handshake()
+				if err != nil {
+					serverErrChan <- fmt.Errorf("handshake: %w", err)
+					return
+				}
 
-	fmt.Println(result.CommandTag)
-	// Output:
-	// 1
-	// 2
-	// 3
-	// SELECT 3
-}
+				if tt.errMsg != "" {
+					return
+				}
 
-func GetSSLPassword(ctx context.Context) string {
-	connString := os.Getenv("PGX_SSL_PASSWORD")
-	return connString
-}
+				script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+				script.Steps = append(script.Steps, pgmock.WaitForClose())
+				serverErrChan <- script.Run(pgproto3.NewBackend(tlsConn, tlsConn))
+			}()
 
-var rsaCertPEM = `-----BEGIN CERTIFICATE-----
-MIIDCTCCAfGgAwIBAgIUQDlN1g1bzxIJ8KWkayNcQY5gzMEwDQYJKoZIhvcNAQEL
-BQAwFDESMBAGA1UEAwwJbG9jYWxob3N0MB4XDTIyMDgxNTIxNDgyNloXDTIzMDgx
-NTIxNDgyNlowFDESMBAGA1UEAwwJbG9jYWxob3N0MIIBIjANBgkqhkiG9w0BAQEF
-AAOCAQ8AMIIBCgKCAQEA0vOppiT8zE+076acRORzD5JVbRYKMK3XlWLVrHua4+ct
-Rm54WyP+3XsYU4JGGGKgb8E+u2UosGJYcSM+b+U1/5XPTcpuumS+pCiD9WP++A39
-tsukYwR7m65cgpiI4dlLEZI3EWpAW+Bb3230KiYW4sAmQ0Ih4PrN+oPvzcs86F4d
-9Y03CqVUxRKLBLaClZQAg8qz2Pawwj1FKKjDX7u2fRVR0wgOugpCMOBJMcCgz9pp
-0HSa4x3KZDHEZY7Pah5XwWrCfAEfRWsSTGcNaoN8gSxGFM1JOEJa8SAuPGjFcYIv
-MmVWdw0FXCgYlSDL02fzLE0uyvXBDibzSqOk770JhQIDAQABo1MwUTAdBgNVHQ4E
-FgQUiJ8JLENJ+2k1Xl4o6y2Lc/qHTh0wHwYDVR0jBBgwFoAUiJ8JLENJ+2k1Xl4o
-6y2Lc/qHTh0wDwYDVR0TAQH/BAUwAwEB/zANBgkqhkiG9w0BAQsFAAOCAQEAwjn2
-gnNAhFvh58VqLIjU6ftvn6rhz5B9dg2+XyY8sskLhhkO1nL9339BVZsRt+eI3a7I
-81GNIm9qHVM3MUAcQv3SZy+0UPVUT8DNH2LwHT3CHnYTBP8U+8n8TDNGSTMUhIBB
-Rx+6KwODpwLdI79VGT3IkbU9bZwuepB9I9nM5t/tt5kS4gHmJFlO0aLJFCTO4Scf
-hp/WLPv4XQUH+I3cPfaJRxz2j0Kc8iOzMhFmvl1XOGByjX6X33LnOzY/LVeTSGyS
-VgC32BGtnMwuy5XZYgFAeUx9HKy4tG4OH2Ux6uPF/WAhsug6PXSjV7BK6wYT5i27
-MlascjupnaptKX/wMA==
------END CERTIFICATE-----
-`
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			require.NoError(t, err)
 
-var rsaKeyPEM = testingKey(`-----BEGIN TESTING KEY-----
-MIIEvwIBADANBgkqhkiG9w0BAQEFAASCBKkwggSlAgEAAoIBAQDS86mmJPzMT7Tv
-ppxE5HMPklVtFgowrdeVYtWse5rj5y1GbnhbI/7dexhTgkYYYqBvwT67ZSiwYlhx
-Iz5v5TX/lc9Nym66ZL6kKIP1Y/74Df22y6RjBHubrlyCmIjh2UsRkjcRakBb4Fvf
-bfQqJhbiwCZDQiHg+s36g+/NyzzoXh31jTcKpVTFEosEtoKVlACDyrPY9rDCPUUo
-qMNfu7Z9FVHTCA66CkIw4EkxwKDP2mnQdJrjHcpkMcRljs9qHlfBasJ8AR9FaxJM
-Zw1qg3yBLEYUzUk4QlrxIC48aMVxgi8yZVZ3DQVcKBiVIMvTZ/MsTS7K9cEOJvNK
-o6TvvQmFAgMBAAECggEAKzTK54Ol33bn2TnnwdiElIjlRE2CUswYXrl6iDRc2hbs
-WAOiVRB/T/+5UMla7/2rXJhY7+rdNZs/ABU24ZYxxCJ77jPrD/Q4c8j0lhsgCtBa
-ycjV543wf0dsHTd+ubtWu8eVzdRUUD0YtB+CJevdPh4a+CWgaMMV0xyYzi61T+Yv
-Z7Uc3awIAiT4Kw9JRmJiTnyMJg5vZqW3BBAX4ZIvS/54ipwEU+9sWLcuH7WmCR0B
-QCTqS6hfJDLm//dGC89Iyno57zfYuiT3PYCWH5crr/DH3LqnwlNaOGSBkhkXuIL+
-QvOaUMe2i0pjqxDrkBx05V554vyy9jEvK7i330HL4QKBgQDUJmouEr0+o7EMBApC
-CPPu58K04qY5t9aGciG/pOurN42PF99yNZ1CnynH6DbcnzSl8rjc6Y65tzTlWods
-bjwVfcmcokG7sPcivJvVjrjKpSQhL8xdZwSAjcqjN4yoJ/+ghm9w+SRmZr6oCQZ3
-1jREfJKT+PGiWTEjYcExPWUD2QKBgQD+jdgq4c3tFavU8Hjnlf75xbStr5qu+fp2
-SGLRRbX+msQwVbl2ZM9AJLoX9MTCl7D9zaI3ONhheMmfJ77lDTa3VMFtr3NevGA6
-MxbiCEfRtQpNkJnsqCixLckx3bskj5+IF9BWzw7y7nOzdhoWVFv/+TltTm3RB51G
-McdlmmVjjQKBgQDSFAw2/YV6vtu2O1XxGC591/Bd8MaMBziev+wde3GHhaZfGVPC
-I8dLTpMwCwowpFKdNeLLl1gnHX161I+f1vUWjw4TVjVjaBUBx+VEr2Tb/nXtiwiD
-QV0a883CnGJjreAblKRMKdpasMmBWhaWmn39h6Iad3zHuCzJjaaiXNpn2QKBgQCf
-k1Q8LanmQnuh1c41f7aD5gjKCRezMUpt9BrejhD1NxheJJ9LNQ8nat6uPedLBcUS
-lmJms+AR2qKqf0QQWyQ98YgAtshgTz8TvQtPT1mWgSOgVFHqJdC8obNK63FyDgc4
-TZVxlgQNDqbBjfv0m5XA9f+mIlB9hYR2iKYzb4K30QKBgQC+LEJYZh00zsXttGHr
-5wU1RzbgDIEsNuu+nZ4MxsaCik8ILNRHNXdeQbnADKuo6ATfhdmDIQMVZLG8Mivi
-UwnwLd1GhizvqvLHa3ULnFphRyMGFxaLGV48axTT2ADoMX67ILrIY/yjycLqRZ3T
-z3w+CgS20UrbLIR1YXfqUXge1g==
------END TESTING KEY-----
-`)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
 
-func testingKey(s string) string { return strings.ReplaceAll(s, "TESTING KEY", "PRIVATE KEY") }
+			connString := fmt.Sprintf("sslmode=require sslnegotiation=direct host=%s port=%s", host, port)
+			conn, err := pgconn.Connect(ctx, connString)
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				closeConn(t, conn)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			}
 
-func TestSNISupport(t *testing.T) {
+			require.NoError(t, <-serverErrChan)
+		})
+	}
+}
+
+func TestConnectSSLCertModeRequire(t *testing.T) {
 	t.Parallel()
+
 	tests := []struct {
-		name      string
-		sni_param string
-		sni_set   bool
+		name       string
+		clientAuth tls.ClientAuthType
+		errMsg     string
 	}{
 		{
-			name:      "SNI is passed by default",
-			sni_param: "",
-			sni_set:   true,
-		},
-		{
-			name:      "SNI is passed when asked for",
-			sni_param: "sslsni=1",
-			sni_set:   true,
+			name:       "server requests client certificate",
+			clientAuth: tls.RequestClientCert,
 		},
 		{
-			name:      "SNI is not passed when disabled",
-			sni_param: "sslsni=0",
-			sni_set:   false,
+			name:       "server does not request client certificate",
+			clientAuth: tls.NoClientCert,
+			errMsg:     "server did not request a client certificate",
 		},
 	}
+
+	ca := newTestCA(t)
+	_, clientCertPath, clientKeyPath := ca.issue(t, 2, "pgx_client")
+
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
@@ -2877,13 +4153,12 @@ func TestSNISupport(t *testing.T) {
 			require.NoError(t, err)
 			defer ln.Close()
 
-			serverErrChan := make(chan error, 1)
-			serverSNINameChan := make(chan string, 1)
-			defer close(serverErrChan)
-			defer close(serverSNINameChan)
+			cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+			require.NoError(t, err)
 
+			serverErrChan := make(chan error, 1)
 			go func() {
-				var sniHost string
+				defer close(serverErrChan)
 
 				conn, err := ln.Accept()
 				if err != nil {
@@ -2898,68 +4173,59 @@ func TestSNISupport(t *testing.T) {
 					return
 				}
 
-				backend := pgproto3.NewBackend(conn, conn)
-				startupMessage, err := backend.ReceiveStartupMessage()
+				sslRequest := make([]byte, 8)
+				_, err = io.ReadFull(conn, sslRequest)
 				if err != nil {
 					serverErrChan <- err
 					return
 				}
-
-				switch startupMessage.(type) {
-				case *pgproto3.SSLRequest:
-					_, err = conn.Write([]byte("S"))
-					if err != nil {
-						serverErrChan <- err
-						return
-					}
-				default:
-					serverErrChan <- fmt.Errorf("unexpected startup message: %#v", startupMessage)
-					return
-				}
-
-				cert, err := tls.X509KeyPair([]byte(rsaCertPEM), []byte(rsaKeyPEM))
+				_, err = conn.Write([]byte("S"))
 				if err != nil {
 					serverErrChan <- err
 					return
 				}
 
-				srv := tls.Server(conn, &tls.Config{
+				tlsConn := tls.Server(conn, &tls.Config{
 					Certificates: []tls.Certificate{cert},
-					GetConfigForClient: func(argHello *tls.ClientHelloInfo) (*tls.Config, error) {
-						sniHost = argHello.ServerName
-						return nil, nil
-					},
+					ClientAuth:   tt.clientAuth,
 				})
-				defer srv.Close()
+				err = tlsConn.Handshake()
+				if err != nil {
+					serverErrChan <- fmt.Errorf("handshake: %w", err)
+					return
+				}
 
-				if err := srv.Handshake(); err != nil {
-					serverErrChan <- fmt.Errorf("handshake: %v", err)
+				if tt.errMsg != "" {
 					return
 				}
 
-				srv.Write((&pgproto3.AuthenticationOk{}).Encode(nil))
-				srv.Write((&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}).Encode(nil))
-				srv.Write((&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(nil))
+				if len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+					serverErrChan <- errors.New("client did not send a certificate")
+					return
+				}
 
-				serverSNINameChan <- sniHost
+				script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+				script.Steps = append(script.Steps, pgmock.WaitForClose())
+				serverErrChan <- script.Run(pgproto3.NewBackend(tlsConn, tlsConn))
 			}()
 
-			port := strings.Split(ln.Addr().String(), ":")[1]
-			connStr := fmt.Sprintf("sslmode=require host=localhost port=%s %s", port, tt.sni_param)
-			_, err = pgconn.Connect(context.Background(), connStr)
+			host, port, err := net.SplitHostPort(ln.Addr().String())
+			require.NoError(t, err)
 
-			select {
-			case sniHost := <-serverSNINameChan:
-				if tt.sni_set {
-					require.Equal(t, sniHost, "localhost")
-				} else {
-					require.Equal(t, sniHost, "")
-				}
-			case err = <-serverErrChan:
-				t.Fatalf("server failed with error: %+v", err)
-			case <-time.After(time.Millisecond * 100):
-				t.Fatal("exceeded connection timeout without erroring out")
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			connString := fmt.Sprintf("sslmode=require sslcertmode=require sslcert=%s sslkey=%s host=%s port=%s", clientCertPath, clientKeyPath, host, port)
+			conn, err := pgconn.Connect(ctx, connString)
+			if tt.errMsg == "" {
+				require.NoError(t, err)
+				closeConn(t, conn)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
 			}
+
+			require.NoError(t, <-serverErrChan)
 		})
 	}
 }
@@ -0,0 +1,432 @@
+package pgconn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// ReplicationMode is the kind of walsender a connection is started as. It corresponds to the libpq replication
+// connection parameter.
+type ReplicationMode int
+
+const (
+	// ReplicationModeNone is a normal, non-replication connection.
+	ReplicationModeNone ReplicationMode = iota
+
+	// ReplicationModePhysical is a physical replication connection (replication=true). It can only run replication
+	// commands.
+	ReplicationModePhysical
+
+	// ReplicationModeLogical is a logical replication connection (replication=database). It can run replication
+	// commands and simple protocol SQL against the database named in the connection string.
+	ReplicationModeLogical
+)
+
+func (m ReplicationMode) String() string {
+	switch m {
+	case ReplicationModeNone:
+		return "false"
+	case ReplicationModePhysical:
+		return "true"
+	case ReplicationModeLogical:
+		return "database"
+	default:
+		return fmt.Sprintf("ReplicationMode(%d)", int(m))
+	}
+}
+
+func parseReplicationMode(s string) (ReplicationMode, error) {
+	switch s {
+	case "", "false", "off", "no", "0":
+		return ReplicationModeNone, nil
+	case "true", "on", "yes", "1":
+		return ReplicationModePhysical, nil
+	case "database":
+		return ReplicationModeLogical, nil
+	default:
+		return ReplicationModeNone, fmt.Errorf("unknown replication value: %v", s)
+	}
+}
+
+// IdentifySystemResult is the parsed result of the IDENTIFY_SYSTEM replication command.
+type IdentifySystemResult struct {
+	SystemID string       // unique system identifier of the cluster
+	Timeline int32        // current timeline ID
+	XLogPos  pgproto3.LSN // current WAL flush location
+	DBName   string       // database connected to or empty for a physical replication connection
+}
+
+// IdentifySystem requests the server to identify itself. pgConn must be a replication connection.
+func (pgConn *PgConn) IdentifySystem(ctx context.Context) (IdentifySystemResult, error) {
+	var isr IdentifySystemResult
+
+	result, err := pgConn.execReplicationCommand(ctx, "IDENTIFY_SYSTEM")
+	if err != nil {
+		return isr, err
+	}
+
+	if len(result.Rows) != 1 || len(result.Rows[0]) != 4 {
+		return isr, errors.New("expected 1 row with 4 fields from IDENTIFY_SYSTEM")
+	}
+
+	row := result.Rows[0]
+	isr.SystemID = string(row[0])
+	timeline, err := strconv.ParseInt(string(row[1]), 10, 32)
+	if err != nil {
+		return isr, fmt.Errorf("failed to parse timeline: %w", err)
+	}
+	isr.Timeline = int32(timeline)
+	isr.XLogPos, err = pgproto3.ParseLSN(string(row[2]))
+	if err != nil {
+		return isr, fmt.Errorf("failed to parse xlogpos: %w", err)
+	}
+	isr.DBName = string(row[3])
+
+	return isr, nil
+}
+
+// CreateReplicationSlotOptions are the options for the CREATE_REPLICATION_SLOT replication command.
+type CreateReplicationSlotOptions struct {
+	Temporary bool
+	Mode      ReplicationMode // ReplicationModePhysical or ReplicationModeLogical
+
+	// SnapshotAction is one of EXPORT_SNAPSHOT, NOEXPORT_SNAPSHOT or USE_SNAPSHOT. It is only valid for logical slots.
+	// If empty the server default is used.
+	SnapshotAction string
+}
+
+// CreateReplicationSlotResult is the parsed result of the CREATE_REPLICATION_SLOT replication command.
+type CreateReplicationSlotResult struct {
+	SlotName        string
+	ConsistentPoint pgproto3.LSN // WAL location at which the slot became consistent
+	SnapshotName    string       // exported snapshot name, only present for logical slots
+	OutputPlugin    string       // output plugin, only present for logical slots
+}
+
+// CreateReplicationSlot creates a replication slot named slotName. outputPlugin is required for logical slots and
+// ignored for physical slots. slotName and outputPlugin are quoted as identifiers.
+func (pgConn *PgConn) CreateReplicationSlot(ctx context.Context, slotName, outputPlugin string, options CreateReplicationSlotOptions) (CreateReplicationSlotResult, error) {
+	var crsr CreateReplicationSlotResult
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "CREATE_REPLICATION_SLOT %s", quoteIdentifier(slotName))
+	if options.Temporary {
+		sb.WriteString(" TEMPORARY")
+	}
+	switch options.Mode {
+	case ReplicationModePhysical:
+		sb.WriteString(" PHYSICAL")
+	case ReplicationModeLogical:
+		fmt.Fprintf(sb, " LOGICAL %s", quoteIdentifier(outputPlugin))
+		if options.SnapshotAction != "" {
+			fmt.Fprintf(sb, " %s", options.SnapshotAction)
+		}
+	default:
+		return crsr, fmt.Errorf("invalid replication slot mode: %v", options.Mode)
+	}
+
+	result, err := pgConn.execReplicationCommand(ctx, sb.String())
+	if err != nil {
+		return crsr, err
+	}
+
+	if len(result.Rows) != 1 || len(result.Rows[0]) != 4 {
+		return crsr, errors.New("expected 1 row with 4 fields from CREATE_REPLICATION_SLOT")
+	}
+
+	row := result.Rows[0]
+	crsr.SlotName = string(row[0])
+	crsr.ConsistentPoint, err = pgproto3.ParseLSN(string(row[1]))
+	if err != nil {
+		return crsr, fmt.Errorf("failed to parse consistent_point: %w", err)
+	}
+	crsr.SnapshotName = string(row[2])
+	crsr.OutputPlugin = string(row[3])
+
+	return crsr, nil
+}
+
+// DropReplicationSlot drops the replication slot named slotName. If wait is true and the slot is active the command
+// waits until the slot becomes inactive instead of returning an error. slotName is quoted as an identifier.
+func (pgConn *PgConn) DropReplicationSlot(ctx context.Context, slotName string, wait bool) error {
+	sql := fmt.Sprintf("DROP_REPLICATION_SLOT %s", quoteIdentifier(slotName))
+	if wait {
+		sql += " WAIT"
+	}
+
+	_, err := pgConn.execReplicationCommand(ctx, sql)
+	return err
+}
+
+// execReplicationCommand executes a single replication command with the simple protocol and returns its result.
+func (pgConn *PgConn) execReplicationCommand(ctx context.Context, sql string) (*Result, error) {
+	results, err := pgConn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) != 1 {
+		return nil, fmt.Errorf("expected 1 result set, got %d", len(results))
+	}
+
+	return results[0], nil
+}
+
+// StartReplicationOptions are the options for the START_REPLICATION replication command.
+type StartReplicationOptions struct {
+	Mode     ReplicationMode // ReplicationModePhysical or ReplicationModeLogical
+	Timeline int32           // physical replication only; 0 means the server's current timeline
+
+	// PluginArgs are passed to the output plugin of a logical slot. Each element must already be formatted as
+	// `name 'value'` or `"name" 'value'`. They are inserted into the command as raw SQL without any quoting or
+	// validation, so they must not contain untrusted input.
+	PluginArgs []string
+}
+
+// StartReplication starts streaming WAL from startLSN (0 to start from the slot's confirmed position) with the
+// START_REPLICATION replication command. slotName may be empty for physical replication without a slot. It is quoted as
+// an identifier.
+//
+// On success the connection is in copy both mode and remains busy until the returned *ReplicationStream is closed. No
+// other methods may be called on pgConn except CancelRequest and Close.
+func (pgConn *PgConn) StartReplication(ctx context.Context, slotName string, startLSN pgproto3.LSN, options StartReplicationOptions) (*ReplicationStream, error) {
+	sb := &strings.Builder{}
+	sb.WriteString("START_REPLICATION")
+	if slotName != "" {
+		fmt.Fprintf(sb, " SLOT %s", quoteIdentifier(slotName))
+	}
+	switch options.Mode {
+	case ReplicationModePhysical:
+		fmt.Fprintf(sb, " PHYSICAL %s", startLSN)
+		if options.Timeline > 0 {
+			fmt.Fprintf(sb, " TIMELINE %d", options.Timeline)
+		}
+	case ReplicationModeLogical:
+		if slotName == "" {
+			return nil, errors.New("logical replication requires a slot name")
+		}
+		fmt.Fprintf(sb, " LOGICAL %s", startLSN)
+		if len(options.PluginArgs) > 0 {
+			fmt.Fprintf(sb, " (%s)", strings.Join(options.PluginArgs, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("invalid replication mode: %v", options.Mode)
+	}
+
+	if err := pgConn.lock(); err != nil {
+		return nil, err
+	}
+
+	if ctx != context.Background() {
+		select {
+		case <-ctx.Done():
+			pgConn.unlock()
+			return nil, newContextAlreadyDoneError(ctx)
+		default:
+		}
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	pgConn.frontend.SendQuery(&pgproto3.Query{String: sb.String()})
+	err := pgConn.frontend.Flush()
+	if err != nil {
+		pgConn.asyncClose()
+		return nil, err
+	}
+
+	var pgErr error
+	for {
+		msg, err := pgConn.receiveMessage()
+		if err != nil {
+			pgConn.asyncClose()
+			return nil, normalizeTimeoutError(ctx, err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			return &ReplicationStream{pgConn: pgConn}, nil
+		case *pgproto3.ErrorResponse:
+			pgErr = ErrorResponseToPgError(msg)
+		case *pgproto3.ReadyForQuery:
+			pgConn.unlock()
+			if pgErr == nil {
+				pgErr = errors.New("server did not enter copy both mode")
+			}
+			return nil, pgErr
+		}
+	}
+}
+
+// ReplicationStream is a replication connection in copy both mode. CopyData messages can be received from and sent
+// to the server until either side ends the stream.
+type ReplicationStream struct {
+	pgConn *PgConn
+
+	serverDone bool // the server has sent CopyDone
+	clientDone bool // the client has sent CopyDone
+	closed     bool
+	err        error
+}
+
+// ReceiveCopyData receives the payload of the next CopyData message from the server. The returned slice is only valid
+// until the next call to ReceiveCopyData. io.EOF is returned when the server has ended the stream. Close must still be
+// called to return the connection to normal mode.
+//
+// If ctx reaches its deadline while waiting for a message a timeout error is returned, but the stream remains usable.
+// This allows waiting for data with a timeout and periodically sending status updates. Any other error closes the
+// connection.
+func (rs *ReplicationStream) ReceiveCopyData(ctx context.Context) ([]byte, error) {
+	if rs.closed {
+		return nil, rs.closedErr()
+	}
+	if rs.serverDone {
+		return nil, io.EOF
+	}
+
+	pgConn := rs.pgConn
+	if ctx != context.Background() {
+		select {
+		case <-ctx.Done():
+			return nil, newContextAlreadyDoneError(ctx)
+		default:
+		}
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	for {
+		msg, err := pgConn.receiveMessage()
+		if err != nil {
+			err = normalizeTimeoutError(ctx, err)
+			if !Timeout(err) {
+				rs.closed = true
+				rs.err = err
+				pgConn.asyncClose()
+			}
+			return nil, err
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			return msg.Data, nil
+		case *pgproto3.CopyDone:
+			rs.serverDone = true
+			return nil, io.EOF
+		case *pgproto3.ErrorResponse:
+			rs.err = ErrorResponseToPgError(msg)
+			rs.serverDone = true
+			rs.clientDone = true
+			return nil, rs.err
+		}
+	}
+}
+
+// SendCopyData sends data to the server in a CopyData message. See SendStandbyStatusUpdate for the most common case.
+func (rs *ReplicationStream) SendCopyData(ctx context.Context, data []byte) error {
+	if rs.closed {
+		return rs.closedErr()
+	}
+	if rs.clientDone {
+		return errors.New("replication stream already ended by client")
+	}
+
+	pgConn := rs.pgConn
+	if ctx != context.Background() {
+		select {
+		case <-ctx.Done():
+			return newContextAlreadyDoneError(ctx)
+		default:
+		}
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	pgConn.frontend.Send(&pgproto3.CopyData{Data: data})
+	err := pgConn.frontend.Flush()
+	if err != nil {
+		rs.closed = true
+		rs.err = normalizeTimeoutError(ctx, err)
+		pgConn.asyncClose()
+		return rs.err
+	}
+
+	return nil
+}
+
+// SendStandbyStatusUpdate reports the client's WAL positions to the server. If msg.ClientTime is zero the current time
+// is used.
+func (rs *ReplicationStream) SendStandbyStatusUpdate(ctx context.Context, msg pgproto3.StandbyStatusUpdate) error {
+	if msg.ClientTime.IsZero() {
+		msg.ClientTime = time.Now()
+	}
+	return rs.SendCopyData(ctx, msg.Encode(nil))
+}
+
+// Close ends the replication stream by sending CopyDone, discards any remaining data from the server, and returns
+// the connection to normal mode. It returns the first error reported by the server, if any.
+func (rs *ReplicationStream) Close(ctx context.Context) error {
+	if rs.closed {
+		return rs.err
+	}
+	rs.closed = true
+
+	pgConn := rs.pgConn
+	if ctx != context.Background() {
+		select {
+		case <-ctx.Done():
+			pgConn.asyncClose()
+			return newContextAlreadyDoneError(ctx)
+		default:
+		}
+		pgConn.contextWatcher.Watch(ctx)
+		defer pgConn.contextWatcher.Unwatch()
+	}
+
+	if !rs.clientDone {
+		rs.clientDone = true
+		pgConn.frontend.Send(&pgproto3.CopyDone{})
+		err := pgConn.frontend.Flush()
+		if err != nil {
+			pgConn.asyncClose()
+			return normalizeTimeoutError(ctx, err)
+		}
+	}
+
+	for {
+		msg, err := pgConn.receiveMessage()
+		if err != nil {
+			pgConn.asyncClose()
+			return normalizeTimeoutError(ctx, err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.ErrorResponse:
+			if rs.err == nil {
+				rs.err = ErrorResponseToPgError(msg)
+			}
+		case *pgproto3.ReadyForQuery:
+			pgConn.unlock()
+			return rs.err
+		}
+	}
+}
+
+func (rs *ReplicationStream) closedErr() error {
+	if rs.err != nil {
+		return rs.err
+	}
+	return errors.New("replication stream closed")
+}
+
+// quoteIdentifier returns s quoted as an identifier of a replication command.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,190 @@
+package pgconn_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicationStream(t *testing.T) {
+	t.Parallel()
+
+	textField := func(name string) pgproto3.FieldDescription {
+		return pgproto3.FieldDescription{Name: []byte(name), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}
+	}
+
+	script := &pgmock.Script{
+		Steps: []pgmock.Step{
+			pgmock.ExpectMessage(&pgproto3.StartupMessage{
+				ProtocolVersion: pgproto3.ProtocolVersionNumber,
+				Parameters:      map[string]string{"user": "jack", "database": "mydb", "replication": "database"},
+			}),
+			pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+			pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+			pgmock.ExpectMessage(&pgproto3.Query{String: "IDENTIFY_SYSTEM"}),
+			pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+				textField("systemid"), textField("timeline"), textField("xlogpos"), textField("dbname"),
+			}}),
+			pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{
+				[]byte("7146587034128015329"), []byte("1"), []byte("16/B374D848"), []byte("mydb"),
+			}}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("IDENTIFY_SYSTEM")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+			pgmock.ExpectMessage(&pgproto3.Query{String: "START_REPLICATION SLOT \"myslot\" LOGICAL 16/B374D848 (\"proto_version\" '1')"}),
+			pgmock.SendMessage(&pgproto3.CopyBothResponse{}),
+			pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("from server")}),
+			pgmock.ExpectMessage(&pgproto3.CopyData{Data: []byte("from client")}),
+			pgmock.SendMessage(&pgproto3.CopyDone{}),
+			pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("START_REPLICATION")}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+			pgmock.ExpectMessage(&pgproto3.Terminate{}),
+		},
+	}
+
+	connString, serverErrChan := startMockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString+" user=jack dbname=mydb replication=database")
+	require.NoError(t, err)
+
+	isr, err := pgConn.IdentifySystem(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "7146587034128015329", isr.SystemID)
+	assert.EqualValues(t, 1, isr.Timeline)
+	assert.Equal(t, "16/B374D848", isr.XLogPos.String())
+	assert.Equal(t, "mydb", isr.DBName)
+
+	stream, err := pgConn.StartReplication(ctx, "myslot", isr.XLogPos, pgconn.StartReplicationOptions{
+		Mode:       pgconn.ReplicationModeLogical,
+		PluginArgs: []string{`"proto_version" '1'`},
+	})
+	require.NoError(t, err)
+	assert.True(t, pgConn.IsBusy())
+
+	data, err := stream.ReceiveCopyData(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "from server", string(data))
+
+	err = stream.SendCopyData(ctx, []byte("from client"))
+	require.NoError(t, err)
+
+	_, err = stream.ReceiveCopyData(ctx)
+	require.ErrorIs(t, err, io.EOF)
+
+	err = stream.Close(ctx)
+	require.NoError(t, err)
+	assert.False(t, pgConn.IsBusy())
+
+	closeConn(t, pgConn)
+	require.NoError(t, <-serverErrChan)
+}
+
+func TestReplicationStreamReceiveTimeoutDoesNotCloseConn(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "START_REPLICATION PHYSICAL 0/0"}),
+		pgmock.SendMessage(&pgproto3.CopyBothResponse{}),
+		pgmockWaitStep(100*time.Millisecond),
+		pgmock.SendMessage(&pgproto3.CopyData{Data: []byte("late")}),
+		pgmock.ExpectMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CopyDone{}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("START_REPLICATION")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := startMockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString+" replication=true")
+	require.NoError(t, err)
+
+	stream, err := pgConn.StartReplication(ctx, "", 0, pgconn.StartReplicationOptions{Mode: pgconn.ReplicationModePhysical})
+	require.NoError(t, err)
+
+	shortCtx, shortCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	_, err = stream.ReceiveCopyData(shortCtx)
+	shortCancel()
+	require.True(t, pgconn.Timeout(err), err)
+	require.False(t, pgConn.IsClosed())
+
+	data, err := stream.ReceiveCopyData(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "late", string(data))
+
+	require.NoError(t, stream.Close(ctx))
+
+	closeConn(t, pgConn)
+	require.NoError(t, <-serverErrChan)
+}
+
+func TestReplicationSlotCommandsQuoteIdentifiers(t *testing.T) {
+	t.Parallel()
+
+	textField := func(name string) pgproto3.FieldDescription {
+		return pgproto3.FieldDescription{Name: []byte(name), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}
+	}
+
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: `CREATE_REPLICATION_SLOT "my""slot; DROP" TEMPORARY LOGICAL "pgoutput"`}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{
+			textField("slot_name"), textField("consistent_point"), textField("snapshot_name"), textField("output_plugin"),
+		}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{
+			[]byte(`my"slot; DROP`), []byte("16/B374D848"), nil, []byte("pgoutput"),
+		}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("CREATE_REPLICATION_SLOT")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectMessage(&pgproto3.Query{String: `DROP_REPLICATION_SLOT "my""slot; DROP" WAIT`}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("DROP_REPLICATION_SLOT")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	connString, serverErrChan := startMockServer(t, script)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pgConn, err := pgconn.Connect(ctx, connString+" replication=database")
+	require.NoError(t, err)
+
+	crsr, err := pgConn.CreateReplicationSlot(ctx, `my"slot; DROP`, "pgoutput", pgconn.CreateReplicationSlotOptions{
+		Temporary: true,
+		Mode:      pgconn.ReplicationModeLogical,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `my"slot; DROP`, crsr.SlotName)
+	assert.Equal(t, "16/B374D848", crsr.ConsistentPoint.String())
+
+	err = pgConn.DropReplicationSlot(ctx, `my"slot; DROP`, true)
+	require.NoError(t, err)
+
+	closeConn(t, pgConn)
+	require.NoError(t, <-serverErrChan)
+}
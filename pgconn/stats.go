@@ -0,0 +1,146 @@
+package pgconn
+
+import (
+	"encoding/binary"
+	"io"
+	"sync/atomic"
+)
+
+// ConnStats are cumulative wire-level statistics for a PgConn. Bytes and messages are counted at the protocol level.
+// That is, TLS overhead is not included and messages exchanged before TLS negotiation such as SSLRequest are not
+// counted.
+type ConnStats struct {
+	BytesSent        int64
+	BytesReceived    int64
+	MessagesSent     int64
+	MessagesReceived int64
+
+	// RoundTrips is the number of times data was received from the server after sending data to the server. Writes not
+	// separated by a read, such as the queued requests of a pipeline, are part of the same round trip.
+	RoundTrips int64
+}
+
+// Stats returns the wire-level statistics of pgConn. It is safe to call concurrently with other methods.
+func (pgConn *PgConn) Stats() ConnStats {
+	s := pgConn.wireStats
+	if s == nil {
+		return ConnStats{}
+	}
+
+	return ConnStats{
+		BytesSent:        atomic.LoadInt64(&s.bytesSent),
+		BytesReceived:    atomic.LoadInt64(&s.bytesReceived),
+		MessagesSent:     atomic.LoadInt64(&s.messagesSent),
+		MessagesReceived: atomic.LoadInt64(&s.messagesReceived),
+		RoundTrips:       atomic.LoadInt64(&s.roundTrips),
+	}
+}
+
+// wireStats holds the counters of a PgConn. It is allocated separately from PgConn so the 64 bit fields accessed with
+// atomics are aligned on 32-bit architectures. See BUGS section of https://pkg.go.dev/sync/atomic.
+type wireStats struct {
+	bytesSent        int64
+	bytesReceived    int64
+	messagesSent     int64
+	messagesReceived int64
+	roundTrips       int64
+
+	wroteSinceRead int32
+
+	// sent and received are only used by Write and Read respectively. Read and Write may be called concurrently.
+	sent     messageCounter
+	received messageCounter
+}
+
+// newWireStats returns a wireStats for a connection whose first sent message is the untyped startup message.
+func newWireStats() *wireStats {
+	return &wireStats{sent: messageCounter{untyped: true}}
+}
+
+// statsReader counts the bytes and messages read from r.
+type statsReader struct {
+	r io.Reader
+	s *wireStats
+}
+
+func (sr *statsReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	if n > 0 {
+		s := sr.s
+		if atomic.CompareAndSwapInt32(&s.wroteSinceRead, 1, 0) {
+			atomic.AddInt64(&s.roundTrips, 1)
+		}
+		atomic.AddInt64(&s.bytesReceived, int64(n))
+		if messages := s.received.count(p[:n]); messages > 0 {
+			atomic.AddInt64(&s.messagesReceived, messages)
+		}
+	}
+	return n, err
+}
+
+// statsWriter counts the bytes and messages written to w.
+type statsWriter struct {
+	w io.Writer
+	s *wireStats
+}
+
+func (sw *statsWriter) Write(p []byte) (int, error) {
+	n, err := sw.w.Write(p)
+	if n > 0 {
+		s := sw.s
+		atomic.StoreInt32(&s.wroteSinceRead, 1)
+		atomic.AddInt64(&s.bytesSent, int64(n))
+		if messages := s.sent.count(p[:n]); messages > 0 {
+			atomic.AddInt64(&s.messagesSent, messages)
+		}
+	}
+	return n, err
+}
+
+// messageCounter counts the messages in a stream of protocol messages that may be split at any point.
+type messageCounter struct {
+	untyped   bool // the next message has no type byte (i.e. it is a startup message)
+	header    [5]byte
+	headerLen int
+	remaining int // bytes of the current message body not yet seen
+}
+
+func (mc *messageCounter) count(buf []byte) int64 {
+	var messages int64
+
+	for len(buf) > 0 {
+		if mc.remaining > 0 {
+			n := mc.remaining
+			if n > len(buf) {
+				n = len(buf)
+			}
+			buf = buf[n:]
+			mc.remaining -= n
+			continue
+		}
+
+		headerSize := 5
+		if mc.untyped {
+			headerSize = 4
+		}
+
+		n := copy(mc.header[mc.headerLen:headerSize], buf)
+		buf = buf[n:]
+		mc.headerLen += n
+		if mc.headerLen < headerSize {
+			break
+		}
+
+		// The message length includes itself but not the type byte.
+		length := int(int32(binary.BigEndian.Uint32(mc.header[headerSize-4 : headerSize])))
+		mc.remaining = length - 4
+		if mc.remaining < 0 {
+			mc.remaining = 0
+		}
+		mc.headerLen = 0
+		mc.untyped = false
+		messages++
+	}
+
+	return messages
+}
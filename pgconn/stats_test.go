@@ -0,0 +1,34 @@
+package pgconn
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCounterSplitStream(t *testing.T) {
+	t.Parallel()
+
+	var buf []byte
+	buf = (&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{"user": "jack"}}).Encode(buf)
+	buf = (&pgproto3.Query{String: "select 1"}).Encode(buf)
+	buf = (&pgproto3.Sync{}).Encode(buf)
+	buf = (&pgproto3.CopyData{Data: make([]byte, 1000)}).Encode(buf)
+	buf = (&pgproto3.Terminate{}).Encode(buf)
+
+	for _, chunkSize := range []int{1, 2, 3, 5, 7, 64, len(buf)} {
+		mc := &messageCounter{untyped: true}
+		var messages int64
+		for i := 0; i < len(buf); i += chunkSize {
+			end := i + chunkSize
+			if end > len(buf) {
+				end = len(buf)
+			}
+			messages += mc.count(buf[i:end])
+		}
+		assert.EqualValuesf(t, 5, messages, "chunk size %d", chunkSize)
+		assert.Zerof(t, mc.remaining, "chunk size %d", chunkSize)
+		assert.Zerof(t, mc.headerLen, "chunk size %d", chunkSize)
+	}
+}
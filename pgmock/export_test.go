@@ -0,0 +1,11 @@
+// File export_test exports some methods for better testing.
+
+package pgmock
+
+func RecordedMessageTypes() []string {
+	names := make([]string, 0, len(recordedMessageTypes))
+	for name := range recordedMessageTypes {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,292 @@
+package pgmock
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Column describes a column of a ResultSet.
+type Column struct {
+	Name        string
+	DataTypeOID uint32
+}
+
+// ResultSet is a canned query result. The values in Rows are encoded with a *pgtype.Map in the format requested by the
+// client.
+type ResultSet struct {
+	Columns []Column
+	Rows    [][]any
+
+	// CommandTag is sent in the CommandComplete message. If empty, "SELECT n" is sent where n is the number of rows.
+	CommandTag string
+}
+
+func (rs *ResultSet) rowDescription(formats []int16) (pgproto3.BackendMessage, error) {
+	if len(rs.Columns) == 0 {
+		return &pgproto3.NoData{}, nil
+	}
+
+	formats, err := pgproto3.ExpandFormatCodes(formats, len(rs.Columns))
+	if err != nil {
+		return nil, fmt.Errorf("bind result formats: %w", err)
+	}
+
+	fields := make([]pgproto3.FieldDescription, len(rs.Columns))
+	for i, c := range rs.Columns {
+		fields[i] = pgproto3.FieldDescription{
+			Name:         []byte(c.Name),
+			DataTypeOID:  c.DataTypeOID,
+			DataTypeSize: -1,
+			TypeModifier: -1,
+			Format:       formats[i],
+		}
+	}
+
+	return &pgproto3.RowDescription{Fields: fields}, nil
+}
+
+func (rs *ResultSet) dataRows(m *pgtype.Map, formats []int16) ([]pgproto3.BackendMessage, error) {
+	formats, err := pgproto3.ExpandFormatCodes(formats, len(rs.Columns))
+	if err != nil {
+		return nil, fmt.Errorf("bind result formats: %w", err)
+	}
+
+	msgs := make([]pgproto3.BackendMessage, 0, len(rs.Rows))
+	for _, row := range rs.Rows {
+		if len(row) != len(rs.Columns) {
+			return nil, fmt.Errorf("row has %d values but result set has %d columns", len(row), len(rs.Columns))
+		}
+
+		values := make([][]byte, len(row))
+		for i, v := range row {
+			buf, err := m.Encode(rs.Columns[i].DataTypeOID, formats[i], v, []byte{})
+			if err != nil {
+				return nil, err
+			}
+			values[i] = buf
+		}
+		msgs = append(msgs, &pgproto3.DataRow{Values: values})
+	}
+
+	return msgs, nil
+}
+
+func (rs *ResultSet) commandComplete() *pgproto3.CommandComplete {
+	commandTag := rs.CommandTag
+	if commandTag == "" {
+		commandTag = fmt.Sprintf("SELECT %d", len(rs.Rows))
+	}
+
+	return &pgproto3.CommandComplete{CommandTag: []byte(commandTag)}
+}
+
+// Query describes a query served by ExtendedQuery.
+type Query struct {
+	// SQL is the expected query text. If empty, any query is accepted.
+	SQL string
+
+	// ParamOIDs are the parameter types described to the client. If nil, the types in the Parse message are used. If the
+	// Parse message does not specify any types, the types are inferred from Args.
+	ParamOIDs []uint32
+
+	// Args are the expected parameter values. If not nil, they are encoded in the format requested by the client and
+	// compared with the values in the Bind message.
+	Args []any
+
+	// Result is the result of executing the query. If nil, the query returns no rows.
+	Result *ResultSet
+
+	// Map encodes Args and Result. If nil, pgtype.NewMap() is used.
+	Map *pgtype.Map
+}
+
+// preparedStatement is a statement created by a Parse message. It is remembered for the rest of the script so later
+// Bind messages can refer to it by name.
+type preparedStatement struct {
+	sql       string
+	paramOIDs []uint32
+}
+
+type extendedQueryStep struct {
+	q *Query
+}
+
+// ExtendedQuery returns a step that serves q with the extended protocol. It handles Parse, Bind, Describe, Execute,
+// and Sync messages as PostgreSQL would, so it serves the messages sent by pgconn.PgConn.ExecParams and ExecPrepared
+// and by pgx.Conn.Query in any query exec mode other than the simple protocol. It is complete after the Sync message
+// that follows an Execute message. Statements created by Parse messages are remembered for the rest of the Script so a
+// later ExtendedQuery for a statement that was prepared earlier checks SQL too.
+func ExtendedQuery(q *Query) Step {
+	return &extendedQueryStep{q: q}
+}
+
+func (e *extendedQueryStep) Step(backend *pgproto3.Backend) error {
+	return e.run(backend, &scriptState{})
+}
+
+func (e *extendedQueryStep) run(backend *pgproto3.Backend, st *scriptState) error {
+	m := e.q.Map
+	if m == nil {
+		m = pgtype.NewMap()
+	}
+
+	result := e.q.Result
+	if result == nil {
+		result = &ResultSet{}
+	}
+
+	if st.statements == nil {
+		st.statements = make(map[string]*preparedStatement)
+	}
+
+	var resultFormats []int16
+	executed := false
+
+	for {
+		msg, err := st.receive(backend)
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.Parse:
+			if e.q.SQL != "" && msg.Query != e.q.SQL {
+				return fmt.Errorf("parse query => %q, want %q", msg.Query, e.q.SQL)
+			}
+
+			paramOIDs, err := e.paramOIDs(m, msg.ParameterOIDs)
+			if err != nil {
+				return err
+			}
+			st.statements[msg.Name] = &preparedStatement{sql: msg.Query, paramOIDs: paramOIDs}
+			backend.Send(&pgproto3.ParseComplete{})
+
+		case *pgproto3.Bind:
+			ps, ok := st.statements[msg.PreparedStatement]
+			if !ok {
+				return fmt.Errorf("bind to unknown prepared statement %q", msg.PreparedStatement)
+			}
+			if e.q.SQL != "" && ps.sql != e.q.SQL {
+				return fmt.Errorf("bind query => %q, want %q", ps.sql, e.q.SQL)
+			}
+
+			err := e.checkArgs(m, ps, msg)
+			if err != nil {
+				return err
+			}
+
+			resultFormats = append([]int16(nil), msg.ResultFormatCodes...)
+			backend.Send(&pgproto3.BindComplete{})
+
+		case *pgproto3.Describe:
+			switch msg.ObjectType {
+			case 'S':
+				ps, ok := st.statements[msg.Name]
+				if !ok {
+					return fmt.Errorf("describe unknown prepared statement %q", msg.Name)
+				}
+				backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: ps.paramOIDs})
+				rd, err := result.rowDescription(nil)
+				if err != nil {
+					return err
+				}
+				backend.Send(rd)
+			case 'P':
+				rd, err := result.rowDescription(resultFormats)
+				if err != nil {
+					return err
+				}
+				backend.Send(rd)
+			default:
+				return fmt.Errorf("describe unknown object type %q", msg.ObjectType)
+			}
+
+		case *pgproto3.Execute:
+			dataRows, err := result.dataRows(m, resultFormats)
+			if err != nil {
+				return err
+			}
+			for _, dr := range dataRows {
+				backend.Send(dr)
+			}
+			backend.Send(result.commandComplete())
+			executed = true
+
+		case *pgproto3.Sync:
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			err := backend.Flush()
+			if err != nil {
+				return err
+			}
+			if executed {
+				return nil
+			}
+
+		default:
+			return fmt.Errorf("unexpected msg => %#v", msg)
+		}
+	}
+}
+
+// paramOIDs returns the parameter types to describe for a statement parsed with parseOIDs.
+func (e *extendedQueryStep) paramOIDs(m *pgtype.Map, parseOIDs []uint32) ([]uint32, error) {
+	if e.q.ParamOIDs != nil {
+		return e.q.ParamOIDs, nil
+	}
+
+	if len(parseOIDs) > 0 {
+		return append([]uint32(nil), parseOIDs...), nil
+	}
+
+	paramOIDs := make([]uint32, len(e.q.Args))
+	for i, arg := range e.q.Args {
+		t, ok := m.TypeForValue(arg)
+		if !ok {
+			return nil, fmt.Errorf("cannot infer parameter type of arg %d (%T)", i, arg)
+		}
+		paramOIDs[i] = t.OID
+	}
+
+	return paramOIDs, nil
+}
+
+func (e *extendedQueryStep) checkArgs(m *pgtype.Map, ps *preparedStatement, bind *pgproto3.Bind) error {
+	if e.q.Args == nil {
+		return nil
+	}
+
+	if len(bind.Parameters) != len(e.q.Args) {
+		return fmt.Errorf("bind has %d parameters, want %d", len(bind.Parameters), len(e.q.Args))
+	}
+
+	formats, err := pgproto3.ExpandFormatCodes(bind.ParameterFormatCodes, len(bind.Parameters))
+	if err != nil {
+		return fmt.Errorf("bind parameter formats: %w", err)
+	}
+
+	for i, arg := range e.q.Args {
+		var oid uint32
+		if i < len(ps.paramOIDs) && ps.paramOIDs[i] != 0 {
+			oid = ps.paramOIDs[i]
+		} else if t, ok := m.TypeForValue(arg); ok {
+			oid = t.OID
+		} else {
+			return fmt.Errorf("cannot infer parameter type of arg %d (%T)", i, arg)
+		}
+
+		want, err := m.Encode(oid, formats[i], arg, []byte{})
+		if err != nil {
+			return err
+		}
+
+		got := bind.Parameters[i]
+		if (want == nil) != (got == nil) || !bytes.Equal(want, got) {
+			return fmt.Errorf("bind parameter %d => %q, want %q", i, got, want)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,339 @@
+// Package pgmock provides the ability to mock a PostgreSQL server.
+//
+// A Script is a sequence of Steps that is run against a *pgproto3.Backend. Steps expect messages from the client (e.g.
+// ExpectMessage), send messages to the client (e.g. SendMessage), or serve a whole exchange (e.g. ExtendedQuery).
+// Optional and Unordered relax the order in which expected messages must arrive.
+//
+// A script can be served over TCP with NewServer or in memory with Pipe. This allows testing code built on pgconn or
+// pgx without a PostgreSQL server:
+//
+//	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+//	script.Steps = append(script.Steps, pgmock.ExtendedQuery(&pgmock.Query{
+//		SQL:    "select name from users where id=$1",
+//		Args:   []any{int32(1)},
+//		Result: &pgmock.ResultSet{Columns: []pgmock.Column{{Name: "name", DataTypeOID: pgtype.TextOID}}, Rows: [][]any{{"Jack"}}},
+//	}))
+//	script.Steps = append(script.Steps, pgmock.WaitForClose())
+//
+//	server, err := pgmock.NewServer(script)
+//	if err != nil {
+//		return err
+//	}
+//	defer server.Close()
+//
+//	conn, err := pgx.Connect(ctx, server.ConnString())
+//	...
+//	err = server.Wait()
+//
+// Scripts can also be recorded from a session with a real server. Use Recorder.BuildFrontend as
+// pgconn.Config.BuildFrontend to record the session and ReplayScripts to build scripts that replay it.
+package pgmock
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+type Step interface {
+	Step(*pgproto3.Backend) error
+}
+
+// messageStep is a Step that is satisfied by a single message from the frontend. Only message steps can be used with
+// Optional and Unordered.
+type messageStep interface {
+	Step
+	match(msg pgproto3.FrontendMessage) error
+}
+
+// scriptStep is a Step that needs the state of the script it is run by.
+type scriptStep interface {
+	Step
+	run(backend *pgproto3.Backend, st *scriptState) error
+}
+
+type Script struct {
+	Steps []Step
+}
+
+// scriptState is shared by a script and the scripts nested in it while they are run.
+type scriptState struct {
+	// pending is a message received by an Optional step that did not match it. It is used by the next step instead of
+	// receiving a message.
+	pending pgproto3.FrontendMessage
+
+	// statements are the prepared statements created by ExtendedQuery steps, by name.
+	statements map[string]*preparedStatement
+}
+
+func (st *scriptState) receive(backend *pgproto3.Backend) (pgproto3.FrontendMessage, error) {
+	if st.pending != nil {
+		msg := st.pending
+		st.pending = nil
+		return msg, nil
+	}
+
+	return backend.Receive()
+}
+
+func (s *Script) Run(backend *pgproto3.Backend) error {
+	st := &scriptState{}
+	err := s.run(backend, st)
+	if err != nil {
+		return err
+	}
+
+	if st.pending != nil {
+		return fmt.Errorf("unexpected msg => %#v", st.pending)
+	}
+
+	return nil
+}
+
+func (s *Script) run(backend *pgproto3.Backend, st *scriptState) error {
+	for _, step := range s.Steps {
+		var err error
+		switch step := step.(type) {
+		case scriptStep:
+			err = step.run(backend, st)
+		case messageStep:
+			var msg pgproto3.FrontendMessage
+			msg, err = st.receive(backend)
+			if err == nil {
+				err = step.match(msg)
+			}
+		case *sendMessageStep:
+			err = step.Step(backend)
+		default:
+			if st.pending != nil {
+				return fmt.Errorf("unexpected msg => %#v", st.pending)
+			}
+			err = step.Step(backend)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Script) Step(backend *pgproto3.Backend) error {
+	return s.Run(backend)
+}
+
+type expectMessageStep struct {
+	want pgproto3.FrontendMessage
+	any  bool
+}
+
+func (e *expectMessageStep) Step(backend *pgproto3.Backend) error {
+	msg, err := backend.Receive()
+	if err != nil {
+		return err
+	}
+
+	return e.match(msg)
+}
+
+func (e *expectMessageStep) match(msg pgproto3.FrontendMessage) error {
+	if e.any && reflect.TypeOf(msg) == reflect.TypeOf(e.want) {
+		return nil
+	}
+
+	if !reflect.DeepEqual(msg, e.want) {
+		return fmt.Errorf("msg => %#v, e.want => %#v", msg, e.want)
+	}
+
+	return nil
+}
+
+type expectStartupMessageStep struct {
+	want *pgproto3.StartupMessage
+	any  bool
+}
+
+func (e *expectStartupMessageStep) Step(backend *pgproto3.Backend) error {
+	msg, err := backend.ReceiveStartupMessage()
+	if err != nil {
+		return err
+	}
+
+	if e.any {
+		return nil
+	}
+
+	if !reflect.DeepEqual(msg, e.want) {
+		return fmt.Errorf("msg => %#v, e.want => %#v", msg, e.want)
+	}
+
+	return nil
+}
+
+func ExpectMessage(want pgproto3.FrontendMessage) Step {
+	return expectMessage(want, false)
+}
+
+func ExpectAnyMessage(want pgproto3.FrontendMessage) Step {
+	return expectMessage(want, true)
+}
+
+func expectMessage(want pgproto3.FrontendMessage, any bool) Step {
+	if want, ok := want.(*pgproto3.StartupMessage); ok {
+		return &expectStartupMessageStep{want: want, any: any}
+	}
+
+	return &expectMessageStep{want: want, any: any}
+}
+
+type sendMessageStep struct {
+	msg pgproto3.BackendMessage
+}
+
+func (e *sendMessageStep) Step(backend *pgproto3.Backend) error {
+	backend.Send(e.msg)
+	return backend.Flush()
+}
+
+func SendMessage(msg pgproto3.BackendMessage) Step {
+	return &sendMessageStep{msg: msg}
+}
+
+type waitForCloseMessageStep struct{}
+
+func (e *waitForCloseMessageStep) Step(backend *pgproto3.Backend) error {
+	for {
+		msg, err := backend.Receive()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if _, ok := msg.(*pgproto3.Terminate); ok {
+			return nil
+		}
+	}
+}
+
+func WaitForClose() Step {
+	return &waitForCloseMessageStep{}
+}
+
+type optionalStep struct {
+	step messageStep
+}
+
+func (e *optionalStep) Step(backend *pgproto3.Backend) error {
+	return fmt.Errorf("optional step must be run by a Script")
+}
+
+func (e *optionalStep) run(backend *pgproto3.Backend, st *scriptState) error {
+	msg, err := st.receive(backend)
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if e.step.match(msg) != nil {
+		st.pending = msg
+	}
+
+	return nil
+}
+
+// Optional returns a step that accepts a message matching step if it is the next message sent by the frontend.
+// Otherwise, the message is left for the next step. step must be a step returned by ExpectMessage or ExpectAnyMessage
+// for a message other than StartupMessage. As it must wait for the next message, an Optional step should not be the
+// last step of a script unless the frontend is expected to send a message or close the connection.
+//
+// Optional steps can only be run as part of a Script.
+func Optional(step Step) Step {
+	ms, ok := step.(messageStep)
+	if !ok {
+		panic(fmt.Sprintf("pgmock: Optional does not support %T", step))
+	}
+
+	return &optionalStep{step: ms}
+}
+
+type unorderedStep struct {
+	steps []Step
+}
+
+func (e *unorderedStep) Step(backend *pgproto3.Backend) error {
+	return e.run(backend, &scriptState{})
+}
+
+func (e *unorderedStep) run(backend *pgproto3.Backend, st *scriptState) error {
+	remaining := make([]Step, len(e.steps))
+	copy(remaining, e.steps)
+
+	required := 0
+	for _, step := range remaining {
+		if _, ok := step.(*optionalStep); !ok {
+			required++
+		}
+	}
+
+	for required > 0 {
+		msg, err := st.receive(backend)
+		if err != nil {
+			return err
+		}
+
+		matched := false
+		for i, step := range remaining {
+			var ms messageStep
+			optional := false
+			switch step := step.(type) {
+			case *optionalStep:
+				ms, optional = step.step, true
+			case messageStep:
+				ms = step
+			}
+
+			if ms.match(msg) == nil {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				if !optional {
+					required--
+				}
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return fmt.Errorf("unexpected msg => %#v", msg)
+		}
+	}
+
+	return nil
+}
+
+// Unordered returns a step that accepts messages matching steps in any order. Each of steps must be a step returned by
+// ExpectMessage or ExpectAnyMessage for a message other than StartupMessage, or an Optional step. The step is complete
+// when every non-optional step has been matched. Optional steps are only matched by messages that arrive before then.
+func Unordered(steps ...Step) Step {
+	for _, step := range steps {
+		switch step.(type) {
+		case messageStep, *optionalStep:
+		default:
+			panic(fmt.Sprintf("pgmock: Unordered does not support %T", step))
+		}
+	}
+
+	return &unorderedStep{steps: steps}
+}
+
+func AcceptUnauthenticatedConnRequestSteps() []Step {
+	return []Step{
+		ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+		SendMessage(&pgproto3.AuthenticationOk{}),
+		SendMessage(&pgproto3.BackendKeyData{ProcessID: 0, SecretKey: 0}),
+		SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	}
+}
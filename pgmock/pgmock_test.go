@@ -0,0 +1,356 @@
+package pgmock_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScript(t *testing.T) {
+	script := &pgmock.Script{
+		Steps: pgmock.AcceptUnauthenticatedConnRequestSteps(),
+	}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Query{String: "select 42"}))
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.RowDescription{
+		Fields: []pgproto3.FieldDescription{
+			pgproto3.FieldDescription{
+				Name:                 []byte("?column?"),
+				TableOID:             0,
+				TableAttributeNumber: 0,
+				DataTypeOID:          23,
+				DataTypeSize:         4,
+				TypeModifier:         -1,
+				Format:               0,
+			},
+		},
+	}))
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.DataRow{
+		Values: [][]byte{[]byte("42")},
+	}))
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}))
+	script.Steps = append(script.Steps, pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}))
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		defer close(serverErrChan)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+		defer conn.Close()
+
+		err = conn.SetDeadline(time.Now().Add(time.Second))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+
+		err = script.Run(pgproto3.NewBackend(conn, conn))
+		if err != nil {
+			serverErrChan <- err
+			return
+		}
+	}()
+
+	parts := strings.Split(ln.Addr().String(), ":")
+	host := parts[0]
+	port := parts[1]
+	connStr := fmt.Sprintf("sslmode=disable host=%s port=%s", host, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pgConn, err := pgconn.Connect(ctx, connStr)
+	require.NoError(t, err)
+	results, err := pgConn.Exec(ctx, "select 42").ReadAll()
+	assert.NoError(t, err)
+
+	assert.Len(t, results, 1)
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
+	assert.Len(t, results[0].Rows, 1)
+	assert.Equal(t, "42", string(results[0].Rows[0][0]))
+
+	pgConn.Close(ctx)
+
+	assert.NoError(t, <-serverErrChan)
+}
+
+func TestServerExtendedQuery(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps,
+		pgmock.ExtendedQuery(&pgmock.Query{
+			SQL:  "select id, name from users where id=$1",
+			Args: []any{int32(7)},
+			Result: &pgmock.ResultSet{
+				Columns: []pgmock.Column{{Name: "id", DataTypeOID: pgtype.Int4OID}, {Name: "name", DataTypeOID: pgtype.TextOID}},
+				Rows:    [][]any{{int32(7), "Jack"}, {int32(7), nil}},
+			},
+		}),
+		pgmock.ExtendedQuery(&pgmock.Query{
+			SQL:    "delete from users",
+			Result: &pgmock.ResultSet{CommandTag: "DELETE 2"},
+		}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, server.ConnString())
+	require.NoError(t, err)
+
+	rr := conn.ExecParams(ctx, "select id, name from users where id=$1", [][]byte{{0, 0, 0, 7}}, []uint32{pgtype.Int4OID}, []int16{pgtype.BinaryFormatCode}, []int16{pgtype.BinaryFormatCode, pgtype.TextFormatCode})
+	fields := rr.FieldDescriptions()
+	require.Len(t, fields, 2)
+	assert.Equal(t, "id", fields[0].Name)
+	assert.Equal(t, int16(pgtype.BinaryFormatCode), fields[0].Format)
+	assert.Equal(t, int16(pgtype.TextFormatCode), fields[1].Format)
+
+	require.True(t, rr.NextRow())
+	assert.Equal(t, [][]byte{{0, 0, 0, 7}, []byte("Jack")}, rr.Values())
+	require.True(t, rr.NextRow())
+	assert.Equal(t, [][]byte{{0, 0, 0, 7}, nil}, rr.Values())
+	require.False(t, rr.NextRow())
+	commandTag, err := rr.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT 2", commandTag.String())
+
+	sd, err := conn.Prepare(ctx, "", "delete from users", nil)
+	require.NoError(t, err)
+	assert.Empty(t, sd.ParamOIDs)
+	assert.Empty(t, sd.Fields)
+
+	result := conn.ExecPrepared(ctx, "", nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, "DELETE 2", result.CommandTag.String())
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
+
+func TestExtendedQueryArgsMismatch(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, pgmock.ExtendedQuery(&pgmock.Query{
+		SQL:  "select $1::int4",
+		Args: []any{int32(1)},
+	}))
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, server.ConnString())
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	conn.ExecParams(ctx, "select $1::int4", [][]byte{[]byte("2")}, nil, nil, nil).Read()
+
+	err = server.Wait()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bind parameter 0")
+}
+
+func TestExtendedQueryMalformedBind(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		paramFormats  []int16
+		resultFormats []int16
+		errMsg        string
+	}{
+		{
+			paramFormats: []int16{pgtype.TextFormatCode, pgtype.TextFormatCode},
+			errMsg:       "bind parameter formats: 2 format codes for 1 values",
+		},
+		{
+			resultFormats: []int16{pgtype.TextFormatCode, pgtype.TextFormatCode},
+			errMsg:        "bind result formats: 2 format codes for 1 values",
+		},
+	} {
+		script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+		script.Steps = append(script.Steps, pgmock.ExtendedQuery(&pgmock.Query{
+			SQL:    "select $1::int4",
+			Args:   []any{int32(1)},
+			Result: &pgmock.ResultSet{Columns: []pgmock.Column{{Name: "int4", DataTypeOID: pgtype.Int4OID}}},
+		}))
+
+		server, err := pgmock.NewServer(script)
+		require.NoError(t, err)
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		conn, err := pgconn.Connect(ctx, server.ConnString())
+		require.NoError(t, err)
+		defer conn.Close(ctx)
+
+		conn.ExecParams(ctx, "select $1::int4", [][]byte{[]byte("1")}, []uint32{pgtype.Int4OID}, tt.paramFormats, tt.resultFormats).Read()
+
+		err = server.Wait()
+		require.Errorf(t, err, "%d", i)
+		assert.Containsf(t, err.Error(), tt.errMsg, "%d", i)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps,
+		pgmock.ExtendedQuery(&pgmock.Query{
+			SQL:    "select 42",
+			Result: &pgmock.ResultSet{Columns: []pgmock.Column{{Name: "?column?", DataTypeOID: pgtype.Int4OID}}, Rows: [][]any{{int32(42)}}},
+		}),
+		pgmock.WaitForClose(),
+	)
+
+	config, err := pgconn.ParseConfig("host=/pgmock sslmode=disable")
+	require.NoError(t, err)
+
+	var serverErrChan <-chan error
+	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var conn net.Conn
+		conn, serverErrChan = pgmock.Pipe(script)
+		return conn, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	result := conn.ExecParams(ctx, "select 42", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("42")}}, result.Rows)
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, <-serverErrChan)
+}
+
+func TestOptionalAndUnordered(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: []pgmock.Step{
+		pgmock.Optional(pgmock.ExpectMessage(&pgproto3.Query{String: "optional"})),
+		pgmock.Unordered(
+			pgmock.ExpectMessage(&pgproto3.Query{String: "a"}),
+			pgmock.ExpectMessage(&pgproto3.Query{String: "b"}),
+			pgmock.Optional(pgmock.ExpectMessage(&pgproto3.Query{String: "c"})),
+		),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.Optional(pgmock.ExpectMessage(&pgproto3.Sync{})),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	}}
+
+	run := func(msgs ...pgproto3.FrontendMessage) error {
+		clientConn, serverErrChan := pgmock.Pipe(script)
+		defer clientConn.Close()
+
+		frontend := pgproto3.NewFrontend(clientConn, clientConn)
+		go func() {
+			for _, msg := range msgs {
+				frontend.Send(msg)
+			}
+			frontend.Flush()
+		}()
+
+		go io.Copy(io.Discard, clientConn)
+
+		return <-serverErrChan
+	}
+
+	err := run(&pgproto3.Query{String: "b"}, &pgproto3.Query{String: "a"}, &pgproto3.Terminate{})
+	assert.NoError(t, err)
+
+	err = run(&pgproto3.Query{String: "optional"}, &pgproto3.Query{String: "c"}, &pgproto3.Query{String: "a"}, &pgproto3.Query{String: "b"}, &pgproto3.Sync{}, &pgproto3.Terminate{})
+	assert.NoError(t, err)
+
+	err = run(&pgproto3.Query{String: "a"}, &pgproto3.Query{String: "d"})
+	assert.Error(t, err)
+
+	err = run(&pgproto3.Query{String: "a"}, &pgproto3.Query{String: "b"}, &pgproto3.Query{String: "c"})
+	assert.Error(t, err)
+}
+
+func TestExtendedQueryWithPgx(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []pgx.QueryExecMode{
+		pgx.QueryExecModeCacheStatement,
+		pgx.QueryExecModeCacheDescribe,
+		pgx.QueryExecModeDescribeExec,
+		pgx.QueryExecModeExec,
+	} {
+		mode := mode
+		t.Run(mode.String(), func(t *testing.T) {
+			t.Parallel()
+
+			query := &pgmock.Query{
+				SQL:    "select name from users where id=$1",
+				Args:   []any{int32(1)},
+				Result: &pgmock.ResultSet{Columns: []pgmock.Column{{Name: "name", DataTypeOID: pgtype.TextOID}}, Rows: [][]any{{"Jack"}}},
+			}
+
+			// The second query reuses any statement prepared by the first.
+			script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+			script.Steps = append(script.Steps, pgmock.ExtendedQuery(query), pgmock.ExtendedQuery(query), pgmock.WaitForClose())
+
+			server, err := pgmock.NewServer(script)
+			require.NoError(t, err)
+			defer server.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			config, err := pgx.ParseConfig(server.ConnString())
+			require.NoError(t, err)
+			config.DefaultQueryExecMode = mode
+
+			conn, err := pgx.ConnectConfig(ctx, config)
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				var name string
+				err = conn.QueryRow(ctx, "select name from users where id=$1", int32(1)).Scan(&name)
+				require.NoError(t, err)
+				assert.Equal(t, "Jack", name)
+			}
+
+			require.NoError(t, conn.Close(ctx))
+			require.NoError(t, server.Wait())
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package pgmock
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// A recording is a sequence of JSON objects, one per line, each holding a message sent by a frontend or a backend. For
+// example:
+//
+//	{"Conn":1,"From":"F","Type":"Query","Message":{"Type":"Query","String":"select 1"}}
+//
+// Conn numbers the connections recorded by a Recorder starting at 1. From is "F" for a message from the frontend and
+// "B" for a message from the backend. Type is the name of the pgproto3 message type. Message is the message as
+// encoded by encoding/json.
+type recordedMessage struct {
+	Conn    int
+	From    string
+	Type    string
+	Message json.RawMessage
+}
+
+const (
+	recordedFromFrontend = "F"
+	recordedFromBackend  = "B"
+)
+
+// recordedMessageTypes are the types of messages that can be in a recording by name.
+var recordedMessageTypes = func() map[string]reflect.Type {
+	m := make(map[string]reflect.Type)
+	for _, msg := range []pgproto3.Message{
+		// Frontend messages
+		&pgproto3.Bind{},
+		&pgproto3.CancelRequest{},
+		&pgproto3.Close{},
+		&pgproto3.CopyFail{},
+		&pgproto3.Describe{},
+		&pgproto3.Execute{},
+		&pgproto3.Flush{},
+		&pgproto3.FunctionCall{},
+		&pgproto3.GSSEncRequest{},
+		&pgproto3.Parse{},
+		&pgproto3.Query{},
+		&pgproto3.SSLRequest{},
+		&pgproto3.StartupMessage{},
+		&pgproto3.Sync{},
+		&pgproto3.Terminate{},
+
+		// Messages sent by both
+		&pgproto3.CopyData{},
+		&pgproto3.CopyDone{},
+
+		// Backend messages
+		&pgproto3.AuthenticationOk{},
+		&pgproto3.BackendKeyData{},
+		&pgproto3.BindComplete{},
+		&pgproto3.CloseComplete{},
+		&pgproto3.CommandComplete{},
+		&pgproto3.CopyBothResponse{},
+		&pgproto3.CopyInResponse{},
+		&pgproto3.CopyOutResponse{},
+		&pgproto3.DataRow{},
+		&pgproto3.EmptyQueryResponse{},
+		&pgproto3.ErrorResponse{},
+		&pgproto3.FunctionCallResponse{},
+		&pgproto3.NegotiateProtocolVersion{},
+		&pgproto3.NoData{},
+		&pgproto3.NoticeResponse{},
+		&pgproto3.NotificationResponse{},
+		&pgproto3.ParameterDescription{},
+		&pgproto3.ParameterStatus{},
+		&pgproto3.ParseComplete{},
+		&pgproto3.PortalSuspended{},
+		&pgproto3.ReadyForQuery{},
+		&pgproto3.RowDescription{},
+	} {
+		t := reflect.TypeOf(msg).Elem()
+		m[t.Name()] = t
+	}
+	return m
+}()
+
+// Recorder records the messages exchanged by connections to a file that can be replayed with ReplayScripts. Recorder
+// is safe for concurrent use by multiple connections.
+//
+// Authentication messages other than AuthenticationOk are not recorded. This keeps passwords and other secrets out of
+// recordings. As the recorded authentication exchange would not succeed again anyway, the replayed server accepts
+// the connection without authentication.
+type Recorder struct {
+	mux   sync.Mutex
+	enc   *json.Encoder
+	conns int
+	err   error
+}
+
+// NewRecorder returns a Recorder that writes the recording to w.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// BuildFrontend builds a *pgproto3.Frontend that records the messages it sends and receives. It can be used as
+// pgconn.Config.BuildFrontend. Each call records a new connection.
+func (rec *Recorder) BuildFrontend(r io.Reader, w io.Writer) *pgproto3.Frontend {
+	rec.mux.Lock()
+	rec.conns++
+	conn := rec.conns
+	rec.mux.Unlock()
+
+	frontendMessages := &feedReader{}
+	backendMessages := &feedReader{}
+
+	rw := &recordingReadWriter{
+		rec:              rec,
+		conn:             conn,
+		r:                r,
+		w:                w,
+		frontendFramer:   messageFramer{untyped: true},
+		frontendDecoder:  pgproto3.NewBackend(frontendMessages, io.Discard),
+		frontendMessages: frontendMessages,
+		backendDecoder:   pgproto3.NewFrontend(backendMessages, io.Discard),
+		backendMessages:  backendMessages,
+	}
+
+	return pgproto3.NewFrontend(readerFunc(rw.read), writerFunc(rw.write))
+}
+
+// Err returns the first error that occurred while recording.
+func (rec *Recorder) Err() error {
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+	return rec.err
+}
+
+func (rec *Recorder) record(conn int, from string, msg pgproto3.Message) {
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	if rec.err != nil {
+		return
+	}
+
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		rec.err = err
+		return
+	}
+
+	rec.err = rec.enc.Encode(recordedMessage{
+		Conn:    conn,
+		From:    from,
+		Type:    reflect.TypeOf(msg).Elem().Name(),
+		Message: buf,
+	})
+}
+
+func (rec *Recorder) fail(err error) {
+	rec.mux.Lock()
+	defer rec.mux.Unlock()
+
+	if rec.err == nil {
+		rec.err = err
+	}
+}
+
+// recordingReadWriter splits the bytes read and written by a connection into messages, decodes them, and records
+// them. The read and write sides are independent as they may be used concurrently (e.g. by CopyFrom).
+type recordingReadWriter struct {
+	rec  *Recorder
+	conn int
+	r    io.Reader
+	w    io.Writer
+
+	frontendFramer   messageFramer
+	frontendDecoder  *pgproto3.Backend
+	frontendMessages *feedReader
+	startupDecoded   bool
+
+	backendFramer   messageFramer
+	backendDecoder  *pgproto3.Frontend
+	backendMessages *feedReader
+}
+
+func (rw *recordingReadWriter) write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+
+	rw.frontendFramer.write(p[:n])
+	for {
+		buf, ferr := rw.frontendFramer.next()
+		if ferr != nil {
+			rw.rec.fail(ferr)
+			break
+		}
+		if buf == nil {
+			break
+		}
+
+		// Password, SASL, and GSS responses are not recorded.
+		if buf[0] == 'p' && rw.startupDecoded {
+			continue
+		}
+
+		rw.frontendMessages.buf.Write(buf)
+		var msg pgproto3.FrontendMessage
+		var derr error
+		if !rw.startupDecoded {
+			msg, derr = rw.frontendDecoder.ReceiveStartupMessage()
+			rw.startupDecoded = true
+		} else {
+			msg, derr = rw.frontendDecoder.Receive()
+		}
+		if derr != nil {
+			rw.rec.fail(derr)
+			continue
+		}
+		rw.rec.record(rw.conn, recordedFromFrontend, msg)
+	}
+
+	return n, err
+}
+
+func (rw *recordingReadWriter) read(p []byte) (int, error) {
+	n, err := rw.r.Read(p)
+
+	rw.backendFramer.write(p[:n])
+	for {
+		buf, ferr := rw.backendFramer.next()
+		if ferr != nil {
+			rw.rec.fail(ferr)
+			break
+		}
+		if buf == nil {
+			break
+		}
+
+		rw.backendMessages.buf.Write(buf)
+		msg, derr := rw.backendDecoder.Receive()
+		if derr != nil {
+			rw.rec.fail(derr)
+			continue
+		}
+
+		switch msg.(type) {
+		case *pgproto3.AuthenticationCleartextPassword, *pgproto3.AuthenticationMD5Password,
+			*pgproto3.AuthenticationGSS, *pgproto3.AuthenticationGSSContinue,
+			*pgproto3.AuthenticationSASL, *pgproto3.AuthenticationSASLContinue, *pgproto3.AuthenticationSASLFinal:
+			continue
+		}
+		rw.rec.record(rw.conn, recordedFromBackend, msg)
+	}
+
+	return n, err
+}
+
+// messageFramer splits a stream of bytes into complete messages.
+type messageFramer struct {
+	buf     []byte
+	untyped bool // the next message has no type byte (i.e. it is a startup message)
+}
+
+func (mf *messageFramer) write(p []byte) {
+	mf.buf = append(mf.buf, p...)
+}
+
+// next returns the next complete message or nil if there is none.
+func (mf *messageFramer) next() ([]byte, error) {
+	lenOffset := 1
+	if mf.untyped {
+		lenOffset = 0
+	}
+
+	if len(mf.buf) < lenOffset+4 {
+		return nil, nil
+	}
+
+	msgLen := int(binary.BigEndian.Uint32(mf.buf[lenOffset:]))
+	if msgLen < 4 {
+		return nil, fmt.Errorf("invalid message length: %d", msgLen)
+	}
+
+	n := lenOffset + msgLen
+	if len(mf.buf) < n {
+		return nil, nil
+	}
+
+	msg := append([]byte(nil), mf.buf[:n]...)
+	mf.buf = append(mf.buf[:0], mf.buf[n:]...)
+	mf.untyped = false
+
+	return msg, nil
+}
+
+// feedReader is read by a message decoder. Only complete messages are written to it so the decoder never blocks.
+type feedReader struct {
+	buf bytes.Buffer
+}
+
+func (fr *feedReader) Read(p []byte) (int, error) {
+	return fr.buf.Read(p)
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+type expectRecordedMessageStep struct {
+	want pgproto3.FrontendMessage
+}
+
+func (e *expectRecordedMessageStep) Step(backend *pgproto3.Backend) error {
+	msg, err := backend.Receive()
+	if err != nil {
+		return err
+	}
+
+	return e.match(msg)
+}
+
+// match compares the wire encoding of the messages so that equivalent messages match even if they differ in ways that
+// do not survive a round trip through JSON (e.g. a nil slice and an empty slice).
+func (e *expectRecordedMessageStep) match(msg pgproto3.FrontendMessage) error {
+	if !bytes.Equal(msg.Encode(nil), e.want.Encode(nil)) {
+		return fmt.Errorf("msg => %#v, e.want => %#v", msg, e.want)
+	}
+
+	return nil
+}
+
+// expectRecordedStartupStep expects a message that is sent in place of a startup message (e.g. an SSLRequest). It is
+// not a messageStep as it must be received with ReceiveStartupMessage.
+type expectRecordedStartupStep struct {
+	want pgproto3.FrontendMessage
+}
+
+func (e *expectRecordedStartupStep) Step(backend *pgproto3.Backend) error {
+	msg, err := backend.ReceiveStartupMessage()
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(msg.Encode(nil), e.want.Encode(nil)) {
+		return fmt.Errorf("msg => %#v, e.want => %#v", msg, e.want)
+	}
+
+	return nil
+}
+
+// ReplayScripts reads a recording made by a Recorder and returns a script for each recorded connection. Each script
+// sends the messages the backend sent and fails if the frontend sends a message other than the one that was recorded.
+// The startup message is accepted regardless of its parameters.
+func ReplayScripts(r io.Reader) ([]*Script, error) {
+	var scripts []*Script
+	scriptsByConn := make(map[int]*Script)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var rm recordedMessage
+		err := json.Unmarshal(line, &rm)
+		if err != nil {
+			return nil, err
+		}
+
+		t, ok := recordedMessageTypes[rm.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown message type: %s", rm.Type)
+		}
+		msg := reflect.New(t).Interface()
+		err = json.Unmarshal(rm.Message, msg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s message: %w", rm.Type, err)
+		}
+
+		script, ok := scriptsByConn[rm.Conn]
+		if !ok {
+			script = &Script{}
+			scriptsByConn[rm.Conn] = script
+			scripts = append(scripts, script)
+		}
+
+		var step Step
+		switch rm.From {
+		case recordedFromFrontend:
+			fmsg, ok := msg.(pgproto3.FrontendMessage)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a frontend message", rm.Type)
+			}
+			switch fmsg := fmsg.(type) {
+			case *pgproto3.StartupMessage:
+				step = ExpectAnyMessage(fmsg)
+			case *pgproto3.CancelRequest, *pgproto3.GSSEncRequest, *pgproto3.SSLRequest:
+				step = &expectRecordedStartupStep{want: fmsg}
+			default:
+				step = &expectRecordedMessageStep{want: fmsg}
+			}
+		case recordedFromBackend:
+			bmsg, ok := msg.(pgproto3.BackendMessage)
+			if !ok {
+				return nil, fmt.Errorf("%s is not a backend message", rm.Type)
+			}
+			step = SendMessage(bmsg)
+		default:
+			return nil, fmt.Errorf("invalid message source: %q", rm.From)
+		}
+
+		script.Steps = append(script.Steps, step)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(scripts) == 0 {
+		return nil, errors.New("recording has no messages")
+	}
+
+	return scripts, nil
+}
@@ -0,0 +1,271 @@
+package pgmock_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	t.Parallel()
+
+	// The "real" server for the recorded session requires a password.
+	script := &pgmock.Script{Steps: []pgmock.Step{
+		pgmock.ExpectAnyMessage(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{}}),
+		pgmock.SendMessage(&pgproto3.AuthenticationCleartextPassword{}),
+		pgmock.ExpectMessage(&pgproto3.PasswordMessage{Password: "secret"}),
+		pgmock.SendMessage(&pgproto3.AuthenticationOk{}),
+		pgmock.SendMessage(&pgproto3.ParameterStatus{Name: "server_version", Value: "15.1"}),
+		pgmock.SendMessage(&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: 0x01020304}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExtendedQuery(&pgmock.Query{
+			SQL:    "select name from users where id=$1",
+			Args:   []any{"7"},
+			Result: &pgmock.ResultSet{Columns: []pgmock.Column{{Name: "name", DataTypeOID: pgtype.TextOID}}, Rows: [][]any{{"Jack"}, {nil}}},
+		}),
+		pgmock.ExpectMessage(&pgproto3.Query{String: "drop table users"}),
+		pgmock.SendMessage(&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: "hello"}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42501", Message: "permission denied"}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	}}
+
+	type sessionResult struct {
+		serverVersion string
+		pid           uint32
+		rows          [][][]byte
+		notices       []string
+		err           string
+	}
+
+	runSession := func(t *testing.T, connString string, buildFrontend pgconn.BuildFrontendFunc, sql string) sessionResult {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		config, err := pgconn.ParseConfig(connString + " user=jack password=secret")
+		require.NoError(t, err)
+		if buildFrontend != nil {
+			config.BuildFrontend = buildFrontend
+		}
+
+		var sr sessionResult
+		config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) {
+			sr.notices = append(sr.notices, n.Message)
+		}
+
+		conn, err := pgconn.ConnectConfig(ctx, config)
+		require.NoError(t, err)
+		defer conn.Close(ctx)
+
+		sr.serverVersion = conn.ParameterStatus("server_version")
+		sr.pid = conn.PID()
+
+		result := conn.ExecParams(ctx, sql, [][]byte{[]byte("7")}, nil, nil, nil).Read()
+		require.NoError(t, result.Err)
+		sr.rows = result.Rows
+
+		_, err = conn.Exec(ctx, "drop table users").ReadAll()
+		require.Error(t, err)
+		sr.err = err.Error()
+
+		require.NoError(t, conn.Close(ctx))
+		return sr
+	}
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	var recording bytes.Buffer
+	recorder := pgmock.NewRecorder(&recording)
+	recorded := runSession(t, server.ConnString(), recorder.BuildFrontend, "select name from users where id=$1")
+	require.NoError(t, server.Wait())
+	require.NoError(t, recorder.Err())
+
+	assert.Equal(t, "15.1", recorded.serverVersion)
+	assert.EqualValues(t, 42, recorded.pid)
+	assert.Equal(t, []string{"hello"}, recorded.notices)
+	assert.NotContains(t, recording.String(), "secret")
+	assert.NotContains(t, recording.String(), "AuthenticationCleartextPassword")
+
+	scripts, err := pgmock.ReplayScripts(bytes.NewReader(recording.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, scripts, 1)
+
+	replayServer, err := pgmock.NewServer(scripts[0])
+	require.NoError(t, err)
+	defer replayServer.Close()
+
+	replayed := runSession(t, replayServer.ConnString(), nil, "select name from users where id=$1")
+	require.NoError(t, replayServer.Wait())
+	assert.Equal(t, recorded, replayed)
+
+	// A divergent frontend message fails the replay.
+	scripts, err = pgmock.ReplayScripts(bytes.NewReader(recording.Bytes()))
+	require.NoError(t, err)
+
+	divergentServer, err := pgmock.NewServer(scripts[0])
+	require.NoError(t, err)
+	defer divergentServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, divergentServer.ConnString())
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	conn.ExecParams(ctx, "select 1", nil, nil, nil, nil).Read()
+	err = divergentServer.Wait()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "select 1")
+}
+
+func TestRecordAndReplayAllMessageTypes(t *testing.T) {
+	t.Parallel()
+
+	// Each startup message starts its own connection.
+	startupMessages := []pgproto3.FrontendMessage{
+		&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{"user": "jack"}},
+		&pgproto3.SSLRequest{},
+		&pgproto3.GSSEncRequest{},
+		&pgproto3.CancelRequest{ProcessID: 42, SecretKey: 0x01020304},
+	}
+	frontendMessages := []pgproto3.FrontendMessage{
+		&pgproto3.Bind{DestinationPortal: "p", PreparedStatement: "s", ParameterFormatCodes: []int16{1}, Parameters: [][]byte{{0, 1}, nil}, ResultFormatCodes: []int16{0, 1}},
+		&pgproto3.Close{ObjectType: 'S', Name: "s"},
+		&pgproto3.CopyFail{Message: "failed"},
+		&pgproto3.Describe{ObjectType: 'P', Name: "p"},
+		&pgproto3.Execute{Portal: "p", MaxRows: 10},
+		&pgproto3.Flush{},
+		&pgproto3.FunctionCall{Function: 7, ArgFormatCodes: []uint16{1}, Arguments: [][]byte{{0, 1}, nil}, ResultFormatCode: 1},
+		&pgproto3.Parse{Name: "s", Query: "select $1", ParameterOIDs: []uint32{pgtype.Int4OID}},
+		&pgproto3.Query{String: "select 1"},
+		&pgproto3.Sync{},
+		&pgproto3.CopyData{Data: []byte{0, 1, 2, 'a', 0xff}},
+		&pgproto3.CopyDone{},
+		&pgproto3.Terminate{},
+	}
+	backendMessages := []pgproto3.BackendMessage{
+		&pgproto3.AuthenticationOk{},
+		&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: 0x01020304},
+		&pgproto3.BindComplete{},
+		&pgproto3.CloseComplete{},
+		&pgproto3.CommandComplete{CommandTag: []byte("COPY 1")},
+		&pgproto3.CopyBothResponse{OverallFormat: 1, ColumnFormatCodes: []uint16{1, 1}},
+		&pgproto3.CopyInResponse{OverallFormat: 0, ColumnFormatCodes: []uint16{0}},
+		&pgproto3.CopyOutResponse{OverallFormat: 1, ColumnFormatCodes: []uint16{1}},
+		&pgproto3.CopyData{Data: []byte{0, 1, 2, 'b', 0xff}},
+		&pgproto3.CopyDone{},
+		&pgproto3.DataRow{Values: [][]byte{[]byte("1"), nil, {0xff}}},
+		&pgproto3.EmptyQueryResponse{},
+		&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42601", Message: "syntax error", Position: 3},
+		&pgproto3.FunctionCallResponse{Result: []byte{0, 1}},
+		&pgproto3.NegotiateProtocolVersion{NewestMinorProtocol: 0, UnrecognizedOptions: []string{"_pq_.foo"}},
+		&pgproto3.NoData{},
+		&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: "hello"},
+		&pgproto3.NotificationResponse{PID: 42, Channel: "c", Payload: "p"},
+		&pgproto3.ParameterDescription{ParameterOIDs: []uint32{pgtype.Int4OID}},
+		&pgproto3.ParameterStatus{Name: "server_version", Value: "15.1"},
+		&pgproto3.ParseComplete{},
+		&pgproto3.PortalSuspended{},
+		&pgproto3.ReadyForQuery{TxStatus: 'I'},
+		&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("n"), DataTypeOID: pgtype.Int4OID, DataTypeSize: 4, TypeModifier: -1, Format: 1}}},
+	}
+
+	// Every type that can be recorded is covered.
+	var covered []string
+	for _, msg := range append(append([]pgproto3.Message{}, toMessages(startupMessages)...), append(toMessages(frontendMessages), toMessages(backendMessages)...)...) {
+		covered = append(covered, reflect.TypeOf(msg).Elem().Name())
+	}
+	assert.ElementsMatch(t, pgmock.RecordedMessageTypes(), uniqueStrings(covered))
+
+	var recording bytes.Buffer
+	recorder := pgmock.NewRecorder(&recording)
+
+	var backendBuf []byte
+	for _, msg := range backendMessages {
+		backendBuf = msg.Encode(backendBuf)
+	}
+	frontend := recorder.BuildFrontend(bytes.NewReader(backendBuf), io.Discard)
+	frontend.Send(startupMessages[0])
+	for _, msg := range frontendMessages {
+		frontend.Send(msg)
+	}
+	require.NoError(t, frontend.Flush())
+	for range backendMessages {
+		_, err := frontend.Receive()
+		require.NoError(t, err)
+	}
+
+	for _, msg := range startupMessages[1:] {
+		frontend := recorder.BuildFrontend(bytes.NewReader(nil), io.Discard)
+		frontend.Send(msg)
+		require.NoError(t, frontend.Flush())
+	}
+	require.NoError(t, recorder.Err())
+
+	scripts, err := pgmock.ReplayScripts(bytes.NewReader(recording.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, scripts, len(startupMessages))
+
+	replay := func(t *testing.T, script *pgmock.Script, send []pgproto3.FrontendMessage, receive []pgproto3.BackendMessage) {
+		clientConn, serverConn := net.Pipe()
+		defer clientConn.Close()
+
+		errChan := make(chan error, 1)
+		go func() {
+			defer serverConn.Close()
+			errChan <- script.Run(pgproto3.NewBackend(serverConn, serverConn))
+		}()
+
+		frontend := pgproto3.NewFrontend(clientConn, clientConn)
+		for _, msg := range send {
+			frontend.Send(msg)
+			require.NoError(t, frontend.Flush())
+		}
+		for _, want := range receive {
+			msg, err := frontend.Receive()
+			require.NoError(t, err)
+			assert.Equal(t, want.Encode(nil), msg.Encode(nil), "%T", want)
+		}
+
+		require.NoError(t, <-errChan)
+	}
+
+	replay(t, scripts[0], append([]pgproto3.FrontendMessage{startupMessages[0]}, frontendMessages...), backendMessages)
+	for i, msg := range startupMessages[1:] {
+		replay(t, scripts[i+1], []pgproto3.FrontendMessage{msg}, nil)
+	}
+}
+
+func toMessages[T pgproto3.Message](msgs []T) []pgproto3.Message {
+	result := make([]pgproto3.Message, len(msgs))
+	for i, msg := range msgs {
+		result[i] = msg
+	}
+	return result
+}
+
+func uniqueStrings(ss []string) []string {
+	sort.Strings(ss)
+	result := ss[:0]
+	for i, s := range ss {
+		if i == 0 || s != ss[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
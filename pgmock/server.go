@@ -0,0 +1,105 @@
+package pgmock
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Server is a mock PostgreSQL server listening on a local TCP port. It serves the first connection it accepts with a
+// Script.
+type Server struct {
+	ln net.Listener
+
+	mux    sync.Mutex
+	conn   net.Conn
+	closed bool
+
+	done chan struct{}
+	err  error
+}
+
+// NewServer starts a Server on 127.0.0.1 that serves script.
+func NewServer(script *Script) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{ln: ln, done: make(chan struct{})}
+	go s.serve(script)
+
+	return s, nil
+}
+
+func (s *Server) serve(script *Script) {
+	defer close(s.done)
+
+	conn, err := s.ln.Accept()
+	if err != nil {
+		s.err = err
+		return
+	}
+	defer conn.Close()
+
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		s.err = net.ErrClosed
+		return
+	}
+	s.conn = conn
+	s.mux.Unlock()
+
+	s.err = script.Run(pgproto3.NewBackend(conn, conn))
+}
+
+// Addr returns the address s is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// ConnString returns a connection string for connecting to s with pgconn.Connect or pgx.Connect. TLS is disabled.
+func (s *Server) ConnString() string {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return fmt.Sprintf("sslmode=disable host=%s port=%s", host, port)
+}
+
+// Wait waits for the script to finish and returns the error from running it.
+func (s *Server) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Close stops s from listening and closes the connection being served. The script will fail if it has not finished.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+
+	s.mux.Lock()
+	s.closed = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mux.Unlock()
+
+	return err
+}
+
+// Pipe returns the client end of an in-memory connection that is served by script and a channel that receives the
+// error from running script. The server end of the connection is closed when script finishes.
+//
+// Pipe can be used to implement pgconn.Config.DialFunc. Setting the host to a Unix domain socket directory (e.g.
+// host=/pgmock) avoids resolving the host with pgconn.Config.LookupFunc.
+func Pipe(script *Script) (net.Conn, <-chan error) {
+	clientConn, serverConn := net.Pipe()
+	errChan := make(chan error, 1)
+
+	go func() {
+		defer serverConn.Close()
+		errChan <- script.Run(pgproto3.NewBackend(serverConn, serverConn))
+	}()
+
+	return clientConn, errChan
+}
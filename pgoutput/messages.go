@@ -0,0 +1,377 @@
+package pgoutput
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Begin is the start of a transaction.
+type Begin struct {
+	FinalLSN   pgproto3.LSN // LSN of the commit record of the transaction
+	CommitTime time.Time
+	Xid        uint32
+}
+
+// Type returns MessageTypeBegin.
+func (*Begin) Type() MessageType { return MessageTypeBegin }
+
+// Decode decodes src into dst.
+func (dst *Begin) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Begin"}
+	dst.FinalLSN = d.lsn()
+	dst.CommitTime = d.time()
+	dst.Xid = d.uint32()
+	return d.finish()
+}
+
+// Commit is the end of a transaction.
+type Commit struct {
+	Flags             uint8 // currently unused
+	CommitLSN         pgproto3.LSN
+	TransactionEndLSN pgproto3.LSN
+	CommitTime        time.Time
+}
+
+// Type returns MessageTypeCommit.
+func (*Commit) Type() MessageType { return MessageTypeCommit }
+
+// Decode decodes src into dst.
+func (dst *Commit) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Commit"}
+	dst.Flags = d.uint8()
+	dst.CommitLSN = d.lsn()
+	dst.TransactionEndLSN = d.lsn()
+	dst.CommitTime = d.time()
+	return d.finish()
+}
+
+// Origin identifies the replication origin of a transaction. It is sent after Begin.
+type Origin struct {
+	CommitLSN pgproto3.LSN // LSN of the commit on the origin server
+	Name      string
+}
+
+// Type returns MessageTypeOrigin.
+func (*Origin) Type() MessageType { return MessageTypeOrigin }
+
+// Decode decodes src into dst.
+func (dst *Origin) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Origin"}
+	dst.CommitLSN = d.lsn()
+	dst.Name = d.string()
+	return d.finish()
+}
+
+// RelationColumn describes a column of a Relation.
+type RelationColumn struct {
+	Flags        uint8 // 1 if the column is part of the key
+	Name         string
+	DataType     uint32 // OID of the column's data type
+	TypeModifier int32
+}
+
+// IsKey reports whether the column is part of the replica identity key.
+func (c *RelationColumn) IsKey() bool {
+	return c.Flags&1 == 1
+}
+
+// Relation describes a table. It is sent before the first DML message for the table and whenever its definition
+// changes. Tuple data in Insert, Update, and Delete messages is interpreted according to the most recent Relation.
+type Relation struct {
+	Xid             uint32 // only set in a streamed transaction
+	RelationID      uint32
+	Namespace       string // empty for pg_catalog
+	RelationName    string
+	ReplicaIdentity uint8 // same as relreplident in pg_class
+	Columns         []RelationColumn
+}
+
+// Type returns MessageTypeRelation.
+func (*Relation) Type() MessageType { return MessageTypeRelation }
+
+// Decode decodes src into dst.
+func (dst *Relation) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Relation"}
+	if inStream {
+		dst.Xid = d.uint32()
+	}
+	dst.RelationID = d.uint32()
+	dst.Namespace = d.string()
+	dst.RelationName = d.string()
+	dst.ReplicaIdentity = d.uint8()
+	columnCount := d.count(10) // flags, name terminator, type OID, and type modifier
+	if d.err != nil {
+		return d.err
+	}
+
+	dst.Columns = make([]RelationColumn, columnCount)
+	for i := range dst.Columns {
+		c := &dst.Columns[i]
+		c.Flags = d.uint8()
+		c.Name = d.string()
+		c.DataType = d.uint32()
+		c.TypeModifier = d.int32()
+	}
+
+	return d.finish()
+}
+
+// Type describes a data type. It is sent before Relation messages that use a non built-in type.
+type Type struct {
+	Xid       uint32 // only set in a streamed transaction
+	DataType  uint32
+	Namespace string // empty for pg_catalog
+	Name      string
+}
+
+// Type returns MessageTypeType.
+func (*Type) Type() MessageType { return MessageTypeType }
+
+// Decode decodes src into dst.
+func (dst *Type) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Type"}
+	if inStream {
+		dst.Xid = d.uint32()
+	}
+	dst.DataType = d.uint32()
+	dst.Namespace = d.string()
+	dst.Name = d.string()
+	return d.finish()
+}
+
+// Insert is a row inserted into a relation.
+type Insert struct {
+	Xid        uint32 // only set in a streamed transaction
+	RelationID uint32
+	Tuple      *TupleData
+}
+
+// Type returns MessageTypeInsert.
+func (*Insert) Type() MessageType { return MessageTypeInsert }
+
+// Decode decodes src into dst.
+func (dst *Insert) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Insert"}
+	if inStream {
+		dst.Xid = d.uint32()
+	}
+	dst.RelationID = d.uint32()
+	if kind := d.uint8(); d.err == nil && kind != 'N' {
+		d.fail("(expected new tuple)")
+	}
+	dst.Tuple = d.tupleData()
+	return d.finish()
+}
+
+// Update is a row updated in a relation.
+type Update struct {
+	Xid        uint32 // only set in a streamed transaction
+	RelationID uint32
+
+	// OldTupleType is 'K' if OldTuple only contains the replica identity key columns, 'O' if it contains the entire old
+	// row, or 0 if OldTuple is not present.
+	OldTupleType uint8
+	OldTuple     *TupleData
+	NewTuple     *TupleData
+}
+
+// Type returns MessageTypeUpdate.
+func (*Update) Type() MessageType { return MessageTypeUpdate }
+
+// Decode decodes src into dst.
+func (dst *Update) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Update"}
+	if inStream {
+		dst.Xid = d.uint32()
+	}
+	dst.RelationID = d.uint32()
+
+	dst.OldTupleType = 0
+	dst.OldTuple = nil
+	kind := d.uint8()
+	if kind == 'K' || kind == 'O' {
+		dst.OldTupleType = kind
+		dst.OldTuple = d.tupleData()
+		kind = d.uint8()
+	}
+	if d.err == nil && kind != 'N' {
+		d.fail("(expected new tuple)")
+	}
+	dst.NewTuple = d.tupleData()
+	return d.finish()
+}
+
+// Delete is a row deleted from a relation.
+type Delete struct {
+	Xid        uint32 // only set in a streamed transaction
+	RelationID uint32
+
+	// OldTupleType is 'K' if OldTuple only contains the replica identity key columns or 'O' if it contains the entire
+	// old row.
+	OldTupleType uint8
+	OldTuple     *TupleData
+}
+
+// Type returns MessageTypeDelete.
+func (*Delete) Type() MessageType { return MessageTypeDelete }
+
+// Decode decodes src into dst.
+func (dst *Delete) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Delete"}
+	if inStream {
+		dst.Xid = d.uint32()
+	}
+	dst.RelationID = d.uint32()
+	dst.OldTupleType = d.uint8()
+	if d.err == nil && dst.OldTupleType != 'K' && dst.OldTupleType != 'O' {
+		d.fail("(expected old tuple)")
+	}
+	dst.OldTuple = d.tupleData()
+	return d.finish()
+}
+
+// Truncate options.
+const (
+	TruncateOptionCascade         = 1
+	TruncateOptionRestartIdentity = 2
+)
+
+// Truncate is one or more relations truncated by a single TRUNCATE command.
+type Truncate struct {
+	Xid         uint32 // only set in a streamed transaction
+	Options     uint8  // bitmask of TruncateOption* constants
+	RelationIDs []uint32
+}
+
+// Type returns MessageTypeTruncate.
+func (*Truncate) Type() MessageType { return MessageTypeTruncate }
+
+// Decode decodes src into dst.
+func (dst *Truncate) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Truncate"}
+	if inStream {
+		dst.Xid = d.uint32()
+	}
+	relationCount := int(d.uint32())
+	dst.Options = d.uint8()
+	if d.err != nil {
+		return d.err
+	}
+	if relationCount*4 != d.remaining() {
+		d.fail("(relation count does not match body length)")
+		return d.err
+	}
+
+	dst.RelationIDs = make([]uint32, relationCount)
+	for i := range dst.RelationIDs {
+		dst.RelationIDs[i] = d.uint32()
+	}
+	return d.finish()
+}
+
+// LogicalDecodingMessage is a message written with pg_logical_emit_message. It is only sent when the messages
+// option of pgoutput is enabled.
+type LogicalDecodingMessage struct {
+	Xid           uint32 // only set in a streamed transaction
+	Transactional bool
+	LSN           pgproto3.LSN
+	Prefix        string
+	Content       []byte
+}
+
+// Type returns MessageTypeMessage.
+func (*LogicalDecodingMessage) Type() MessageType { return MessageTypeMessage }
+
+// Decode decodes src into dst.
+func (dst *LogicalDecodingMessage) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "Message"}
+	if inStream {
+		dst.Xid = d.uint32()
+	}
+	dst.Transactional = d.uint8()&1 == 1
+	dst.LSN = d.lsn()
+	dst.Prefix = d.string()
+	contentLen := int(d.int32())
+	dst.Content = d.next(contentLen)
+	return d.finish()
+}
+
+// StreamStart is the start of a block of changes of a streamed in-progress transaction (protocol version 2 or later).
+type StreamStart struct {
+	Xid          uint32
+	FirstSegment bool // true if this is the first stream segment for Xid
+}
+
+// Type returns MessageTypeStreamStart.
+func (*StreamStart) Type() MessageType { return MessageTypeStreamStart }
+
+// Decode decodes src into dst.
+func (dst *StreamStart) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "StreamStart"}
+	dst.Xid = d.uint32()
+	dst.FirstSegment = d.uint8() == 1
+	return d.finish()
+}
+
+// StreamStop is the end of a block of changes of a streamed transaction.
+type StreamStop struct{}
+
+// Type returns MessageTypeStreamStop.
+func (*StreamStop) Type() MessageType { return MessageTypeStreamStop }
+
+// Decode decodes src into dst.
+func (dst *StreamStop) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "StreamStop"}
+	return d.finish()
+}
+
+// StreamCommit is the commit of a streamed transaction.
+type StreamCommit struct {
+	Xid               uint32
+	Flags             uint8 // currently unused
+	CommitLSN         pgproto3.LSN
+	TransactionEndLSN pgproto3.LSN
+	CommitTime        time.Time
+}
+
+// Type returns MessageTypeStreamCommit.
+func (*StreamCommit) Type() MessageType { return MessageTypeStreamCommit }
+
+// Decode decodes src into dst.
+func (dst *StreamCommit) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "StreamCommit"}
+	dst.Xid = d.uint32()
+	dst.Flags = d.uint8()
+	dst.CommitLSN = d.lsn()
+	dst.TransactionEndLSN = d.lsn()
+	dst.CommitTime = d.time()
+	return d.finish()
+}
+
+// StreamAbort is the abort of a streamed transaction or one of its subtransactions.
+type StreamAbort struct {
+	Xid    uint32
+	SubXid uint32 // equal to Xid if the top-level transaction was aborted
+
+	// AbortLSN and AbortTime are only sent by protocol version 4 or later with parallel streaming enabled.
+	AbortLSN  pgproto3.LSN
+	AbortTime time.Time
+}
+
+// Type returns MessageTypeStreamAbort.
+func (*StreamAbort) Type() MessageType { return MessageTypeStreamAbort }
+
+// Decode decodes src into dst.
+func (dst *StreamAbort) Decode(src []byte, inStream bool) error {
+	d := &decoder{src: src, messageType: "StreamAbort"}
+	dst.Xid = d.uint32()
+	dst.SubXid = d.uint32()
+	dst.AbortLSN = 0
+	dst.AbortTime = time.Time{}
+	if d.remaining() > 0 {
+		dst.AbortLSN = d.lsn()
+		dst.AbortTime = d.time()
+	}
+	return d.finish()
+}
@@ -0,0 +1,247 @@
+// Package pgoutput is a decoder for the messages of the PostgreSQL pgoutput logical decoding plugin.
+//
+// pgoutput messages are carried as the payload of XLogData messages in the CopyData stream of a logical replication
+// connection. See https://www.postgresql.org/docs/current/protocol-logicalrep-message-formats.html for the message
+// formats.
+//
+// Parse decodes a single message. Decoder additionally tracks streamed transactions and Relation messages so that
+// tuple columns can be decoded to Go values with a *pgtype.Map.
+package pgoutput
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// MessageType is the first byte of a pgoutput message.
+type MessageType uint8
+
+const (
+	MessageTypeBegin        MessageType = 'B'
+	MessageTypeCommit       MessageType = 'C'
+	MessageTypeOrigin       MessageType = 'O'
+	MessageTypeRelation     MessageType = 'R'
+	MessageTypeType         MessageType = 'Y'
+	MessageTypeInsert       MessageType = 'I'
+	MessageTypeUpdate       MessageType = 'U'
+	MessageTypeDelete       MessageType = 'D'
+	MessageTypeTruncate     MessageType = 'T'
+	MessageTypeMessage      MessageType = 'M'
+	MessageTypeStreamStart  MessageType = 'S'
+	MessageTypeStreamStop   MessageType = 'E'
+	MessageTypeStreamCommit MessageType = 'c'
+	MessageTypeStreamAbort  MessageType = 'A'
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MessageTypeBegin:
+		return "Begin"
+	case MessageTypeCommit:
+		return "Commit"
+	case MessageTypeOrigin:
+		return "Origin"
+	case MessageTypeRelation:
+		return "Relation"
+	case MessageTypeType:
+		return "Type"
+	case MessageTypeInsert:
+		return "Insert"
+	case MessageTypeUpdate:
+		return "Update"
+	case MessageTypeDelete:
+		return "Delete"
+	case MessageTypeTruncate:
+		return "Truncate"
+	case MessageTypeMessage:
+		return "Message"
+	case MessageTypeStreamStart:
+		return "StreamStart"
+	case MessageTypeStreamStop:
+		return "StreamStop"
+	case MessageTypeStreamCommit:
+		return "StreamCommit"
+	case MessageTypeStreamAbort:
+		return "StreamAbort"
+	default:
+		return fmt.Sprintf("MessageType(%q)", byte(t))
+	}
+}
+
+// Message is a decoded pgoutput message.
+type Message interface {
+	// Type returns the message type.
+	Type() MessageType
+
+	// Decode decodes src into the message. src does not include the message type byte. inStream reports whether the
+	// message was received between StreamStart and StreamStop, in which case it may be prefixed by a transaction ID.
+	// Decode is allowed and expected to retain a reference to src after returning.
+	Decode(src []byte, inStream bool) error
+}
+
+// Parse decodes a single pgoutput message. inStream must be true when the message was received between a StreamStart
+// and a StreamStop message (protocol version 2 or later). Decoder can track this automatically.
+func Parse(data []byte, inStream bool) (Message, error) {
+	if len(data) == 0 {
+		return nil, &invalidMessageFormatErr{messageType: "pgoutput", details: "empty message"}
+	}
+
+	var msg Message
+	switch MessageType(data[0]) {
+	case MessageTypeBegin:
+		msg = &Begin{}
+	case MessageTypeCommit:
+		msg = &Commit{}
+	case MessageTypeOrigin:
+		msg = &Origin{}
+	case MessageTypeRelation:
+		msg = &Relation{}
+	case MessageTypeType:
+		msg = &Type{}
+	case MessageTypeInsert:
+		msg = &Insert{}
+	case MessageTypeUpdate:
+		msg = &Update{}
+	case MessageTypeDelete:
+		msg = &Delete{}
+	case MessageTypeTruncate:
+		msg = &Truncate{}
+	case MessageTypeMessage:
+		msg = &LogicalDecodingMessage{}
+	case MessageTypeStreamStart:
+		msg = &StreamStart{}
+	case MessageTypeStreamStop:
+		msg = &StreamStop{}
+	case MessageTypeStreamCommit:
+		msg = &StreamCommit{}
+	case MessageTypeStreamAbort:
+		msg = &StreamAbort{}
+	default:
+		return nil, fmt.Errorf("unknown pgoutput message type: %c", data[0])
+	}
+
+	err := msg.Decode(data[1:], inStream)
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+type invalidMessageFormatErr struct {
+	messageType string
+	details     string
+}
+
+func (e *invalidMessageFormatErr) Error() string {
+	return fmt.Sprintf("%s body is invalid %s", e.messageType, e.details)
+}
+
+// decoder reads big-endian values from a message body. The first read past the end of the body records an error and
+// all subsequent reads return zero values.
+type decoder struct {
+	src         []byte
+	rp          int
+	messageType string
+	err         error
+}
+
+func (d *decoder) fail(details string) {
+	if d.err == nil {
+		d.err = &invalidMessageFormatErr{messageType: d.messageType, details: details}
+	}
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.src)-d.rp < n {
+		d.fail("(too short)")
+		return nil
+	}
+	buf := d.src[d.rp : d.rp+n]
+	d.rp += n
+	return buf
+}
+
+func (d *decoder) uint8() uint8 {
+	buf := d.next(1)
+	if buf == nil {
+		return 0
+	}
+	return buf[0]
+}
+
+// count reads a uint16 count of items that are each encoded in at least minSize bytes. It fails if the rest of the
+// body is too short for that many items so a corrupt count cannot cause a huge allocation.
+func (d *decoder) count(minSize int) int {
+	buf := d.next(2)
+	if buf == nil {
+		return 0
+	}
+	n := int(binary.BigEndian.Uint16(buf))
+	if n*minSize > d.remaining() {
+		d.fail("(count exceeds body length)")
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) uint32() uint32 {
+	buf := d.next(4)
+	if buf == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(buf)
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) uint64() uint64 {
+	buf := d.next(8)
+	if buf == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+func (d *decoder) lsn() pgproto3.LSN {
+	return pgproto3.LSN(d.uint64())
+}
+
+func (d *decoder) time() time.Time {
+	return pgproto3.PgTimeToTime(int64(d.uint64()))
+}
+
+func (d *decoder) string() string {
+	if d.err != nil {
+		return ""
+	}
+	for i := d.rp; i < len(d.src); i++ {
+		if d.src[i] == 0 {
+			s := string(d.src[d.rp:i])
+			d.rp = i + 1
+			return s
+		}
+	}
+	d.fail("(unterminated string)")
+	return ""
+}
+
+func (d *decoder) remaining() int {
+	return len(d.src) - d.rp
+}
+
+// finish returns the first decoding error or an error if any bytes were not consumed.
+func (d *decoder) finish() error {
+	if d.err == nil && d.rp != len(d.src) {
+		d.fail(fmt.Sprintf("(%d extra bytes)", len(d.src)-d.rp))
+	}
+	return d.err
+}
@@ -0,0 +1,286 @@
+package pgoutput_test
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgoutput"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Fixtures in the format produced by pgoutput for a users (id int4 primary key, name text, created_at timestamptz)
+// table with relation ID 16394.
+const (
+	fixtureBegin        = "4200000016b374d848000292123209f000000002ef"
+	fixtureRelation     = "520000400a7075626c6963007573657273006400030169640000000017ffffffff006e616d650000000019ffffffff00637265617465645f617400000004a0ffffffff"
+	fixtureInsert       = "490000400a4e00037400000001317400000005616c6963656e"
+	fixtureUpdate       = "550000400a4b00037400000001316e6e4e00037400000001317400000003626f6275"
+	fixtureDelete       = "440000400a4f00037400000001317400000003626f627400000016323032322d31322d30352031323a30303a30302b3030"
+	fixtureTruncate     = "5400000002010000400a00004010"
+	fixtureCommit       = "430000000016b374d84800000016b374d878000292123209f000"
+	fixtureMessage      = "4d0100000016b374d8486175646974000000000568656c6c6f"
+	fixtureOrigin       = "4f00000016b374d8486e6f64653100"
+	fixtureType         = "59000040747075626c6963006d6f6f6400"
+	fixtureStreamStart  = "53000002f001"
+	fixtureStreamInsert = "49000002f00000400a4e000374000000013274000000056361726f6c6e"
+	fixtureStreamStop   = "45"
+	fixtureStreamCommit = "63000002f00000000016b374d84800000016b374d878000292123209f000"
+	fixtureStreamAbort  = "41000002f0000002f1"
+)
+
+var fixtureTime = time.Date(2022, 12, 5, 12, 0, 0, 0, time.UTC)
+
+const fixtureLSN = 0x16B374D848
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	buf, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return buf
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		fixture  string
+		inStream bool
+		want     pgoutput.Message
+	}{
+		{
+			fixture: fixtureBegin,
+			want:    &pgoutput.Begin{FinalLSN: fixtureLSN, CommitTime: fixtureTime, Xid: 751},
+		},
+		{
+			fixture: fixtureRelation,
+			want: &pgoutput.Relation{
+				RelationID:      16394,
+				Namespace:       "public",
+				RelationName:    "users",
+				ReplicaIdentity: 'd',
+				Columns: []pgoutput.RelationColumn{
+					{Flags: 1, Name: "id", DataType: pgtype.Int4OID, TypeModifier: -1},
+					{Flags: 0, Name: "name", DataType: pgtype.TextOID, TypeModifier: -1},
+					{Flags: 0, Name: "created_at", DataType: pgtype.TimestamptzOID, TypeModifier: -1},
+				},
+			},
+		},
+		{
+			fixture: fixtureInsert,
+			want: &pgoutput.Insert{
+				RelationID: 16394,
+				Tuple: &pgoutput.TupleData{Columns: []pgoutput.TupleDataColumn{
+					{Kind: 't', Data: []byte("1")},
+					{Kind: 't', Data: []byte("alice")},
+					{Kind: 'n'},
+				}},
+			},
+		},
+		{
+			fixture: fixtureUpdate,
+			want: &pgoutput.Update{
+				RelationID:   16394,
+				OldTupleType: 'K',
+				OldTuple: &pgoutput.TupleData{Columns: []pgoutput.TupleDataColumn{
+					{Kind: 't', Data: []byte("1")},
+					{Kind: 'n'},
+					{Kind: 'n'},
+				}},
+				NewTuple: &pgoutput.TupleData{Columns: []pgoutput.TupleDataColumn{
+					{Kind: 't', Data: []byte("1")},
+					{Kind: 't', Data: []byte("bob")},
+					{Kind: 'u'},
+				}},
+			},
+		},
+		{
+			fixture: fixtureDelete,
+			want: &pgoutput.Delete{
+				RelationID:   16394,
+				OldTupleType: 'O',
+				OldTuple: &pgoutput.TupleData{Columns: []pgoutput.TupleDataColumn{
+					{Kind: 't', Data: []byte("1")},
+					{Kind: 't', Data: []byte("bob")},
+					{Kind: 't', Data: []byte("2022-12-05 12:00:00+00")},
+				}},
+			},
+		},
+		{
+			fixture: fixtureTruncate,
+			want:    &pgoutput.Truncate{Options: pgoutput.TruncateOptionCascade, RelationIDs: []uint32{16394, 16400}},
+		},
+		{
+			fixture: fixtureCommit,
+			want:    &pgoutput.Commit{CommitLSN: fixtureLSN, TransactionEndLSN: fixtureLSN + 0x30, CommitTime: fixtureTime},
+		},
+		{
+			fixture: fixtureMessage,
+			want:    &pgoutput.LogicalDecodingMessage{Transactional: true, LSN: fixtureLSN, Prefix: "audit", Content: []byte("hello")},
+		},
+		{
+			fixture: fixtureOrigin,
+			want:    &pgoutput.Origin{CommitLSN: fixtureLSN, Name: "node1"},
+		},
+		{
+			fixture: fixtureType,
+			want:    &pgoutput.Type{DataType: 16500, Namespace: "public", Name: "mood"},
+		},
+		{
+			fixture: fixtureStreamStart,
+			want:    &pgoutput.StreamStart{Xid: 752, FirstSegment: true},
+		},
+		{
+			fixture:  fixtureStreamInsert,
+			inStream: true,
+			want: &pgoutput.Insert{
+				Xid:        752,
+				RelationID: 16394,
+				Tuple: &pgoutput.TupleData{Columns: []pgoutput.TupleDataColumn{
+					{Kind: 't', Data: []byte("2")},
+					{Kind: 't', Data: []byte("carol")},
+					{Kind: 'n'},
+				}},
+			},
+		},
+		{
+			fixture: fixtureStreamStop,
+			want:    &pgoutput.StreamStop{},
+		},
+		{
+			fixture: fixtureStreamCommit,
+			want:    &pgoutput.StreamCommit{Xid: 752, CommitLSN: fixtureLSN, TransactionEndLSN: fixtureLSN + 0x30, CommitTime: fixtureTime},
+		},
+		{
+			fixture: fixtureStreamAbort,
+			want:    &pgoutput.StreamAbort{Xid: 752, SubXid: 753},
+		},
+	}
+
+	for i, tt := range tests {
+		msg, err := pgoutput.Parse(mustDecodeHex(t, tt.fixture), tt.inStream)
+		if !assert.NoErrorf(t, err, "%d", i) {
+			continue
+		}
+
+		assert.Equalf(t, tt.want.Type(), msg.Type(), "%d", i)
+
+		// time.Time values are compared with Equal rather than by their internal representation.
+		switch msg := msg.(type) {
+		case *pgoutput.Begin:
+			assert.Truef(t, fixtureTime.Equal(msg.CommitTime), "%d", i)
+			msg.CommitTime = fixtureTime
+		case *pgoutput.Commit:
+			assert.Truef(t, fixtureTime.Equal(msg.CommitTime), "%d", i)
+			msg.CommitTime = fixtureTime
+		case *pgoutput.StreamCommit:
+			assert.Truef(t, fixtureTime.Equal(msg.CommitTime), "%d", i)
+			msg.CommitTime = fixtureTime
+		}
+
+		assert.Equalf(t, tt.want, msg, "%d", i)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	for i, s := range []string{
+		"",
+		"7a",                               // unknown message type
+		fixtureBegin[:len(fixtureBegin)-2], // truncated
+		fixtureBegin + "00",                // extra bytes
+		"490000400a4e0001780000",           // unknown tuple data kind
+		"4f00000016b374d8486e6f6465",       // unterminated string
+		"5400000003010000400a00004010",     // relation count does not match
+		"520000000100610064ffff",           // relation column count exceeds body
+		"490000400a4effff",                 // tuple column count exceeds body
+		"490000400a4e00027400000001",       // truncated tuple data
+	} {
+		_, err := pgoutput.Parse(mustDecodeHex(t, s), false)
+		assert.Errorf(t, err, "%d", i)
+	}
+}
+
+func TestDecoderDecodeTuple(t *testing.T) {
+	t.Parallel()
+
+	d := pgoutput.NewDecoder(nil)
+
+	for _, fixture := range []string{fixtureBegin, fixtureRelation} {
+		_, err := d.Decode(mustDecodeHex(t, fixture))
+		require.NoError(t, err)
+	}
+
+	rel, ok := d.Relation(16394)
+	require.True(t, ok)
+	assert.Equal(t, "users", rel.RelationName)
+
+	msg, err := d.Decode(mustDecodeHex(t, fixtureInsert))
+	require.NoError(t, err)
+	insert := msg.(*pgoutput.Insert)
+	values, err := d.DecodeTuple(insert.RelationID, insert.Tuple)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"id": int32(1), "name": "alice", "created_at": nil}, values)
+
+	msg, err = d.Decode(mustDecodeHex(t, fixtureUpdate))
+	require.NoError(t, err)
+	update := msg.(*pgoutput.Update)
+	values, err = d.DecodeTuple(update.RelationID, update.NewTuple)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"id": int32(1), "name": "bob"}, values, "unchanged TOAST column is omitted")
+
+	msg, err = d.Decode(mustDecodeHex(t, fixtureDelete))
+	require.NoError(t, err)
+	del := msg.(*pgoutput.Delete)
+	values, err = d.DecodeTuple(del.RelationID, del.OldTuple)
+	require.NoError(t, err)
+	require.IsType(t, time.Time{}, values["created_at"])
+	assert.True(t, fixtureTime.Equal(values["created_at"].(time.Time)))
+
+	_, err = d.DecodeTuple(1, insert.Tuple)
+	assert.Error(t, err, "unknown relation")
+}
+
+func TestDecoderTracksStream(t *testing.T) {
+	t.Parallel()
+
+	d := pgoutput.NewDecoder(nil)
+
+	_, err := d.Decode(mustDecodeHex(t, fixtureRelation))
+	require.NoError(t, err)
+
+	_, err = d.Decode(mustDecodeHex(t, fixtureStreamStart))
+	require.NoError(t, err)
+
+	msg, err := d.Decode(mustDecodeHex(t, fixtureStreamInsert))
+	require.NoError(t, err)
+	insert := msg.(*pgoutput.Insert)
+	assert.EqualValues(t, 752, insert.Xid)
+	values, err := d.DecodeTuple(insert.RelationID, insert.Tuple)
+	require.NoError(t, err)
+	assert.Equal(t, "carol", values["name"])
+
+	_, err = d.Decode(mustDecodeHex(t, fixtureStreamStop))
+	require.NoError(t, err)
+
+	// Outside of a stream the insert is decoded without the transaction ID.
+	msg, err = d.Decode(mustDecodeHex(t, fixtureInsert))
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, msg.(*pgoutput.Insert).Xid)
+}
+
+func TestDecoderDecodeCopiesData(t *testing.T) {
+	t.Parallel()
+
+	d := pgoutput.NewDecoder(nil)
+	buf := mustDecodeHex(t, fixtureMessage)
+	msg, err := d.Decode(buf)
+	require.NoError(t, err)
+
+	for i := range buf {
+		buf[i] = 0
+	}
+	assert.Equal(t, []byte("hello"), msg.(*pgoutput.LogicalDecodingMessage).Content)
+}
@@ -0,0 +1,154 @@
+package pgoutput
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Tuple column data kinds.
+const (
+	TupleDataKindNull      = 'n' // the column is NULL
+	TupleDataKindUnchanged = 'u' // an unchanged TOASTed value; the actual value is not sent
+	TupleDataKindText      = 't' // the value is in text format
+	TupleDataKindBinary    = 'b' // the value is in binary format (only with the binary option)
+)
+
+// TupleDataColumn is a single column of a TupleData.
+type TupleDataColumn struct {
+	Kind uint8 // one of the TupleDataKind* constants
+	Data []byte
+}
+
+// TupleData is the row data carried by Insert, Update, and Delete messages.
+type TupleData struct {
+	Columns []TupleDataColumn
+}
+
+func (d *decoder) tupleData() *TupleData {
+	columnCount := d.count(1) // kind
+	if d.err != nil {
+		return nil
+	}
+
+	td := &TupleData{Columns: make([]TupleDataColumn, columnCount)}
+	for i := range td.Columns {
+		c := &td.Columns[i]
+		c.Kind = d.uint8()
+		switch c.Kind {
+		case TupleDataKindNull, TupleDataKindUnchanged:
+		case TupleDataKindText, TupleDataKindBinary:
+			c.Data = d.next(int(d.int32()))
+		default:
+			if d.err == nil {
+				d.fail(fmt.Sprintf("(unknown tuple data kind %q)", c.Kind))
+			}
+		}
+		if d.err != nil {
+			return nil
+		}
+	}
+
+	return td
+}
+
+// Decoder decodes a stream of pgoutput messages. It tracks whether messages are part of a streamed transaction and
+// remembers the most recent Relation message for each relation so tuple data can be decoded into Go values.
+//
+// A Decoder is not safe for concurrent use.
+type Decoder struct {
+	typeMap   *pgtype.Map
+	relations map[uint32]*Relation
+	inStream  bool
+}
+
+// NewDecoder returns a new Decoder. typeMap is used to decode tuple columns. If typeMap is nil, pgtype.NewMap() is
+// used.
+func NewDecoder(typeMap *pgtype.Map) *Decoder {
+	if typeMap == nil {
+		typeMap = pgtype.NewMap()
+	}
+	return &Decoder{
+		typeMap:   typeMap,
+		relations: make(map[uint32]*Relation),
+	}
+}
+
+// Decode decodes a single pgoutput message. Unlike Parse, the returned message does not retain a reference to data.
+func (d *Decoder) Decode(data []byte) (Message, error) {
+	data = append([]byte(nil), data...)
+
+	msg, err := Parse(data, d.inStream)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg := msg.(type) {
+	case *StreamStart:
+		d.inStream = true
+	case *StreamStop:
+		d.inStream = false
+	case *Relation:
+		d.relations[msg.RelationID] = msg
+	}
+
+	return msg, nil
+}
+
+// Relation returns the most recently received Relation message for relationID.
+func (d *Decoder) Relation(relationID uint32) (*Relation, bool) {
+	rel, ok := d.relations[relationID]
+	return rel, ok
+}
+
+// DecodeTuple decodes the columns of tuple according to the Relation for relationID. The result maps column names to
+// Go values. NULL columns map to nil. Unchanged TOASTed columns are omitted because their value is not known.
+// Columns with a data type not registered in the type map decode to string for text format and []byte for binary
+// format.
+func (d *Decoder) DecodeTuple(relationID uint32, tuple *TupleData) (map[string]any, error) {
+	rel, ok := d.relations[relationID]
+	if !ok {
+		return nil, fmt.Errorf("unknown relation ID %d", relationID)
+	}
+
+	if tuple == nil {
+		return nil, nil
+	}
+
+	if len(tuple.Columns) != len(rel.Columns) {
+		return nil, fmt.Errorf("tuple has %d columns but relation %s.%s has %d", len(tuple.Columns), rel.Namespace, rel.RelationName, len(rel.Columns))
+	}
+
+	values := make(map[string]any, len(tuple.Columns))
+	for i, col := range tuple.Columns {
+		relCol := &rel.Columns[i]
+		switch col.Kind {
+		case TupleDataKindNull:
+			values[relCol.Name] = nil
+		case TupleDataKindUnchanged:
+		case TupleDataKindText, TupleDataKindBinary:
+			format := int16(pgtype.TextFormatCode)
+			if col.Kind == TupleDataKindBinary {
+				format = pgtype.BinaryFormatCode
+			}
+			value, err := d.decodeColumn(relCol.DataType, format, col.Data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode column %s: %w", relCol.Name, err)
+			}
+			values[relCol.Name] = value
+		}
+	}
+
+	return values, nil
+}
+
+func (d *Decoder) decodeColumn(oid uint32, format int16, data []byte) (any, error) {
+	if dt, ok := d.typeMap.TypeForOID(oid); ok {
+		return dt.Codec.DecodeValue(d.typeMap, oid, format, data)
+	}
+
+	if format == pgtype.TextFormatCode {
+		return string(data), nil
+	}
+	return append([]byte(nil), data...), nil
+}
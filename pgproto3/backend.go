@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
 )
 
 // Backend acts as a server for the PostgreSQL wire protocol version 3.
@@ -38,6 +39,7 @@ type Backend struct {
 	terminate      Terminate
 
 	bodyLen    int
+	maxBodyLen int // maxBodyLen is the maximum length of a message body in octets. If 0 there is no limit.
 	msgType    byte
 	partialMsg bool
 	authType   uint32
@@ -83,12 +85,13 @@ func (b *Backend) Flush() error {
 }
 
 // Trace starts tracing the message traffic to w. It writes in a similar format to that produced by the libpq function
-// PQtrace.
+// PQtrace or as JSON if options.JSON is set.
 func (b *Backend) Trace(w io.Writer, options TracerOptions) {
 	b.tracer = &tracer{
 		w:             w,
 		buf:           &bytes.Buffer{},
 		TracerOptions: options,
+		start:         time.Now(),
 	}
 }
 
@@ -118,13 +121,15 @@ func (b *Backend) ReceiveStartupMessage() (FrontendMessage, error) {
 
 	code := binary.BigEndian.Uint32(buf)
 
-	switch code {
-	case ProtocolVersionNumber:
+	if code>>16 == protocolMajorVersion3 {
 		err = b.startupMessage.Decode(buf)
 		if err != nil {
 			return nil, err
 		}
 		return &b.startupMessage, nil
+	}
+
+	switch code {
 	case sslRequestNumber:
 		err = b.sslRequest.Decode(buf)
 		if err != nil {
@@ -148,6 +153,13 @@ func (b *Backend) ReceiveStartupMessage() (FrontendMessage, error) {
 	}
 }
 
+// SetMaxBodyLen sets the maximum length of a message body in octets. If a message body exceeds this length, Receive
+// returns an *ExceededMaxBodyLenErr without reading the body. This protects against a malicious or faulty client forcing
+// excessive memory allocation. If maxBodyLen is 0 there is no limit (the default).
+func (b *Backend) SetMaxBodyLen(maxBodyLen int) {
+	b.maxBodyLen = maxBodyLen
+}
+
 // Receive receives a message from the frontend. The returned message is only valid until the next call to Receive.
 func (b *Backend) Receive() (FrontendMessage, error) {
 	if !b.partialMsg {
@@ -157,7 +169,16 @@ func (b *Backend) Receive() (FrontendMessage, error) {
 		}
 
 		b.msgType = header[0]
-		b.bodyLen = int(binary.BigEndian.Uint32(header[1:])) - 4
+
+		msgLength := int(binary.BigEndian.Uint32(header[1:]))
+		if msgLength < 4 {
+			return nil, fmt.Errorf("invalid message length: %d", msgLength)
+		}
+
+		b.bodyLen = msgLength - 4
+		if b.maxBodyLen > 0 && b.bodyLen > b.maxBodyLen {
+			return nil, &ExceededMaxBodyLenErr{b.maxBodyLen, b.bodyLen}
+		}
 		b.partialMsg = true
 	}
 
@@ -2,14 +2,24 @@ package pgproto3
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 
 	"github.com/jackc/pgx/v5/internal/pgio"
 )
 
+// maxSecretKeyLen is the maximum length of a secret key. Protocol version 3.0 always uses 4 byte keys. Protocol version
+// 3.2 allows variable-length keys of up to 256 bytes.
+const maxSecretKeyLen = 256
+
 type BackendKeyData struct {
 	ProcessID uint32
 	SecretKey uint32
+
+	// LongSecretKey is the secret key if it is longer than 4 bytes. Protocol version 3.2 allows secret keys of up to 256
+	// bytes. SecretKey is ignored if LongSecretKey is set.
+	LongSecretKey []byte
 }
 
 // Backend identifies this message as sendable by the PostgreSQL backend.
@@ -18,12 +28,12 @@ func (*BackendKeyData) Backend() {}
 // Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
 // type identifier and 4 byte message length.
 func (dst *BackendKeyData) Decode(src []byte) error {
-	if len(src) != 8 {
-		return &invalidMessageLenErr{messageType: "BackendKeyData", expectedLen: 8, actualLen: len(src)}
+	if len(src) < 8 || len(src) > 4+maxSecretKeyLen {
+		return &invalidMessageFormatErr{messageType: "BackendKeyData", details: fmt.Sprintf("invalid length %d", len(src))}
 	}
 
 	dst.ProcessID = binary.BigEndian.Uint32(src[:4])
-	dst.SecretKey = binary.BigEndian.Uint32(src[4:])
+	dst.SecretKey, dst.LongSecretKey = decodeSecretKey(src[4:], dst.LongSecretKey)
 
 	return nil
 }
@@ -31,9 +41,9 @@ func (dst *BackendKeyData) Decode(src []byte) error {
 // Encode encodes src into dst. dst will include the 1 byte message type identifier and the 4 byte message length.
 func (src *BackendKeyData) Encode(dst []byte) []byte {
 	dst = append(dst, 'K')
-	dst = pgio.AppendUint32(dst, 12)
+	dst = pgio.AppendUint32(dst, uint32(8+secretKeyLen(src.LongSecretKey)))
 	dst = pgio.AppendUint32(dst, src.ProcessID)
-	dst = pgio.AppendUint32(dst, src.SecretKey)
+	dst = appendSecretKey(dst, src.SecretKey, src.LongSecretKey)
 	return dst
 }
 
@@ -42,10 +52,91 @@ func (src BackendKeyData) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type      string
 		ProcessID uint32
-		SecretKey uint32
+		SecretKey any
 	}{
 		Type:      "BackendKeyData",
 		ProcessID: src.ProcessID,
-		SecretKey: src.SecretKey,
+		SecretKey: secretKeyToJSON(src.SecretKey, src.LongSecretKey),
 	})
 }
+
+// UnmarshalJSON implements encoding/json.Unmarshaler.
+func (dst *BackendKeyData) UnmarshalJSON(data []byte) error {
+	// Ignore null, like in the main JSON package.
+	if string(data) == "null" {
+		return nil
+	}
+
+	var msg struct {
+		ProcessID uint32
+		SecretKey json.RawMessage
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	secretKey, longSecretKey, err := secretKeyFromJSON(msg.SecretKey)
+	if err != nil {
+		return err
+	}
+
+	dst.ProcessID = msg.ProcessID
+	dst.SecretKey = secretKey
+	dst.LongSecretKey = longSecretKey
+	return nil
+}
+
+// decodeSecretKey decodes src into a 4 byte secret key or a long secret key. buf is reused for a long secret key.
+func decodeSecretKey(src, buf []byte) (uint32, []byte) {
+	if len(src) == 4 {
+		return binary.BigEndian.Uint32(src), nil
+	}
+	return 0, append(buf[:0], src...)
+}
+
+// secretKeyLen returns the encoded length of a secret key.
+func secretKeyLen(longKey []byte) int {
+	if longKey != nil {
+		return len(longKey)
+	}
+	return 4
+}
+
+// appendSecretKey appends longKey if it is set and key otherwise.
+func appendSecretKey(dst []byte, key uint32, longKey []byte) []byte {
+	if longKey != nil {
+		return append(dst, longKey...)
+	}
+	return pgio.AppendUint32(dst, key)
+}
+
+// secretKeyToJSON returns the JSON representation of a secret key. A 4 byte key is represented as a number for
+// compatibility with protocol version 3.0. A long key is represented as a hex string.
+func secretKeyToJSON(key uint32, longKey []byte) any {
+	if longKey != nil {
+		return hex.EncodeToString(longKey)
+	}
+	return key
+}
+
+func secretKeyFromJSON(data json.RawMessage) (uint32, []byte, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return 0, nil, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		longKey, err := hex.DecodeString(s)
+		if err != nil {
+			return 0, nil, err
+		}
+		key, longKey := decodeSecretKey(longKey, nil)
+		return key, longKey, nil
+	}
+
+	var n uint32
+	if err := json.Unmarshal(data, &n); err != nil {
+		return 0, nil, err
+	}
+	return n, nil, nil
+}
@@ -61,6 +61,36 @@ func TestBackendReceiveUnexpectedEOF(t *testing.T) {
 	assert.Equal(t, io.ErrUnexpectedEOF, err)
 }
 
+func TestBackendReceiveExceededMaxBodyLen(t *testing.T) {
+	t.Parallel()
+
+	client := &interruptReader{}
+	client.push([]byte{'Q', 0, 0, 10, 10})
+
+	backend := pgproto3.NewBackend(client, nil)
+	backend.SetMaxBodyLen(2048)
+
+	msg, err := backend.Receive()
+	assert.Nil(t, msg)
+	var invalidBodyLenErr *pgproto3.ExceededMaxBodyLenErr
+	require.ErrorAs(t, err, &invalidBodyLenErr)
+	assert.Equal(t, 2048, invalidBodyLenErr.MaxExpectedBodyLen)
+	assert.Equal(t, 2566, invalidBodyLenErr.ActualBodyLen)
+}
+
+func TestBackendReceiveInvalidMessageLength(t *testing.T) {
+	t.Parallel()
+
+	client := &interruptReader{}
+	client.push([]byte{'Q', 0, 0, 0, 3})
+
+	backend := pgproto3.NewBackend(client, nil)
+
+	msg, err := backend.Receive()
+	assert.Nil(t, msg)
+	require.Error(t, err)
+}
+
 func TestStartupMessage(t *testing.T) {
 	t.Parallel()
 
@@ -84,6 +114,24 @@ func TestStartupMessage(t *testing.T) {
 		require.Equal(t, want, msg)
 	})
 
+	t.Run("protocol version 3.2", func(t *testing.T) {
+		want := &pgproto3.StartupMessage{
+			ProtocolVersion: pgproto3.ProtocolVersion32,
+			Parameters: map[string]string{
+				"username": "tester",
+			},
+		}
+
+		server := &interruptReader{}
+		server.push(want.Encode(nil))
+
+		backend := pgproto3.NewBackend(server, nil)
+
+		msg, err := backend.ReceiveStartupMessage()
+		require.NoError(t, err)
+		require.Equal(t, want, msg)
+	})
+
 	t.Run("invalid packet length", func(t *testing.T) {
 		wantErr := "invalid length of startup packet"
 		tests := []struct {
@@ -120,3 +168,22 @@ func TestStartupMessage(t *testing.T) {
 		}
 	})
 }
+
+func TestCancelRequest(t *testing.T) {
+	t.Parallel()
+
+	for _, want := range []*pgproto3.CancelRequest{
+		{ProcessID: 42, SecretKey: 7},
+		{ProcessID: 42, LongSecretKey: []byte("a variable-length protocol version 3.2 secret key")},
+	} {
+
+		server := &interruptReader{}
+		server.push(want.Encode(nil))
+
+		backend := pgproto3.NewBackend(server, nil)
+
+		msg, err := backend.ReceiveStartupMessage()
+		require.NoError(t, err)
+		require.Equal(t, want, msg)
+	}
+}
@@ -21,6 +21,26 @@ type Bind struct {
 // Frontend identifies this message as sendable by a PostgreSQL frontend.
 func (*Bind) Frontend() {}
 
+// ExpandFormatCodes returns the format code of each of n values given the ParameterFormatCodes or ResultFormatCodes of
+// a Bind message. No format codes means text for all values, one format code applies to all values, and otherwise
+// there must be a format code for each value. An error is returned if the number of format codes is invalid.
+func ExpandFormatCodes(formatCodes []int16, n int) ([]int16, error) {
+	switch len(formatCodes) {
+	case n:
+		return formatCodes, nil
+	case 0:
+		return make([]int16, n), nil
+	case 1:
+		expanded := make([]int16, n)
+		for i := range expanded {
+			expanded[i] = formatCodes[0]
+		}
+		return expanded, nil
+	default:
+		return nil, fmt.Errorf("%d format codes for %d values", len(formatCodes), n)
+	}
+}
+
 // Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
 // type identifier and 4 byte message length.
 func (dst *Bind) Decode(src []byte) error {
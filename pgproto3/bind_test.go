@@ -0,0 +1,35 @@
+package pgproto3_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandFormatCodes(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		formatCodes []int16
+		n           int
+		expected    []int16
+	}{
+		{formatCodes: nil, n: 0, expected: nil},
+		{formatCodes: nil, n: 2, expected: []int16{0, 0}},
+		{formatCodes: []int16{1}, n: 3, expected: []int16{1, 1, 1}},
+		{formatCodes: []int16{1}, n: 1, expected: []int16{1}},
+		{formatCodes: []int16{0, 1}, n: 2, expected: []int16{0, 1}},
+	} {
+		formatCodes, err := pgproto3.ExpandFormatCodes(tt.formatCodes, tt.n)
+		require.NoErrorf(t, err, "%d", i)
+		assert.Equalf(t, tt.expected, formatCodes, "%d", i)
+	}
+
+	_, err := pgproto3.ExpandFormatCodes([]int16{0, 1}, 3)
+	require.EqualError(t, err, "2 format codes for 3 values")
+
+	_, err = pgproto3.ExpandFormatCodes([]int16{0, 1}, 0)
+	require.Error(t, err)
+}
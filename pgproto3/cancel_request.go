@@ -13,13 +13,17 @@ const cancelRequestCode = 80877102
 type CancelRequest struct {
 	ProcessID uint32
 	SecretKey uint32
+
+	// LongSecretKey is the secret key if it is longer than 4 bytes. Protocol version 3.2 allows secret keys of up to 256
+	// bytes. SecretKey is ignored if LongSecretKey is set.
+	LongSecretKey []byte
 }
 
 // Frontend identifies this message as sendable by a PostgreSQL frontend.
 func (*CancelRequest) Frontend() {}
 
 func (dst *CancelRequest) Decode(src []byte) error {
-	if len(src) != 12 {
+	if len(src) < 12 || len(src) > 8+maxSecretKeyLen {
 		return errors.New("bad cancel request size")
 	}
 
@@ -30,17 +34,17 @@ func (dst *CancelRequest) Decode(src []byte) error {
 	}
 
 	dst.ProcessID = binary.BigEndian.Uint32(src[4:])
-	dst.SecretKey = binary.BigEndian.Uint32(src[8:])
+	dst.SecretKey, dst.LongSecretKey = decodeSecretKey(src[8:], dst.LongSecretKey)
 
 	return nil
 }
 
 // Encode encodes src into dst. dst will include the 4 byte message length.
 func (src *CancelRequest) Encode(dst []byte) []byte {
-	dst = pgio.AppendInt32(dst, 16)
+	dst = pgio.AppendInt32(dst, int32(12+secretKeyLen(src.LongSecretKey)))
 	dst = pgio.AppendInt32(dst, cancelRequestCode)
 	dst = pgio.AppendUint32(dst, src.ProcessID)
-	dst = pgio.AppendUint32(dst, src.SecretKey)
+	dst = appendSecretKey(dst, src.SecretKey, src.LongSecretKey)
 	return dst
 }
 
@@ -49,10 +53,36 @@ func (src CancelRequest) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type      string
 		ProcessID uint32
-		SecretKey uint32
+		SecretKey any
 	}{
 		Type:      "CancelRequest",
 		ProcessID: src.ProcessID,
-		SecretKey: src.SecretKey,
+		SecretKey: secretKeyToJSON(src.SecretKey, src.LongSecretKey),
 	})
 }
+
+// UnmarshalJSON implements encoding/json.Unmarshaler.
+func (dst *CancelRequest) UnmarshalJSON(data []byte) error {
+	// Ignore null, like in the main JSON package.
+	if string(data) == "null" {
+		return nil
+	}
+
+	var msg struct {
+		ProcessID uint32
+		SecretKey json.RawMessage
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+
+	secretKey, longSecretKey, err := secretKeyFromJSON(msg.SecretKey)
+	if err != nil {
+		return err
+	}
+
+	dst.ProcessID = msg.ProcessID
+	dst.SecretKey = secretKey
+	dst.LongSecretKey = longSecretKey
+	return nil
+}
@@ -63,9 +63,11 @@ func (src *CopyBothResponse) Encode(dst []byte) []byte {
 func (src CopyBothResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type              string
+		OverallFormat     string
 		ColumnFormatCodes []uint16
 	}{
 		Type:              "CopyBothResponse",
+		OverallFormat:     string(src.OverallFormat),
 		ColumnFormatCodes: src.ColumnFormatCodes,
 	})
 }
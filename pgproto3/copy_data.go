@@ -57,6 +57,10 @@ func (dst *CopyData) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	dst.Data = []byte(msg.Data)
+	data, err := hex.DecodeString(msg.Data)
+	if err != nil {
+		return err
+	}
+	dst.Data = data
 	return nil
 }
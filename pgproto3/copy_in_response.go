@@ -64,9 +64,11 @@ func (src *CopyInResponse) Encode(dst []byte) []byte {
 func (src CopyInResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type              string
+		OverallFormat     string
 		ColumnFormatCodes []uint16
 	}{
 		Type:              "CopyInResponse",
+		OverallFormat:     string(src.OverallFormat),
 		ColumnFormatCodes: src.ColumnFormatCodes,
 	})
 }
@@ -64,9 +64,11 @@ func (src *CopyOutResponse) Encode(dst []byte) []byte {
 func (src CopyOutResponse) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Type              string
+		OverallFormat     string
 		ColumnFormatCodes []uint16
 	}{
 		Type:              "CopyOutResponse",
+		OverallFormat:     string(src.OverallFormat),
 		ColumnFormatCodes: src.ColumnFormatCodes,
 	})
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx/v5/internal/pgio"
 )
@@ -92,7 +93,8 @@ func (src DataRow) MarshalJSON() ([]byte, error) {
 			continue
 		}
 
-		var hasNonPrintable bool
+		// Invalid UTF-8 would not survive a round trip through a JSON string.
+		hasNonPrintable := !utf8.Valid(v)
 		for _, b := range v {
 			if b < 32 {
 				hasNonPrintable = true
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
 // Frontend acts as a client for the PostgreSQL wire protocol version 3.
@@ -42,6 +43,7 @@ type Frontend struct {
 	emptyQueryResponse              EmptyQueryResponse
 	errorResponse                   ErrorResponse
 	functionCallResponse            FunctionCallResponse
+	negotiateProtocolVersion        NegotiateProtocolVersion
 	noData                          NoData
 	noticeResponse                  NoticeResponse
 	notificationResponse            NotificationResponse
@@ -53,6 +55,7 @@ type Frontend struct {
 	portalSuspended                 PortalSuspended
 
 	bodyLen    int
+	maxBodyLen int // maxBodyLen is the maximum length of a message body in octets. If 0 there is no limit.
 	msgType    byte
 	partialMsg bool
 	authType   uint32
@@ -102,12 +105,13 @@ func (f *Frontend) Flush() error {
 }
 
 // Trace starts tracing the message traffic to w. It writes in a similar format to that produced by the libpq function
-// PQtrace.
+// PQtrace or as JSON if options.JSON is set.
 func (f *Frontend) Trace(w io.Writer, options TracerOptions) {
 	f.tracer = &tracer{
 		w:             w,
 		buf:           &bytes.Buffer{},
 		TracerOptions: options,
+		start:         time.Now(),
 	}
 }
 
@@ -122,7 +126,7 @@ func (f *Frontend) SendBind(msg *Bind) {
 	prevLen := len(f.wbuf)
 	f.wbuf = msg.Encode(f.wbuf)
 	if f.tracer != nil {
-		f.tracer.traceBind('F', int32(len(f.wbuf)-prevLen), msg)
+		f.tracer.traceMessage('F', int32(len(f.wbuf)-prevLen), msg)
 	}
 }
 
@@ -132,7 +136,7 @@ func (f *Frontend) SendParse(msg *Parse) {
 	prevLen := len(f.wbuf)
 	f.wbuf = msg.Encode(f.wbuf)
 	if f.tracer != nil {
-		f.tracer.traceParse('F', int32(len(f.wbuf)-prevLen), msg)
+		f.tracer.traceMessage('F', int32(len(f.wbuf)-prevLen), msg)
 	}
 }
 
@@ -142,7 +146,7 @@ func (f *Frontend) SendClose(msg *Close) {
 	prevLen := len(f.wbuf)
 	f.wbuf = msg.Encode(f.wbuf)
 	if f.tracer != nil {
-		f.tracer.traceClose('F', int32(len(f.wbuf)-prevLen), msg)
+		f.tracer.traceMessage('F', int32(len(f.wbuf)-prevLen), msg)
 	}
 }
 
@@ -152,7 +156,7 @@ func (f *Frontend) SendDescribe(msg *Describe) {
 	prevLen := len(f.wbuf)
 	f.wbuf = msg.Encode(f.wbuf)
 	if f.tracer != nil {
-		f.tracer.traceDescribe('F', int32(len(f.wbuf)-prevLen), msg)
+		f.tracer.traceMessage('F', int32(len(f.wbuf)-prevLen), msg)
 	}
 }
 
@@ -162,7 +166,7 @@ func (f *Frontend) SendExecute(msg *Execute) {
 	prevLen := len(f.wbuf)
 	f.wbuf = msg.Encode(f.wbuf)
 	if f.tracer != nil {
-		f.tracer.TraceQueryute('F', int32(len(f.wbuf)-prevLen), msg)
+		f.tracer.traceMessage('F', int32(len(f.wbuf)-prevLen), msg)
 	}
 }
 
@@ -172,7 +176,7 @@ func (f *Frontend) SendSync(msg *Sync) {
 	prevLen := len(f.wbuf)
 	f.wbuf = msg.Encode(f.wbuf)
 	if f.tracer != nil {
-		f.tracer.traceSync('F', int32(len(f.wbuf)-prevLen), msg)
+		f.tracer.traceMessage('F', int32(len(f.wbuf)-prevLen), msg)
 	}
 }
 
@@ -182,7 +186,7 @@ func (f *Frontend) SendQuery(msg *Query) {
 	prevLen := len(f.wbuf)
 	f.wbuf = msg.Encode(f.wbuf)
 	if f.tracer != nil {
-		f.tracer.traceQuery('F', int32(len(f.wbuf)-prevLen), msg)
+		f.tracer.traceMessage('F', int32(len(f.wbuf)-prevLen), msg)
 	}
 }
 
@@ -201,7 +205,7 @@ func (f *Frontend) SendUnbufferedEncodedCopyData(msg []byte) error {
 	}
 
 	if f.tracer != nil {
-		f.tracer.traceCopyData('F', int32(len(msg)-1), &CopyData{})
+		f.tracer.traceMessage('F', int32(len(msg)-1), &CopyData{})
 	}
 
 	return nil
@@ -214,6 +218,13 @@ func translateEOFtoErrUnexpectedEOF(err error) error {
 	return err
 }
 
+// SetMaxBodyLen sets the maximum length of a message body in octets. If a message body exceeds this length, Receive
+// returns an *ExceededMaxBodyLenErr without reading the body. This protects against a malicious or faulty server forcing
+// excessive memory allocation. If maxBodyLen is 0 there is no limit (the default).
+func (f *Frontend) SetMaxBodyLen(maxBodyLen int) {
+	f.maxBodyLen = maxBodyLen
+}
+
 // Receive receives a message from the backend. The returned message is only valid until the next call to Receive.
 func (f *Frontend) Receive() (BackendMessage, error) {
 	if !f.partialMsg {
@@ -230,6 +241,9 @@ func (f *Frontend) Receive() (BackendMessage, error) {
 		}
 
 		f.bodyLen = msgLength - 4
+		if f.maxBodyLen > 0 && f.bodyLen > f.maxBodyLen {
+			return nil, &ExceededMaxBodyLenErr{f.maxBodyLen, f.bodyLen}
+		}
 		f.partialMsg = true
 	}
 
@@ -286,6 +300,8 @@ func (f *Frontend) Receive() (BackendMessage, error) {
 		msg = &f.parameterDescription
 	case 'T':
 		msg = &f.rowDescription
+	case 'v':
+		msg = &f.negotiateProtocolVersion
 	case 'V':
 		msg = &f.functionCallResponse
 	case 'W':
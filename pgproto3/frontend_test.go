@@ -115,3 +115,30 @@ func TestErrorResponse(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, want, got)
 }
+
+func TestFrontendReceiveExceededMaxBodyLen(t *testing.T) {
+	t.Parallel()
+
+	server := &interruptReader{}
+	server.push([]byte{'D', 0, 0, 10, 10})
+
+	frontend := pgproto3.NewFrontend(server, nil)
+	frontend.SetMaxBodyLen(2048)
+
+	msg, err := frontend.Receive()
+	assert.Nil(t, msg)
+	var invalidBodyLenErr *pgproto3.ExceededMaxBodyLenErr
+	require.ErrorAs(t, err, &invalidBodyLenErr)
+	assert.Equal(t, 2048, invalidBodyLenErr.MaxExpectedBodyLen)
+	assert.Equal(t, 2566, invalidBodyLenErr.ActualBodyLen)
+
+	// A body within the limit is still accepted.
+	server = &interruptReader{}
+	server.push([]byte{'Z', 0, 0, 0, 5, 'I'})
+	frontend = pgproto3.NewFrontend(server, nil)
+	frontend.SetMaxBodyLen(1)
+
+	msg, err = frontend.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, &pgproto3.ReadyForQuery{TxStatus: 'I'}, msg)
+}
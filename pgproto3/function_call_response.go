@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx/v5/internal/pgio"
 )
@@ -59,7 +60,8 @@ func (src *FunctionCallResponse) Encode(dst []byte) []byte {
 // MarshalJSON implements encoding/json.Marshaler.
 func (src FunctionCallResponse) MarshalJSON() ([]byte, error) {
 	var formattedValue map[string]string
-	var hasNonPrintable bool
+	// Invalid UTF-8 would not survive a round trip through a JSON string.
+	hasNonPrintable := !utf8.Valid(src.Result)
 	for _, b := range src.Result {
 		if b < 32 {
 			hasNonPrintable = true
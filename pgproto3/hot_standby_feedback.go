@@ -0,0 +1,45 @@
+package pgproto3
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/jackc/pgx/v5/internal/pgio"
+)
+
+// HotStandbyFeedback is sent by a physical standby on a replication stream to report the oldest transaction it still
+// needs so the primary does not remove rows visible to it.
+type HotStandbyFeedback struct {
+	ClientTime       time.Time
+	Xmin             uint32 // 0 disables feedback
+	XminEpoch        uint32
+	CatalogXmin      uint32 // 0 if there is no catalog_xmin
+	CatalogXminEpoch uint32
+}
+
+// Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
+// type identifier.
+func (dst *HotStandbyFeedback) Decode(src []byte) error {
+	if len(src) != 24 {
+		return &invalidMessageLenErr{messageType: "HotStandbyFeedback", expectedLen: 24, actualLen: len(src)}
+	}
+
+	dst.ClientTime = PgTimeToTime(int64(binary.BigEndian.Uint64(src)))
+	dst.Xmin = binary.BigEndian.Uint32(src[8:])
+	dst.XminEpoch = binary.BigEndian.Uint32(src[12:])
+	dst.CatalogXmin = binary.BigEndian.Uint32(src[16:])
+	dst.CatalogXminEpoch = binary.BigEndian.Uint32(src[20:])
+
+	return nil
+}
+
+// Encode encodes src into dst. dst will include the 1 byte message type identifier.
+func (src *HotStandbyFeedback) Encode(dst []byte) []byte {
+	dst = append(dst, HotStandbyFeedbackByteID)
+	dst = pgio.AppendInt64(dst, TimeToPgTime(src.ClientTime))
+	dst = pgio.AppendUint32(dst, src.Xmin)
+	dst = pgio.AppendUint32(dst, src.XminEpoch)
+	dst = pgio.AppendUint32(dst, src.CatalogXmin)
+	dst = pgio.AppendUint32(dst, src.CatalogXminEpoch)
+	return dst
+}
@@ -109,6 +109,29 @@ func TestJSONUnmarshalBackendKeyData(t *testing.T) {
 	}
 }
 
+func TestJSONBackendKeyDataVariableLengthSecretKey(t *testing.T) {
+	want := BackendKeyData{
+		ProcessID:     8864,
+		LongSecretKey: []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+
+	data, err := json.Marshal(want)
+	if err != nil {
+		t.Fatalf("cannot JSON marshal %v", err)
+	}
+	if string(data) != `{"Type":"BackendKeyData","ProcessID":8864,"SecretKey":"0102030405060708"}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	var got BackendKeyData
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Errorf("cannot JSON unmarshal %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("unmarshaled BackendKeyData struct doesn't match expected value")
+	}
+}
+
 func TestJSONUnmarshalCommandComplete(t *testing.T) {
 	data := []byte(`{"Type":"CommandComplete","CommandTag":"SELECT 1"}`)
 	want := CommandComplete{
@@ -0,0 +1,34 @@
+package pgproto3
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LSN is a PostgreSQL Log Sequence Number, a byte position in the write-ahead log.
+type LSN uint64
+
+// String formats the LSN in the PostgreSQL text format (e.g. 16/B374D848).
+func (lsn LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
+
+// ParseLSN parses an LSN in the PostgreSQL text format (e.g. 16/B374D848).
+func ParseLSN(s string) (LSN, error) {
+	hi, lo, found := strings.Cut(s, "/")
+	if !found {
+		return 0, fmt.Errorf("invalid LSN: %q", s)
+	}
+
+	upper, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN: %q", s)
+	}
+	lower, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN: %q", s)
+	}
+
+	return LSN(upper<<32 | lower), nil
+}
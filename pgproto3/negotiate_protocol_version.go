@@ -0,0 +1,84 @@
+package pgproto3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/internal/pgio"
+)
+
+// NegotiateProtocolVersion is sent by the backend when it does not support the minor protocol version requested by the
+// frontend or does not recognize some of the protocol options (those with names starting with "_pq_.") in the
+// StartupMessage.
+type NegotiateProtocolVersion struct {
+	NewestMinorProtocol uint32
+	UnrecognizedOptions []string
+}
+
+// Backend identifies this message as sendable by the PostgreSQL backend.
+func (*NegotiateProtocolVersion) Backend() {}
+
+// Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
+// type identifier and 4 byte message length.
+func (dst *NegotiateProtocolVersion) Decode(src []byte) error {
+	if len(src) < 8 {
+		return &invalidMessageLenErr{messageType: "NegotiateProtocolVersion", expectedLen: 8, actualLen: len(src)}
+	}
+
+	dst.NewestMinorProtocol = binary.BigEndian.Uint32(src[:4])
+	optionCount := binary.BigEndian.Uint32(src[4:8])
+	rp := 8
+
+	// Each option is at least a null terminator. Don't trust the count any further than that.
+	if uint64(optionCount) > uint64(len(src)-rp) {
+		return &invalidMessageFormatErr{messageType: "NegotiateProtocolVersion", details: "option count exceeds message length"}
+	}
+
+	dst.UnrecognizedOptions = []string{}
+	for i := uint32(0); i < optionCount; i++ {
+		idx := bytes.IndexByte(src[rp:], 0)
+		if idx < 0 {
+			return &invalidMessageFormatErr{messageType: "NegotiateProtocolVersion", details: "unterminated string"}
+		}
+		dst.UnrecognizedOptions = append(dst.UnrecognizedOptions, string(src[rp:rp+idx]))
+		rp += idx + 1
+	}
+
+	if rp != len(src) {
+		return &invalidMessageFormatErr{messageType: "NegotiateProtocolVersion", details: "extra data"}
+	}
+
+	return nil
+}
+
+// Encode encodes src into dst. dst will include the 1 byte message type identifier and the 4 byte message length.
+func (src *NegotiateProtocolVersion) Encode(dst []byte) []byte {
+	dst = append(dst, 'v')
+	sp := len(dst)
+	dst = pgio.AppendInt32(dst, -1)
+
+	dst = pgio.AppendUint32(dst, src.NewestMinorProtocol)
+	dst = pgio.AppendUint32(dst, uint32(len(src.UnrecognizedOptions)))
+	for _, option := range src.UnrecognizedOptions {
+		dst = append(dst, option...)
+		dst = append(dst, 0)
+	}
+
+	pgio.SetInt32(dst[sp:], int32(len(dst[sp:])))
+
+	return dst
+}
+
+// MarshalJSON implements encoding/json.Marshaler.
+func (src NegotiateProtocolVersion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type                string
+		NewestMinorProtocol uint32
+		UnrecognizedOptions []string
+	}{
+		Type:                "NegotiateProtocolVersion",
+		NewestMinorProtocol: src.NewestMinorProtocol,
+		UnrecognizedOptions: src.UnrecognizedOptions,
+	})
+}
@@ -0,0 +1,64 @@
+package pgproto3_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateProtocolVersionEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	want := &pgproto3.NegotiateProtocolVersion{
+		NewestMinorProtocol: 0,
+		UnrecognizedOptions: []string{"_pq_.foo", "_pq_.bar"},
+	}
+
+	server := &interruptReader{}
+	server.push(want.Encode(nil))
+
+	frontend := pgproto3.NewFrontend(server, nil)
+
+	msg, err := frontend.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, want, msg)
+}
+
+func TestNegotiateProtocolVersionDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	for i, src := range [][]byte{
+		{0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0, 1, 'a'},
+		{0, 0, 0, 0, 0, 0, 0, 0, 'a', 0},
+		{0, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff},
+		{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 'a', 0},
+		{0, 0, 0, 0, 0, 0, 0, 2, 'a', 0},
+	} {
+		var msg pgproto3.NegotiateProtocolVersion
+		assert.Errorf(t, msg.Decode(src), "%d", i)
+	}
+}
+
+func TestBackendKeyDataVariableLengthSecretKey(t *testing.T) {
+	t.Parallel()
+
+	want := &pgproto3.BackendKeyData{ProcessID: 42, LongSecretKey: make([]byte, 32)}
+	for i := range want.LongSecretKey {
+		want.LongSecretKey[i] = byte(i)
+	}
+
+	server := &interruptReader{}
+	server.push(want.Encode(nil))
+
+	frontend := pgproto3.NewFrontend(server, nil)
+
+	msg, err := frontend.Receive()
+	require.NoError(t, err)
+	assert.Equal(t, want, msg)
+
+	var tooLong pgproto3.BackendKeyData
+	assert.Error(t, tooLong.Decode(make([]byte, 4+257)))
+}
@@ -53,6 +53,17 @@ func (e *invalidMessageFormatErr) Error() string {
 	return fmt.Sprintf("%s body is invalid %s", e.messageType, e.details)
 }
 
+// ExceededMaxBodyLenErr is returned by Frontend.Receive and Backend.Receive when the length of a message body exceeds
+// the maximum set with SetMaxBodyLen. The message body is not read.
+type ExceededMaxBodyLenErr struct {
+	MaxExpectedBodyLen int
+	ActualBodyLen      int
+}
+
+func (e *ExceededMaxBodyLenErr) Error() string {
+	return fmt.Sprintf("invalid body length: expected max %d, but got %d", e.MaxExpectedBodyLen, e.ActualBodyLen)
+}
+
 type writeError struct {
 	err         error
 	safeToRetry bool
@@ -0,0 +1,45 @@
+package pgproto3
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/jackc/pgx/v5/internal/pgio"
+)
+
+// PrimaryKeepaliveMessage is sent periodically by the server on a replication stream.
+type PrimaryKeepaliveMessage struct {
+	ServerWALEnd LSN // current end of WAL on the server
+	ServerTime   time.Time
+
+	// ReplyRequested is true if the server requests a StandbyStatusUpdate as soon as possible to avoid a timeout
+	// disconnect.
+	ReplyRequested bool
+}
+
+// Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
+// type identifier.
+func (dst *PrimaryKeepaliveMessage) Decode(src []byte) error {
+	if len(src) != 17 {
+		return &invalidMessageLenErr{messageType: "PrimaryKeepaliveMessage", expectedLen: 17, actualLen: len(src)}
+	}
+
+	dst.ServerWALEnd = LSN(binary.BigEndian.Uint64(src))
+	dst.ServerTime = PgTimeToTime(int64(binary.BigEndian.Uint64(src[8:])))
+	dst.ReplyRequested = src[16] == 1
+
+	return nil
+}
+
+// Encode encodes src into dst. dst will include the 1 byte message type identifier.
+func (src *PrimaryKeepaliveMessage) Encode(dst []byte) []byte {
+	dst = append(dst, PrimaryKeepaliveMessageByteID)
+	dst = pgio.AppendUint64(dst, uint64(src.ServerWALEnd))
+	dst = pgio.AppendInt64(dst, TimeToPgTime(src.ServerTime))
+	if src.ReplyRequested {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	return dst
+}
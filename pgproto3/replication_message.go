@@ -0,0 +1,72 @@
+package pgproto3
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReplicationMessage is a streaming replication message carried in the Data of a CopyData message. Unlike Message
+// these are not framed by a length. The first byte of the CopyData payload identifies the message type.
+//
+// See https://www.postgresql.org/docs/current/protocol-replication.html.
+type ReplicationMessage interface {
+	// Decode is allowed and expected to retain a reference to data after returning (unlike
+	// encoding.BinaryUnmarshaler). src does not include the initial 1 byte message type identifier.
+	Decode(src []byte) error
+
+	// Encode appends itself, including the 1 byte message type identifier, to dst and returns the new buffer.
+	Encode(dst []byte) []byte
+}
+
+// Replication message type identifiers.
+const (
+	XLogDataByteID                = 'w'
+	PrimaryKeepaliveMessageByteID = 'k'
+	StandbyStatusUpdateByteID     = 'r'
+	HotStandbyFeedbackByteID      = 'h'
+)
+
+// ParseReplicationMessage decodes the Data of a CopyData message received on a streaming replication connection.
+// The returned message retains a reference to data.
+func ParseReplicationMessage(data []byte) (ReplicationMessage, error) {
+	if len(data) == 0 {
+		return nil, &invalidMessageFormatErr{messageType: "ReplicationMessage", details: "(empty)"}
+	}
+
+	var msg ReplicationMessage
+	switch data[0] {
+	case XLogDataByteID:
+		msg = &XLogData{}
+	case PrimaryKeepaliveMessageByteID:
+		msg = &PrimaryKeepaliveMessage{}
+	case StandbyStatusUpdateByteID:
+		msg = &StandbyStatusUpdate{}
+	case HotStandbyFeedbackByteID:
+		msg = &HotStandbyFeedback{}
+	default:
+		return nil, fmt.Errorf("unknown replication message type: %c", data[0])
+	}
+
+	err := msg.Decode(data[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// microsecFromUnixEpochToY2K is the number of microseconds between the Unix epoch and the PostgreSQL epoch.
+const microsecFromUnixEpochToY2K = 946684800 * 1000000
+
+// PgTimeToTime converts microseconds since the PostgreSQL epoch (2000-01-01 UTC) to a time.Time. It is the format of
+// timestamps in replication messages.
+func PgTimeToTime(microsecSinceY2K int64) time.Time {
+	microsecSinceUnixEpoch := microsecFromUnixEpochToY2K + microsecSinceY2K
+	return time.Unix(microsecSinceUnixEpoch/1000000, (microsecSinceUnixEpoch%1000000)*1000)
+}
+
+// TimeToPgTime converts t to microseconds since the PostgreSQL epoch (2000-01-01 UTC).
+func TimeToPgTime(t time.Time) int64 {
+	microsecSinceUnixEpoch := t.Unix()*1000000 + int64(t.Nanosecond())/1000
+	return microsecSinceUnixEpoch - microsecFromUnixEpochToY2K
+}
@@ -0,0 +1,97 @@
+package pgproto3_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSN(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		s   string
+		lsn pgproto3.LSN
+	}{
+		{"0/0", 0},
+		{"16/B374D848", 0x16B374D848},
+		{"FFFFFFFF/FFFFFFFF", 0xFFFFFFFFFFFFFFFF},
+	} {
+		lsn, err := pgproto3.ParseLSN(tt.s)
+		require.NoErrorf(t, err, "%d", i)
+		assert.Equalf(t, tt.lsn, lsn, "%d", i)
+		assert.Equalf(t, tt.s, tt.lsn.String(), "%d", i)
+	}
+
+	lsn, err := pgproto3.ParseLSN("16/b374d848")
+	require.NoError(t, err)
+	assert.Equal(t, pgproto3.LSN(0x16B374D848), lsn)
+
+	for i, s := range []string{"", "16", "16/", "/B374D848", "16/B374D848/0", "G/0", "100000000/0"} {
+		_, err := pgproto3.ParseLSN(s)
+		assert.Errorf(t, err, "%d", i)
+	}
+}
+
+func TestReplicationMessageEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	serverTime := time.Date(2022, 12, 5, 12, 0, 0, 123456000, time.UTC)
+
+	for i, msg := range []pgproto3.ReplicationMessage{
+		&pgproto3.XLogData{WALStart: 0x16B374D848, ServerWALEnd: 0x16B374D878, ServerTime: serverTime, WALData: []byte("BEGIN 751")},
+		&pgproto3.PrimaryKeepaliveMessage{ServerWALEnd: 0x16B374D878, ServerTime: serverTime, ReplyRequested: true},
+		&pgproto3.StandbyStatusUpdate{WALWritePosition: 3, WALFlushPosition: 2, WALApplyPosition: 1, ClientTime: serverTime},
+		&pgproto3.HotStandbyFeedback{ClientTime: serverTime, Xmin: 751, XminEpoch: 1, CatalogXmin: 700, CatalogXminEpoch: 1},
+	} {
+		buf := msg.Encode(nil)
+		decoded, err := pgproto3.ParseReplicationMessage(buf)
+		require.NoErrorf(t, err, "%d", i)
+		assert.Equalf(t, buf, decoded.Encode(nil), "%d", i)
+	}
+}
+
+func TestParseReplicationMessage(t *testing.T) {
+	t.Parallel()
+
+	// An XLogData message as received on a logical replication stream.
+	data := []byte{
+		'w',
+		0x00, 0x00, 0x00, 0x16, 0xb3, 0x74, 0xd8, 0x48, // WALStart
+		0x00, 0x00, 0x00, 0x16, 0xb3, 0x74, 0xd8, 0x78, // ServerWALEnd
+		0x00, 0x02, 0x92, 0x12, 0x32, 0x09, 0xf0, 0x00, // ServerTime
+		'B', 'E', 'G', 'I', 'N',
+	}
+	msg, err := pgproto3.ParseReplicationMessage(data)
+	require.NoError(t, err)
+	xld, ok := msg.(*pgproto3.XLogData)
+	require.True(t, ok)
+	assert.Equal(t, "16/B374D848", xld.WALStart.String())
+	assert.Equal(t, "16/B374D878", xld.ServerWALEnd.String())
+	assert.True(t, time.Date(2022, 12, 5, 12, 0, 0, 0, time.UTC).Equal(xld.ServerTime))
+	assert.Equal(t, []byte("BEGIN"), xld.WALData)
+
+	// A PrimaryKeepaliveMessage requesting a reply.
+	data = []byte{
+		'k',
+		0x00, 0x00, 0x00, 0x16, 0xb3, 0x74, 0xd8, 0x78, // ServerWALEnd
+		0x00, 0x02, 0x92, 0x12, 0x32, 0x09, 0xf0, 0x00, // ServerTime
+		0x01, // ReplyRequested
+	}
+	msg, err = pgproto3.ParseReplicationMessage(data)
+	require.NoError(t, err)
+	pkm, ok := msg.(*pgproto3.PrimaryKeepaliveMessage)
+	require.True(t, ok)
+	assert.Equal(t, pgproto3.LSN(0x16B374D878), pkm.ServerWALEnd)
+	assert.True(t, pkm.ReplyRequested)
+
+	_, err = pgproto3.ParseReplicationMessage(nil)
+	assert.Error(t, err)
+	_, err = pgproto3.ParseReplicationMessage([]byte{'z'})
+	assert.Error(t, err)
+	_, err = pgproto3.ParseReplicationMessage(data[:len(data)-1])
+	assert.Error(t, err)
+}
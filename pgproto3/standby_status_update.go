@@ -0,0 +1,51 @@
+package pgproto3
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/jackc/pgx/v5/internal/pgio"
+)
+
+// StandbyStatusUpdate is sent by the client on a replication stream to report its progress. The server uses the flush
+// position to advance the replication slot.
+type StandbyStatusUpdate struct {
+	WALWritePosition LSN // last WAL byte + 1 received and written to disk
+	WALFlushPosition LSN // last WAL byte + 1 flushed to disk
+	WALApplyPosition LSN // last WAL byte + 1 applied
+	ClientTime       time.Time
+
+	// ReplyRequested is true if the client requests the server to reply immediately with a PrimaryKeepaliveMessage.
+	ReplyRequested bool
+}
+
+// Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
+// type identifier.
+func (dst *StandbyStatusUpdate) Decode(src []byte) error {
+	if len(src) != 33 {
+		return &invalidMessageLenErr{messageType: "StandbyStatusUpdate", expectedLen: 33, actualLen: len(src)}
+	}
+
+	dst.WALWritePosition = LSN(binary.BigEndian.Uint64(src))
+	dst.WALFlushPosition = LSN(binary.BigEndian.Uint64(src[8:]))
+	dst.WALApplyPosition = LSN(binary.BigEndian.Uint64(src[16:]))
+	dst.ClientTime = PgTimeToTime(int64(binary.BigEndian.Uint64(src[24:])))
+	dst.ReplyRequested = src[32] == 1
+
+	return nil
+}
+
+// Encode encodes src into dst. dst will include the 1 byte message type identifier.
+func (src *StandbyStatusUpdate) Encode(dst []byte) []byte {
+	dst = append(dst, StandbyStatusUpdateByteID)
+	dst = pgio.AppendUint64(dst, uint64(src.WALWritePosition))
+	dst = pgio.AppendUint64(dst, uint64(src.WALFlushPosition))
+	dst = pgio.AppendUint64(dst, uint64(src.WALApplyPosition))
+	dst = pgio.AppendInt64(dst, TimeToPgTime(src.ClientTime))
+	if src.ReplyRequested {
+		dst = append(dst, 1)
+	} else {
+		dst = append(dst, 0)
+	}
+	return dst
+}
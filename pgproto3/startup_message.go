@@ -10,7 +10,17 @@ import (
 	"github.com/jackc/pgx/v5/internal/pgio"
 )
 
-const ProtocolVersionNumber = 196608 // 3.0
+const (
+	ProtocolVersion30 = 196608 // 3.0
+	ProtocolVersion32 = 196610 // 3.2
+
+	// ProtocolVersionNumber is the default protocol version requested by a frontend.
+	ProtocolVersionNumber = ProtocolVersion30
+)
+
+// protocolMajorVersion3 is the major version of all supported protocol versions. A backend accepts a StartupMessage
+// with any minor version and replies with NegotiateProtocolVersion if it does not support it.
+const protocolMajorVersion3 = 3
 
 type StartupMessage struct {
 	ProtocolVersion uint32
@@ -30,8 +40,8 @@ func (dst *StartupMessage) Decode(src []byte) error {
 	dst.ProtocolVersion = binary.BigEndian.Uint32(src)
 	rp := 4
 
-	if dst.ProtocolVersion != ProtocolVersionNumber {
-		return fmt.Errorf("Bad startup message version number. Expected %d, got %d", ProtocolVersionNumber, dst.ProtocolVersion)
+	if dst.ProtocolVersion>>16 != protocolMajorVersion3 {
+		return fmt.Errorf("Bad startup message version number. Expected major version %d, got %d", protocolMajorVersion3, dst.ProtocolVersion)
 	}
 
 	dst.Parameters = make(map[string]string)
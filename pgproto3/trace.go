@@ -2,8 +2,11 @@ package pgproto3
 
 import (
 	"bytes"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -12,8 +15,9 @@ import (
 // tracer traces the messages send to and from a Backend or Frontend. The format it produces roughly mimics the
 // format produced by the libpq C function PQtrace.
 type tracer struct {
-	w   io.Writer
-	buf *bytes.Buffer
+	w     io.Writer
+	buf   *bytes.Buffer
+	start time.Time
 	TracerOptions
 }
 
@@ -24,9 +28,40 @@ type TracerOptions struct {
 
 	// RegressMode redacts fields that may be vary between executions.
 	RegressMode bool
+
+	// JSON writes one JSON object per line for each message instead of the PQtrace format. See TraceRecord.
+	JSON bool
+}
+
+// TraceRecord is the JSON object written for each message when tracing with TracerOptions.JSON.
+type TraceRecord struct {
+	// Time is the wall clock time the message was traced. It is omitted if TracerOptions.SuppressTimestamps is set.
+	Time *time.Time `json:",omitempty"`
+
+	// Elapsed is the time since tracing started in nanoseconds. It is measured with the monotonic clock so it is not
+	// affected by changes to the wall clock. It is omitted if TracerOptions.SuppressTimestamps is set.
+	Elapsed *time.Duration `json:",omitempty"`
+
+	// From is "F" for messages sent by the frontend and "B" for messages sent by the backend.
+	From string
+
+	// Type is the name of the message type (e.g. "Query").
+	Type string
+
+	// Length is the length of the encoded message.
+	Length int32
+
+	// Message is the message as encoded by json.Marshal. It is omitted for messages that contain credentials (e.g.
+	// PasswordMessage).
+	Message json.RawMessage `json:",omitempty"`
 }
 
 func (t *tracer) traceMessage(sender byte, encodedLen int32, msg Message) {
+	if t.JSON {
+		t.traceJSON(sender, encodedLen, msg)
+		return
+	}
+
 	switch msg := msg.(type) {
 	case *AuthenticationCleartextPassword:
 		t.traceAuthenticationCleartextPassword(sender, encodedLen, msg)
@@ -88,6 +123,8 @@ func (t *tracer) traceMessage(sender byte, encodedLen int32, msg Message) {
 		t.traceFunctionCallResponse(sender, encodedLen, msg)
 	case *GSSEncRequest:
 		t.traceGSSEncRequest(sender, encodedLen, msg)
+	case *NegotiateProtocolVersion:
+		t.traceNegotiateProtocolVersion(sender, encodedLen, msg)
 	case *NoData:
 		t.traceNoData(sender, encodedLen, msg)
 	case *NoticeResponse:
@@ -169,7 +206,7 @@ func (t *tracer) traceBackendKeyData(sender byte, encodedLen int32, msg *Backend
 	if t.RegressMode {
 		t.buf.WriteString("\t NNNN NNNN")
 	} else {
-		fmt.Fprintf(t.buf, "\t %d %d", msg.ProcessID, msg.SecretKey)
+		fmt.Fprintf(t.buf, "\t %d %s", msg.ProcessID, traceSecretKey(msg.SecretKey, msg.LongSecretKey))
 	}
 	t.finishTrace()
 }
@@ -303,6 +340,15 @@ func (t *tracer) traceGSSEncRequest(sender byte, encodedLen int32, msg *GSSEncRe
 	t.finishTrace()
 }
 
+func (t *tracer) traceNegotiateProtocolVersion(sender byte, encodedLen int32, msg *NegotiateProtocolVersion) {
+	t.beginTrace(sender, encodedLen, "NegotiateProtocolVersion")
+	fmt.Fprintf(t.buf, "\t %d %d", msg.NewestMinorProtocol, len(msg.UnrecognizedOptions))
+	for _, option := range msg.UnrecognizedOptions {
+		fmt.Fprintf(t.buf, " %s", traceDoubleQuotedString([]byte(option)))
+	}
+	t.finishTrace()
+}
+
 func (t *tracer) traceNoData(sender byte, encodedLen int32, msg *NoData) {
 	t.beginTrace(sender, encodedLen, "NoData")
 	t.finishTrace()
@@ -390,6 +436,40 @@ func (t *tracer) traceTerminate(sender byte, encodedLen int32, msg *Terminate) {
 	t.finishTrace()
 }
 
+func (t *tracer) traceJSON(sender byte, encodedLen int32, msg Message) {
+	record := TraceRecord{
+		From:   string(sender),
+		Type:   reflect.TypeOf(msg).Elem().Name(),
+		Length: encodedLen,
+	}
+
+	if !t.SuppressTimestamps {
+		now := time.Now()
+		elapsed := now.Sub(t.start)
+		record.Time = &now
+		record.Elapsed = &elapsed
+	}
+
+	switch msg.(type) {
+	case *PasswordMessage, *SASLInitialResponse, *SASLResponse, *GSSResponse:
+	case *BackendKeyData:
+		if t.RegressMode {
+			record.Message = json.RawMessage(`{"Type":"BackendKeyData","ProcessID":"NNNN","SecretKey":"NNNN"}`)
+		} else {
+			record.Message, _ = json.Marshal(msg)
+		}
+	default:
+		record.Message, _ = json.Marshal(msg)
+	}
+
+	buf, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	t.buf.Write(buf)
+	t.finishTrace()
+}
+
 func (t *tracer) beginTrace(sender byte, encodedLen int32, msgType string) {
 	if !t.SuppressTimestamps {
 		now := time.Now()
@@ -415,6 +495,14 @@ func (t *tracer) finishTrace() {
 	}
 }
 
+// traceSecretKey returns longKey as hex if it is set and key as a number otherwise.
+func traceSecretKey(key uint32, longKey []byte) string {
+	if longKey != nil {
+		return hex.EncodeToString(longKey)
+	}
+	return strconv.FormatUint(uint64(key), 10)
+}
+
 // traceDoubleQuotedString returns t.buf as a double-quoted string without any escaping. It is roughly equivalent to
 // pqTraceOutputString in libpq.
 func traceDoubleQuotedString(buf []byte) string {
@@ -3,6 +3,7 @@ package pgproto3_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"os"
 	"testing"
 	"time"
@@ -54,3 +55,63 @@ B	ReadyForQuery	6	 I
 
 	require.Equal(t, expected, traceOutput.String())
 }
+
+func TestTraceJSON(t *testing.T) {
+	t.Parallel()
+
+	var serverOutput bytes.Buffer
+	backend := pgproto3.NewBackend(&bytes.Buffer{}, &serverOutput)
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 42, SecretKey: 7})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	require.NoError(t, backend.Flush())
+
+	traceOutput := &bytes.Buffer{}
+	frontend := pgproto3.NewFrontend(&serverOutput, &bytes.Buffer{})
+	frontend.Trace(traceOutput, pgproto3.TracerOptions{
+		SuppressTimestamps: true,
+		RegressMode:        true,
+		JSON:               true,
+	})
+
+	frontend.Send(&pgproto3.PasswordMessage{Password: "secret"})
+	frontend.SendQuery(&pgproto3.Query{String: "select 1"})
+	require.NoError(t, frontend.Flush())
+	for i := 0; i < 2; i++ {
+		_, err := frontend.Receive()
+		require.NoError(t, err)
+	}
+
+	expected := `{"From":"F","Type":"PasswordMessage","Length":12}
+{"From":"F","Type":"Query","Length":14,"Message":{"Type":"Query","String":"select 1"}}
+{"From":"B","Type":"BackendKeyData","Length":13,"Message":{"Type":"BackendKeyData","ProcessID":"NNNN","SecretKey":"NNNN"}}
+{"From":"B","Type":"ReadyForQuery","Length":6,"Message":{"Type":"ReadyForQuery","TxStatus":"I"}}
+`
+	require.Equal(t, expected, traceOutput.String())
+}
+
+func TestTraceJSONTimestamps(t *testing.T) {
+	t.Parallel()
+
+	traceOutput := &bytes.Buffer{}
+	frontend := pgproto3.NewFrontend(&bytes.Buffer{}, &bytes.Buffer{})
+	frontend.Trace(traceOutput, pgproto3.TracerOptions{JSON: true})
+
+	frontend.SendSync(&pgproto3.Sync{})
+	frontend.SendSync(&pgproto3.Sync{})
+
+	decoder := json.NewDecoder(traceOutput)
+	var records []pgproto3.TraceRecord
+	for decoder.More() {
+		var record pgproto3.TraceRecord
+		require.NoError(t, decoder.Decode(&record))
+		records = append(records, record)
+	}
+
+	require.Len(t, records, 2)
+	for _, record := range records {
+		require.NotNil(t, record.Time)
+		require.NotNil(t, record.Elapsed)
+		require.Equal(t, "Sync", record.Type)
+	}
+	require.LessOrEqual(t, *records[0].Elapsed, *records[1].Elapsed)
+}
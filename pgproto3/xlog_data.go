@@ -0,0 +1,42 @@
+package pgproto3
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/jackc/pgx/v5/internal/pgio"
+)
+
+// XLogData is a chunk of WAL data sent by the server on a replication stream. For logical replication WALData is the
+// output of the logical decoding plugin.
+type XLogData struct {
+	WALStart     LSN // starting point of the WAL data in this message
+	ServerWALEnd LSN // current end of WAL on the server
+	ServerTime   time.Time
+	WALData      []byte
+}
+
+// Decode decodes src into dst. src must contain the complete message with the exception of the initial 1 byte message
+// type identifier.
+func (dst *XLogData) Decode(src []byte) error {
+	if len(src) < 24 {
+		return &invalidMessageFormatErr{messageType: "XLogData", details: "(too short)"}
+	}
+
+	dst.WALStart = LSN(binary.BigEndian.Uint64(src))
+	dst.ServerWALEnd = LSN(binary.BigEndian.Uint64(src[8:]))
+	dst.ServerTime = PgTimeToTime(int64(binary.BigEndian.Uint64(src[16:])))
+	dst.WALData = src[24:]
+
+	return nil
+}
+
+// Encode encodes src into dst. dst will include the 1 byte message type identifier.
+func (src *XLogData) Encode(dst []byte) []byte {
+	dst = append(dst, XLogDataByteID)
+	dst = pgio.AppendUint64(dst, uint64(src.WALStart))
+	dst = pgio.AppendUint64(dst, uint64(src.ServerWALEnd))
+	dst = pgio.AppendInt64(dst, TimeToPgTime(src.ServerTime))
+	dst = append(dst, src.WALData...)
+	return dst
+}
@@ -0,0 +1,384 @@
+package pgproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgserver"
+)
+
+// errServerConnClosing is returned by serverConn.receive after close is called.
+var errServerConnClosing = errors.New("server connection closing")
+
+// Conn is a client connection to a Proxy and its connection to the server.
+type Conn struct {
+	proxy   *Proxy
+	netConn net.Conn
+
+	// backendMux serializes writes to the client after startup. Reads are only done by the goroutine relaying client
+	// messages.
+	backendMux sync.Mutex
+	backend    *pgproto3.Backend
+
+	server *serverConn
+
+	clientStartup *pgserver.ClientStartup
+	pid           uint32
+	secretKey     uint32
+}
+
+// NetConn returns the client's net.Conn. It is a *tls.Conn if the client connected with TLS.
+func (c *Conn) NetConn() net.Conn {
+	return c.netConn
+}
+
+// StartupParameters returns the parameters sent by the client in the startup message (e.g. user, database, and
+// application_name).
+func (c *Conn) StartupParameters() map[string]string {
+	return c.clientStartup.Parameters
+}
+
+// User returns the user the client connected as.
+func (c *Conn) User() string {
+	return c.clientStartup.User()
+}
+
+// Database returns the database the client connected to. It defaults to the user.
+func (c *Conn) Database() string {
+	return c.clientStartup.Database()
+}
+
+// PID returns the process ID sent to the client in the BackendKeyData message. It is assigned by the proxy.
+func (c *Conn) PID() uint32 {
+	return c.pid
+}
+
+// ServerPID returns the process ID of the server connection. It is 0 until the proxy has connected to the server.
+func (c *Conn) ServerPID() uint32 {
+	if c.server == nil {
+		return 0
+	}
+	return c.server.hc.PID
+}
+
+// SendToClient sends msgs to the client without calling Config.BackendMessage. It is safe to call from hooks and
+// other goroutines once the connection is relaying messages.
+func (c *Conn) SendToClient(msgs ...pgproto3.BackendMessage) error {
+	c.backendMux.Lock()
+	defer c.backendMux.Unlock()
+
+	for _, msg := range msgs {
+		c.backend.Send(msg)
+	}
+	return c.backend.Flush()
+}
+
+// SendToServer sends msgs to the server without calling Config.FrontendMessage. It is safe to call from hooks and
+// other goroutines once the connection is relaying messages. The messages may not have been written when SendToServer
+// returns.
+func (c *Conn) SendToServer(msgs ...pgproto3.FrontendMessage) error {
+	return c.server.send(msgs...)
+}
+
+// startup handles the startup messages. c.server is nil on return without error if the connection was a cancel
+// request.
+func (c *Conn) startup(ctx context.Context) error {
+	clientStartup, err := pgserver.AcceptStartup(ctx, c.netConn, c.proxy.config.TLSConfig)
+	if err != nil {
+		return err
+	}
+	c.netConn = clientStartup.NetConn
+	c.backend = clientStartup.Backend
+
+	if msg := clientStartup.CancelRequest; msg != nil {
+		// Only 4 byte secret keys are issued so a long secret key cannot match.
+		if msg.LongSecretKey != nil {
+			return nil
+		}
+		return c.proxy.cancel(ctx, msg.ProcessID, msg.SecretKey)
+	}
+	c.clientStartup = clientStartup
+
+	config := c.proxy.config
+	if config.Authenticate != nil {
+		err := config.Authenticate(ctx, c, c.backend)
+		if err != nil {
+			c.fatal(errorResponse(err, "28P01", fmt.Sprintf("password authentication failed for user %q", c.User())))
+			return fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	connConfig, err := config.ConnectConfig(ctx, c)
+	if err != nil {
+		c.fatal(errorResponse(err, "08001", "could not connect to server"))
+		return err
+	}
+
+	pgConn, err := pgconn.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		c.fatal(errorResponse(err, "08001", "could not connect to server"))
+		return err
+	}
+
+	hc, err := pgConn.Hijack()
+	if err != nil {
+		pgConn.Close(ctx)
+		return err
+	}
+
+	c.server = &serverConn{hc: hc}
+	err = c.proxy.register(c)
+	if err != nil {
+		hc.Conn.Close()
+		c.server = nil
+		return err
+	}
+
+	c.backend.Send(&pgproto3.AuthenticationOk{})
+	for k, v := range hc.ParameterStatuses {
+		c.backend.Send(&pgproto3.ParameterStatus{Name: k, Value: v})
+	}
+	c.backend.Send(&pgproto3.BackendKeyData{ProcessID: c.pid, SecretKey: c.secretKey})
+	c.backend.Send(&pgproto3.ReadyForQuery{TxStatus: hc.TxStatus})
+	return c.backend.Flush()
+}
+
+// fatal sends a fatal error to the client and returns it as an error.
+func (c *Conn) fatal(er *pgproto3.ErrorResponse) error {
+	return pgserver.SendFatal(c.backend, er)
+}
+
+// errorResponse returns err as a FATAL ErrorResponse if it is a *pgconn.PgError. Otherwise, it returns an
+// ErrorResponse with code and message so details of err are not disclosed to the client.
+func errorResponse(err error, code, message string) *pgproto3.ErrorResponse {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return &pgproto3.ErrorResponse{Severity: "FATAL", SeverityUnlocalized: "FATAL", Code: code, Message: message}
+	}
+
+	return &pgproto3.ErrorResponse{
+		Severity:            "FATAL",
+		SeverityUnlocalized: "FATAL",
+		Code:                pgErr.Code,
+		Message:             pgErr.Message,
+		Detail:              pgErr.Detail,
+		Hint:                pgErr.Hint,
+		Position:            pgErr.Position,
+		InternalPosition:    pgErr.InternalPosition,
+		InternalQuery:       pgErr.InternalQuery,
+		Where:               pgErr.Where,
+		SchemaName:          pgErr.SchemaName,
+		TableName:           pgErr.TableName,
+		ColumnName:          pgErr.ColumnName,
+		DataTypeName:        pgErr.DataTypeName,
+		ConstraintName:      pgErr.ConstraintName,
+		File:                pgErr.File,
+		Line:                pgErr.Line,
+		Routine:             pgErr.Routine,
+	}
+}
+
+// relay relays messages between the client and the server until either side closes its connection.
+func (c *Conn) relay(ctx context.Context) error {
+	serverDone := make(chan struct{})
+	clientErrChan := make(chan error, 1)
+	go func() {
+		err := c.relayClientMessages(ctx)
+		select {
+		case <-serverDone:
+			// The client connection was closed because the server connection was.
+			err = nil
+		default:
+		}
+		c.server.close()
+		clientErrChan <- err
+	}()
+
+	serverErr := c.relayServerMessages(ctx)
+	close(serverDone)
+	if errors.Is(serverErr, errServerConnClosing) {
+		serverErr = nil
+	}
+	c.netConn.Close()
+
+	clientErr := <-clientErrChan
+	if clientErr != nil {
+		return clientErr
+	}
+	return serverErr
+}
+
+// relayClientMessages relays messages from the client until the client terminates the connection.
+func (c *Conn) relayClientMessages(ctx context.Context) error {
+	for {
+		msg, err := c.backend.Receive()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// The client closed the connection without sending Terminate.
+				return nil
+			}
+			return err
+		}
+
+		msgs := []pgproto3.FrontendMessage{msg}
+		if c.proxy.config.FrontendMessage != nil {
+			msgs, err = c.proxy.config.FrontendMessage(ctx, c, msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(msgs) > 0 {
+			err = c.server.send(msgs...)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, ok := msg.(*pgproto3.Terminate); ok {
+			return nil
+		}
+	}
+}
+
+// relayServerMessages relays messages from the server until the server connection is closed.
+func (c *Conn) relayServerMessages(ctx context.Context) error {
+	for {
+		msg, err := c.server.receive()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// The server closed the connection (e.g. after a FATAL error).
+				return nil
+			}
+			return err
+		}
+
+		msgs := []pgproto3.BackendMessage{msg}
+		if c.proxy.config.BackendMessage != nil {
+			msgs, err = c.proxy.config.BackendMessage(ctx, c, msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(msgs) > 0 {
+			err = c.SendToClient(msgs...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// serverConn is the connection to the server. The connection established by pgconn must not be read and written
+// concurrently so the goroutine receiving messages also writes them. Other goroutines queue encoded messages and
+// interrupt the blocked read by setting the read deadline. pgproto3.Frontend resumes a message interrupted by a
+// deadline on the next Receive.
+type serverConn struct {
+	hc *pgconn.HijackedConn
+
+	mux     sync.Mutex
+	wbuf    []byte
+	closing bool
+}
+
+// send queues msgs to be written by the goroutine calling receive.
+func (s *serverConn) send(msgs ...pgproto3.FrontendMessage) error {
+	s.mux.Lock()
+	if s.closing {
+		s.mux.Unlock()
+		return errServerConnClosing
+	}
+	for _, msg := range msgs {
+		s.wbuf = msg.Encode(s.wbuf)
+	}
+	s.mux.Unlock()
+
+	return s.hc.Conn.SetReadDeadline(time.Now())
+}
+
+// close makes receive return errServerConnClosing after it has written any queued messages.
+func (s *serverConn) close() {
+	s.mux.Lock()
+	s.closing = true
+	s.mux.Unlock()
+
+	s.hc.Conn.SetReadDeadline(time.Now())
+}
+
+// receive writes queued messages and then receives the next message from the server.
+func (s *serverConn) receive() (pgproto3.BackendMessage, error) {
+	for {
+		// The deadline must be cleared before taking the queue. Otherwise, a message queued in between could be left
+		// until the server sends something.
+		err := s.hc.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		s.mux.Lock()
+		buf, closing := s.wbuf, s.closing
+		s.wbuf = nil
+		s.mux.Unlock()
+
+		if len(buf) > 0 {
+			_, err = s.hc.Conn.Write(buf)
+			if err == nil {
+				err = s.hc.Conn.Flush()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if closing {
+			return nil, errServerConnClosing
+		}
+
+		msg, err := s.hc.Frontend.Receive()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			return nil, err
+		}
+
+		return msg, nil
+	}
+}
+
+// cancelRequest sends a cancel request for the server connection. Like pgconn.PgConn.CancelRequest, the address is
+// taken from the connection instead of the config.
+func (s *serverConn) cancelRequest(ctx context.Context) error {
+	serverAddr := s.hc.Conn.RemoteAddr()
+	cancelConn, err := s.hc.Config.DialFunc(ctx, serverAddr.Network(), serverAddr.String())
+	if err != nil {
+		return err
+	}
+	defer cancelConn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		cancelConn.SetDeadline(deadline)
+	}
+
+	buf := (&pgproto3.CancelRequest{ProcessID: s.hc.PID, SecretKey: s.hc.SecretKey, LongSecretKey: s.hc.LongSecretKey}).Encode(nil)
+	_, err = cancelConn.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = cancelConn.Read(buf)
+	if err != io.EOF {
+		return err
+	}
+
+	return nil
+}
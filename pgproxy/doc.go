@@ -0,0 +1,34 @@
+// Package pgproxy implements a man-in-the-middle proxy for the PostgreSQL wire protocol.
+//
+// A Proxy accepts client connections, optionally authenticates the client itself, connects to the server with pgconn,
+// and then relays messages in both directions. Hooks can inspect, rewrite, inject, or drop messages as they pass:
+//
+//	proxy, err := pgproxy.NewProxy(&pgproxy.Config{
+//		ConnectConfig: func(ctx context.Context, conn *pgproxy.Conn) (*pgconn.Config, error) {
+//			config, err := pgconn.ParseConfig("host=db.example.com user=app password=secret")
+//			if err != nil {
+//				return nil, err
+//			}
+//			config.Database = conn.Database()
+//			return config, nil
+//		},
+//		FrontendMessage: func(ctx context.Context, conn *pgproxy.Conn, msg pgproto3.FrontendMessage) ([]pgproto3.FrontendMessage, error) {
+//			if q, ok := msg.(*pgproto3.Query); ok {
+//				log.Printf("%s: %s", conn.User(), q.String)
+//			}
+//			return []pgproto3.FrontendMessage{msg}, nil
+//		},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//
+//	ln, err := net.Listen("tcp", "127.0.0.1:5432")
+//	if err != nil {
+//		return err
+//	}
+//	return proxy.Serve(ln)
+//
+// The client and the server each authenticate separately. The client's password is never forwarded to the server.
+// Cancel requests from clients are forwarded to the server connection they belong to.
+package pgproxy
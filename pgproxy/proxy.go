@@ -0,0 +1,164 @@
+package pgproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Config is the configuration of a Proxy.
+type Config struct {
+	// ConnectConfig returns the configuration used to connect to the server for conn. It is called after the client has
+	// authenticated. The client's startup parameters are available from conn but are not forwarded unless they are set
+	// in the returned config (e.g. as RuntimeParams). It is required.
+	ConnectConfig func(ctx context.Context, conn *Conn) (*pgconn.Config, error)
+
+	// TLSConfig is used to accept SSLRequests from clients. If nil, SSLRequests are refused and clients must connect
+	// without TLS. TLS to the server is configured by the pgconn.Config returned by ConnectConfig.
+	TLSConfig *tls.Config
+
+	// Authenticate authenticates the client with backend before the proxy connects to the server (e.g. with
+	// pgserver.AuthenticateSCRAM). It must not send AuthenticationOk. If it returns a *pgconn.PgError it is sent to the
+	// client. Any other error is reported as a failed password authentication. If nil, clients are not authenticated by
+	// the proxy.
+	Authenticate func(ctx context.Context, conn *Conn, backend *pgproto3.Backend) error
+
+	// FrontendMessage is called for each message received from the client after startup. The returned messages are sent
+	// to the server instead of msg. Returning msg relays it unchanged, returning nil drops it, and returning more
+	// messages injects them. msg is only valid until FrontendMessage returns. Returning an error closes the connection.
+	FrontendMessage func(ctx context.Context, conn *Conn, msg pgproto3.FrontendMessage) ([]pgproto3.FrontendMessage, error)
+
+	// BackendMessage is called for each message received from the server after startup. It works like FrontendMessage
+	// in the other direction.
+	BackendMessage func(ctx context.Context, conn *Conn, msg pgproto3.BackendMessage) ([]pgproto3.BackendMessage, error)
+}
+
+// Proxy relays connections from clients to a server.
+type Proxy struct {
+	config *Config
+
+	connsMux sync.Mutex
+	conns    map[uint32]*Conn
+	lastPID  uint32
+}
+
+// NewProxy returns a new Proxy.
+func NewProxy(config *Config) (*Proxy, error) {
+	if config.ConnectConfig == nil {
+		return nil, errors.New("ConnectConfig is required")
+	}
+
+	return &Proxy{
+		config: config,
+		conns:  make(map[uint32]*Conn),
+	}, nil
+}
+
+// Serve accepts connections on ln and serves each in a new goroutine until ln is closed. Errors from serving
+// individual connections are discarded. Use ServeConn to handle them.
+func (p *Proxy) Serve(ln net.Listener) error {
+	for {
+		netConn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go p.ServeConn(context.Background(), netConn)
+	}
+}
+
+// ServeConn relays a client connected with netConn to the server. It returns nil when either side closes the
+// connection normally. netConn and the connection to the server are closed when ServeConn returns. Canceling ctx
+// closes both connections.
+func (p *Proxy) ServeConn(ctx context.Context, netConn net.Conn) error {
+	defer netConn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		netConn.Close()
+	}()
+
+	c := &Conn{
+		proxy:   p,
+		netConn: netConn,
+	}
+
+	err := c.startup(ctx)
+	if err != nil {
+		return err
+	}
+	if c.server == nil {
+		// The connection was a cancel request.
+		return nil
+	}
+	defer c.server.hc.Conn.Close()
+	defer p.unregister(c)
+
+	go func() {
+		<-ctx.Done()
+		c.server.hc.Conn.Close()
+	}()
+
+	return c.relay(ctx)
+}
+
+// register assigns a process ID and secret key to c so cancel requests can be routed to it.
+func (p *Proxy) register(c *Conn) error {
+	var buf [4]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return err
+	}
+	secretKey := binary.BigEndian.Uint32(buf[:])
+
+	p.connsMux.Lock()
+	defer p.connsMux.Unlock()
+
+	for {
+		p.lastPID++
+		if p.lastPID == 0 {
+			continue
+		}
+		if _, ok := p.conns[p.lastPID]; !ok {
+			break
+		}
+	}
+
+	c.pid = p.lastPID
+	c.secretKey = secretKey
+	p.conns[c.pid] = c
+
+	return nil
+}
+
+func (p *Proxy) unregister(c *Conn) {
+	p.connsMux.Lock()
+	defer p.connsMux.Unlock()
+
+	if p.conns[c.pid] == c {
+		delete(p.conns, c.pid)
+	}
+}
+
+// cancel forwards a cancel request to the server of the connection identified by pid and secretKey, if any.
+func (p *Proxy) cancel(ctx context.Context, pid uint32, secretKey uint32) error {
+	p.connsMux.Lock()
+	c, ok := p.conns[pid]
+	p.connsMux.Unlock()
+
+	if !ok || subtle.ConstantTimeEq(int32(c.secretKey), int32(secretKey)) != 1 {
+		return nil
+	}
+
+	return c.server.cancelRequest(ctx)
+}
@@ -0,0 +1,295 @@
+package pgproxy_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgproxy"
+	"github.com/jackc/pgx/v5/pgserver"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startProxy starts a proxy to the server at serverConnString and returns a connection string for it.
+func startProxy(t *testing.T, serverConnString string, config *pgproxy.Config) string {
+	t.Helper()
+
+	config.ConnectConfig = func(ctx context.Context, conn *pgproxy.Conn) (*pgconn.Config, error) {
+		connConfig, err := pgconn.ParseConfig(serverConnString)
+		if err != nil {
+			return nil, err
+		}
+		connConfig.User = conn.User()
+		return connConfig, nil
+	}
+
+	proxy, err := pgproxy.NewProxy(config)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go proxy.Serve(ln)
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	return fmt.Sprintf("host=%s port=%s user=jack sslmode=disable", host, port)
+}
+
+func selectOneSteps(sql string) []pgmock.Step {
+	return []pgmock.Step{
+		pgmock.ExpectMessage(&pgproto3.Query{String: sql}),
+		pgmock.SendMessage(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("?column?"), DataTypeOID: pgtype.Int4OID, DataTypeSize: 4, TypeModifier: -1}}}),
+		pgmock.SendMessage(&pgproto3.DataRow{Values: [][]byte{[]byte("1")}}),
+		pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+	}
+}
+
+func TestProxyRelay(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, selectOneSteps("select 1")...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	connString := startProxy(t, server.ConnString(), &pgproxy.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+	// The client receives a process ID assigned by the proxy instead of the server's.
+	assert.NotZero(t, conn.PID())
+
+	results, err := conn.Exec(ctx, "select 1").ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, [][][]byte{{[]byte("1")}}, results[0].Rows)
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
+
+func TestProxyHooks(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, selectOneSteps("select 1")...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	connString := startProxy(t, server.ConnString(), &pgproxy.Config{
+		FrontendMessage: func(ctx context.Context, conn *pgproxy.Conn, msg pgproto3.FrontendMessage) ([]pgproto3.FrontendMessage, error) {
+			q, ok := msg.(*pgproto3.Query)
+			if !ok {
+				return []pgproto3.FrontendMessage{msg}, nil
+			}
+
+			switch q.String {
+			case "select 2":
+				// Rewrite.
+				return []pgproto3.FrontendMessage{&pgproto3.Query{String: "select 1"}}, nil
+			case "drop table audit":
+				// Drop and respond without the server.
+				err := conn.SendToClient(
+					&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42501", Message: "blocked by proxy"},
+					&pgproto3.ReadyForQuery{TxStatus: 'I'},
+				)
+				return nil, err
+			default:
+				return []pgproto3.FrontendMessage{msg}, nil
+			}
+		},
+		BackendMessage: func(ctx context.Context, conn *pgproxy.Conn, msg pgproto3.BackendMessage) ([]pgproto3.BackendMessage, error) {
+			if cc, ok := msg.(*pgproto3.CommandComplete); ok && string(cc.CommandTag) == "SELECT 1" {
+				// Inject a notice before the message.
+				return []pgproto3.BackendMessage{&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: "audited"}, msg}, nil
+			}
+			return []pgproto3.BackendMessage{msg}, nil
+		},
+	})
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+	var notices []string
+	config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) {
+		notices = append(notices, n.Message)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	require.NoError(t, err)
+
+	_, err = conn.Exec(ctx, "drop table audit").ReadAll()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "42501", pgErr.Code)
+
+	results, err := conn.Exec(ctx, "select 2").ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, [][][]byte{{[]byte("1")}}, results[0].Rows)
+	assert.Equal(t, []string{"audited"}, notices)
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
+
+func TestProxyTLSAndAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	secret, err := pgserver.NewSCRAMSecret("secret")
+	require.NoError(t, err)
+
+	connString := startProxy(t, server.ConnString(), &pgproxy.Config{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		Authenticate: func(ctx context.Context, conn *pgproxy.Conn, backend *pgproto3.Backend) error {
+			if _, ok := conn.NetConn().(*tls.Conn); !ok {
+				return &pgconn.PgError{Code: "28000", Message: "TLS required"}
+			}
+			return pgserver.AuthenticateSCRAM(backend, secret)
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, tt := range []struct {
+		extra string
+		code  string
+	}{
+		{" password=secret", "28000"},
+		{" sslmode=require password=wrong", "28P01"},
+	} {
+		_, err = pgconn.Connect(ctx, connString+tt.extra)
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr, tt.extra)
+		assert.Equal(t, tt.code, pgErr.Code, tt.extra)
+	}
+
+	conn, err := pgconn.Connect(ctx, connString+" sslmode=require password=secret")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
+
+// sleepHandler is a pgserver.Handler for "select $1::int4" and "sleep", which blocks until it is canceled.
+type sleepHandler struct{}
+
+func (h *sleepHandler) Describe(ctx context.Context, sql string, paramOIDs []uint32) (*pgserver.Description, error) {
+	switch sql {
+	case "select $1::int4":
+		return &pgserver.Description{
+			ParamOIDs: []uint32{pgtype.Int4OID},
+			Columns:   []pgserver.Column{{Name: "int4", DataTypeOID: pgtype.Int4OID}},
+		}, nil
+	case "sleep":
+		return &pgserver.Description{}, nil
+	default:
+		return nil, &pgconn.PgError{Severity: "ERROR", Code: "42601", Message: fmt.Sprintf("syntax error: %s", sql)}
+	}
+}
+
+func (h *sleepHandler) Exec(ctx context.Context, sql string, args []any) ([]*pgserver.Result, error) {
+	switch sql {
+	case "select $1::int4":
+		return []*pgserver.Result{{Columns: []pgserver.Column{{Name: "int4", DataTypeOID: pgtype.Int4OID}}, Rows: [][]any{{args[0]}}}}, nil
+	case "sleep":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, &pgconn.PgError{Severity: "ERROR", Code: "42601", Message: fmt.Sprintf("syntax error: %s", sql)}
+	}
+}
+
+func TestProxyCancelRequest(t *testing.T) {
+	t.Parallel()
+
+	server, err := pgserver.NewServer(&pgserver.Config{
+		NewHandler: func(ctx context.Context, conn *pgserver.Conn) (pgserver.Handler, error) {
+			return &sleepHandler{}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer ln.Close()
+	go server.Serve(ln)
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+	connString := startProxy(t, fmt.Sprintf("host=%s port=%s sslmode=disable", host, port), &pgproxy.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, connString)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	var n int32
+	err = conn.QueryRow(ctx, "select $1::int4", 42).Scan(&n)
+	require.NoError(t, err)
+	assert.EqualValues(t, 42, n)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.PgConn().CancelRequest(ctx)
+	}()
+
+	_, err = conn.Exec(ctx, "sleep", pgx.QueryExecModeSimpleProtocol)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "57014", pgErr.Code)
+
+	err = conn.QueryRow(ctx, "select $1::int4", 7).Scan(&n)
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, n)
+}
@@ -0,0 +1,163 @@
+package pgserver
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const (
+	md5SaltLen            = 4
+	passwordAuthFailedFmt = "password authentication failed for user %q"
+)
+
+// ErrAuthFailed is returned when a client fails to authenticate.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// authenticate authenticates the client of c according to the configured method. password is the password of the user
+// or a stored secret as described for Config.GetPassword.
+func (c *Conn) authenticate(method, password string) error {
+	switch method {
+	case "", "trust":
+		return nil
+	case "password":
+		return c.authenticatePassword(password)
+	case "md5":
+		// Like PostgreSQL, a user with a SCRAM secret authenticates with SCRAM even when md5 is configured.
+		if strings.HasPrefix(password, scramSHA256Name+"$") {
+			return c.authenticateSCRAM(password)
+		}
+		return c.authenticateMD5(password)
+	case "scram-sha-256":
+		return c.authenticateSCRAM(password)
+	default:
+		return fmt.Errorf("unknown auth method: %s", method)
+	}
+}
+
+func (c *Conn) receivePassword() (string, error) {
+	msg, err := c.backend.Receive()
+	if err != nil {
+		return "", err
+	}
+
+	pm, ok := msg.(*pgproto3.PasswordMessage)
+	if !ok {
+		return "", fmt.Errorf("expected PasswordMessage, got %T", msg)
+	}
+
+	return pm.Password, nil
+}
+
+func (c *Conn) authenticatePassword(password string) error {
+	c.backend.Send(&pgproto3.AuthenticationCleartextPassword{})
+	err := c.backend.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = c.backend.SetAuthType(pgproto3.AuthTypeCleartextPassword)
+	if err != nil {
+		return err
+	}
+
+	got, err := c.receivePassword()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(password, scramSHA256Name+"$"):
+		secret, err := ParseSCRAMSecret(password)
+		if err != nil {
+			return err
+		}
+		if !secret.VerifyPassword(got) {
+			return ErrAuthFailed
+		}
+	case isMD5Secret(password):
+		if subtle.ConstantTimeCompare([]byte("md5"+hexMD5(got+c.User())), []byte(password)) != 1 {
+			return ErrAuthFailed
+		}
+	default:
+		if subtle.ConstantTimeCompare([]byte(got), []byte(password)) != 1 {
+			return ErrAuthFailed
+		}
+	}
+
+	return nil
+}
+
+func (c *Conn) authenticateMD5(password string) error {
+	var salt [md5SaltLen]byte
+	_, err := rand.Read(salt[:])
+	if err != nil {
+		return err
+	}
+
+	c.backend.Send(&pgproto3.AuthenticationMD5Password{Salt: salt})
+	err = c.backend.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = c.backend.SetAuthType(pgproto3.AuthTypeMD5Password)
+	if err != nil {
+		return err
+	}
+
+	got, err := c.receivePassword()
+	if err != nil {
+		return err
+	}
+
+	secret := password
+	if !isMD5Secret(secret) {
+		secret = "md5" + hexMD5(password+c.User())
+	}
+
+	want := "md5" + hexMD5(secret[3:]+string(salt[:]))
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+func (c *Conn) authenticateSCRAM(password string) error {
+	var secret *SCRAMSecret
+	var err error
+	if strings.HasPrefix(password, scramSHA256Name+"$") {
+		secret, err = ParseSCRAMSecret(password)
+	} else if isMD5Secret(password) {
+		// The password cannot be recovered from an MD5 secret.
+		return ErrAuthFailed
+	} else {
+		secret, err = NewSCRAMSecret(password)
+	}
+	if err != nil {
+		return err
+	}
+
+	return AuthenticateSCRAM(c.backend, secret)
+}
+
+// isMD5Secret returns true if s is a password stored as "md5" followed by the hex MD5 hash of the password and user.
+func isMD5Secret(s string) bool {
+	if len(s) != 35 || !strings.HasPrefix(s, "md5") {
+		return false
+	}
+	_, err := hex.DecodeString(s[3:])
+	return err == nil
+}
+
+func hexMD5(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,506 @@
+package pgserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Conn is a client connection to a Server.
+type Conn struct {
+	server  *Server
+	netConn net.Conn
+	backend *pgproto3.Backend
+	handler Handler
+	typeMap *pgtype.Map
+
+	clientStartup *ClientStartup
+	pid           uint32
+	secretKey     uint32
+
+	statements map[string]*statement
+	portals    map[string]*portal
+
+	// ignoreTillSync is set when an error occurs in the extended protocol. Messages are discarded until the next Sync.
+	ignoreTillSync bool
+
+	cancelMux       sync.Mutex
+	cancelStatement context.CancelFunc
+	canceled        bool
+}
+
+// statement is a prepared statement created by a Parse message.
+type statement struct {
+	sql         string
+	description *Description
+}
+
+// portal is a statement bound to parameter values by a Bind message. The statement is executed by the first Execute
+// message. Later Execute messages continue to return rows if the first one was limited by MaxRows.
+type portal struct {
+	stmt          *statement
+	args          []any
+	resultFormats []int16
+
+	result   *Result
+	rowsSent int
+}
+
+// NetConn returns the underlying net.Conn. It is a *tls.Conn if the client connected with TLS.
+func (c *Conn) NetConn() net.Conn {
+	return c.netConn
+}
+
+// StartupParameters returns the parameters sent by the client in the startup message (e.g. user, database, and
+// application_name).
+func (c *Conn) StartupParameters() map[string]string {
+	return c.clientStartup.Parameters
+}
+
+// User returns the user the client connected as.
+func (c *Conn) User() string {
+	return c.clientStartup.User()
+}
+
+// Database returns the database the client connected to. It defaults to the user.
+func (c *Conn) Database() string {
+	return c.clientStartup.Database()
+}
+
+// PID returns the process ID sent to the client in the BackendKeyData message.
+func (c *Conn) PID() uint32 {
+	return c.pid
+}
+
+// startup handles the startup messages. c.handler is nil on return without error if the connection was a cancel
+// request.
+func (c *Conn) startup(ctx context.Context) error {
+	clientStartup, err := AcceptStartup(ctx, c.netConn, c.server.config.TLSConfig)
+	if err != nil {
+		return err
+	}
+	c.netConn = clientStartup.NetConn
+	c.backend = clientStartup.Backend
+
+	if msg := clientStartup.CancelRequest; msg != nil {
+		// Only 4 byte secret keys are issued so a long secret key cannot match.
+		if msg.LongSecretKey == nil {
+			c.server.cancel(msg.ProcessID, msg.SecretKey)
+		}
+		return nil
+	}
+	c.clientStartup = clientStartup
+
+	config := c.server.config
+	var password string
+	if config.GetPassword != nil && config.AuthMethod != "" && config.AuthMethod != "trust" {
+		password, err = config.GetPassword(ctx, c.User())
+		if err != nil {
+			// Run the authentication exchange anyway so clients cannot tell whether the user exists.
+			password = ""
+			c.authenticate(config.AuthMethod, password)
+			return c.fatal(newErrorResponse("FATAL", "28P01", fmt.Sprintf(passwordAuthFailedFmt, c.User())))
+		}
+	}
+
+	err = c.authenticate(config.AuthMethod, password)
+	if err != nil {
+		if errors.Is(err, ErrAuthFailed) {
+			return c.fatal(newErrorResponse("FATAL", "28P01", fmt.Sprintf(passwordAuthFailedFmt, c.User())))
+		}
+		return err
+	}
+	c.backend.Send(&pgproto3.AuthenticationOk{})
+
+	for k, v := range c.server.parameterStatuses {
+		c.backend.Send(&pgproto3.ParameterStatus{Name: k, Value: v})
+	}
+
+	err = c.server.register(c)
+	if err != nil {
+		return err
+	}
+	c.backend.Send(&pgproto3.BackendKeyData{ProcessID: c.pid, SecretKey: c.secretKey})
+
+	c.typeMap = pgtype.NewMap()
+	c.statements = make(map[string]*statement)
+	c.portals = make(map[string]*portal)
+
+	handler, err := config.NewHandler(ctx, c)
+	if err != nil {
+		c.server.unregister(c)
+		return c.fatal(errorResponse(err, false))
+	}
+	c.handler = handler
+
+	return c.sendReadyForQuery()
+}
+
+// fatal sends a fatal error to the client and returns it as an error.
+func (c *Conn) fatal(er *pgproto3.ErrorResponse) error {
+	return SendFatal(c.backend, er)
+}
+
+func (c *Conn) sendReadyForQuery() error {
+	txStatus := byte('I')
+	if h, ok := c.handler.(TxStatusHandler); ok {
+		txStatus = h.TxStatus()
+	}
+
+	c.backend.Send(&pgproto3.ReadyForQuery{TxStatus: txStatus})
+	return c.backend.Flush()
+}
+
+func (c *Conn) serve(ctx context.Context) error {
+	for {
+		msg, err := c.backend.Receive()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// The client closed the connection without sending Terminate.
+				return nil
+			}
+			return err
+		}
+
+		if _, ok := msg.(*pgproto3.Terminate); ok {
+			return nil
+		}
+
+		if c.ignoreTillSync {
+			if _, ok := msg.(*pgproto3.Sync); !ok {
+				continue
+			}
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			err = c.handleQuery(ctx, msg)
+		case *pgproto3.Parse:
+			err = c.handleParse(ctx, msg)
+		case *pgproto3.Bind:
+			err = c.handleBind(msg)
+		case *pgproto3.Describe:
+			err = c.handleDescribe(msg)
+		case *pgproto3.Execute:
+			err = c.handleExecute(ctx, msg)
+		case *pgproto3.Close:
+			err = c.handleClose(msg)
+		case *pgproto3.Sync:
+			c.ignoreTillSync = false
+			err = c.sendReadyForQuery()
+		case *pgproto3.Flush:
+			err = c.backend.Flush()
+		default:
+			c.sendExtendedError(newErrorResponse("ERROR", "0A000", fmt.Sprintf("unsupported message: %T", msg)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendExtendedError sends an error that occurred while processing an extended protocol message. Messages are then
+// discarded until the next Sync.
+func (c *Conn) sendExtendedError(er *pgproto3.ErrorResponse) {
+	c.backend.Send(er)
+	c.ignoreTillSync = true
+}
+
+// statementContext returns a context for executing a statement that is canceled by a cancel request. done must be
+// called when the statement is complete. canceled reports whether the statement was canceled by a cancel request.
+func (c *Conn) statementContext(ctx context.Context) (stmtCtx context.Context, done func() (canceled bool)) {
+	stmtCtx, cancel := context.WithCancel(ctx)
+
+	c.cancelMux.Lock()
+	c.canceled = false
+	c.cancelStatement = func() {
+		c.cancelMux.Lock()
+		c.canceled = true
+		c.cancelMux.Unlock()
+		cancel()
+	}
+	c.cancelMux.Unlock()
+
+	return stmtCtx, func() bool {
+		c.cancelMux.Lock()
+		defer c.cancelMux.Unlock()
+		c.cancelStatement = nil
+		cancel()
+		return c.canceled
+	}
+}
+
+// cancelCurrentStatement cancels the statement in progress, if any.
+func (c *Conn) cancelCurrentStatement() {
+	c.cancelMux.Lock()
+	cancelStatement := c.cancelStatement
+	c.cancelMux.Unlock()
+
+	if cancelStatement != nil {
+		cancelStatement()
+	}
+}
+
+func (c *Conn) handleQuery(ctx context.Context, msg *pgproto3.Query) error {
+	// A simple query destroys the unnamed statement and portal.
+	delete(c.statements, "")
+	delete(c.portals, "")
+
+	if strings.TrimSpace(msg.String) == "" {
+		c.backend.Send(&pgproto3.EmptyQueryResponse{})
+		return c.sendReadyForQuery()
+	}
+
+	stmtCtx, done := c.statementContext(ctx)
+	results, err := c.handler.Exec(stmtCtx, msg.String, nil)
+	canceled := done()
+
+	for _, result := range results {
+		if result.Columns != nil {
+			rd, rdErr := rowDescription(result.Columns, nil)
+			if rdErr != nil {
+				err = rdErr
+				break
+			}
+			c.backend.Send(rd)
+		}
+		rowErr := c.sendRows(result, nil, 0, 0)
+		if rowErr != nil {
+			err = rowErr
+			break
+		}
+		c.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(result.commandTag())})
+	}
+
+	if err != nil {
+		c.backend.Send(errorResponse(err, canceled))
+	}
+
+	return c.sendReadyForQuery()
+}
+
+// sendRows sends the rows of result starting at the row with index start. If maxRows is greater than 0 at most
+// maxRows rows are sent.
+func (c *Conn) sendRows(result *Result, formats []int16, start, maxRows int) error {
+	end := len(result.Rows)
+	if maxRows > 0 && start+maxRows < end {
+		end = start + maxRows
+	}
+
+	formats, err := pgproto3.ExpandFormatCodes(formats, len(result.Columns))
+	if err != nil {
+		return err
+	}
+
+	for _, row := range result.Rows[start:end] {
+		if len(row) != len(result.Columns) {
+			return fmt.Errorf("row has %d values but result has %d columns", len(row), len(result.Columns))
+		}
+
+		values := make([][]byte, len(row))
+		for i, v := range row {
+			buf, err := c.typeMap.Encode(result.Columns[i].DataTypeOID, formats[i], v, []byte{})
+			if err != nil {
+				return err
+			}
+			values[i] = buf
+		}
+		c.backend.Send(&pgproto3.DataRow{Values: values})
+	}
+
+	return nil
+}
+
+func (c *Conn) handleParse(ctx context.Context, msg *pgproto3.Parse) error {
+	if msg.Name != "" {
+		if _, ok := c.statements[msg.Name]; ok {
+			c.sendExtendedError(newErrorResponse("ERROR", "42P05", fmt.Sprintf("prepared statement %q already exists", msg.Name)))
+			return nil
+		}
+	}
+
+	paramOIDs := append([]uint32(nil), msg.ParameterOIDs...)
+	stmtCtx, done := c.statementContext(ctx)
+	description, err := c.handler.Describe(stmtCtx, msg.Query, paramOIDs)
+	canceled := done()
+	if err != nil {
+		c.sendExtendedError(errorResponse(err, canceled))
+		return nil
+	}
+	if description == nil {
+		description = &Description{}
+	}
+	if description.ParamOIDs == nil {
+		description.ParamOIDs = paramOIDs
+	}
+
+	c.statements[msg.Name] = &statement{sql: msg.Query, description: description}
+	c.backend.Send(&pgproto3.ParseComplete{})
+
+	return nil
+}
+
+func (c *Conn) handleBind(msg *pgproto3.Bind) error {
+	stmt, ok := c.statements[msg.PreparedStatement]
+	if !ok {
+		c.sendExtendedError(newErrorResponse("ERROR", "26000", fmt.Sprintf("prepared statement %q does not exist", msg.PreparedStatement)))
+		return nil
+	}
+
+	paramOIDs := stmt.description.ParamOIDs
+	if len(msg.Parameters) != len(paramOIDs) {
+		c.sendExtendedError(newErrorResponse("ERROR", "08P01", fmt.Sprintf("bind message supplies %d parameters, but prepared statement %q requires %d", len(msg.Parameters), msg.PreparedStatement, len(paramOIDs))))
+		return nil
+	}
+
+	paramFormats, err := pgproto3.ExpandFormatCodes(msg.ParameterFormatCodes, len(msg.Parameters))
+	if err != nil {
+		c.sendExtendedError(newErrorResponse("ERROR", "08P01", fmt.Sprintf("bind message has %d parameter formats but %d parameters", len(msg.ParameterFormatCodes), len(msg.Parameters))))
+		return nil
+	}
+	columns := stmt.description.Columns
+	if _, err := pgproto3.ExpandFormatCodes(msg.ResultFormatCodes, len(columns)); err != nil {
+		c.sendExtendedError(newErrorResponse("ERROR", "08P01", fmt.Sprintf("bind message has %d result formats but query has %d columns", len(msg.ResultFormatCodes), len(columns))))
+		return nil
+	}
+
+	args := make([]any, len(msg.Parameters))
+	for i, src := range msg.Parameters {
+		arg, err := c.decodeParam(paramOIDs[i], paramFormats[i], src)
+		if err != nil {
+			c.sendExtendedError(newErrorResponse("ERROR", "22P02", fmt.Sprintf("invalid value for parameter $%d: %v", i+1, err)))
+			return nil
+		}
+		args[i] = arg
+	}
+
+	c.portals[msg.DestinationPortal] = &portal{
+		stmt:          stmt,
+		args:          args,
+		resultFormats: append([]int16(nil), msg.ResultFormatCodes...),
+	}
+	c.backend.Send(&pgproto3.BindComplete{})
+
+	return nil
+}
+
+// decodeParam decodes a parameter value. Values of unknown types are decoded to a string in the text format and to a
+// []byte in the binary format.
+func (c *Conn) decodeParam(oid uint32, format int16, src []byte) (any, error) {
+	if src == nil {
+		return nil, nil
+	}
+
+	// src refers to the receive buffer of the backend.
+	src = append([]byte(nil), src...)
+
+	if t, ok := c.typeMap.TypeForOID(oid); ok {
+		return t.Codec.DecodeValue(c.typeMap, oid, format, src)
+	}
+
+	if format == pgtype.TextFormatCode {
+		return string(src), nil
+	}
+	return src, nil
+}
+
+func (c *Conn) handleDescribe(msg *pgproto3.Describe) error {
+	switch msg.ObjectType {
+	case 'S':
+		stmt, ok := c.statements[msg.Name]
+		if !ok {
+			c.sendExtendedError(newErrorResponse("ERROR", "26000", fmt.Sprintf("prepared statement %q does not exist", msg.Name)))
+			return nil
+		}
+		c.backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: stmt.description.ParamOIDs})
+		rd, err := rowDescription(stmt.description.Columns, nil)
+		if err != nil {
+			c.sendExtendedError(errorResponse(err, false))
+			return nil
+		}
+		c.backend.Send(rd)
+	case 'P':
+		p, ok := c.portals[msg.Name]
+		if !ok {
+			c.sendExtendedError(newErrorResponse("ERROR", "34000", fmt.Sprintf("portal %q does not exist", msg.Name)))
+			return nil
+		}
+		rd, err := rowDescription(p.stmt.description.Columns, p.resultFormats)
+		if err != nil {
+			c.sendExtendedError(errorResponse(err, false))
+			return nil
+		}
+		c.backend.Send(rd)
+	default:
+		c.sendExtendedError(newErrorResponse("ERROR", "08P01", fmt.Sprintf("invalid DESCRIBE message subtype %d", msg.ObjectType)))
+	}
+
+	return nil
+}
+
+func (c *Conn) handleExecute(ctx context.Context, msg *pgproto3.Execute) error {
+	p, ok := c.portals[msg.Portal]
+	if !ok {
+		c.sendExtendedError(newErrorResponse("ERROR", "34000", fmt.Sprintf("portal %q does not exist", msg.Portal)))
+		return nil
+	}
+
+	if strings.TrimSpace(p.stmt.sql) == "" {
+		c.backend.Send(&pgproto3.EmptyQueryResponse{})
+		return nil
+	}
+
+	if p.result == nil {
+		stmtCtx, done := c.statementContext(ctx)
+		results, err := c.handler.Exec(stmtCtx, p.stmt.sql, p.args)
+		canceled := done()
+		if err != nil {
+			c.sendExtendedError(errorResponse(err, canceled))
+			return nil
+		}
+		if len(results) != 1 {
+			c.sendExtendedError(newErrorResponse("ERROR", "XX000", fmt.Sprintf("handler returned %d results for a statement", len(results))))
+			return nil
+		}
+		p.result = results[0]
+	}
+
+	err := c.sendRows(p.result, p.resultFormats, p.rowsSent, int(msg.MaxRows))
+	if err != nil {
+		c.sendExtendedError(errorResponse(err, false))
+		return nil
+	}
+
+	if msg.MaxRows > 0 && p.rowsSent+int(msg.MaxRows) < len(p.result.Rows) {
+		p.rowsSent += int(msg.MaxRows)
+		c.backend.Send(&pgproto3.PortalSuspended{})
+		return nil
+	}
+
+	p.rowsSent = len(p.result.Rows)
+	c.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(p.result.commandTag())})
+
+	return nil
+}
+
+func (c *Conn) handleClose(msg *pgproto3.Close) error {
+	switch msg.ObjectType {
+	case 'S':
+		delete(c.statements, msg.Name)
+	case 'P':
+		delete(c.portals, msg.Name)
+	default:
+		c.sendExtendedError(newErrorResponse("ERROR", "08P01", fmt.Sprintf("invalid CLOSE message subtype %d", msg.ObjectType)))
+		return nil
+	}
+
+	c.backend.Send(&pgproto3.CloseComplete{})
+	return nil
+}
@@ -0,0 +1,30 @@
+// Package pgserver implements the server side of the PostgreSQL wire protocol on top of pgproto3.Backend.
+//
+// A Server handles the protocol: the startup handshake, SSLRequest, cleartext, MD5, and SCRAM-SHA-256
+// authentication, ParameterStatus and BackendKeyData, the simple and extended query protocols including recovery from
+// errors until Sync, and routing of cancel requests. Statements are executed by a Handler that is built for each
+// connection. A Handler only receives SQL and arguments and returns rows and command tags:
+//
+//	server, err := pgserver.NewServer(&pgserver.Config{
+//		NewHandler: func(ctx context.Context, conn *pgserver.Conn) (pgserver.Handler, error) {
+//			return &myHandler{}, nil
+//		},
+//		AuthMethod:  "scram-sha-256",
+//		GetPassword: func(ctx context.Context, user string) (string, error) { return passwords[user], nil },
+//	})
+//	if err != nil {
+//		return err
+//	}
+//
+//	ln, err := net.Listen("tcp", "127.0.0.1:5432")
+//	if err != nil {
+//		return err
+//	}
+//	return server.Serve(ln)
+//
+// Values are encoded and decoded with a *pgtype.Map so rows and arguments are ordinary Go values.
+//
+// AuthenticateSCRAM can be used on its own to authenticate a client of any pgproto3.Backend with a SCRAM-SHA-256
+// secret in the format PostgreSQL stores in pg_authid. Similarly, AcceptStartup handles the startup handshake of a
+// client up to authentication for servers that relay or otherwise handle the rest of the protocol themselves.
+package pgserver
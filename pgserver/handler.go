@@ -0,0 +1,134 @@
+package pgserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Handler executes statements for a connection. A Handler is built for each connection by Config.NewHandler and is
+// only used by that connection, so it does not need to be safe for concurrent use.
+//
+// Errors returned by a Handler are sent to the client. A *pgconn.PgError is sent as is. Any other error is sent with
+// SQLSTATE XX000 (internal_error) unless the context was canceled by a cancel request, in which case it is sent with
+// SQLSTATE 57014 (query_canceled).
+type Handler interface {
+	// Describe returns the parameter types and result columns of the statement sql without executing it. paramOIDs are
+	// the parameter types specified by the client where 0 means the client did not specify a type. It is called when a
+	// statement is prepared with the extended protocol.
+	Describe(ctx context.Context, sql string, paramOIDs []uint32) (*Description, error)
+
+	// Exec executes sql and returns its results. With the simple protocol sql may contain multiple statements, args is
+	// nil, and a result should be returned for each statement. Results returned with an error are sent to the client
+	// before the error. With the extended protocol sql is a single statement, args are the parameter values decoded
+	// according to the types returned by Describe, and exactly one result must be returned.
+	Exec(ctx context.Context, sql string, args []any) ([]*Result, error)
+}
+
+// TxStatusHandler is an optional interface implemented by a Handler that supports transactions.
+type TxStatusHandler interface {
+	// TxStatus returns the transaction status that is sent to the client in the ReadyForQuery message. It is 'I' when
+	// idle, 'T' in a transaction block, or 'E' in a failed transaction block.
+	TxStatus() byte
+}
+
+// Column describes a column of a result.
+type Column struct {
+	Name        string
+	DataTypeOID uint32
+}
+
+// Description describes a statement.
+type Description struct {
+	ParamOIDs []uint32
+	Columns   []Column // nil if the statement does not return rows
+}
+
+// Result is the result of a statement. The values in Rows are encoded with a *pgtype.Map in the format requested by
+// the client.
+type Result struct {
+	Columns []Column // nil if the statement does not return rows
+	Rows    [][]any
+
+	// CommandTag is sent in the CommandComplete message (e.g. "INSERT 0 1"). If empty and Columns is not nil,
+	// "SELECT n" is sent where n is the number of rows.
+	CommandTag string
+}
+
+func (r *Result) commandTag() string {
+	if r.CommandTag == "" && r.Columns != nil {
+		return fmt.Sprintf("SELECT %d", len(r.Rows))
+	}
+	return r.CommandTag
+}
+
+// rowDescription returns the description of columns returned in formats, which are the result format codes of a Bind
+// message.
+func rowDescription(columns []Column, formats []int16) (pgproto3.BackendMessage, error) {
+	if columns == nil {
+		return &pgproto3.NoData{}, nil
+	}
+
+	formats, err := pgproto3.ExpandFormatCodes(formats, len(columns))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]pgproto3.FieldDescription, len(columns))
+	for i, c := range columns {
+		fields[i] = pgproto3.FieldDescription{
+			Name:         []byte(c.Name),
+			DataTypeOID:  c.DataTypeOID,
+			DataTypeSize: -1,
+			TypeModifier: -1,
+			Format:       formats[i],
+		}
+	}
+
+	return &pgproto3.RowDescription{Fields: fields}, nil
+}
+
+// errorResponse converts err to the ErrorResponse sent to the client. canceled is true if the context of the
+// operation that failed was canceled by a cancel request.
+func errorResponse(err error, canceled bool) *pgproto3.ErrorResponse {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		severity := pgErr.Severity
+		if severity == "" {
+			severity = "ERROR"
+		}
+		return &pgproto3.ErrorResponse{
+			Severity:            severity,
+			SeverityUnlocalized: severity,
+			Code:                pgErr.Code,
+			Message:             pgErr.Message,
+			Detail:              pgErr.Detail,
+			Hint:                pgErr.Hint,
+			Position:            pgErr.Position,
+			InternalPosition:    pgErr.InternalPosition,
+			InternalQuery:       pgErr.InternalQuery,
+			Where:               pgErr.Where,
+			SchemaName:          pgErr.SchemaName,
+			TableName:           pgErr.TableName,
+			ColumnName:          pgErr.ColumnName,
+			DataTypeName:        pgErr.DataTypeName,
+			ConstraintName:      pgErr.ConstraintName,
+			File:                pgErr.File,
+			Line:                pgErr.Line,
+			Routine:             pgErr.Routine,
+		}
+	}
+
+	if canceled {
+		return newErrorResponse("ERROR", "57014", "canceling statement due to user request")
+	}
+
+	return newErrorResponse("ERROR", "XX000", err.Error())
+}
+
+func newErrorResponse(severity, code, message string) *pgproto3.ErrorResponse {
+	return &pgproto3.ErrorResponse{Severity: severity, SeverityUnlocalized: severity, Code: code, Message: message}
+}
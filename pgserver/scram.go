@@ -0,0 +1,253 @@
+// Server side of SCRAM-SHA-256 authentication
+//
+// Resources:
+//   https://tools.ietf.org/html/rfc5802
+//   https://tools.ietf.org/html/rfc7677
+//   https://www.postgresql.org/docs/current/sasl-authentication.html
+
+package pgserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/secure/precis"
+)
+
+const (
+	scramSHA256Name     = "SCRAM-SHA-256"
+	scramIterations     = 4096
+	scramSaltLen        = 16
+	scramServerNonceLen = 18
+)
+
+// SCRAMSecret is what a server stores to verify a SCRAM-SHA-256 client instead of the password.
+type SCRAMSecret struct {
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewSCRAMSecret returns a SCRAMSecret for password with a random salt and 4096 iterations like PostgreSQL.
+func NewSCRAMSecret(password string) (*SCRAMSecret, error) {
+	salt := make([]byte, scramSaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSCRAMSecret(password, salt, scramIterations), nil
+}
+
+func newSCRAMSecret(password string, salt []byte, iterations int) *SCRAMSecret {
+	// precis.OpaqueString is equivalent to SASLprep for password.
+	prepared, err := precis.OpaqueString.Bytes([]byte(password))
+	if err != nil {
+		// PostgreSQL allows passwords invalid according to SCRAM / SASLprep.
+		prepared = []byte(password)
+	}
+
+	saltedPassword := pbkdf2.Key(prepared, salt, iterations, sha256.Size, sha256.New)
+	clientKey := computeHMAC(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+
+	return &SCRAMSecret{
+		Iterations: iterations,
+		Salt:       salt,
+		StoredKey:  storedKey[:],
+		ServerKey:  computeHMAC(saltedPassword, []byte("Server Key")),
+	}
+}
+
+// ParseSCRAMSecret parses a secret in the format PostgreSQL stores in pg_authid.rolpassword:
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey> where salt and the keys are base64 encoded.
+func ParseSCRAMSecret(s string) (*SCRAMSecret, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 3 || parts[0] != scramSHA256Name {
+		return nil, errors.New("invalid SCRAM secret: expected SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>")
+	}
+
+	iterationsAndSalt := strings.SplitN(parts[1], ":", 2)
+	keys := strings.SplitN(parts[2], ":", 2)
+	if len(iterationsAndSalt) != 2 || len(keys) != 2 {
+		return nil, errors.New("invalid SCRAM secret: expected SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>")
+	}
+
+	iterations, err := strconv.Atoi(iterationsAndSalt[0])
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("invalid SCRAM secret: invalid iterations %q", iterationsAndSalt[0])
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(iterationsAndSalt[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCRAM secret: invalid salt: %w", err)
+	}
+
+	storedKey, err := base64.StdEncoding.DecodeString(keys[0])
+	if err != nil || len(storedKey) != sha256.Size {
+		return nil, errors.New("invalid SCRAM secret: invalid StoredKey")
+	}
+
+	serverKey, err := base64.StdEncoding.DecodeString(keys[1])
+	if err != nil || len(serverKey) != sha256.Size {
+		return nil, errors.New("invalid SCRAM secret: invalid ServerKey")
+	}
+
+	return &SCRAMSecret{Iterations: iterations, Salt: salt, StoredKey: storedKey, ServerKey: serverKey}, nil
+}
+
+// String returns the secret in the format parsed by ParseSCRAMSecret.
+func (s *SCRAMSecret) String() string {
+	return fmt.Sprintf("%s$%d:%s$%s:%s",
+		scramSHA256Name,
+		s.Iterations,
+		base64.StdEncoding.EncodeToString(s.Salt),
+		base64.StdEncoding.EncodeToString(s.StoredKey),
+		base64.StdEncoding.EncodeToString(s.ServerKey),
+	)
+}
+
+// VerifyPassword returns true if password is the password s was derived from.
+func (s *SCRAMSecret) VerifyPassword(password string) bool {
+	other := newSCRAMSecret(password, s.Salt, s.Iterations)
+	return subtle.ConstantTimeCompare(other.StoredKey, s.StoredKey) == 1 &&
+		subtle.ConstantTimeCompare(other.ServerKey, s.ServerKey) == 1
+}
+
+// AuthenticateSCRAM authenticates the client of backend with SCRAM-SHA-256 using secret. It sends
+// AuthenticationSASL, receives SASLInitialResponse (client-first-message), sends AuthenticationSASLContinue
+// (server-first-message), receives SASLResponse (client-final-message), and verifies the client proof.
+//
+// If the client proves it knows the password, AuthenticationSASLFinal (server-final-message) is queued but not flushed
+// and nil is returned. The caller should then send AuthenticationOk and flush. If the proof is wrong ErrAuthFailed is
+// returned. Any other error means the exchange failed (e.g. a network error or an invalid message). Channel binding
+// (SCRAM-SHA-256-PLUS) is not supported.
+func AuthenticateSCRAM(backend *pgproto3.Backend, secret *SCRAMSecret) error {
+	backend.Send(&pgproto3.AuthenticationSASL{AuthMechanisms: []string{scramSHA256Name}})
+	err := backend.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = backend.SetAuthType(pgproto3.AuthTypeSASL)
+	if err != nil {
+		return err
+	}
+
+	msg, err := backend.Receive()
+	if err != nil {
+		return err
+	}
+	initialResponse, ok := msg.(*pgproto3.SASLInitialResponse)
+	if !ok {
+		return fmt.Errorf("expected SASLInitialResponse, got %T", msg)
+	}
+	if initialResponse.AuthMechanism != scramSHA256Name {
+		return fmt.Errorf("unsupported SASL mechanism: %s", initialResponse.AuthMechanism)
+	}
+
+	gs2Header, clientFirstMessageBare, clientNonce, err := parseSCRAMClientFirstMessage(string(initialResponse.Data))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, scramServerNonceLen)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return err
+	}
+	nonce := clientNonce + base64.RawStdEncoding.EncodeToString(buf)
+
+	serverFirstMessage := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(secret.Salt), secret.Iterations)
+	backend.Send(&pgproto3.AuthenticationSASLContinue{Data: []byte(serverFirstMessage)})
+	err = backend.Flush()
+	if err != nil {
+		return err
+	}
+
+	err = backend.SetAuthType(pgproto3.AuthTypeSASLContinue)
+	if err != nil {
+		return err
+	}
+
+	msg, err = backend.Receive()
+	if err != nil {
+		return err
+	}
+	response, ok := msg.(*pgproto3.SASLResponse)
+	if !ok {
+		return fmt.Errorf("expected SASLResponse, got %T", msg)
+	}
+
+	// client-final-message is "c=" channel-binding ",r=" nonce ",p=" proof.
+	clientFinalMessage := string(response.Data)
+	proofIdx := strings.LastIndex(clientFinalMessage, ",p=")
+	if proofIdx < 0 {
+		return errors.New("invalid SCRAM client-final-message: did not include p=")
+	}
+	clientFinalMessageWithoutProof := clientFinalMessage[:proofIdx]
+	proof, err := base64.StdEncoding.DecodeString(clientFinalMessage[proofIdx+3:])
+	if err != nil {
+		return fmt.Errorf("invalid SCRAM client-final-message: %w", err)
+	}
+
+	wantWithoutProof := fmt.Sprintf("c=%s,r=%s", base64.StdEncoding.EncodeToString([]byte(gs2Header)), nonce)
+	if clientFinalMessageWithoutProof != wantWithoutProof {
+		return errors.New("invalid SCRAM client-final-message: channel binding or nonce mismatch")
+	}
+
+	authMessage := []byte(clientFirstMessageBare + "," + serverFirstMessage + "," + clientFinalMessageWithoutProof)
+	clientSignature := computeHMAC(secret.StoredKey, authMessage)
+	if len(proof) != len(clientSignature) {
+		return ErrAuthFailed
+	}
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(storedKey[:], secret.StoredKey) != 1 {
+		return ErrAuthFailed
+	}
+
+	serverSignature := computeHMAC(secret.ServerKey, authMessage)
+	backend.Send(&pgproto3.AuthenticationSASLFinal{Data: []byte("v=" + base64.StdEncoding.EncodeToString(serverSignature))})
+
+	return nil
+}
+
+// parseSCRAMClientFirstMessage splits a client-first-message into its gs2-header and client-first-message-bare and
+// returns the client nonce. Channel binding is not supported so the gs2-header must be "n,," or "y,,".
+func parseSCRAMClientFirstMessage(msg string) (gs2Header, bare, clientNonce string, err error) {
+	if !strings.HasPrefix(msg, "n,,") && !strings.HasPrefix(msg, "y,,") {
+		return "", "", "", errors.New("invalid SCRAM client-first-message: unsupported gs2-header")
+	}
+	gs2Header, bare = msg[:3], msg[3:]
+
+	for _, attr := range strings.Split(bare, ",") {
+		if strings.HasPrefix(attr, "r=") {
+			clientNonce = attr[2:]
+		}
+	}
+	if clientNonce == "" {
+		return "", "", "", errors.New("invalid SCRAM client-first-message: did not include r=")
+	}
+
+	return gs2Header, bare, clientNonce, nil
+}
+
+func computeHMAC(key, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
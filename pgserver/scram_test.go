@@ -0,0 +1,164 @@
+package pgserver_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgserver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSCRAMSecret(t *testing.T) {
+	t.Parallel()
+
+	// A secret in the format PostgreSQL stores in pg_authid.rolpassword.
+	s := "SCRAM-SHA-256$4096:BFxvKdq71tYnOD08ed3ZMg==$0nFiuAxGZEOpwNXCUJjS8bY1VPPQmvwoUtxk0fy/XWM=:+jKDiRfFRXBYs0UoXH2n0gcxvEwS1aQgiZWrWxIfgEQ="
+	secret, err := pgserver.ParseSCRAMSecret(s)
+	require.NoError(t, err)
+	assert.Equal(t, 4096, secret.Iterations)
+	assert.Len(t, secret.Salt, 16)
+	assert.Equal(t, s, secret.String())
+
+	secret, err = pgserver.NewSCRAMSecret("secret")
+	require.NoError(t, err)
+	assert.True(t, secret.VerifyPassword("secret"))
+	assert.False(t, secret.VerifyPassword("wrong"))
+
+	parsed, err := pgserver.ParseSCRAMSecret(secret.String())
+	require.NoError(t, err)
+	assert.Equal(t, secret, parsed)
+
+	for _, s := range []string{
+		"",
+		"secret",
+		"md5e4e2d1c1b8ac7bb4e57b8d6c0c4df0d1",
+		"SCRAM-SHA-256$4096:BFxvKdq71tYnOD08ed3ZMg==",
+		"SCRAM-SHA-256$abc:BFxvKdq71tYnOD08ed3ZMg==$0nFiuAxGZEOpwNXCUJjS8bY1VPPQmvwoUtxk0fy/XWM=:+jKDiRfFRXBYs0UoXH2n0gcxvEwS1aQgiZWrWxIfgEQ=",
+		"SCRAM-SHA-256$4096:!!!$0nFiuAxGZEOpwNXCUJjS8bY1VPPQmvwoUtxk0fy/XWM=:+jKDiRfFRXBYs0UoXH2n0gcxvEwS1aQgiZWrWxIfgEQ=",
+		"SCRAM-SHA-256$4096:BFxvKdq71tYnOD08ed3ZMg==$c2hvcnQ=:+jKDiRfFRXBYs0UoXH2n0gcxvEwS1aQgiZWrWxIfgEQ=",
+	} {
+		_, err := pgserver.ParseSCRAMSecret(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAuthenticateSCRAM(t *testing.T) {
+	t.Parallel()
+
+	secret, err := pgserver.NewSCRAMSecret("secret")
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// The server only authenticates the client. A successful client receives AuthenticationOk and ReadyForQuery.
+	serverErrChan := make(chan error, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			serverErrChan <- func() error {
+				conn, err := ln.Accept()
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+				backend := pgproto3.NewBackend(conn, conn)
+				_, err = backend.ReceiveStartupMessage()
+				if err != nil {
+					return err
+				}
+
+				err = pgserver.AuthenticateSCRAM(backend, secret)
+				if err != nil {
+					backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"})
+					backend.Flush()
+					return err
+				}
+
+				backend.Send(&pgproto3.AuthenticationOk{})
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				return backend.Flush()
+			}()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+	connString := fmt.Sprintf("host=%s port=%s user=jack sslmode=disable", host, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, connString+" password=secret")
+	require.NoError(t, err)
+	require.NoError(t, <-serverErrChan)
+	conn.Close(ctx)
+
+	_, err = pgconn.Connect(ctx, connString+" password=wrong")
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "28P01", pgErr.Code)
+	assert.True(t, errors.Is(<-serverErrChan, pgserver.ErrAuthFailed))
+}
+
+func TestServerAuthStoredSecret(t *testing.T) {
+	t.Parallel()
+
+	scramSecret, err := pgserver.NewSCRAMSecret("secret")
+	require.NoError(t, err)
+
+	// md5 of "secret" + "jack".
+	md5Secret := "md525837b3b52df6e9ef3a131068e9acfcf"
+
+	for _, tt := range []struct {
+		method   string
+		password string
+		ok       bool
+	}{
+		{"password", scramSecret.String(), true},
+		{"md5", scramSecret.String(), true},
+		{"scram-sha-256", scramSecret.String(), true},
+		{"password", md5Secret, true},
+		{"md5", md5Secret, true},
+		{"scram-sha-256", md5Secret, false},
+	} {
+		tt := tt
+		t.Run(fmt.Sprintf("%s %s", tt.method, tt.password[:3]), func(t *testing.T) {
+			t.Parallel()
+
+			connString := startServer(t, &pgserver.Config{
+				AuthMethod: tt.method,
+				GetPassword: func(ctx context.Context, user string) (string, error) {
+					return tt.password, nil
+				},
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := pgconn.Connect(ctx, connString+" password=secret")
+			if tt.ok {
+				require.NoError(t, err)
+				require.NoError(t, conn.Close(ctx))
+			} else {
+				var pgErr *pgconn.PgError
+				require.ErrorAs(t, err, &pgErr)
+				assert.Equal(t, "28P01", pgErr.Code)
+			}
+
+			_, err = pgconn.Connect(ctx, connString+" password=wrong")
+			var pgErr *pgconn.PgError
+			require.ErrorAs(t, err, &pgErr)
+			assert.Equal(t, "28P01", pgErr.Code)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package pgserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Config is the configuration of a Server.
+type Config struct {
+	// NewHandler builds the Handler for a connection after the client has authenticated. Returning an error rejects
+	// the connection. It is required.
+	NewHandler func(ctx context.Context, conn *Conn) (Handler, error)
+
+	// TLSConfig is used to accept SSLRequests. If nil, SSLRequests are refused and clients must connect without TLS.
+	TLSConfig *tls.Config
+
+	// AuthMethod is the authentication method clients must use. It is one of "trust" (the default if empty),
+	// "password", "md5", or "scram-sha-256".
+	AuthMethod string
+
+	// GetPassword returns the password of user. It is required unless AuthMethod is "trust". If it returns an error the
+	// client fails to authenticate. Like pg_authid.rolpassword, the password can also be stored as a SCRAM-SHA-256
+	// secret (see ParseSCRAMSecret) or as "md5" followed by the hex MD5 hash of the password and user. A SCRAM secret
+	// is used for SCRAM authentication even if AuthMethod is "md5". An MD5 secret cannot be used with "scram-sha-256".
+	GetPassword func(ctx context.Context, user string) (string, error)
+
+	// ParameterStatuses are sent to clients after they authenticate. They are added to or replace the defaults:
+	// server_version, server_encoding, client_encoding, DateStyle, IntervalStyle, TimeZone, integer_datetimes, and
+	// standard_conforming_strings.
+	ParameterStatuses map[string]string
+}
+
+var defaultParameterStatuses = map[string]string{
+	"server_version":              "15.0",
+	"server_encoding":             "UTF8",
+	"client_encoding":             "UTF8",
+	"DateStyle":                   "ISO, MDY",
+	"IntervalStyle":               "postgres",
+	"TimeZone":                    "UTC",
+	"integer_datetimes":           "on",
+	"standard_conforming_strings": "on",
+}
+
+// Server is a PostgreSQL server that executes statements with a Handler. It handles startup, TLS, authentication,
+// the simple and extended query protocols, and cancel requests.
+type Server struct {
+	config            *Config
+	parameterStatuses map[string]string
+
+	connsMux sync.Mutex
+	conns    map[uint32]*Conn
+	lastPID  uint32
+}
+
+// NewServer returns a new Server.
+func NewServer(config *Config) (*Server, error) {
+	if config.NewHandler == nil {
+		return nil, errors.New("NewHandler is required")
+	}
+
+	switch config.AuthMethod {
+	case "", "trust":
+	case "password", "md5", "scram-sha-256":
+		if config.GetPassword == nil {
+			return nil, fmt.Errorf("GetPassword is required for auth method %s", config.AuthMethod)
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth method: %s", config.AuthMethod)
+	}
+
+	parameterStatuses := make(map[string]string, len(defaultParameterStatuses)+len(config.ParameterStatuses))
+	for k, v := range defaultParameterStatuses {
+		parameterStatuses[k] = v
+	}
+	for k, v := range config.ParameterStatuses {
+		parameterStatuses[k] = v
+	}
+
+	return &Server{
+		config:            config,
+		parameterStatuses: parameterStatuses,
+		conns:             make(map[uint32]*Conn),
+	}, nil
+}
+
+// Serve accepts connections on ln and serves each in a new goroutine until ln is closed. Errors from serving
+// individual connections are discarded. Use ServeConn to handle them.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		netConn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.ServeConn(context.Background(), netConn)
+	}
+}
+
+// ServeConn serves a client connected with netConn. It returns nil when the client terminates the connection
+// normally. netConn is closed when ServeConn returns. Canceling ctx closes netConn.
+func (s *Server) ServeConn(ctx context.Context, netConn net.Conn) error {
+	defer netConn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		netConn.Close()
+	}()
+
+	c := &Conn{
+		server:  s,
+		netConn: netConn,
+	}
+
+	err := c.startup(ctx)
+	if err != nil {
+		return err
+	}
+	if c.handler == nil {
+		// The connection was a cancel request.
+		return nil
+	}
+	defer s.unregister(c)
+
+	return c.serve(ctx)
+}
+
+// register assigns a process ID and secret key to c so it can be canceled.
+func (s *Server) register(c *Conn) error {
+	var buf [4]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return err
+	}
+	secretKey := binary.BigEndian.Uint32(buf[:])
+
+	s.connsMux.Lock()
+	defer s.connsMux.Unlock()
+
+	for {
+		s.lastPID++
+		if s.lastPID == 0 {
+			continue
+		}
+		if _, ok := s.conns[s.lastPID]; !ok {
+			break
+		}
+	}
+
+	c.pid = s.lastPID
+	c.secretKey = secretKey
+	s.conns[c.pid] = c
+
+	return nil
+}
+
+func (s *Server) unregister(c *Conn) {
+	s.connsMux.Lock()
+	defer s.connsMux.Unlock()
+
+	if s.conns[c.pid] == c {
+		delete(s.conns, c.pid)
+	}
+}
+
+// cancel cancels the statement in progress on the connection identified by pid and secretKey, if any.
+func (s *Server) cancel(pid uint32, secretKey uint32) {
+	s.connsMux.Lock()
+	c, ok := s.conns[pid]
+	s.connsMux.Unlock()
+
+	if ok && subtle.ConstantTimeEq(int32(c.secretKey), int32(secretKey)) == 1 {
+		c.cancelCurrentStatement()
+	}
+}
@@ -0,0 +1,477 @@
+package pgserver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgserver"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHandler implements a few fixed statements.
+type testHandler struct {
+	inTx bool
+}
+
+func (h *testHandler) Describe(ctx context.Context, sql string, paramOIDs []uint32) (*pgserver.Description, error) {
+	switch sql {
+	case "select 1":
+		return &pgserver.Description{Columns: []pgserver.Column{{Name: "?column?", DataTypeOID: pgtype.Int4OID}}}, nil
+	case "select $1::int4 + 1 as n, $2::text as s":
+		return &pgserver.Description{
+			ParamOIDs: []uint32{pgtype.Int4OID, pgtype.TextOID},
+			Columns:   []pgserver.Column{{Name: "n", DataTypeOID: pgtype.Int4OID}, {Name: "s", DataTypeOID: pgtype.TextOID}},
+		}, nil
+	case "select generate_series(1, 5)":
+		return &pgserver.Description{Columns: []pgserver.Column{{Name: "generate_series", DataTypeOID: pgtype.Int4OID}}}, nil
+	case "insert", "sleep", "begin", "commit", "":
+		return &pgserver.Description{}, nil
+	default:
+		return nil, &pgconn.PgError{Severity: "ERROR", Code: "42601", Message: fmt.Sprintf("syntax error: %s", sql)}
+	}
+}
+
+func (h *testHandler) Exec(ctx context.Context, sql string, args []any) ([]*pgserver.Result, error) {
+	var results []*pgserver.Result
+	for _, stmt := range strings.Split(sql, ";") {
+		stmt = strings.TrimSpace(stmt)
+		_, err := h.Describe(ctx, stmt, nil)
+		if err != nil {
+			return results, err
+		}
+
+		var result *pgserver.Result
+		switch stmt {
+		case "select 1":
+			result = &pgserver.Result{Columns: []pgserver.Column{{Name: "?column?", DataTypeOID: pgtype.Int4OID}}, Rows: [][]any{{int32(1)}}}
+		case "select $1::int4 + 1 as n, $2::text as s":
+			result = &pgserver.Result{
+				Columns: []pgserver.Column{{Name: "n", DataTypeOID: pgtype.Int4OID}, {Name: "s", DataTypeOID: pgtype.TextOID}},
+				Rows:    [][]any{{args[0].(int32) + 1, args[1]}},
+			}
+		case "select generate_series(1, 5)":
+			result = &pgserver.Result{Columns: []pgserver.Column{{Name: "generate_series", DataTypeOID: pgtype.Int4OID}}}
+			for i := int32(1); i <= 5; i++ {
+				result.Rows = append(result.Rows, []any{i})
+			}
+		case "insert":
+			result = &pgserver.Result{CommandTag: "INSERT 0 1"}
+		case "sleep":
+			<-ctx.Done()
+			return results, ctx.Err()
+		case "begin":
+			h.inTx = true
+			result = &pgserver.Result{CommandTag: "BEGIN"}
+		case "commit":
+			h.inTx = false
+			result = &pgserver.Result{CommandTag: "COMMIT"}
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func (h *testHandler) TxStatus() byte {
+	if h.inTx {
+		return 'T'
+	}
+	return 'I'
+}
+
+func startServer(t *testing.T, config *pgserver.Config) string {
+	t.Helper()
+
+	if config.NewHandler == nil {
+		config.NewHandler = func(ctx context.Context, conn *pgserver.Conn) (pgserver.Handler, error) {
+			return &testHandler{}, nil
+		}
+	}
+
+	server, err := pgserver.NewServer(config)
+	require.NoError(t, err)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go server.Serve(ln)
+
+	host, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+
+	return fmt.Sprintf("host=%s port=%s user=jack sslmode=disable", host, port)
+}
+
+func TestServerSimpleProtocol(t *testing.T) {
+	t.Parallel()
+
+	connString := startServer(t, &pgserver.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	assert.Equal(t, "on", conn.ParameterStatus("standard_conforming_strings"))
+	assert.NotZero(t, conn.PID())
+
+	results, err := conn.Exec(ctx, "select 1; insert").ReadAll()
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, [][][]byte{{[]byte("1")}}, results[0].Rows)
+	assert.Equal(t, "SELECT 1", results[0].CommandTag.String())
+	assert.Equal(t, "INSERT 0 1", results[1].CommandTag.String())
+
+	results, err = conn.Exec(ctx, "insert; bogus; insert").ReadAll()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "42601", pgErr.Code)
+	require.Len(t, results, 1)
+	assert.Equal(t, "INSERT 0 1", results[0].CommandTag.String())
+
+	_, err = conn.Exec(ctx, "begin").ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, byte('T'), conn.TxStatus())
+	_, err = conn.Exec(ctx, "commit").ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, byte('I'), conn.TxStatus())
+
+	results, err = conn.Exec(ctx, "").ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, results, 0)
+}
+
+func TestServerExtendedProtocol(t *testing.T) {
+	t.Parallel()
+
+	connString := startServer(t, &pgserver.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	sd, err := conn.Prepare(ctx, "ps", "select $1::int4 + 1 as n, $2::text as s", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{pgtype.Int4OID, pgtype.TextOID}, sd.ParamOIDs)
+	require.Len(t, sd.Fields, 2)
+	assert.Equal(t, "n", sd.Fields[0].Name)
+
+	result := conn.ExecPrepared(ctx, "ps", [][]byte{{0, 0, 0, 41}, []byte("foo")}, []int16{pgtype.BinaryFormatCode, pgtype.TextFormatCode}, []int16{pgtype.BinaryFormatCode}).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{{0, 0, 0, 42}, []byte("foo")}}, result.Rows)
+
+	// An error is reported and the connection recovers at the next Sync.
+	result = conn.ExecParams(ctx, "bogus", nil, nil, nil, nil).Read()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, result.Err, &pgErr)
+	assert.Equal(t, "42601", pgErr.Code)
+
+	result = conn.ExecParams(ctx, "select $1::int4 + 1 as n, $2::text as s", [][]byte{[]byte("1")}, nil, nil, nil).Read()
+	require.ErrorAs(t, result.Err, &pgErr)
+	assert.Equal(t, "08P01", pgErr.Code)
+
+	result = conn.ExecParams(ctx, "insert", nil, nil, nil, nil).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, "INSERT 0 1", result.CommandTag.String())
+
+	result = conn.ExecPrepared(ctx, "missing", nil, nil, nil).Read()
+	require.ErrorAs(t, result.Err, &pgErr)
+	assert.Equal(t, "26000", pgErr.Code)
+
+	// A Bind message with a format code count that is neither 0, 1, nor the number of values is rejected.
+	binary, text := int16(pgtype.BinaryFormatCode), int16(pgtype.TextFormatCode)
+	result = conn.ExecPrepared(ctx, "ps", [][]byte{{0, 0, 0, 41}, []byte("foo")}, []int16{binary, text, text}, nil).Read()
+	require.ErrorAs(t, result.Err, &pgErr)
+	assert.Equal(t, "08P01", pgErr.Code)
+	assert.Equal(t, "bind message has 3 parameter formats but 2 parameters", pgErr.Message)
+
+	result = conn.ExecPrepared(ctx, "ps", [][]byte{{0, 0, 0, 41}, []byte("foo")}, []int16{binary, text}, []int16{binary, text, text}).Read()
+	require.ErrorAs(t, result.Err, &pgErr)
+	assert.Equal(t, "08P01", pgErr.Code)
+	assert.Equal(t, "bind message has 3 result formats but query has 2 columns", pgErr.Message)
+
+	result = conn.ExecPrepared(ctx, "ps", [][]byte{{0, 0, 0, 41}, []byte("foo")}, []int16{binary, text}, nil).Read()
+	require.NoError(t, result.Err)
+	assert.Equal(t, [][][]byte{{[]byte("42"), []byte("foo")}}, result.Rows)
+}
+
+func TestServerPortalMaxRows(t *testing.T) {
+	t.Parallel()
+
+	connString := startServer(t, &pgserver.Config{})
+
+	config, err := pgconn.ParseConfig(connString)
+	require.NoError(t, err)
+
+	netConn, err := net.Dial("tcp", net.JoinHostPort(config.Host, fmt.Sprint(config.Port)))
+	require.NoError(t, err)
+	defer netConn.Close()
+	netConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	frontend := pgproto3.NewFrontend(netConn, netConn)
+	frontend.Send(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: map[string]string{"user": "jack"}})
+	require.NoError(t, frontend.Flush())
+
+	receiveUntilReadyForQuery := func() []string {
+		var types []string
+		for {
+			msg, err := frontend.Receive()
+			require.NoError(t, err)
+			types = append(types, fmt.Sprintf("%T", msg))
+			if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+				return types
+			}
+		}
+	}
+	receiveUntilReadyForQuery()
+
+	frontend.SendParse(&pgproto3.Parse{Query: "select generate_series(1, 5)"})
+	frontend.SendBind(&pgproto3.Bind{})
+	frontend.SendExecute(&pgproto3.Execute{MaxRows: 2})
+	frontend.SendExecute(&pgproto3.Execute{MaxRows: 2})
+	frontend.SendExecute(&pgproto3.Execute{MaxRows: 2})
+	frontend.SendSync(&pgproto3.Sync{})
+	require.NoError(t, frontend.Flush())
+
+	assert.Equal(t, []string{
+		"*pgproto3.ParseComplete",
+		"*pgproto3.BindComplete",
+		"*pgproto3.DataRow", "*pgproto3.DataRow", "*pgproto3.PortalSuspended",
+		"*pgproto3.DataRow", "*pgproto3.DataRow", "*pgproto3.PortalSuspended",
+		"*pgproto3.DataRow", "*pgproto3.CommandComplete",
+		"*pgproto3.ReadyForQuery",
+	}, receiveUntilReadyForQuery())
+}
+
+func TestServerPgx(t *testing.T) {
+	t.Parallel()
+
+	connString := startServer(t, &pgserver.Config{})
+
+	for _, mode := range []pgx.QueryExecMode{
+		pgx.QueryExecModeCacheStatement,
+		pgx.QueryExecModeCacheDescribe,
+		pgx.QueryExecModeDescribeExec,
+		pgx.QueryExecModeExec,
+		pgx.QueryExecModeSimpleProtocol,
+	} {
+		mode := mode
+		t.Run(mode.String(), func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			config, err := pgx.ParseConfig(connString)
+			require.NoError(t, err)
+			config.DefaultQueryExecMode = mode
+
+			conn, err := pgx.ConnectConfig(ctx, config)
+			require.NoError(t, err)
+			defer conn.Close(ctx)
+
+			if mode == pgx.QueryExecModeSimpleProtocol {
+				var n int32
+				err = conn.QueryRow(ctx, "select 1").Scan(&n)
+				require.NoError(t, err)
+				assert.EqualValues(t, 1, n)
+				return
+			}
+
+			for i := 0; i < 2; i++ {
+				var n int32
+				var s string
+				err = conn.QueryRow(ctx, "select $1::int4 + 1 as n, $2::text as s", 41, "foo").Scan(&n, &s)
+				require.NoError(t, err)
+				assert.EqualValues(t, 42, n)
+				assert.Equal(t, "foo", s)
+			}
+
+			commandTag, err := conn.Exec(ctx, "insert")
+			require.NoError(t, err)
+			assert.Equal(t, "INSERT 0 1", commandTag.String())
+		})
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	t.Parallel()
+
+	for _, method := range []string{"password", "md5", "scram-sha-256"} {
+		method := method
+		t.Run(method, func(t *testing.T) {
+			t.Parallel()
+
+			connString := startServer(t, &pgserver.Config{
+				AuthMethod: method,
+				GetPassword: func(ctx context.Context, user string) (string, error) {
+					if user != "jack" {
+						return "", errors.New("unknown user")
+					}
+					return "secret", nil
+				},
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := pgconn.Connect(ctx, connString+" password=secret")
+			require.NoError(t, err)
+			require.NoError(t, conn.Close(ctx))
+
+			for _, extra := range []string{" password=wrong", " password=secret user=bob"} {
+				_, err = pgconn.Connect(ctx, connString+extra)
+				var pgErr *pgconn.PgError
+				require.ErrorAs(t, err, &pgErr, extra)
+				assert.Equal(t, "28P01", pgErr.Code)
+			}
+		})
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	connString := startServer(t, &pgserver.Config{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		NewHandler: func(ctx context.Context, conn *pgserver.Conn) (pgserver.Handler, error) {
+			if _, ok := conn.NetConn().(*tls.Conn); !ok {
+				return nil, errors.New("TLS required")
+			}
+			return &testHandler{}, nil
+		},
+	})
+	connString = strings.Replace(connString, "sslmode=disable", "sslmode=require", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "select 1").ReadAll()
+	require.NoError(t, err)
+}
+
+func TestServerCancelRequest(t *testing.T) {
+	t.Parallel()
+
+	connString := startServer(t, &pgserver.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgconn.Connect(ctx, connString)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		conn.CancelRequest(ctx)
+	}()
+
+	_, err = conn.Exec(ctx, "sleep").ReadAll()
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "57014", pgErr.Code)
+
+	results, err := conn.Exec(ctx, "select 1").ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+func TestAcceptStartup(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		frontend := pgproto3.NewFrontend(clientConn, clientConn)
+		frontend.Send(&pgproto3.StartupMessage{
+			ProtocolVersion: pgproto3.ProtocolVersion32,
+			Parameters:      map[string]string{"user": "jack", "_pq_.foo": "bar"},
+		})
+		frontend.Flush()
+	}()
+
+	clientStartup, err := pgserver.AcceptStartup(ctx, serverConn, nil)
+	require.NoError(t, err)
+	assert.Nil(t, clientStartup.CancelRequest)
+	assert.Equal(t, map[string]string{"user": "jack"}, clientStartup.Parameters)
+	assert.Equal(t, "jack", clientStartup.User())
+	assert.Equal(t, "jack", clientStartup.Database())
+
+	// The negotiation is sent with the rest of the handshake.
+	go clientStartup.Backend.Flush()
+	msg, err := pgproto3.NewFrontend(clientConn, clientConn).Receive()
+	require.NoError(t, err)
+	assert.Equal(t, &pgproto3.NegotiateProtocolVersion{NewestMinorProtocol: 0, UnrecognizedOptions: []string{"_pq_.foo"}}, msg)
+}
+
+func TestAcceptStartupNoUser(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := pgserver.AcceptStartup(ctx, serverConn, nil)
+		errChan <- err
+	}()
+
+	frontend := pgproto3.NewFrontend(clientConn, clientConn)
+	frontend.Send(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersion30, Parameters: map[string]string{"database": "db"}})
+	require.NoError(t, frontend.Flush())
+
+	msg, err := frontend.Receive()
+	require.NoError(t, err)
+	errResp, ok := msg.(*pgproto3.ErrorResponse)
+	require.True(t, ok)
+	assert.Equal(t, "28000", errResp.Code)
+	require.EqualError(t, <-errChan, "FATAL: no PostgreSQL user name specified in startup packet (SQLSTATE 28000)")
+}
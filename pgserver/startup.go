@@ -0,0 +1,141 @@
+package pgserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// ClientStartup is the result of the startup handshake of a client accepted by AcceptStartup.
+type ClientStartup struct {
+	// NetConn is the client connection. It is a *tls.Conn if the client negotiated TLS.
+	NetConn net.Conn
+
+	// Backend reads from and writes to NetConn.
+	Backend *pgproto3.Backend
+
+	// CancelRequest is the cancel request the client sent instead of a startup message. If it is not nil the handshake
+	// is complete and Parameters is nil.
+	CancelRequest *pgproto3.CancelRequest
+
+	// Parameters are the parameters sent by the client in the startup message (e.g. user, database, and
+	// application_name) without protocol options.
+	Parameters map[string]string
+}
+
+// User returns the user the client connected as.
+func (cs *ClientStartup) User() string {
+	return cs.Parameters["user"]
+}
+
+// Database returns the database the client connected to. It defaults to the user.
+func (cs *ClientStartup) Database() string {
+	if database := cs.Parameters["database"]; database != "" {
+		return database
+	}
+	return cs.User()
+}
+
+// AcceptStartup handles the startup messages of a client connected with netConn until it sends a startup message or a
+// cancel request. An SSLRequest is accepted if tlsConfig is not nil and a GSSEncRequest is always refused. Protocol
+// version 3.0 is negotiated with clients that request a newer minor version or protocol options. Clients with an
+// unsupported protocol version or without a user are sent a FATAL error.
+//
+// The caller continues the handshake with authentication on the returned Backend.
+func AcceptStartup(ctx context.Context, netConn net.Conn, tlsConfig *tls.Config) (*ClientStartup, error) {
+	backend := pgproto3.NewBackend(netConn, netConn)
+
+	for {
+		msg, err := backend.ReceiveStartupMessage()
+		if err != nil {
+			return nil, fmt.Errorf("error receiving startup message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.SSLRequest:
+			if tlsConfig == nil || isTLSConn(netConn) {
+				_, err = netConn.Write([]byte("N"))
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			_, err = netConn.Write([]byte("S"))
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConn := tls.Server(netConn, tlsConfig)
+			err = tlsConn.HandshakeContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("TLS handshake failed: %w", err)
+			}
+			netConn = tlsConn
+			backend = pgproto3.NewBackend(tlsConn, tlsConn)
+
+		case *pgproto3.GSSEncRequest:
+			_, err = netConn.Write([]byte("N"))
+			if err != nil {
+				return nil, err
+			}
+
+		case *pgproto3.CancelRequest:
+			return &ClientStartup{NetConn: netConn, Backend: backend, CancelRequest: msg}, nil
+
+		case *pgproto3.StartupMessage:
+			params, err := acceptStartupMessage(backend, msg)
+			if err != nil {
+				return nil, err
+			}
+			return &ClientStartup{NetConn: netConn, Backend: backend, Parameters: params}, nil
+
+		default:
+			return nil, fmt.Errorf("unexpected startup message: %#v", msg)
+		}
+	}
+}
+
+func isTLSConn(conn net.Conn) bool {
+	_, ok := conn.(*tls.Conn)
+	return ok
+}
+
+// acceptStartupMessage validates msg and returns its parameters without protocol options.
+func acceptStartupMessage(backend *pgproto3.Backend, msg *pgproto3.StartupMessage) (map[string]string, error) {
+	if msg.ProtocolVersion>>16 != 3 {
+		return nil, SendFatal(backend, newErrorResponse("FATAL", "0A000", fmt.Sprintf("unsupported frontend protocol %d.%d", msg.ProtocolVersion>>16, msg.ProtocolVersion&0xffff)))
+	}
+
+	params := make(map[string]string, len(msg.Parameters))
+	var unrecognizedOptions []string
+	for k, v := range msg.Parameters {
+		if strings.HasPrefix(k, "_pq_.") {
+			unrecognizedOptions = append(unrecognizedOptions, k)
+			continue
+		}
+		params[k] = v
+	}
+
+	if params["user"] == "" {
+		return nil, SendFatal(backend, newErrorResponse("FATAL", "28000", "no PostgreSQL user name specified in startup packet"))
+	}
+
+	// Only protocol version 3.0 is supported. Newer minor versions and protocol options are negotiated down.
+	if msg.ProtocolVersion != pgproto3.ProtocolVersion30 || len(unrecognizedOptions) > 0 {
+		backend.Send(&pgproto3.NegotiateProtocolVersion{NewestMinorProtocol: 0, UnrecognizedOptions: unrecognizedOptions})
+	}
+
+	return params, nil
+}
+
+// SendFatal sends er to the client of backend and returns it as an error. The caller should close the connection.
+func SendFatal(backend *pgproto3.Backend, er *pgproto3.ErrorResponse) error {
+	backend.Send(er)
+	backend.Flush()
+	return fmt.Errorf("%s: %s (SQLSTATE %s)", er.Severity, er.Message, er.Code)
+}
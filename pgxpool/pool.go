@@ -94,6 +94,12 @@ type Pool struct {
 
 	healthCheckChan chan struct{}
 
+	// connStatsMux guards liveConns and closedConnStats. They are used to aggregate the pgconn.ConnStats of all
+	// connections for Stat.
+	connStatsMux    sync.Mutex
+	liveConns       map[*pgconn.PgConn]struct{}
+	closedConnStats pgconn.ConnStats
+
 	closeOnce sync.Once
 	closeChan chan struct{}
 }
@@ -187,6 +193,7 @@ func NewWithConfig(ctx context.Context, config *Config) (*Pool, error) {
 		maxConnIdleTime:       config.MaxConnIdleTime,
 		healthCheckPeriod:     config.HealthCheckPeriod,
 		healthCheckChan:       make(chan struct{}, 1),
+		liveConns:             make(map[*pgconn.PgConn]struct{}),
 		closeChan:             make(chan struct{}),
 	}
 
@@ -231,6 +238,10 @@ func NewWithConfig(ctx context.Context, config *Config) (*Pool, error) {
 					maxAgeTime: maxAgeTime,
 				}
 
+				p.connStatsMux.Lock()
+				p.liveConns[conn.PgConn()] = struct{}{}
+				p.connStatsMux.Unlock()
+
 				return cr, nil
 			},
 			Destructor: func(value *connResource) {
@@ -242,6 +253,11 @@ func NewWithConfig(ctx context.Context, config *Config) (*Pool, error) {
 				case <-ctx.Done():
 				}
 				cancel()
+
+				p.connStatsMux.Lock()
+				delete(p.liveConns, conn.PgConn())
+				p.closedConnStats = addConnStats(p.closedConnStats, conn.PgConn().Stats())
+				p.connStatsMux.Unlock()
 			},
 			MaxSize: config.MaxConns,
 		},
@@ -560,6 +576,29 @@ func (p *Pool) Stat() *Stat {
 		newConnsCount:        atomic.LoadInt64(&p.newConnsCount),
 		lifetimeDestroyCount: atomic.LoadInt64(&p.lifetimeDestroyCount),
 		idleDestroyCount:     atomic.LoadInt64(&p.idleDestroyCount),
+		connStats:            p.connStats(),
+	}
+}
+
+// connStats returns the sum of the pgconn.ConnStats of all connections that are or have been in the pool.
+func (p *Pool) connStats() pgconn.ConnStats {
+	p.connStatsMux.Lock()
+	defer p.connStatsMux.Unlock()
+
+	stats := p.closedConnStats
+	for pgConn := range p.liveConns {
+		stats = addConnStats(stats, pgConn.Stats())
+	}
+	return stats
+}
+
+func addConnStats(a, b pgconn.ConnStats) pgconn.ConnStats {
+	return pgconn.ConnStats{
+		BytesSent:        a.BytesSent + b.BytesSent,
+		BytesReceived:    a.BytesReceived + b.BytesReceived,
+		MessagesSent:     a.MessagesSent + b.MessagesSent,
+		MessagesReceived: a.MessagesReceived + b.MessagesReceived,
+		RoundTrips:       a.RoundTrips + b.RoundTrips,
 	}
 }
 
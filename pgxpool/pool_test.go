@@ -452,6 +452,43 @@ func TestConnReleaseClosesBusyConn(t *testing.T) {
 	assert.EqualValues(t, 0, stats.TotalConns())
 }
 
+func TestPoolStatConnStats(t *testing.T) {
+	t.Parallel()
+
+	db, err := pgxpool.New(context.Background(), os.Getenv("PGX_TEST_DATABASE"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	c, err := db.Acquire(context.Background())
+	require.NoError(t, err)
+
+	before := db.Stat().ConnStats()
+	assert.Equal(t, c.Conn().PgConn().Stats(), before)
+
+	_, err = c.Exec(context.Background(), "select 1")
+	require.NoError(t, err)
+
+	after := db.Stat().ConnStats()
+	assert.Greater(t, after.BytesSent, before.BytesSent)
+	assert.Greater(t, after.BytesReceived, before.BytesReceived)
+	assert.Greater(t, after.MessagesSent, before.MessagesSent)
+	assert.Greater(t, after.MessagesReceived, before.MessagesReceived)
+	assert.Equal(t, before.RoundTrips+1, after.RoundTrips)
+
+	// Statistics of closed connections are retained.
+	c.Conn().Close(context.Background())
+	c.Release()
+	waitForReleaseToComplete()
+
+	for i := 0; i < 1000; i++ {
+		if db.Stat().TotalConns() == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, after, db.Stat().ConnStats())
+}
+
 func TestPoolBackgroundChecksMaxConnLifetime(t *testing.T) {
 	t.Parallel()
 
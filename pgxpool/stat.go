@@ -3,6 +3,7 @@ package pgxpool
 import (
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/puddle/v2"
 )
 
@@ -12,6 +13,7 @@ type Stat struct {
 	newConnsCount        int64
 	lifetimeDestroyCount int64
 	idleDestroyCount     int64
+	connStats            pgconn.ConnStats
 }
 
 // AcquireCount returns the cumulative count of successful acquires from the pool.
@@ -82,3 +84,9 @@ func (s *Stat) MaxLifetimeDestroyCount() int64 {
 func (s *Stat) MaxIdleDestroyCount() int64 {
 	return s.idleDestroyCount
 }
+
+// ConnStats returns the cumulative wire-level statistics of all connections in the pool, including connections that
+// have since been closed.
+func (s *Stat) ConnStats() pgconn.ConnStats {
+	return s.connStats
+}
@@ -0,0 +1,242 @@
+package pgx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Statement is a single statement of a SQL script.
+type Statement struct {
+	SQL  string // text of the statement without leading comments and the terminating semicolon
+	Line int    // line of the script where the statement starts, starting at 1
+}
+
+// SplitStatements splits script into statements separated by semicolons. Semicolons in quoted strings, quoted
+// identifiers, dollar-quoted strings, comments, and parentheses do not end a statement. Neither do semicolons in the
+// BEGIN ATOMIC ... END body of a CREATE FUNCTION or CREATE PROCEDURE statement. Statements that are empty or only
+// contain comments are omitted.
+func SplitStatements(script string) []Statement {
+	splitter := &statementSplitter{tokenStart: -1}
+	l := &sqlLexer{
+		src:      script,
+		stateFn:  rawState,
+		splitter: splitter,
+	}
+
+	for l.stateFn != nil {
+		l.stateFn = l.stateFn(l)
+	}
+
+	if splitter.tokenStart >= 0 {
+		splitter.ends = append(splitter.ends, [2]int{splitter.tokenStart, len(script)})
+	}
+
+	statements := make([]Statement, 0, len(splitter.ends))
+	line, linePos := 1, 0
+	for _, e := range splitter.ends {
+		line += strings.Count(script[linePos:e[0]], "\n")
+		linePos = e[0]
+		statements = append(statements, Statement{
+			SQL:  strings.TrimRightFunc(script[e[0]:e[1]], unicode.IsSpace),
+			Line: line,
+		})
+	}
+
+	return statements
+}
+
+// statementSplitter finds the statement boundaries of a script lexed by sqlLexer.
+type statementSplitter struct {
+	ends [][2]int // start and end of each statement
+
+	// State of the current statement.
+	tokenStart int // position of the first token or -1 if none has been lexed
+	parenDepth int
+	beginDepth int     // depth of BEGIN ... END and CASE ... END blocks in a routine body
+	wordCount  int     // number of words lexed
+	words      [4]byte // first letter of the leading words that identify CREATE [OR REPLACE] FUNCTION|PROCEDURE
+}
+
+// raw is called by rawState for each rune r of width outside of quotes and comments. It returns true if it consumed
+// r.
+func (s *statementSplitter) raw(l *sqlLexer, r rune, width int) bool {
+	if r == utf8.RuneError || unicode.IsSpace(r) {
+		return false
+	}
+
+	nextRune, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	if (r == '-' && nextRune == '-') || (r == '/' && nextRune == '*') {
+		return false
+	}
+
+	if s.tokenStart < 0 {
+		if r == ';' {
+			// An empty statement.
+			return true
+		}
+		s.tokenStart = l.pos - width
+	}
+
+	switch {
+	case r == '(':
+		s.parenDepth++
+	case r == ')':
+		if s.parenDepth > 0 {
+			s.parenDepth--
+		}
+	case r == ';':
+		if s.parenDepth > 0 || s.beginDepth > 0 {
+			return false
+		}
+		s.ends = append(s.ends, [2]int{s.tokenStart, l.pos - width})
+		*s = statementSplitter{ends: s.ends, tokenStart: -1}
+		return true
+	case (isLetter(r) || r == '_' || r >= utf8.RuneSelf) && !l.followsIdentifier(width):
+		if (r == 'e' || r == 'E') && nextRune == '\'' {
+			// An escape string.
+			return false
+		}
+		start := l.pos - width
+		for {
+			r, w := utf8.DecodeRuneInString(l.src[l.pos:])
+			if !(isLetter(r) || r == '_' || r == '$' || (r >= '0' && r <= '9') || (r >= utf8.RuneSelf && r != utf8.RuneError)) {
+				break
+			}
+			l.pos += w
+		}
+		s.word(l.src[start:l.pos])
+		return true
+	}
+
+	return false
+}
+
+// word tracks BEGIN ATOMIC ... END bodies with the same heuristic as psql. Semicolons are not statement terminators
+// between BEGIN and its matching END in a CREATE [OR REPLACE] FUNCTION|PROCEDURE statement. CASE ... END blocks in
+// the body are counted so their END does not end the body.
+func (s *statementSplitter) word(w string) {
+	if s.wordCount < len(s.words) {
+		switch strings.ToLower(w) {
+		case "create", "or", "replace", "function", "procedure":
+			s.words[s.wordCount] = byte(unicode.ToLower(rune(w[0])))
+		}
+	}
+	s.wordCount++
+
+	isRoutine := s.words[0] == 'c' &&
+		(s.words[1] == 'f' || s.words[1] == 'p' ||
+			(s.words[1] == 'o' && s.words[2] == 'r' && (s.words[3] == 'f' || s.words[3] == 'p')))
+	if !isRoutine || s.parenDepth > 0 {
+		return
+	}
+
+	switch {
+	case strings.EqualFold(w, "begin"):
+		s.beginDepth++
+	case strings.EqualFold(w, "case"):
+		if s.beginDepth > 0 {
+			s.beginDepth++
+		}
+	case strings.EqualFold(w, "end"):
+		if s.beginDepth > 0 {
+			s.beginDepth--
+		}
+	}
+}
+
+// ExecScriptOptions are options for Conn.ExecScript.
+type ExecScriptOptions struct {
+	// TxOptions, if not nil, runs the script in a transaction started with these options. The transaction is committed
+	// if every statement succeeds and rolled back otherwise. The script must not control transactions itself.
+	TxOptions *TxOptions
+
+	// NamedArgs are the values of '@' named placeholders in the script. Each statement is executed with the args it
+	// references.
+	NamedArgs NamedArgs
+}
+
+// ScriptError is returned by ExecScript when a statement of the script fails.
+type ScriptError struct {
+	Statement Statement // the statement that failed
+	Index     int       // index of the statement in the script, starting at 0
+	Line      int       // line of the error in the script, if the server reported a position, or of the statement
+	Err       error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("statement %d at line %d: %v", e.Index+1, e.Line, e.Err)
+}
+
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// ExecScript splits script into statements with SplitStatements and executes them in order. Statements are executed
+// with Exec, so they may use the extended protocol. ExecScript stops at the first statement that fails and returns a
+// *ScriptError. options may be nil.
+func (c *Conn) ExecScript(ctx context.Context, script string, options *ExecScriptOptions) error {
+	if options == nil {
+		options = &ExecScriptOptions{}
+	}
+
+	statements := SplitStatements(script)
+
+	execStatements := func(exec func(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)) error {
+		for i, stmt := range statements {
+			var args []any
+			if options.NamedArgs != nil {
+				args = []any{options.NamedArgs}
+			}
+
+			_, err := exec(ctx, stmt.SQL, args...)
+			if err != nil {
+				sql := stmt.SQL
+				if options.NamedArgs != nil {
+					// The server reports the position of an error in the SQL it received.
+					sql, _ = rewriteNamedArgs(sql, NamedArgSyntaxAt, false)
+				}
+				return &ScriptError{Statement: stmt, Index: i, Line: scriptErrorLine(stmt, sql, err), Err: err}
+			}
+		}
+		return nil
+	}
+
+	if options.TxOptions == nil {
+		return execStatements(c.Exec)
+	}
+
+	return BeginTxFunc(ctx, c, *options.TxOptions, func(tx Tx) error {
+		return execStatements(tx.Exec)
+	})
+}
+
+// scriptErrorLine returns the line of the script where err occurred in stmt. sql is the text of stmt as sent to the
+// server.
+func scriptErrorLine(stmt Statement, sql string, err error) int {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Position <= 0 {
+		return stmt.Line
+	}
+
+	// Position is a 1-based character index into sql. Rewriting named args changes the length of lines but not the
+	// number of lines before any position so the line in sql is the line in the script.
+	line := stmt.Line
+	var n int32
+	for _, r := range sql {
+		n++
+		if n >= pgErr.Position {
+			break
+		}
+		if r == '\n' {
+			line++
+		}
+	}
+
+	return line
+}
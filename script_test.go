@@ -0,0 +1,181 @@
+package pgx_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgmock"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		script   string
+		expected []pgx.Statement
+	}{
+		{
+			script:   "",
+			expected: []pgx.Statement{},
+		},
+		{
+			script:   "select 1",
+			expected: []pgx.Statement{{SQL: "select 1", Line: 1}},
+		},
+		{
+			script:   "select 1; select 2;\n\nselect 3 ;\n",
+			expected: []pgx.Statement{{SQL: "select 1", Line: 1}, {SQL: "select 2", Line: 1}, {SQL: "select 3", Line: 3}},
+		},
+		{
+			script: "select ';', \"a;b\", E'\\';' from t;\nselect $$;$$, $tag$ $$; $tag$;",
+			expected: []pgx.Statement{
+				{SQL: "select ';', \"a;b\", E'\\';' from t", Line: 1},
+				{SQL: "select $$;$$, $tag$ $$; $tag$", Line: 2},
+			},
+		},
+		{
+			script: "-- header; comment\n/* block; /* nested; */ */\nselect 1 -- trailing;\n;\n-- only a comment;\n;;",
+			expected: []pgx.Statement{
+				{SQL: "select 1 -- trailing;", Line: 3},
+			},
+		},
+		{
+			script: "create rule r as on insert to t do also (insert into a values (1); insert into b values (2));\nselect 1",
+			expected: []pgx.Statement{
+				{SQL: "create rule r as on insert to t do also (insert into a values (1); insert into b values (2))", Line: 1},
+				{SQL: "select 1", Line: 2},
+			},
+		},
+		{
+			script: `CREATE OR REPLACE FUNCTION f(x int) RETURNS int
+LANGUAGE sql
+BEGIN ATOMIC
+  SELECT CASE WHEN x > 0 THEN 1 ELSE 0 END;
+  SELECT x;
+END;
+create procedure p() begin atomic insert into t values (1); end;
+begin;
+select 1;
+end;`,
+			expected: []pgx.Statement{
+				{SQL: "CREATE OR REPLACE FUNCTION f(x int) RETURNS int\nLANGUAGE sql\nBEGIN ATOMIC\n  SELECT CASE WHEN x > 0 THEN 1 ELSE 0 END;\n  SELECT x;\nEND", Line: 1},
+				{SQL: "create procedure p() begin atomic insert into t values (1); end", Line: 7},
+				{SQL: "begin", Line: 8},
+				{SQL: "select 1", Line: 9},
+				{SQL: "end", Line: 10},
+			},
+		},
+		{
+			script: "create function f() returns int as $$ begin return 1; end $$ language plpgsql;\nselect 1",
+			expected: []pgx.Statement{
+				{SQL: "create function f() returns int as $$ begin return 1; end $$ language plpgsql", Line: 1},
+				{SQL: "select 1", Line: 2},
+			},
+		},
+		{
+			script: "select 1; select 'unterminated;",
+			expected: []pgx.Statement{
+				{SQL: "select 1", Line: 1},
+				{SQL: "select 'unterminated;", Line: 1},
+			},
+		},
+	} {
+		assert.Equalf(t, tt.expected, pgx.SplitStatements(tt.script), "%d", i)
+	}
+}
+
+func TestConnExecScript(t *testing.T) {
+	t.Parallel()
+
+	execSteps := func(sql string, commandTag string) []pgmock.Step {
+		return []pgmock.Step{
+			pgmock.ExpectMessage(&pgproto3.Query{String: sql}),
+			pgmock.SendMessage(&pgproto3.CommandComplete{CommandTag: []byte(commandTag)}),
+			pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		}
+	}
+
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps, execSteps("create table t (id int)", "CREATE TABLE")...)
+	script.Steps = append(script.Steps, execSteps("insert into t values (1)", "INSERT 0 1")...)
+
+	// A failing statement in a transaction.
+	script.Steps = append(script.Steps, execSteps("begin", "BEGIN")...)
+	script.Steps = append(script.Steps, execSteps("insert into t values (2)", "INSERT 0 1")...)
+	script.Steps = append(script.Steps,
+		pgmock.ExpectMessage(&pgproto3.Query{String: "insert into t\nvaluez (3)"}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42601", Message: `syntax error at or near "valuez"`, Position: 15}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'E'}),
+	)
+	script.Steps = append(script.Steps, execSteps("rollback", "ROLLBACK")...)
+	script.Steps = append(script.Steps, pgmock.ExpectMessage(&pgproto3.Terminate{}))
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, server.ConnString())
+	require.NoError(t, err)
+
+	err = conn.ExecScript(ctx, "create table t (id int);\ninsert into t values (1);\n", nil)
+	require.NoError(t, err)
+
+	err = conn.ExecScript(ctx, "insert into t values (2);\n\ninsert into t\nvaluez (3);\ninsert into t values (4);", &pgx.ExecScriptOptions{TxOptions: &pgx.TxOptions{}})
+	var scriptErr *pgx.ScriptError
+	require.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, 1, scriptErr.Index)
+	assert.Equal(t, 3, scriptErr.Statement.Line)
+	assert.Equal(t, 4, scriptErr.Line)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "42601", pgErr.Code)
+	assert.Equal(t, `statement 2 at line 4: ERROR: syntax error at or near "valuez" (SQLSTATE 42601)`, err.Error())
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}
+
+func TestConnExecScriptNamedArgsErrorLine(t *testing.T) {
+	t.Parallel()
+
+	// The error position refers to the rewritten statement where @verylongname is the shorter $1.
+	rewrittenSQL := "select $1,\n bogus"
+	script := &pgmock.Script{Steps: pgmock.AcceptUnauthenticatedConnRequestSteps()}
+	script.Steps = append(script.Steps,
+		pgmock.ExpectAnyMessage(&pgproto3.Parse{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Describe{}),
+		pgmock.ExpectAnyMessage(&pgproto3.Sync{}),
+		pgmock.SendMessage(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "42703", Message: `column "bogus" does not exist`, Position: int32(strings.Index(rewrittenSQL, "bogus") + 1)}),
+		pgmock.SendMessage(&pgproto3.ReadyForQuery{TxStatus: 'I'}),
+		pgmock.ExpectMessage(&pgproto3.Terminate{}),
+	)
+
+	server, err := pgmock.NewServer(script)
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(ctx, server.ConnString())
+	require.NoError(t, err)
+
+	err = conn.ExecScript(ctx, "\nselect @verylongname,\n bogus;", &pgx.ExecScriptOptions{NamedArgs: pgx.NamedArgs{"verylongname": 1}})
+	var scriptErr *pgx.ScriptError
+	require.ErrorAs(t, err, &scriptErr)
+	assert.Equal(t, 2, scriptErr.Statement.Line)
+	assert.Equal(t, 3, scriptErr.Line)
+
+	require.NoError(t, conn.Close(ctx))
+	require.NoError(t, server.Wait())
+}